@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"github.com/xtracdev/xavi/config"
 	"github.com/xtracdev/xavi/kvstore"
 	"github.com/xtracdev/xavi/loadbalancer"
+	"github.com/xtracdev/xavi/service"
 	"os"
 	"strings"
 )
@@ -24,6 +26,9 @@ type AddBackend struct {
 //we load the file, check its format and content, and so on.
 var ErrBadPathSpec = errors.New("CA certificate path is invalid or inaccessible")
 
+//ErrBadCACert indicates the given inline CA certificate contains no certificates
+var ErrBadCACert = errors.New("CA certificate contains no certificates")
+
 //Help provides detailed command help
 func (ab *AddBackend) Help() string {
 	helpText := `
@@ -34,7 +39,24 @@ func (ab *AddBackend) Help() string {
 			-servers List of servers to add to backend, e.g. server1,server2,server3 no spaces
 			-load-balancer-policy Load balancer policy name
 			-cacert-path Path to PEM file containing CA cert for backend servers
+			-cacert PEM data of the CA cert for backend servers, usually a reference such as ${file:/etc/xavi/ca.pem}
 			-tls-only Use TSL/HTTPS only when calling server.
+			-timeout Optional timeout in milliseconds for each call to a server
+			-connect-timeout Optional timeout in milliseconds for connecting to a server
+			-response-header-timeout Optional timeout in milliseconds for receiving response headers
+			-hash-key Request key used by the consistent-hash policy: header:<name>, cookie:<name> or ip (default)
+			-affinity-cookie Optional name of a cookie the gateway issues to pin clients to a server
+			-outlier-consecutive-errors Eject a server after this many errors in a row on live requests
+			-outlier-error-rate Eject a server when this percentage of its live requests fail
+			-outlier-base-ejection-time Milliseconds a server is first ejected for, doubling on each ejection (default 30000)
+			-outlier-max-ejection-percent Most servers ejected at once as a percentage (default 10, at least one server)
+			-circuit-breaker-failures Open a server's circuit after this many failed requests in a row
+			-circuit-breaker-open-time Milliseconds a circuit stays open before trial requests (default 10000)
+			-circuit-breaker-trial-requests Successful trial requests that close a half-open circuit (default 1)
+			-retry-attempts Most attempts per request including the first, each on a different server if possible
+			-retry-on Conditions to retry on: connect-error, reset, timeout, 5xx or status codes (default connect-error,502,503,504)
+			-retry-non-idempotent Retry requests with non-idempotent methods such as POST
+			-retry-backoff Milliseconds of jittered backoff before a retry, doubling for each attempt (default 25)
 
 	Known load balancers:`
 
@@ -47,15 +69,38 @@ func (ab *AddBackend) Help() string {
 //a backend configuration to the KV store assocaited with AddBackend
 func (ab *AddBackend) Run(args []string) int {
 	log.Debug("AddBackend run commands ", args)
-	var name, serverList, loadBalancerPolicy, caCertPath string
+	var name, serverList, loadBalancerPolicy, caCertPath, caCert, hashKey, affinityCookie string
 	var tlsOnly bool
+	var timeout, connectTimeout, responseHeaderTimeout int
+	var outlierConsecutiveErrors, outlierErrorRate, outlierBaseEjectionTime, outlierMaxEjectionPercent int
+	var circuitBreakerFailures, circuitBreakerOpenTime, circuitBreakerTrialRequests int
+	var retryAttempts, retryBackoff int
+	var retryOn string
+	var retryNonIdempotent bool
 	cmdFlags := flag.NewFlagSet("add-backend", flag.ContinueOnError)
 	cmdFlags.Usage = func() { ab.UI.Output(ab.Help()) }
 	cmdFlags.StringVar(&name, "name", "", "")
 	cmdFlags.StringVar(&serverList, "servers", "", "")
 	cmdFlags.StringVar(&loadBalancerPolicy, "load-balancer-policy", "", "")
 	cmdFlags.StringVar(&caCertPath, "cacert-path", "", "")
+	cmdFlags.StringVar(&caCert, "cacert", "", "")
 	cmdFlags.BoolVar(&tlsOnly, "tls-only", false, "")
+	cmdFlags.IntVar(&timeout, "timeout", 0, "")
+	cmdFlags.IntVar(&connectTimeout, "connect-timeout", 0, "")
+	cmdFlags.IntVar(&responseHeaderTimeout, "response-header-timeout", 0, "")
+	cmdFlags.StringVar(&hashKey, "hash-key", "", "")
+	cmdFlags.StringVar(&affinityCookie, "affinity-cookie", "", "")
+	cmdFlags.IntVar(&outlierConsecutiveErrors, "outlier-consecutive-errors", 0, "")
+	cmdFlags.IntVar(&outlierErrorRate, "outlier-error-rate", 0, "")
+	cmdFlags.IntVar(&outlierBaseEjectionTime, "outlier-base-ejection-time", 0, "")
+	cmdFlags.IntVar(&outlierMaxEjectionPercent, "outlier-max-ejection-percent", 0, "")
+	cmdFlags.IntVar(&circuitBreakerFailures, "circuit-breaker-failures", 0, "")
+	cmdFlags.IntVar(&circuitBreakerOpenTime, "circuit-breaker-open-time", 0, "")
+	cmdFlags.IntVar(&circuitBreakerTrialRequests, "circuit-breaker-trial-requests", 0, "")
+	cmdFlags.IntVar(&retryAttempts, "retry-attempts", 0, "")
+	cmdFlags.StringVar(&retryOn, "retry-on", "", "")
+	cmdFlags.BoolVar(&retryNonIdempotent, "retry-non-idempotent", false, "")
+	cmdFlags.IntVar(&retryBackoff, "retry-backoff", 0, "")
 
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -73,6 +118,31 @@ func (ab *AddBackend) Run(args []string) int {
 		argErr = true
 	}
 
+	if timeout < 0 || connectTimeout < 0 || responseHeaderTimeout < 0 {
+		ab.UI.Error("Timeouts cannot be negative")
+		argErr = true
+	}
+
+	if outlierConsecutiveErrors < 0 || outlierBaseEjectionTime < 0 {
+		ab.UI.Error("Outlier detection settings cannot be negative")
+		argErr = true
+	}
+
+	if outlierErrorRate < 0 || outlierErrorRate > 100 || outlierMaxEjectionPercent < 0 || outlierMaxEjectionPercent > 100 {
+		ab.UI.Error("Outlier percentages must be between 0 and 100")
+		argErr = true
+	}
+
+	if circuitBreakerFailures < 0 || circuitBreakerOpenTime < 0 || circuitBreakerTrialRequests < 0 {
+		ab.UI.Error("Circuit breaker settings cannot be negative")
+		argErr = true
+	}
+
+	if retryAttempts < 0 || retryBackoff < 0 {
+		ab.UI.Error("Retry settings cannot be negative")
+		argErr = true
+	}
+
 	if argErr {
 		ab.UI.Error("")
 		ab.UI.Error(ab.Help())
@@ -86,18 +156,53 @@ func (ab *AddBackend) Run(args []string) int {
 		return 1
 	}
 
+	if err := loadbalancer.ValidateHashKey(hashKey); err != nil {
+		ab.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := service.ValidateRetryOn(retryOn); err != nil {
+		ab.UI.Error(err.Error())
+		return 1
+	}
+
 	//Check cacert
 	if err := ab.validCertPath(caCertPath); err != nil {
 		ab.UI.Error(err.Error())
 		return 1
 	}
 
+	if err := validCert(caCert, caCertPath); err != nil {
+		ab.UI.Error(err.Error())
+		return 1
+	}
+
 	backend := &config.BackendConfig{
-		Name:               name,
-		ServerNames:        strings.Split(serverList, ","),
-		LoadBalancerPolicy: loadBalancerPolicy,
-		TLSOnly:            tlsOnly,
-		CACertPath:         caCertPath,
+		Name:                  name,
+		ServerNames:           strings.Split(serverList, ","),
+		LoadBalancerPolicy:    loadBalancerPolicy,
+		TLSOnly:               tlsOnly,
+		CACertPath:            caCertPath,
+		CACert:                caCert,
+		Timeout:               timeout,
+		ConnectTimeout:        connectTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		HashKey:               hashKey,
+		AffinityCookie:        affinityCookie,
+
+		OutlierConsecutiveErrors:  outlierConsecutiveErrors,
+		OutlierErrorRate:          outlierErrorRate,
+		OutlierBaseEjectionTime:   outlierBaseEjectionTime,
+		OutlierMaxEjectionPercent: outlierMaxEjectionPercent,
+
+		CircuitBreakerFailures:      circuitBreakerFailures,
+		CircuitBreakerOpenTime:      circuitBreakerOpenTime,
+		CircuitBreakerTrialRequests: circuitBreakerTrialRequests,
+
+		RetryAttempts:      retryAttempts,
+		RetryOn:            retryOn,
+		RetryNonIdempotent: retryNonIdempotent,
+		RetryBackoff:       retryBackoff,
 	}
 
 	if err := backend.Store(ab.KVStore); err != nil {
@@ -121,10 +226,29 @@ func (ab *AddBackend) Synopsis() string {
 
 func (ab *AddBackend) validCertPath(caCertPath string) error {
 	log.Debugf("validCertPath %s", caCertPath)
-	if caCertPath != "" {
+	//Secret references are resolved when the listener is built
+	if caCertPath != "" && !config.ContainsSecretRefs(caCertPath) {
 		if _, err := os.Stat(caCertPath); os.IsNotExist(err) {
 			return ErrBadPathSpec
 		}
 	}
 	return nil
 }
+
+//validCert checks that at most one of the inline CA cert and CA cert path is given, and that an
+//inline CA cert without secret references contains certificates.
+func validCert(caCert, caCertPath string) error {
+	if caCert == "" {
+		return nil
+	}
+
+	if caCertPath != "" {
+		return config.ErrCACertAndPath
+	}
+
+	if !config.ContainsSecretRefs(caCert) && !x509.NewCertPool().AppendCertsFromPEM([]byte(caCert)) {
+		return ErrBadCACert
+	}
+
+	return nil
+}
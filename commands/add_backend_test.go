@@ -115,6 +115,40 @@ func TestAddBackendInvalidCACertPath(t *testing.T) {
 	assert.True(t, strings.Contains(writer.String(), ErrBadPathSpec.Error()))
 }
 
+func TestAddBackendWithSecretRefCACertPath(t *testing.T) {
+	writer, addBackend := testMakeAddBackend(false)
+	args := []string{"-name", "test", "-servers", "foo", "-cacert-path", "${env:XAVI_CA_PATH}"}
+	status := addBackend.Run(args)
+	assert.Equal(t, 0, status, writer.String())
+}
+
+func TestAddBackendWithSecretRefCACert(t *testing.T) {
+	writer, addBackend := testMakeAddBackend(false)
+	args := []string{"-name", "test", "-servers", "foo", "-cacert", "${file:/etc/xavi/ca.pem}"}
+	status := addBackend.Run(args)
+	assert.Equal(t, 0, status, writer.String())
+
+	storedBytes, err := addBackend.KVStore.Get("backends/test")
+	assert.Nil(t, err)
+	b := config.JSONToBackend(storedBytes)
+	assert.Equal(t, "${file:/etc/xavi/ca.pem}", b.CACert)
+	assert.Equal(t, "", b.CACertPath)
+}
+
+func TestAddBackendInvalidCACert(t *testing.T) {
+	writer, addBackend := testMakeAddBackend(false)
+	args := []string{"-name", "test", "-servers", "foo", "-cacert", "not a cert"}
+	status := addBackend.Run(args)
+	assert.Equal(t, 1, status)
+	assert.True(t, strings.Contains(writer.String(), ErrBadCACert.Error()))
+
+	writer, addBackend = testMakeAddBackend(false)
+	args = []string{"-name", "test", "-servers", "foo", "-cacert", "${file:/etc/xavi/ca.pem}", "-cacert-path", "${env:XAVI_CA_PATH}"}
+	status = addBackend.Run(args)
+	assert.Equal(t, 1, status)
+	assert.True(t, strings.Contains(writer.String(), config.ErrCACertAndPath.Error()))
+}
+
 func TestAddBackendWithValidCACertPath(t *testing.T) {
 
 	tmpfile, err := ioutil.TempFile("/tmp", "catest")
@@ -167,3 +201,94 @@ func TestSpecifyTLSOnlyCACertOk(t *testing.T) {
 		t.Log(writer.String())
 	}
 }
+
+func TestAddBackendWithTimeouts(t *testing.T) {
+	_, addBackend := testMakeAddBackend(false)
+	args := []string{"-name", "test", "-servers", "foo", "-timeout", "500", "-connect-timeout", "100",
+		"-response-header-timeout", "250"}
+	status := addBackend.Run(args)
+	assert.Equal(t, 0, status)
+
+	storedBytes, err := addBackend.KVStore.Get("backends/test")
+	assert.Nil(t, err)
+
+	b := config.JSONToBackend(storedBytes)
+	assert.Equal(t, 500, b.Timeout)
+	assert.Equal(t, 100, b.ConnectTimeout)
+	assert.Equal(t, 250, b.ResponseHeaderTimeout)
+
+	status = addBackend.Run([]string{"-name", "test", "-servers", "foo", "-connect-timeout", "-1"})
+	assert.Equal(t, 1, status)
+}
+
+func TestAddBackendWithHashKey(t *testing.T) {
+	_, addBackend := testMakeAddBackend(false)
+	args := []string{"-name", "test", "-servers", "foo", "-load-balancer-policy", "consistent-hash",
+		"-hash-key", "header:X-User", "-affinity-cookie", "xavi-affinity"}
+	assert.Equal(t, 0, addBackend.Run(args))
+
+	storedBytes, err := addBackend.KVStore.Get("backends/test")
+	assert.Nil(t, err)
+
+	b := config.JSONToBackend(storedBytes)
+	assert.Equal(t, "header:X-User", b.HashKey)
+	assert.Equal(t, "xavi-affinity", b.AffinityCookie)
+
+	args = []string{"-name", "test", "-servers", "foo", "-load-balancer-policy", "consistent-hash", "-hash-key", "body"}
+	assert.Equal(t, 1, addBackend.Run(args))
+}
+
+func TestAddBackendWithOutlierDetection(t *testing.T) {
+	_, addBackend := testMakeAddBackend(false)
+	args := []string{"-name", "test", "-servers", "foo", "-outlier-consecutive-errors", "5", "-outlier-error-rate", "50",
+		"-outlier-base-ejection-time", "10000", "-outlier-max-ejection-percent", "30"}
+	assert.Equal(t, 0, addBackend.Run(args))
+
+	storedBytes, err := addBackend.KVStore.Get("backends/test")
+	assert.Nil(t, err)
+
+	b := config.JSONToBackend(storedBytes)
+	assert.Equal(t, 5, b.OutlierConsecutiveErrors)
+	assert.Equal(t, 50, b.OutlierErrorRate)
+	assert.Equal(t, 10000, b.OutlierBaseEjectionTime)
+	assert.Equal(t, 30, b.OutlierMaxEjectionPercent)
+
+	assert.Equal(t, 1, addBackend.Run([]string{"-name", "test", "-servers", "foo", "-outlier-error-rate", "101"}))
+	assert.Equal(t, 1, addBackend.Run([]string{"-name", "test", "-servers", "foo", "-outlier-consecutive-errors", "-1"}))
+}
+
+func TestAddBackendWithCircuitBreaker(t *testing.T) {
+	_, addBackend := testMakeAddBackend(false)
+	args := []string{"-name", "test", "-servers", "foo", "-circuit-breaker-failures", "5",
+		"-circuit-breaker-open-time", "20000", "-circuit-breaker-trial-requests", "3"}
+	assert.Equal(t, 0, addBackend.Run(args))
+
+	storedBytes, err := addBackend.KVStore.Get("backends/test")
+	assert.Nil(t, err)
+
+	b := config.JSONToBackend(storedBytes)
+	assert.Equal(t, 5, b.CircuitBreakerFailures)
+	assert.Equal(t, 20000, b.CircuitBreakerOpenTime)
+	assert.Equal(t, 3, b.CircuitBreakerTrialRequests)
+
+	assert.Equal(t, 1, addBackend.Run([]string{"-name", "test", "-servers", "foo", "-circuit-breaker-failures", "-1"}))
+}
+
+func TestAddBackendWithRetries(t *testing.T) {
+	_, addBackend := testMakeAddBackend(false)
+	args := []string{"-name", "test", "-servers", "foo", "-retry-attempts", "3", "-retry-on", "connect-error,5xx",
+		"-retry-non-idempotent", "-retry-backoff", "50"}
+	assert.Equal(t, 0, addBackend.Run(args))
+
+	storedBytes, err := addBackend.KVStore.Get("backends/test")
+	assert.Nil(t, err)
+
+	b := config.JSONToBackend(storedBytes)
+	assert.Equal(t, 3, b.RetryAttempts)
+	assert.Equal(t, "connect-error,5xx", b.RetryOn)
+	assert.True(t, b.RetryNonIdempotent)
+	assert.Equal(t, 50, b.RetryBackoff)
+
+	assert.Equal(t, 1, addBackend.Run([]string{"-name", "test", "-servers", "foo", "-retry-on", "often"}))
+	assert.Equal(t, 1, addBackend.Run([]string{"-name", "test", "-servers", "foo", "-retry-attempts", "-2"}))
+}
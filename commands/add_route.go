@@ -7,6 +7,7 @@ import (
 	"github.com/xtracdev/xavi/config"
 	"github.com/xtracdev/xavi/kvstore"
 	"github.com/xtracdev/xavi/plugin"
+	"github.com/xtracdev/xavi/service"
 	"strings"
 )
 
@@ -28,6 +29,12 @@ func (ar *AddRoute) Help() string {
 		-plugins Optional list of plugin names
 		-multibackend-adapter Plugin injected with multiple backend handlers
 		-msgprop Message properties for matching route
+		-timeout Optional overall request timeout in milliseconds
+		-retry-attempts Most attempts per request including the first, overriding the retry settings
+			of the backends. 1 turns retries off for the route.
+		-retry-on Conditions to retry on: connect-error, reset, timeout, 5xx or status codes (default connect-error,502,503,504)
+		-retry-non-idempotent Retry requests with non-idempotent methods such as POST
+		-retry-backoff Milliseconds of jittered backoff before a retry, doubling for each attempt (default 25)
 		`
 
 	return strings.TrimSpace(helpText)
@@ -58,7 +65,9 @@ func pluginssRegistered(plugins []string) (string, bool) {
 
 //Run executes the AddRoute command using the provided arguments
 func (ar *AddRoute) Run(args []string) int {
-	var name, backends, baseuri, pluginList, msgprop, multiBackendAdapter string
+	var name, backends, baseuri, pluginList, msgprop, multiBackendAdapter, retryOn string
+	var timeout, retryAttempts, retryBackoff int
+	var retryNonIdempotent bool
 	cmdFlags := flag.NewFlagSet("add-route", flag.ContinueOnError)
 	cmdFlags.Usage = func() { ar.UI.Output(ar.Help()) }
 	cmdFlags.StringVar(&name, "name", "", "")
@@ -67,6 +76,11 @@ func (ar *AddRoute) Run(args []string) int {
 	cmdFlags.StringVar(&pluginList, "plugins", "", "")
 	cmdFlags.StringVar(&msgprop, "msgprop", "", "")
 	cmdFlags.StringVar(&multiBackendAdapter, "multibackend-adapter", "", "")
+	cmdFlags.IntVar(&timeout, "timeout", 0, "")
+	cmdFlags.IntVar(&retryAttempts, "retry-attempts", 0, "")
+	cmdFlags.StringVar(&retryOn, "retry-on", "", "")
+	cmdFlags.BoolVar(&retryNonIdempotent, "retry-non-idempotent", false, "")
+	cmdFlags.IntVar(&retryBackoff, "retry-backoff", 0, "")
 
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -89,12 +103,27 @@ func (ar *AddRoute) Run(args []string) int {
 		argErr = true
 	}
 
+	if timeout < 0 {
+		ar.UI.Error("Timeout cannot be negative")
+		argErr = true
+	}
+
+	if retryAttempts < 0 || retryBackoff < 0 {
+		ar.UI.Error("Retry settings cannot be negative")
+		argErr = true
+	}
+
 	if argErr {
 		ar.UI.Error("")
 		ar.UI.Error(ar.Help())
 		return 1
 	}
 
+	if err := service.ValidateRetryOn(retryOn); err != nil {
+		ar.UI.Error(err.Error())
+		return 1
+	}
+
 	cmdBackends := strings.Split(backends, ",")
 
 	//Check that the multi route plugin was specified if multiple backends were configured
@@ -129,6 +158,11 @@ func (ar *AddRoute) Run(args []string) int {
 		Plugins:             plugins,
 		MsgProps:            msgprop,
 		MultiBackendAdapter: multiBackendAdapter,
+		Timeout:             timeout,
+		RetryAttempts:       retryAttempts,
+		RetryOn:             retryOn,
+		RetryNonIdempotent:  retryNonIdempotent,
+		RetryBackoff:        retryBackoff,
 	}
 
 	if err := route.Store(ar.KVStore); err != nil {
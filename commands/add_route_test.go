@@ -162,3 +162,36 @@ func TestAddRouteSynopsis(t *testing.T) {
 	assert.NotNil(t, addRoute)
 	assert.NotEqual(t, "", addRoute.Synopsis())
 }
+
+func TestAddRouteWithTimeout(t *testing.T) {
+	_, addRoute := testMakeAddRoute(false, t)
+
+	args := []string{"-name", "route1", "-backends", "b1", "-base-uri", "/foo", "-timeout", "1500"}
+	status := addRoute.Run(args)
+	assert.Equal(t, 0, status)
+	storedBytes, err := addRoute.KVStore.Get("routes/route1")
+	assert.Nil(t, err)
+
+	r := config.JSONToRoute(storedBytes)
+	assert.Equal(t, 1500, r.Timeout)
+
+	args = []string{"-name", "route1", "-backends", "b1", "-base-uri", "/foo", "-timeout", "-1"}
+	status = addRoute.Run(args)
+	assert.Equal(t, 1, status)
+}
+
+func TestAddRouteWithRetries(t *testing.T) {
+	_, addRoute := testMakeAddRoute(false, t)
+
+	args := []string{"-name", "route1", "-backends", "b1", "-base-uri", "/foo", "-retry-attempts", "3", "-retry-on", "5xx"}
+	assert.Equal(t, 0, addRoute.Run(args))
+	storedBytes, err := addRoute.KVStore.Get("routes/route1")
+	assert.Nil(t, err)
+
+	r := config.JSONToRoute(storedBytes)
+	assert.Equal(t, 3, r.RetryAttempts)
+	assert.Equal(t, "5xx", r.RetryOn)
+
+	args = []string{"-name", "route1", "-backends", "b1", "-base-uri", "/foo", "-retry-on", "sometimes"}
+	assert.Equal(t, 1, addRoute.Run(args))
+}
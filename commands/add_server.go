@@ -31,6 +31,16 @@ func (as *AddServer) Help() string {
 		-health-check Health check type (optional)
 		-health-check-interval (optional) duration in milliseconds at which health is checked
 		-health-check-timeout (optional) time in milliseconds for healthcheck timeout
+		-weight (optional) relative share of requests for weighted load balancer policies, default 1
+		-health-check-method (optional) HTTP method used by http health checks, default GET
+		-health-check-header (optional) header sent by http health checks as Name:value, may be repeated
+		-health-check-body (optional) request body sent by http and tcp health checks
+		-health-check-status (optional) healthy status codes and ranges, e.g. 200-299,304, default 200
+		-health-check-match (optional) response body match: a substring, regex:<expression>, json:<path>
+			or json:<path>=<value>, also matched against tcp health check replies
+		-health-check-command (optional) command run by exec health checks, healthy when it exits with 0
+		-health-check-rise (optional) passing checks in a row needed to mark the server up, default 1
+		-health-check-fall (optional) failing checks in a row needed to mark the server down, default 1
 
 	Known health checks:
 	`
@@ -46,6 +56,10 @@ func (as *AddServer) Run(args []string) int {
 	var port int
 	var healthCheck string
 	var healthCheckInterval, healthCheckTimeout int
+	var weight int
+	var healthCheckMethod, healthCheckBody, healthCheckStatus, healthCheckMatch, healthCheckCommand string
+	var healthCheckRise, healthCheckFall int
+	healthCheckHeaders := make(headerFlags)
 
 	cmdFlags := flag.NewFlagSet("add-server", flag.ContinueOnError)
 	cmdFlags.Usage = func() { as.UI.Output(as.Help()) }
@@ -56,6 +70,15 @@ func (as *AddServer) Run(args []string) int {
 	cmdFlags.StringVar(&healthCheck, "health-check", "none", "")
 	cmdFlags.IntVar(&healthCheckInterval, "health-check-interval", loadbalancer.DefaultHealthCheckInterval, "")
 	cmdFlags.IntVar(&healthCheckTimeout, "health-check-timeout", loadbalancer.DefaultHealthCheckTimeout, "")
+	cmdFlags.IntVar(&weight, "weight", 0, "")
+	cmdFlags.StringVar(&healthCheckMethod, "health-check-method", "", "")
+	cmdFlags.Var(healthCheckHeaders, "health-check-header", "")
+	cmdFlags.StringVar(&healthCheckBody, "health-check-body", "", "")
+	cmdFlags.StringVar(&healthCheckStatus, "health-check-status", "", "")
+	cmdFlags.StringVar(&healthCheckMatch, "health-check-match", "", "")
+	cmdFlags.StringVar(&healthCheckCommand, "health-check-command", "", "")
+	cmdFlags.IntVar(&healthCheckRise, "health-check-rise", 0, "")
+	cmdFlags.IntVar(&healthCheckFall, "health-check-fall", 0, "")
 
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -92,6 +115,11 @@ func (as *AddServer) Run(args []string) int {
 		argErr = true
 	}
 
+	if weight < 0 {
+		as.UI.Error("Weight cannot be negative")
+		argErr = true
+	}
+
 	if argErr {
 		as.UI.Error("")
 		as.UI.Error(as.Help())
@@ -106,6 +134,23 @@ func (as *AddServer) Run(args []string) int {
 		HealthCheck:         healthCheck,
 		HealthCheckInterval: healthCheckInterval,
 		HealthCheckTimeout:  healthCheckTimeout,
+		Weight:              weight,
+		HealthCheckMethod:   healthCheckMethod,
+		HealthCheckBody:     healthCheckBody,
+		HealthCheckStatus:   healthCheckStatus,
+		HealthCheckMatch:    healthCheckMatch,
+		HealthCheckCommand:  healthCheckCommand,
+		HealthCheckRise:     healthCheckRise,
+		HealthCheckFall:     healthCheckFall,
+	}
+
+	if len(healthCheckHeaders) > 0 {
+		serverDef.HealthCheckHeaders = healthCheckHeaders
+	}
+
+	if err := loadbalancer.ValidateHealthCheckSpec(serverDef); err != nil {
+		as.UI.Error(err.Error())
+		return 1
 	}
 
 	err := serverDef.Store(as.KVStore)
@@ -122,6 +167,27 @@ func (as *AddServer) Run(args []string) int {
 	return 0
 }
 
+//headerFlags collects repeated Name:value header flags
+type headerFlags map[string]string
+
+func (hf headerFlags) String() string {
+	var headers []string
+	for name, value := range hf {
+		headers = append(headers, name+":"+value)
+	}
+	return strings.Join(headers, ",")
+}
+
+func (hf headerFlags) Set(header string) error {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("Invalid header '%s' - expected Name:value", header)
+	}
+
+	hf[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	return nil
+}
+
 //Synopsis gives a concise description of the AddServer command
 func (as *AddServer) Synopsis() string {
 	return "Add a server definition"
@@ -157,3 +157,69 @@ func TestAddServerCustomHealthcheck(t *testing.T) {
 		assert.Equal(t, 10, s.HealthCheckTimeout)
 	}
 }
+
+func TestAddServerWithWeight(t *testing.T) {
+	_, addServer := testMakeAddServer(false)
+
+	args := []string{"-address", "an-address", "-port", "42", "-name", "test-name", "-weight", "3"}
+	assert.Equal(t, 0, addServer.Run(args))
+	storedBytes, err := addServer.KVStore.Get("servers/test-name")
+	assert.Nil(t, err)
+
+	s := config.JSONToServer(storedBytes)
+	assert.Equal(t, 3, s.Weight)
+
+	args = []string{"-address", "an-address", "-port", "42", "-name", "test-name", "-weight", "-3"}
+	assert.Equal(t, 1, addServer.Run(args))
+}
+
+func TestAddServerWithHealthCheckSpec(t *testing.T) {
+	_, addServer := testMakeAddServer(false)
+
+	args := []string{"-address", "an-address", "-port", "42", "-name", "test-name", "-health-check", "http-get",
+		"-health-check-method", "POST", "-health-check-header", "Host: status.local",
+		"-health-check-header", "X-Probe:1", "-health-check-body", `{"deep":true}`,
+		"-health-check-status", "200-299,304", "-health-check-match", "json:status=UP",
+		"-health-check-rise", "2", "-health-check-fall", "3"}
+	assert.Equal(t, 0, addServer.Run(args))
+	storedBytes, err := addServer.KVStore.Get("servers/test-name")
+	assert.Nil(t, err)
+
+	s := config.JSONToServer(storedBytes)
+	assert.Equal(t, "POST", s.HealthCheckMethod)
+	assert.Equal(t, map[string]string{"Host": "status.local", "X-Probe": "1"}, s.HealthCheckHeaders)
+	assert.Equal(t, `{"deep":true}`, s.HealthCheckBody)
+	assert.Equal(t, "200-299,304", s.HealthCheckStatus)
+	assert.Equal(t, "json:status=UP", s.HealthCheckMatch)
+	assert.Equal(t, 2, s.HealthCheckRise)
+	assert.Equal(t, 3, s.HealthCheckFall)
+
+	for _, bad := range [][]string{
+		{"-health-check-status", "200-199"},
+		{"-health-check-match", "regex:("},
+		{"-health-check-header", "no-colon"},
+		{"-health-check-fall", "-1"},
+		{"-health-check", "exec"},
+	} {
+		args = append([]string{"-address", "an-address", "-port", "42", "-name", "test-name"}, bad...)
+		assert.Equal(t, 1, addServer.Run(args), bad[1])
+	}
+}
+
+func TestAddServerWithExecHealthCheck(t *testing.T) {
+	_, addServer := testMakeAddServer(false)
+
+	args := []string{"-address", "an-address", "-port", "42", "-name", "test-name", "-health-check", "exec",
+		"-health-check-command", "/usr/local/bin/check-db --quick"}
+	assert.Equal(t, 0, addServer.Run(args))
+	storedBytes, err := addServer.KVStore.Get("servers/test-name")
+	assert.Nil(t, err)
+
+	s := config.JSONToServer(storedBytes)
+	assert.Equal(t, "exec", s.HealthCheck)
+	assert.Equal(t, "/usr/local/bin/check-db --quick", s.HealthCheckCommand)
+
+	args = []string{"-address", "an-address", "-port", "42", "-name", "test-name", "-health-check", "tcp",
+		"-health-check-body", "PING\r\n", "-health-check-match", "PONG"}
+	assert.Equal(t, 0, addServer.Run(args))
+}
@@ -3,10 +3,21 @@ package agent
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/config"
 	"github.com/xtracdev/xavi/kvstore"
 	"net/http"
+	"strconv"
 )
 
+//definitionIndexHeader is the response header carrying the modify index of a definition
+//returned by GET. The index can be passed back as the index query parameter on a PUT to
+//make the update conditional on the definition not having changed in the meantime.
+const definitionIndexHeader = "X-Xavi-Index"
+
+//authorHeader is the request header identifying the author of changes made via the API. If
+//absent, the user name of any basic auth credentials is used.
+const authorHeader = "X-Xavi-Author"
+
 //APICommand defines common functionality that web api enabled configuration
 //services must implement
 type APICommand interface {
@@ -15,6 +26,7 @@ type APICommand interface {
 	GetDefinitionList(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error)
 	PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error)
 	DoPost(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error)
+	DeleteDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error)
 }
 
 //APIService exposes APICommand instances as REST services
@@ -29,6 +41,14 @@ func NewAPIService(cmd APICommand) *APIService {
 
 func (apiSvc *APIService) serversEndpoint(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	log.Info(fmt.Sprintf("Handling REST request at %s", req.RequestURI))
+	kvs = config.WithAuthor(kvs, requestAuthor(req))
+
+	if hc, ok := apiSvc.cmd.(historyCommand); ok {
+		if name, action, isHistory := historyRequest(apiSvc.cmd.GetURIRoot(), req.URL.Path); isHistory {
+			return historyEndpoint(hc.definitionKind(), name, action, kvs, resp, req)
+		}
+	}
+
 	list := (req.URL.RequestURI() == apiSvc.cmd.GetURIRoot())
 
 	switch req.Method {
@@ -46,8 +66,94 @@ func (apiSvc *APIService) serversEndpoint(kvs kvstore.KVStore, resp http.Respons
 	case "POST":
 		return apiSvc.cmd.DoPost(kvs, resp, req)
 
+	case "DELETE":
+		return apiSvc.cmd.DeleteDefinition(kvs, resp, req)
+
 	default:
 		resp.WriteHeader(http.StatusMethodNotAllowed)
 		return nil, nil
 	}
 }
+
+//cascadeRequested returns true if the request asks for referencing definitions to be
+//deleted along with the target resource, e.g. DELETE /v1/servers/s1?cascade=true
+func cascadeRequested(req *http.Request) bool {
+	return req.URL.Query().Get("cascade") == "true"
+}
+
+//writeDeleteErrorStatus writes the status code appropriate for an error returned by
+//one of the config package delete functions
+func writeDeleteErrorStatus(resp http.ResponseWriter, err error) {
+	if _, inUse := err.(*config.InUseError); inUse {
+		resp.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	switch err {
+	case config.ErrNoSuchServer, config.ErrNoSuchBackend, config.ErrNoSuchRoute, config.ErrNoSuchListener:
+		resp.WriteHeader(http.StatusNotFound)
+	default:
+		resp.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//setIndexHeader returns the modify index of a definition to the caller
+func setIndexHeader(resp http.ResponseWriter, index uint64) {
+	resp.Header().Set(definitionIndexHeader, strconv.FormatUint(index, 10))
+}
+
+//indexFromRequest returns the index query parameter of the request, if present, e.g.
+//PUT /v1/routes/r1?index=42. The second return value indicates whether an index was given.
+func indexFromRequest(req *http.Request) (uint64, bool, error) {
+	indexParam := req.URL.Query().Get("index")
+	if indexParam == "" {
+		return 0, false, nil
+	}
+
+	index, err := strconv.ParseUint(indexParam, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("Invalid index parameter '%s'", indexParam)
+	}
+
+	return index, true, nil
+}
+
+//storeDefinition stores a definition from a PUT request, conditionally if the request
+//includes an index, writing the appropriate status code if the store fails
+func storeDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request,
+	store func(kvstore.KVStore) error, storeIfUnmodified func(kvstore.KVStore, uint64) error) error {
+	index, conditional, err := indexFromRequest(req)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+
+	if conditional {
+		err = storeIfUnmodified(kvs, index)
+	} else {
+		err = store(kvs)
+	}
+
+	switch err {
+	case nil:
+	case config.ErrDefinitionModified:
+		resp.WriteHeader(http.StatusConflict)
+	default:
+		resp.WriteHeader(http.StatusInternalServerError)
+	}
+
+	return err
+}
+
+//requestAuthor returns the author of changes made by the request, if known
+func requestAuthor(req *http.Request) string {
+	if author := req.Header.Get(authorHeader); author != "" {
+		return author
+	}
+
+	if user, _, ok := req.BasicAuth(); ok {
+		return user
+	}
+
+	return ""
+}
@@ -43,7 +43,7 @@ func (BackendDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, r
 		return nil, nil
 	}
 
-	backendName := resourceIDFromURI(req.RequestURI)
+	backendName := resourceIDFromURI(req.URL.Path)
 	log.Info(backendName)
 	if backendName == "" {
 		resp.WriteHeader(http.StatusNotFound)
@@ -59,10 +59,9 @@ func (BackendDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, r
 	}
 
 	backendConfig.Name = backendName
-	err = backendConfig.Store(kvs)
+	err = storeDefinition(kvs, resp, req, backendConfig.Store, backendConfig.StoreIfUnmodified)
 	if err != nil {
-		log.Warn("Error persisting backend definition")
-		resp.WriteHeader(http.StatusInternalServerError)
+		log.Warn("Error persisting backend definition: ", err.Error())
 		return nil, err
 	}
 
@@ -93,9 +92,9 @@ func (BackendDef) GetDefinitionList(kvs kvstore.KVStore, resp http.ResponseWrite
 
 //GetDefinition retrieves a specific backend definition
 func (BackendDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
-	backendName := resourceIDFromURI(req.RequestURI)
+	backendName := resourceIDFromURI(req.URL.Path)
 
-	backendConfig, err := config.ReadBackendConfig(backendName, kvs)
+	backendConfig, index, err := config.ReadBackendConfigWithIndex(backendName, kvs)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
 		return nil, err
@@ -106,6 +105,8 @@ func (BackendDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, r
 		return nil, errBackendNotFound
 	}
 
+	setIndexHeader(resp, index)
+
 	return backendConfig, err
 
 }
@@ -115,3 +116,27 @@ func (BackendDef) DoPost(kvs kvstore.KVStore, resp http.ResponseWriter, req *htt
 	resp.WriteHeader(http.StatusMethodNotAllowed)
 	return nil, nil
 }
+
+//DeleteDefinition removes a backend definition. Definitions still referenced by route
+//definitions are only removed if cascade=true is given as a query parameter.
+func (BackendDef) DeleteDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	backendName := resourceIDFromURI(req.URL.Path)
+	if backendName == "" {
+		resp.WriteHeader(http.StatusNotFound)
+		return nil, errBackendResourceMissing
+	}
+
+	err := config.DeleteBackendConfig(backendName, kvs, cascadeRequested(req))
+	if err != nil {
+		log.Warn("Error deleting backend definition: ", err.Error())
+		writeDeleteErrorStatus(resp, err)
+		return nil, err
+	}
+
+	err = kvs.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
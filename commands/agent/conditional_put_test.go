@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConditionalRoutePut(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+	ts := httptest.NewServer(http.HandlerFunc(wrap(kvs, NewAPIService(RouteDefCmd))))
+	defer ts.Close()
+
+	response, err := http.Get(ts.URL + "/v1/routes/r1")
+	assert.Nil(t, err)
+	response.Body.Close()
+	index := response.Header.Get(definitionIndexHeader)
+	assert.NotEqual(t, "", index)
+
+	put := func(query string, payload string) int {
+		request, err := http.NewRequest("PUT", fmt.Sprintf("%s/v1/routes/r1%s", ts.URL, query),
+			strings.NewReader(payload))
+		assert.Nil(t, err)
+		response, err := http.DefaultClient.Do(request)
+		assert.Nil(t, err)
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	t.Log("Update based on the index we read succeeds")
+	status := put("?index="+index, `{"URIRoot":"/first","Backends":["b1"]}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	t.Log("Second update based on the same index is rejected")
+	status = put("?index="+index, `{"URIRoot":"/second","Backends":["b1"]}`)
+	assert.Equal(t, http.StatusConflict, status)
+
+	r, _ := config.ReadRouteConfig("r1", kvs)
+	assert.Equal(t, "/first", r.URIRoot)
+
+	status = put("?index=not-a-number", `{"URIRoot":"/third"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	t.Log("Unconditional update still overwrites")
+	status = put("", `{"URIRoot":"/fourth","Backends":["b1"]}`)
+	assert.Equal(t, http.StatusOK, status)
+	r, _ = config.ReadRouteConfig("r1", kvs)
+	assert.Equal(t, "/fourth", r.URIRoot)
+}
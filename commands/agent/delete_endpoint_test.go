@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testMakeDeleteKVStore(t *testing.T) *kvstore.HashKVStore {
+	kvs, _ := kvstore.NewHashKVStore("")
+
+	s := &config.ServerConfig{Name: "s1", Address: "localhost", Port: 3000, HealthCheck: "none"}
+	b := &config.BackendConfig{Name: "b1", ServerNames: []string{"s1"}}
+	r := &config.RouteConfig{Name: "r1", URIRoot: "/r1", Backends: []string{"b1"}}
+	l := &config.ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}
+
+	for _, err := range []error{s.Store(kvs), b.Store(kvs), r.Store(kvs), l.Store(kvs)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return kvs
+}
+
+func testDelete(t *testing.T, kvs kvstore.KVStore, cmd APICommand, uri string) int {
+	ts := httptest.NewServer(http.HandlerFunc(wrap(kvs, NewAPIService(cmd))))
+	defer ts.Close()
+
+	request, err := http.NewRequest("DELETE", fmt.Sprintf("%s%s", ts.URL, uri), nil)
+	assert.Nil(t, err)
+	client := &http.Client{}
+	response, err := client.Do(request)
+	assert.Nil(t, err)
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func TestDeleteReferencedServer(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+	status := testDelete(t, kvs, ServerDefCmd, "/v1/servers/s1")
+	assert.Equal(t, http.StatusConflict, status)
+
+	s, _ := config.ReadServerConfig("s1", kvs)
+	assert.NotNil(t, s)
+}
+
+func TestDeleteServerCascade(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+	status := testDelete(t, kvs, ServerDefCmd, "/v1/servers/s1?cascade=true")
+	assert.Equal(t, http.StatusOK, status)
+
+	l, _ := config.ReadListenerConfig("l1", kvs)
+	assert.Nil(t, l)
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+	assert.Equal(t, http.StatusNotFound, testDelete(t, kvs, ServerDefCmd, "/v1/servers/nope"))
+	assert.Equal(t, http.StatusNotFound, testDelete(t, kvs, BackendDefCmd, "/v1/backends/nope"))
+	assert.Equal(t, http.StatusNotFound, testDelete(t, kvs, RouteDefCmd, "/v1/routes/nope"))
+	assert.Equal(t, http.StatusNotFound, testDelete(t, kvs, ListenerDefCmd, "/v1/listeners/nope"))
+}
+
+func TestDeleteInOrder(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+	assert.Equal(t, http.StatusConflict, testDelete(t, kvs, RouteDefCmd, "/v1/routes/r1"))
+	assert.Equal(t, http.StatusOK, testDelete(t, kvs, ListenerDefCmd, "/v1/listeners/l1"))
+	assert.Equal(t, http.StatusOK, testDelete(t, kvs, RouteDefCmd, "/v1/routes/r1"))
+	assert.Equal(t, http.StatusOK, testDelete(t, kvs, BackendDefCmd, "/v1/backends/b1"))
+	assert.Equal(t, http.StatusOK, testDelete(t, kvs, ServerDefCmd, "/v1/servers/s1"))
+}
+
+func TestDeleteKVSFault(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+	kvs.InjectFaults()
+	assert.Equal(t, http.StatusInternalServerError, testDelete(t, kvs, ServerDefCmd, "/v1/servers/s1"))
+}
+
+func TestDeleteNotAllowedForSpawn(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+	assert.Equal(t, http.StatusMethodNotAllowed, testDelete(t, kvs, SpawnListenerDefCmd, "/v1/spawn-listener/"))
+	assert.Equal(t, http.StatusMethodNotAllowed, testDelete(t, kvs, SpawnKillerDefCmd, "/v1/spawn-killer/123"))
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//Actions available on the history of a definition, e.g. GET /v1/routes/r1/history lists the
+//revisions of route r1, and POST /v1/routes/r1/rollback?to=3 restores revision 3
+const (
+	historyAction  = "history"
+	rollbackAction = "rollback"
+)
+
+var errRevisionMissing = errors.New("Revision to roll back to not specified - expected to=revision query parameter")
+
+//historyCommand is implemented by the API commands for definitions with revision history
+type historyCommand interface {
+	definitionKind() string
+}
+
+func (ServerDef) definitionKind() string {
+	return config.ServerKind
+}
+
+func (BackendDef) definitionKind() string {
+	return config.BackendKind
+}
+
+func (RouteDef) definitionKind() string {
+	return config.RouteKind
+}
+
+func (ListenerDef) definitionKind() string {
+	return config.ListenerKind
+}
+
+//historyRequest determines if the request path addresses the history of a definition,
+//returning the definition name and the history action
+func historyRequest(uriRoot string, path string) (string, string, bool) {
+	if !strings.HasPrefix(path, uriRoot) {
+		return "", "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, uriRoot), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+
+	switch parts[1] {
+	case historyAction, rollbackAction:
+		return parts[0], parts[1], true
+	default:
+		return "", "", false
+	}
+}
+
+func historyEndpoint(kind, name, action string, kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	switch {
+	case action == historyAction && req.Method == "GET":
+		revisions, err := config.ListRevisions(kind, name, kvs)
+		if err != nil {
+			resp.WriteHeader(http.StatusInternalServerError)
+			return nil, err
+		}
+
+		if revisions == nil {
+			revisions = make([]*config.Revision, 0)
+		}
+
+		return revisions, nil
+
+	case action == rollbackAction && req.Method == "POST":
+		to, err := strconv.Atoi(req.URL.Query().Get("to"))
+		if err != nil || to <= 0 {
+			resp.WriteHeader(http.StatusBadRequest)
+			return nil, errRevisionMissing
+		}
+
+		err = config.Rollback(kind, name, to, kvs)
+		switch err {
+		case nil:
+		case config.ErrNoSuchRevision:
+			resp.WriteHeader(http.StatusNotFound)
+			return nil, err
+		default:
+			log.Warn("Error rolling back definition: ", err.Error())
+			resp.WriteHeader(http.StatusInternalServerError)
+			return nil, err
+		}
+
+		err = kvs.Flush()
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return nil, nil
+	}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRequest(t *testing.T) {
+	name, action, ok := historyRequest(routesURI, "/v1/routes/r1/history")
+	assert.True(t, ok)
+	assert.Equal(t, "r1", name)
+	assert.Equal(t, historyAction, action)
+
+	_, action, ok = historyRequest(routesURI, "/v1/routes/r1/rollback")
+	assert.True(t, ok)
+	assert.Equal(t, rollbackAction, action)
+
+	for _, path := range []string{"/v1/routes/r1", "/v1/routes/", "/v1/routes//history", "/v1/routes/r1/other", "/v1/servers/s1/history"} {
+		_, _, ok = historyRequest(routesURI, path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestHistoryAndRollbackEndpoints(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+	ts := httptest.NewServer(http.HandlerFunc(wrap(kvs, NewAPIService(RouteDefCmd))))
+	defer ts.Close()
+
+	request, _ := http.NewRequest("PUT", ts.URL+"/v1/routes/r1", strings.NewReader(`{"URIRoot":"/changed","Backends":["b1"]}`))
+	request.Header.Set(authorHeader, "bob")
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	response, err = http.Get(ts.URL + "/v1/routes/r1/history")
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	var revisions []*config.Revision
+	assert.Nil(t, json.Unmarshal(body, &revisions))
+	if assert.Equal(t, 2, len(revisions)) {
+		assert.Equal(t, "bob", revisions[1].Author)
+	}
+
+	post := func(uri string) int {
+		response, err := http.Post(fmt.Sprintf("%s%s", ts.URL, uri), "application/json", nil)
+		assert.Nil(t, err)
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post("/v1/routes/r1/rollback?to=1"))
+	r, _ := config.ReadRouteConfig("r1", kvs)
+	assert.Equal(t, "/r1", r.URIRoot)
+
+	assert.Equal(t, http.StatusNotFound, post("/v1/routes/r1/rollback?to=42"))
+	assert.Equal(t, http.StatusBadRequest, post("/v1/routes/r1/rollback"))
+	assert.Equal(t, http.StatusMethodNotAllowed, post("/v1/routes/r1/history"))
+}
+
+func TestRequestAuthor(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/v1/routes/r1", nil)
+	assert.Equal(t, "", requestAuthor(req))
+
+	req.SetBasicAuth("carol", "secret")
+	assert.Equal(t, "carol", requestAuthor(req))
+
+	req.Header.Set(authorHeader, "dave")
+	assert.Equal(t, "dave", requestAuthor(req))
+}
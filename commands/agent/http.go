@@ -47,6 +47,9 @@ func (a *Agent) registerHandlers() {
 
 	spawnKillAPIService := NewAPIService(SpawnKillerDefCmd)
 	a.addHandler(spawnKillURI, wrap(a.kvstore, spawnKillAPIService))
+
+	validateAPIService := NewAPIService(ValidateDefCmd)
+	a.addHandler(validateURI, wrap(a.kvstore, validateAPIService))
 }
 
 func wrap(kvs kvstore.KVStore, apiService *APIService) func(resp http.ResponseWriter, req *http.Request) {
@@ -43,7 +43,7 @@ func (ListenerDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter,
 		return nil, nil
 	}
 
-	listenerName := resourceIDFromURI(req.RequestURI)
+	listenerName := resourceIDFromURI(req.URL.Path)
 	log.Info(listenerName)
 	if listenerName == "" {
 		resp.WriteHeader(http.StatusNotFound)
@@ -61,10 +61,9 @@ func (ListenerDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter,
 	}
 
 	listenerConfig.Name = listenerName
-	err = listenerConfig.Store(kvs)
+	err = storeDefinition(kvs, resp, req, listenerConfig.Store, listenerConfig.StoreIfUnmodified)
 	if err != nil {
-		log.Warn("Error persisting listener definition")
-		resp.WriteHeader(http.StatusInternalServerError)
+		log.Warn("Error persisting listener definition: ", err.Error())
 		return nil, err
 	}
 
@@ -95,9 +94,9 @@ func (ListenerDef) GetDefinitionList(kvs kvstore.KVStore, resp http.ResponseWrit
 
 //GetDefinition retrieves a specific listener definition
 func (ListenerDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
-	listenerName := resourceIDFromURI(req.RequestURI)
+	listenerName := resourceIDFromURI(req.URL.Path)
 
-	listenerConfig, err := config.ReadListenerConfig(listenerName, kvs)
+	listenerConfig, index, err := config.ReadListenerConfigWithIndex(listenerName, kvs)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
 		return nil, err
@@ -108,6 +107,8 @@ func (ListenerDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter,
 		return nil, errListenerNotFound
 	}
 
+	setIndexHeader(resp, index)
+
 	return listenerConfig, err
 
 }
@@ -117,3 +118,26 @@ func (ListenerDef) DoPost(kvs kvstore.KVStore, resp http.ResponseWriter, req *ht
 	resp.WriteHeader(http.StatusMethodNotAllowed)
 	return nil, nil
 }
+
+//DeleteDefinition removes a listener definition
+func (ListenerDef) DeleteDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	listenerName := resourceIDFromURI(req.URL.Path)
+	if listenerName == "" {
+		resp.WriteHeader(http.StatusNotFound)
+		return nil, errListenerResourceMissing
+	}
+
+	err := config.DeleteListenerConfig(listenerName, kvs)
+	if err != nil {
+		log.Warn("Error deleting listener definition: ", err.Error())
+		writeDeleteErrorStatus(resp, err)
+		return nil, err
+	}
+
+	err = kvs.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
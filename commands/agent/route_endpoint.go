@@ -43,7 +43,7 @@ func (RouteDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req
 		return nil, nil
 	}
 
-	routeName := resourceIDFromURI(req.RequestURI)
+	routeName := resourceIDFromURI(req.URL.Path)
 	log.Info(routeName)
 	if routeName == "" {
 		resp.WriteHeader(http.StatusNotFound)
@@ -59,10 +59,9 @@ func (RouteDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req
 	}
 
 	routeConfig.Name = routeName
-	err = routeConfig.Store(kvs)
+	err = storeDefinition(kvs, resp, req, routeConfig.Store, routeConfig.StoreIfUnmodified)
 	if err != nil {
-		log.Warn("Error persisting route definition")
-		resp.WriteHeader(http.StatusInternalServerError)
+		log.Warn("Error persisting route definition: ", err.Error())
 		return nil, err
 	}
 
@@ -93,9 +92,9 @@ func (RouteDef) GetDefinitionList(kvs kvstore.KVStore, resp http.ResponseWriter,
 
 //GetDefinition retrieves a specific route definition
 func (RouteDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
-	routeName := resourceIDFromURI(req.RequestURI)
+	routeName := resourceIDFromURI(req.URL.Path)
 
-	routeConfig, err := config.ReadRouteConfig(routeName, kvs)
+	routeConfig, index, err := config.ReadRouteConfigWithIndex(routeName, kvs)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
 		return nil, err
@@ -106,6 +105,8 @@ func (RouteDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req
 		return nil, errRouteNotFound
 	}
 
+	setIndexHeader(resp, index)
+
 	return routeConfig, err
 
 }
@@ -115,3 +116,27 @@ func (RouteDef) DoPost(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.
 	resp.WriteHeader(http.StatusMethodNotAllowed)
 	return nil, nil
 }
+
+//DeleteDefinition removes a route definition. Definitions still referenced by listener
+//definitions are only removed if cascade=true is given as a query parameter.
+func (RouteDef) DeleteDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	routeName := resourceIDFromURI(req.URL.Path)
+	if routeName == "" {
+		resp.WriteHeader(http.StatusNotFound)
+		return nil, errRouteResourceMissing
+	}
+
+	err := config.DeleteRouteConfig(routeName, kvs, cascadeRequested(req))
+	if err != nil {
+		log.Warn("Error deleting route definition: ", err.Error())
+		writeDeleteErrorStatus(resp, err)
+		return nil, err
+	}
+
+	err = kvs.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
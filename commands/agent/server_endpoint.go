@@ -7,6 +7,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/config"
 	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/loadbalancer"
 	"io/ioutil"
 	"net/http"
 )
@@ -20,6 +21,7 @@ const (
 var (
 	errServerNotFound         = errors.New("Server definition not found")
 	errServiceResourceMissing = errors.New("Server resource not present in url - expected /v1/servers/server-resource")
+	errExecHealthCheck        = errors.New("Servers with exec health checks cannot be defined using the API - use xavi add-server")
 )
 
 //ServerDefCmd is the ServerDef instance used to expose as an API endpoint.
@@ -44,7 +46,7 @@ func (ServerDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, re
 		return nil, nil
 	}
 
-	serverName := resourceIDFromURI(req.RequestURI)
+	serverName := resourceIDFromURI(req.URL.Path)
 	log.Info(serverName)
 	if serverName == "" {
 		resp.WriteHeader(http.StatusNotFound)
@@ -60,10 +62,21 @@ func (ServerDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, re
 	}
 
 	serverConfig.Name = serverName
-	err = serverConfig.Store(kvs)
+	if serverConfig.HealthCheck == "exec" {
+		log.Warn("Rejecting server definition with an exec health check")
+		resp.WriteHeader(http.StatusBadRequest)
+		return nil, errExecHealthCheck
+	}
+
+	if err := loadbalancer.ValidateHealthCheckSpec(serverConfig); err != nil {
+		log.Warn("Invalid health check in server definition: ", err.Error())
+		resp.WriteHeader(http.StatusBadRequest)
+		return nil, err
+	}
+
+	err = storeDefinition(kvs, resp, req, serverConfig.Store, serverConfig.StoreIfUnmodified)
 	if err != nil {
-		log.Warn("Error persisting server definition")
-		resp.WriteHeader(http.StatusInternalServerError)
+		log.Warn("Error persisting server definition: ", err.Error())
 		return nil, err
 	}
 
@@ -94,9 +107,9 @@ func (ServerDef) GetDefinitionList(kvs kvstore.KVStore, resp http.ResponseWriter
 
 //GetDefinition retrieves a specific server definition
 func (ServerDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
-	serverName := resourceIDFromURI(req.RequestURI)
+	serverName := resourceIDFromURI(req.URL.Path)
 
-	serverConfig, err := config.ReadServerConfig(serverName, kvs)
+	serverConfig, index, err := config.ReadServerConfigWithIndex(serverName, kvs)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
 		return nil, err
@@ -107,6 +120,8 @@ func (ServerDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, re
 		return nil, errServerNotFound
 	}
 
+	setIndexHeader(resp, index)
+
 	return serverConfig, err
 
 }
@@ -116,3 +131,27 @@ func (ServerDef) DoPost(kvs kvstore.KVStore, resp http.ResponseWriter, req *http
 	resp.WriteHeader(http.StatusMethodNotAllowed)
 	return nil, nil
 }
+
+//DeleteDefinition removes a server definition. Definitions still referenced by backend
+//definitions are only removed if cascade=true is given as a query parameter.
+func (ServerDef) DeleteDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	serverName := resourceIDFromURI(req.URL.Path)
+	if serverName == "" {
+		resp.WriteHeader(http.StatusNotFound)
+		return nil, errServiceResourceMissing
+	}
+
+	err := config.DeleteServerConfig(serverName, kvs, cascadeRequested(req))
+	if err != nil {
+		log.Warn("Error deleting server definition: ", err.Error())
+		writeDeleteErrorStatus(resp, err)
+		return nil, err
+	}
+
+	err = kvs.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
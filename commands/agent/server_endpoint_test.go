@@ -240,3 +240,73 @@ func TestServerNotFound(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 404, res.StatusCode)
 }
+
+func TestServerPutHealthCheckSpec(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(wrappedFn))
+	defer ts.Close()
+
+	testPayload := `{"Address":"localhost","Port":9876,"PingURI":"/status","HealthCheck":"http-get",
+"HealthCheckInterval":30,"HealthCheckTimeout":10,"HealthCheckMethod":"HEAD","HealthCheckHeaders":{"X-Probe":"1"},
+"HealthCheckStatus":"200-299","HealthCheckMatch":"ok","HealthCheckRise":2,"HealthCheckFall":3}`
+
+	testURL := fmt.Sprintf("%s/v1/servers/spec-server", ts.URL)
+	request, err := http.NewRequest("PUT", testURL, strings.NewReader(testPayload))
+	assert.Nil(t, err)
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	res, err := http.Get(testURL)
+	assert.Nil(t, err)
+	rs, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Nil(t, err)
+
+	expected := `{"Name":"spec-server","Address":"localhost","Port":9876,"PingURI":"/status","HealthCheck":"http-get",` +
+		`"HealthCheckInterval":30,"HealthCheckTimeout":10,"HealthCheckMethod":"HEAD","HealthCheckHeaders":{"X-Probe":"1"},` +
+		`"HealthCheckStatus":"200-299","HealthCheckMatch":"ok","HealthCheckRise":2,"HealthCheckFall":3}`
+	assert.Equal(t, expected, string(rs))
+}
+
+func TestServerPutInvalidHealthCheckSpec(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(wrappedFn))
+	defer ts.Close()
+
+	for _, payload := range []string{
+		`{"Address":"localhost","Port":9876,"HealthCheck":"http-get","HealthCheckMatch":"regex:("}`,
+		`{"Address":"localhost","Port":9876,"HealthCheck":"http-get","HealthCheckStatus":"2xx"}`,
+		`{"Address":"localhost","Port":9876,"HealthCheck":"http-get","HealthCheckRise":-1}`,
+	} {
+		testURL := fmt.Sprintf("%s/v1/servers/bad-spec-server", ts.URL)
+		request, err := http.NewRequest("PUT", testURL, strings.NewReader(payload))
+		assert.Nil(t, err)
+		response, err := http.DefaultClient.Do(request)
+		if assert.Nil(t, err) {
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, payload)
+		}
+
+		res, err := http.Get(testURL)
+		if assert.Nil(t, err) {
+			assert.Equal(t, http.StatusNotFound, res.StatusCode, "Invalid definitions are not stored")
+		}
+	}
+}
+
+func TestServerPutExecHealthCheckRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(wrappedFn))
+	defer ts.Close()
+
+	testURL := fmt.Sprintf("%s/v1/servers/exec-server", ts.URL)
+	payload := `{"Address":"localhost","Port":9876,"HealthCheck":"exec","HealthCheckCommand":"/bin/true"}`
+	request, err := http.NewRequest("PUT", testURL, strings.NewReader(payload))
+	assert.Nil(t, err)
+	response, err := http.DefaultClient.Do(request)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	}
+
+	res, err := http.Get(testURL)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	}
+}
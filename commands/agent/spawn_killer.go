@@ -43,6 +43,12 @@ func (SpawnKillerDef) GetDefinitionList(kvs kvstore.KVStore, resp http.ResponseW
 	return nil, nil
 }
 
+//DeleteDefinition is not implemented for spawn killer
+func (SpawnKillerDef) DeleteDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	resp.WriteHeader(http.StatusMethodNotAllowed)
+	return nil, nil
+}
+
 //DoPost handles post requests, which are used to spawn new listener instances. This service is intended to
 //support testability, and will likely not be exposed in production configurations.
 func (SpawnKillerDef) DoPost(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
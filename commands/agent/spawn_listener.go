@@ -80,6 +80,12 @@ func (SpawnListenerDef) GetDefinitionList(kvs kvstore.KVStore, resp http.Respons
 	return nil, nil
 }
 
+//DeleteDefinition is not provided for spawning
+func (SpawnListenerDef) DeleteDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	resp.WriteHeader(http.StatusMethodNotAllowed)
+	return nil, nil
+}
+
 //DoPost spawns the xavi listener as specified by the payload
 func (SpawnListenerDef) DoPost(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	body, err := ioutil.ReadAll(req.Body)
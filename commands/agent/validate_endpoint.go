@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/service"
+	"net/http"
+)
+
+const (
+	validateURI = "/v1/validate/"
+)
+
+//ValidateDefCmd is the ValidateDef instance used to expose configuration validation as an
+//API endpoint
+var ValidateDefCmd ValidateDef
+
+//ValidateDef is used to hang the ApiCommand functions needed to validate the stored
+//definitions via a REST API. GET /v1/validate/ validates all definitions, and
+//GET /v1/validate/<listener> the named listener and the definitions it uses.
+type ValidateDef struct{}
+
+//GetURIRoot returns the URI root used to validate definitions
+func (ValidateDef) GetURIRoot() string {
+	return validateURI
+}
+
+//PutDefinition is not implemented for validation
+func (ValidateDef) PutDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	resp.WriteHeader(http.StatusMethodNotAllowed)
+	return nil, nil
+}
+
+//GetDefinition validates the named listener and the definitions it uses
+func (ValidateDef) GetDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return validate(resourceIDFromURI(req.URL.Path), kvs, resp)
+}
+
+//GetDefinitionList validates all definitions
+func (ValidateDef) GetDefinitionList(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return validate("", kvs, resp)
+}
+
+//DeleteDefinition is not implemented for validation
+func (ValidateDef) DeleteDefinition(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	resp.WriteHeader(http.StatusMethodNotAllowed)
+	return nil, nil
+}
+
+//DoPost is not implemented for validation
+func (ValidateDef) DoPost(kvs kvstore.KVStore, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	resp.WriteHeader(http.StatusMethodNotAllowed)
+	return nil, nil
+}
+
+func validate(listenerName string, kvs kvstore.KVStore, resp http.ResponseWriter) (interface{}, error) {
+	report, err := service.ValidateStore(listenerName, kvs)
+	if err != nil {
+		resp.WriteHeader(http.StatusInternalServerError)
+		return nil, err
+	}
+
+	return report, nil
+}
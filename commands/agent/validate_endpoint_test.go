@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/service"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testValidateRequest(t *testing.T, kvs kvstore.KVStore, method, uri string) (int, *service.ValidationReport) {
+	ts := httptest.NewServer(http.HandlerFunc(wrap(kvs, NewAPIService(ValidateDefCmd))))
+	defer ts.Close()
+
+	request, _ := http.NewRequest(method, ts.URL+uri, nil)
+	response, err := http.DefaultClient.Do(request)
+	if !assert.Nil(t, err) {
+		return 0, nil
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return response.StatusCode, nil
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+	report := new(service.ValidationReport)
+	assert.Nil(t, json.Unmarshal(body, report))
+	return response.StatusCode, report
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	kvs := testMakeDeleteKVStore(t)
+
+	status, report := testValidateRequest(t, kvs, "GET", "/v1/validate/")
+	assert.Equal(t, http.StatusOK, status)
+	if assert.NotNil(t, report) {
+		assert.True(t, report.Valid)
+		assert.Equal(t, 0, len(report.Problems))
+	}
+
+	(&config.ListenerConfig{Name: "l2", RouteNames: []string{"r2"}}).Store(kvs)
+
+	status, report = testValidateRequest(t, kvs, "GET", "/v1/validate/l2")
+	assert.Equal(t, http.StatusOK, status)
+	if assert.NotNil(t, report) {
+		assert.False(t, report.Valid)
+		assert.Equal(t, "l2", report.Listener)
+		if assert.Equal(t, 1, len(report.Problems)) {
+			assert.Equal(t, "listener", report.Problems[0].Kind)
+			assert.Equal(t, "l2", report.Problems[0].Name)
+		}
+	}
+
+	status, report = testValidateRequest(t, kvs, "GET", "/v1/validate/l1")
+	assert.Equal(t, http.StatusOK, status)
+	if assert.NotNil(t, report) {
+		assert.True(t, report.Valid)
+	}
+
+	status, _ = testValidateRequest(t, kvs, "PUT", "/v1/validate/l1")
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/service"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//Apply command
+type Apply struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the Apply command
+func (a *Apply) Help() string {
+	helpText := `
+	Usage: xavi apply -f <file>
+
+		Validates and stores the server, backend, route and listener definitions in
+		the file, for example a complete listener tree as written by xavi export.
+		Nothing is stored if the definitions would introduce problems reported by
+		xavi validate. Definitions already stored with the same values are left
+		unchanged. The changes are stored in one transaction if they fit in one,
+		otherwise in several, storing servers, backends, routes then listeners.
+
+	Options:
+		-f File containing the definitions, in YAML or - for .json files - JSON.
+		   Use - to read YAML or JSON from standard input.
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+func readDefinitionsFile(filename string) (*config.Definitions, error) {
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return config.ParseDefinitions(data, config.FormatForFile(filename))
+}
+
+//checkDefinitions validates the configuration that would result from applying the
+//definitions, returning an error listing any problems the definitions would introduce
+func checkDefinitions(defs *config.Definitions, kvs kvstore.KVStore) error {
+	if err := defs.Validate(kvs); err != nil {
+		return err
+	}
+
+	stored, err := config.ExportDefinitions("", kvs)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for _, p := range service.ValidateDefinitions(stored, "").Errors() {
+		existing[p.String()] = true
+	}
+
+	var problems []string
+	for _, p := range service.ValidateDefinitions(stored.Overlay(defs), "").Errors() {
+		if !existing[p.String()] {
+			problems = append(problems, p.String())
+		}
+	}
+
+	if len(problems) > 0 {
+		return &config.ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+//Run executes the Apply command with the given arguments
+func (a *Apply) Run(args []string) int {
+	var filename string
+	cmdFlags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	cmdFlags.Usage = func() { a.UI.Output(a.Help()) }
+	cmdFlags.StringVar(&filename, "f", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if filename == "" {
+		a.UI.Error("A file must be specified")
+		a.UI.Error("")
+		a.UI.Error(a.Help())
+		return 1
+	}
+
+	defs, err := readDefinitionsFile(filename)
+	if err != nil {
+		a.UI.Error(fmt.Sprintf("Error reading %s: %s", filename, err.Error()))
+		return 1
+	}
+
+	if err := checkDefinitions(defs, a.KVStore); err != nil {
+		a.UI.Error(err.Error())
+		return 1
+	}
+
+	results, err := defs.Apply(a.KVStore)
+	transactions := 0
+	for _, r := range results {
+		a.UI.Output(fmt.Sprintf("%s %s %s", r.Kind, r.Name, r.Action))
+		if r.Transaction > transactions {
+			transactions = r.Transaction
+		}
+	}
+
+	if transactions > 1 {
+		a.UI.Warn(fmt.Sprintf("The changes were too many for one KV store transaction and were applied in %d "+
+			"transactions, so the apply was not atomic", transactions))
+	}
+
+	if err != nil {
+		a.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := a.KVStore.Flush(); err != nil {
+		a.UI.Error(err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+//Synopsis gives the synopsis of the Apply command
+func (a *Apply) Synopsis() string {
+	return "Apply definitions from a YAML or JSON file"
+}
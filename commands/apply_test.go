@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testWriteDefinitionsFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "xavi-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func testMakeApply(kvs kvstore.KVStore) (*bytes.Buffer, *Apply) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &Apply{UI: ui, KVStore: kvs}
+}
+
+func testMakeExport(kvs kvstore.KVStore) (*bytes.Buffer, *Export) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &Export{UI: ui, KVStore: kvs}
+}
+
+const testApplyJSON = `{
+  "Servers": [{"Name": "s1", "Address": "localhost", "Port": 3000, "HealthCheck": "none"}],
+  "Backends": [{"Name": "b1", "ServerNames": ["s1"]}],
+  "Routes": [{"Name": "r1", "URIRoot": "/one", "Backends": ["b1"]}],
+  "Listeners": [{"Name": "l1", "RouteNames": ["r1"]}]
+}`
+
+func TestApplyThenExport(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	filename, cleanup := testWriteDefinitionsFile(t, "gateway.json", testApplyJSON)
+	defer cleanup()
+
+	writer, apply := testMakeApply(kvs)
+	status := apply.Run([]string{"-f", filename})
+	assert.Equal(t, 0, status, writer.String())
+	assert.Contains(t, writer.String(), "server s1 created")
+	assert.Contains(t, writer.String(), "listener l1 created")
+
+	writer, apply = testMakeApply(kvs)
+	assert.Equal(t, 0, apply.Run([]string{"-f", filename}))
+	assert.Contains(t, writer.String(), "route r1 unchanged")
+
+	writer, export := testMakeExport(kvs)
+	assert.Equal(t, 0, export.Run([]string{"-listener", "l1"}))
+
+	//The exported YAML applies cleanly to another store
+	exported, cleanupExport := testWriteDefinitionsFile(t, "gateway.yaml", writer.String())
+	defer cleanupExport()
+
+	otherKVS, _ := kvstore.NewHashKVStore("")
+	writer, apply = testMakeApply(otherKVS)
+	assert.Equal(t, 0, apply.Run([]string{"-f", exported}), writer.String())
+
+	l, _ := config.ReadListenerConfig("l1", otherKVS)
+	if assert.NotNil(t, l) {
+		assert.Equal(t, []string{"r1"}, l.RouteNames)
+	}
+
+	writer, export = testMakeExport(kvs)
+	assert.Equal(t, 0, export.Run([]string{"-format", "json"}))
+	assert.Contains(t, writer.String(), `"Name": "s1"`)
+}
+
+func TestApplyInvalidDefinitions(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	filename, cleanup := testWriteDefinitionsFile(t, "gateway.yaml", `
+servers:
+- name: s1
+  address: localhost
+  port: 3000
+  healthcheck: smoke-signals
+backends:
+- name: b1
+  servernames: [s1]
+routes:
+- name: r1
+  uriroot: /one
+  backends: [b1]
+  plugins: [no-such-plugin]
+`)
+	defer cleanup()
+
+	writer, apply := testMakeApply(kvs)
+	assert.Equal(t, 1, apply.Run([]string{"-f", filename}))
+	assert.Contains(t, writer.String(), "smoke-signals")
+	assert.Contains(t, writer.String(), "no-such-plugin")
+
+	s, _ := config.ReadServerConfig("s1", kvs)
+	assert.Nil(t, s, "Nothing should be stored when the definitions are invalid")
+
+	filename, cleanup = testWriteDefinitionsFile(t, "dangling.yaml", "routes:\n- name: r1\n  uriroot: /one\n  backends: [b1]\n")
+	defer cleanup()
+
+	writer, apply = testMakeApply(kvs)
+	assert.Equal(t, 1, apply.Run([]string{"-f", filename}))
+	assert.Contains(t, writer.String(), "route r1 references backend b1, which is not defined")
+}
+
+func TestApplyExportBadArgs(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	_, apply := testMakeApply(kvs)
+	assert.Equal(t, 1, apply.Run([]string{}))
+	assert.Equal(t, 1, apply.Run([]string{"-f", "/no/such/gateway.yaml"}))
+	assert.NotEmpty(t, apply.Help())
+	assert.NotEmpty(t, apply.Synopsis())
+
+	_, export := testMakeExport(kvs)
+	assert.Equal(t, 1, export.Run([]string{"-listener", "nope"}))
+	assert.Equal(t, 1, export.Run([]string{"-format", "xml"}))
+	assert.NotEmpty(t, export.Help())
+	assert.NotEmpty(t, export.Synopsis())
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"flag"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//DeleteBackend command
+type DeleteBackend struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the DeleteBackend command
+func (db *DeleteBackend) Help() string {
+	helpText := `
+	Usage: xavi delete-backend [options]
+
+		Deletes a backend definition. The delete is refused if the backend is still referenced
+		by route definitions unless -cascade is given.
+
+	Options:
+		-name Name of the backend to delete
+		-cascade Remove the backend from the route definitions that reference it. Routes
+			left with no backends are deleted, along with listeners left with no routes
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the DeleteBackend command with the given arguments
+func (db *DeleteBackend) Run(args []string) int {
+	var name string
+	var cascade bool
+	cmdFlags := flag.NewFlagSet("delete-backend", flag.ContinueOnError)
+	cmdFlags.Usage = func() { db.UI.Output(db.Help()) }
+	cmdFlags.StringVar(&name, "name", "", "")
+	cmdFlags.BoolVar(&cascade, "cascade", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if name == "" {
+		db.UI.Error("Name must be specified")
+		db.UI.Error("")
+		db.UI.Error(db.Help())
+		return 1
+	}
+
+	if err := config.DeleteBackendConfig(name, db.KVStore, cascade); err != nil {
+		db.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := db.KVStore.Flush(); err != nil {
+		db.UI.Error(err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+//Synopsis gives the synopsis of the DeleteBackend command
+func (db *DeleteBackend) Synopsis() string {
+	return "Delete a backend definition"
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+	"testing"
+)
+
+func testMakeDeleteBackend() (*bytes.Buffer, *DeleteBackend) {
+	var kvs, _ = kvstore.NewHashKVStore("")
+
+	b := &config.BackendConfig{Name: "b1", ServerNames: []string{"s1"}}
+	b.Store(kvs)
+	r := &config.RouteConfig{Name: "r1", URIRoot: "/r1", Backends: []string{"b1"}}
+	r.Store(kvs)
+	l := &config.ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}
+	l.Store(kvs)
+
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	var deleteBackend = &DeleteBackend{
+		UI:      ui,
+		KVStore: kvs,
+	}
+
+	return writer, deleteBackend
+}
+
+func TestDeleteBackendReferenced(t *testing.T) {
+	writer, deleteBackend := testMakeDeleteBackend()
+	assert.NotEmpty(t, deleteBackend.Synopsis())
+
+	status := deleteBackend.Run([]string{"-name", "b1"})
+	assert.Equal(t, 1, status)
+	assert.True(t, strings.Contains(writer.String(), "routes/r1"))
+}
+
+func TestDeleteBackendCascade(t *testing.T) {
+	_, deleteBackend := testMakeDeleteBackend()
+	status := deleteBackend.Run([]string{"-name", "b1", "-cascade"})
+	assert.Equal(t, 0, status)
+
+	r, _ := config.ReadRouteConfig("r1", deleteBackend.KVStore)
+	assert.Nil(t, r)
+	l, _ := config.ReadListenerConfig("l1", deleteBackend.KVStore)
+	assert.Nil(t, l)
+}
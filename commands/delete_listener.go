@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"flag"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//DeleteListener command
+type DeleteListener struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the DeleteListener command
+func (dl *DeleteListener) Help() string {
+	helpText := `
+	Usage: xavi delete-listener [options]
+
+		Deletes a listener definition.
+
+	Options:
+		-name Name of the listener to delete
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the DeleteListener command with the given arguments
+func (dl *DeleteListener) Run(args []string) int {
+	var name string
+	cmdFlags := flag.NewFlagSet("delete-listener", flag.ContinueOnError)
+	cmdFlags.Usage = func() { dl.UI.Output(dl.Help()) }
+	cmdFlags.StringVar(&name, "name", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if name == "" {
+		dl.UI.Error("Name must be specified")
+		dl.UI.Error("")
+		dl.UI.Error(dl.Help())
+		return 1
+	}
+
+	if err := config.DeleteListenerConfig(name, dl.KVStore); err != nil {
+		dl.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := dl.KVStore.Flush(); err != nil {
+		dl.UI.Error(err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+//Synopsis gives the synopsis of the DeleteListener command
+func (dl *DeleteListener) Synopsis() string {
+	return "Delete a listener definition"
+}
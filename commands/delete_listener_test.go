@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"testing"
+)
+
+func testMakeDeleteListener() (*bytes.Buffer, *DeleteListener) {
+	var kvs, _ = kvstore.NewHashKVStore("")
+
+	l := &config.ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}
+	l.Store(kvs)
+
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	var deleteListener = &DeleteListener{
+		UI:      ui,
+		KVStore: kvs,
+	}
+
+	return writer, deleteListener
+}
+
+func TestDeleteListener(t *testing.T) {
+	_, deleteListener := testMakeDeleteListener()
+	assert.NotEmpty(t, deleteListener.Synopsis())
+
+	status := deleteListener.Run([]string{"-name", "l1"})
+	assert.Equal(t, 0, status)
+
+	l, _ := config.ReadListenerConfig("l1", deleteListener.KVStore)
+	assert.Nil(t, l)
+}
+
+func TestDeleteListenerNotFound(t *testing.T) {
+	_, deleteListener := testMakeDeleteListener()
+	status := deleteListener.Run([]string{"-name", "nope"})
+	assert.Equal(t, 1, status)
+}
+
+func TestDeleteListenerMissingName(t *testing.T) {
+	_, deleteListener := testMakeDeleteListener()
+	var args []string
+	status := deleteListener.Run(args)
+	assert.Equal(t, 1, status)
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"flag"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//DeleteRoute command
+type DeleteRoute struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the DeleteRoute command
+func (dr *DeleteRoute) Help() string {
+	helpText := `
+	Usage: xavi delete-route [options]
+
+		Deletes a route definition. The delete is refused if the route is still referenced
+		by listener definitions unless -cascade is given.
+
+	Options:
+		-name Name of the route to delete
+		-cascade Remove the route from the listener definitions that reference it. Listeners
+			left with no routes are deleted
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the DeleteRoute command with the given arguments
+func (dr *DeleteRoute) Run(args []string) int {
+	var name string
+	var cascade bool
+	cmdFlags := flag.NewFlagSet("delete-route", flag.ContinueOnError)
+	cmdFlags.Usage = func() { dr.UI.Output(dr.Help()) }
+	cmdFlags.StringVar(&name, "name", "", "")
+	cmdFlags.BoolVar(&cascade, "cascade", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if name == "" {
+		dr.UI.Error("Name must be specified")
+		dr.UI.Error("")
+		dr.UI.Error(dr.Help())
+		return 1
+	}
+
+	if err := config.DeleteRouteConfig(name, dr.KVStore, cascade); err != nil {
+		dr.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := dr.KVStore.Flush(); err != nil {
+		dr.UI.Error(err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+//Synopsis gives the synopsis of the DeleteRoute command
+func (dr *DeleteRoute) Synopsis() string {
+	return "Delete a route definition"
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+	"testing"
+)
+
+func testMakeDeleteRoute() (*bytes.Buffer, *DeleteRoute) {
+	var kvs, _ = kvstore.NewHashKVStore("")
+
+	r := &config.RouteConfig{Name: "r1", URIRoot: "/r1", Backends: []string{"b1"}}
+	r.Store(kvs)
+	l := &config.ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}
+	l.Store(kvs)
+
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	var deleteRoute = &DeleteRoute{
+		UI:      ui,
+		KVStore: kvs,
+	}
+
+	return writer, deleteRoute
+}
+
+func TestDeleteRouteReferenced(t *testing.T) {
+	writer, deleteRoute := testMakeDeleteRoute()
+	assert.NotEmpty(t, deleteRoute.Synopsis())
+
+	status := deleteRoute.Run([]string{"-name", "r1"})
+	assert.Equal(t, 1, status)
+	assert.True(t, strings.Contains(writer.String(), "listeners/l1"))
+}
+
+func TestDeleteRouteCascade(t *testing.T) {
+	_, deleteRoute := testMakeDeleteRoute()
+	status := deleteRoute.Run([]string{"-name", "r1", "-cascade"})
+	assert.Equal(t, 0, status)
+
+	r, _ := config.ReadRouteConfig("r1", deleteRoute.KVStore)
+	assert.Nil(t, r)
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"flag"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//DeleteServer command
+type DeleteServer struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the DeleteServer command
+func (ds *DeleteServer) Help() string {
+	helpText := `
+	Usage: xavi delete-server [options]
+
+		Deletes a server definition. The delete is refused if the server is still referenced
+		by backend definitions unless -cascade is given.
+
+	Options:
+		-name Name of the server to delete
+		-cascade Remove the server from the backend definitions that reference it. Backends
+			left with no servers are deleted, along with anything left empty in turn
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the DeleteServer command with the given arguments
+func (ds *DeleteServer) Run(args []string) int {
+	var name string
+	var cascade bool
+	cmdFlags := flag.NewFlagSet("delete-server", flag.ContinueOnError)
+	cmdFlags.Usage = func() { ds.UI.Output(ds.Help()) }
+	cmdFlags.StringVar(&name, "name", "", "")
+	cmdFlags.BoolVar(&cascade, "cascade", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if name == "" {
+		ds.UI.Error("Name must be specified")
+		ds.UI.Error("")
+		ds.UI.Error(ds.Help())
+		return 1
+	}
+
+	if err := config.DeleteServerConfig(name, ds.KVStore, cascade); err != nil {
+		ds.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := ds.KVStore.Flush(); err != nil {
+		ds.UI.Error(err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+//Synopsis gives the synopsis of the DeleteServer command
+func (ds *DeleteServer) Synopsis() string {
+	return "Delete a server definition"
+}
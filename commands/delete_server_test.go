@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+	"testing"
+)
+
+func testMakeDeleteServer(faultyStore bool) (*bytes.Buffer, *DeleteServer) {
+	var kvs, _ = kvstore.NewHashKVStore("")
+
+	s := &config.ServerConfig{Name: "s1", Address: "localhost", Port: 3000, HealthCheck: "none"}
+	s.Store(kvs)
+	b := &config.BackendConfig{Name: "b1", ServerNames: []string{"s1"}}
+	b.Store(kvs)
+
+	if faultyStore {
+		kvs.InjectFaults()
+	}
+
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	var deleteServer = &DeleteServer{
+		UI:      ui,
+		KVStore: kvs,
+	}
+
+	return writer, deleteServer
+}
+
+func TestDeleteServerSynopsisAndHelp(t *testing.T) {
+	_, deleteServer := testMakeDeleteServer(false)
+	assert.NotEmpty(t, deleteServer.Synopsis())
+	assert.NotEmpty(t, deleteServer.Help())
+}
+
+func TestDeleteServerMissingName(t *testing.T) {
+	writer, deleteServer := testMakeDeleteServer(false)
+	var args []string
+	status := deleteServer.Run(args)
+	assert.Equal(t, 1, status)
+	assert.True(t, strings.Contains(writer.String(), deleteServer.Help()))
+}
+
+func TestDeleteServerParseArgsError(t *testing.T) {
+	_, deleteServer := testMakeDeleteServer(false)
+	args := []string{"-foofest"}
+	status := deleteServer.Run(args)
+	assert.Equal(t, 1, status)
+}
+
+func TestDeleteServerReferenced(t *testing.T) {
+	writer, deleteServer := testMakeDeleteServer(false)
+	args := []string{"-name", "s1"}
+	status := deleteServer.Run(args)
+	assert.Equal(t, 1, status)
+	assert.True(t, strings.Contains(writer.String(), "backends/b1"))
+
+	s, _ := config.ReadServerConfig("s1", deleteServer.KVStore)
+	assert.NotNil(t, s)
+}
+
+func TestDeleteServerCascade(t *testing.T) {
+	_, deleteServer := testMakeDeleteServer(false)
+	args := []string{"-name", "s1", "-cascade"}
+	status := deleteServer.Run(args)
+	assert.Equal(t, 0, status)
+
+	s, _ := config.ReadServerConfig("s1", deleteServer.KVStore)
+	assert.Nil(t, s)
+	b, _ := config.ReadBackendConfig("b1", deleteServer.KVStore)
+	assert.Nil(t, b)
+}
+
+func TestDeleteServerFaultyStore(t *testing.T) {
+	_, deleteServer := testMakeDeleteServer(true)
+	args := []string{"-name", "s1"}
+	status := deleteServer.Run(args)
+	assert.Equal(t, 1, status)
+}
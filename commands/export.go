@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"flag"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//Export command
+type Export struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the Export command
+func (e *Export) Help() string {
+	helpText := `
+	Usage: xavi export [-listener <name>] [-format yaml|json]
+
+		Writes definitions in the format read by xavi apply. All definitions are
+		exported unless a listener is specified, in which case the listener and the
+		routes, backends and servers it uses are exported.
+
+	Options:
+		-listener Listener to export
+		-format Output format, yaml (the default) or json
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the Export command with the given arguments
+func (e *Export) Run(args []string) int {
+	var listener, format string
+	cmdFlags := flag.NewFlagSet("export", flag.ContinueOnError)
+	cmdFlags.Usage = func() { e.UI.Output(e.Help()) }
+	cmdFlags.StringVar(&listener, "listener", "", "")
+	cmdFlags.StringVar(&format, "format", config.FormatYAML, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	defs, err := config.ExportDefinitions(listener, e.KVStore)
+	if err != nil {
+		e.UI.Error(err.Error())
+		return 1
+	}
+
+	out, err := defs.Marshal(format)
+	if err != nil {
+		e.UI.Error(err.Error())
+		return 1
+	}
+
+	e.UI.Output(strings.TrimSpace(string(out)))
+	return 0
+}
+
+//Synopsis gives the synopsis of the Export command
+func (e *Export) Synopsis() string {
+	return "Export definitions as YAML or JSON"
+}
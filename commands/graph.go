@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"flag"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//Graph command
+type Graph struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the Graph command
+func (g *Graph) Help() string {
+	helpText := `
+	Usage: xavi graph [-listener <name>] [-format dot|mermaid|json]
+
+		Renders the topology of the stored listeners, and the routes, backends and
+		servers they use, as a Graphviz DOT digraph, a Mermaid flowchart or JSON.
+		Each definition is shown with its settings: route URI roots, plugins and
+		MsgProps guards, backend load balancer policies and server addresses and
+		health checks. Secret references are shown as is.
+
+	Options:
+		-listener Only render the listener and the definitions it uses
+		-format Output format, dot (the default), mermaid or json
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the Graph command with the given arguments
+func (g *Graph) Run(args []string) int {
+	var listener, format string
+	cmdFlags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	cmdFlags.Usage = func() { g.UI.Output(g.Help()) }
+	cmdFlags.StringVar(&listener, "listener", "", "")
+	cmdFlags.StringVar(&format, "format", config.GraphFormatDOT, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	graph, err := config.ReadGraph(listener, g.KVStore)
+	if err != nil {
+		g.UI.Error(err.Error())
+		return 1
+	}
+
+	out, err := graph.Render(format)
+	if err != nil {
+		g.UI.Error(err.Error())
+		return 1
+	}
+
+	g.UI.Output(strings.TrimSpace(string(out)))
+	return 0
+}
+
+//Synopsis gives the synopsis of the Graph command
+func (g *Graph) Synopsis() string {
+	return "Render the listener topology as a graph"
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"testing"
+)
+
+func testMakeGraph(kvs kvstore.KVStore) (*bytes.Buffer, *Graph) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &Graph{UI: ui, KVStore: kvs}
+}
+
+func TestGraph(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	defs, _ := config.ParseDefinitions([]byte(testApplyJSON), config.FormatJSON)
+	if _, err := defs.Apply(kvs); !assert.Nil(t, err) {
+		return
+	}
+
+	writer, graph := testMakeGraph(kvs)
+	assert.Equal(t, 0, graph.Run([]string{}))
+	assert.Contains(t, writer.String(), `"route:r1" -> "backend:b1";`)
+
+	writer, graph = testMakeGraph(kvs)
+	assert.Equal(t, 0, graph.Run([]string{"-listener", "l1", "-format", "mermaid"}))
+	assert.Contains(t, writer.String(), "n1 --> n2")
+
+	writer, graph = testMakeGraph(kvs)
+	assert.Equal(t, 0, graph.Run([]string{"-format", "json"}))
+	assert.Contains(t, writer.String(), `"ID": "server:s1"`)
+}
+
+func TestGraphErrors(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	_, graph := testMakeGraph(kvs)
+	assert.Equal(t, 1, graph.Run([]string{"-listener", "nope"}))
+	assert.Equal(t, 1, graph.Run([]string{"-format", "png"}))
+	assert.NotEmpty(t, graph.Help())
+	assert.NotEmpty(t, graph.Synopsis())
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"encoding/json"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//History command
+type History struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the History command
+func (h *History) Help() string {
+	helpText := `
+	Usage: xavi history <kind> <name>
+
+		Lists the revisions of a definition, oldest first. Kind is one of server,
+		backend, route or listener. Each revision records when the change was made,
+		who made it if known, and the definition before and after the change.
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//kindAndName extracts the kind and name positional arguments, returning the remaining
+//arguments
+func kindAndName(args []string) (string, string, []string, bool) {
+	if len(args) < 2 || strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[1], "-") {
+		return "", "", nil, false
+	}
+	return args[0], args[1], args[2:], true
+}
+
+//Run executes the History command with the supplied args
+func (h *History) Run(args []string) int {
+	kind, name, rest, ok := kindAndName(args)
+	if !ok || len(rest) > 0 {
+		h.UI.Error("Kind and name must be specified")
+		h.UI.Error("")
+		h.UI.Error(h.Help())
+		return 1
+	}
+
+	revisions, err := config.ListRevisions(kind, name, h.KVStore)
+	if err != nil {
+		h.UI.Error(err.Error())
+		return 1
+	}
+
+	if revisions == nil {
+		revisions = make([]*config.Revision, 0)
+	}
+
+	jsonRep, err := json.Marshal(revisions)
+	if err != nil {
+		h.UI.Error(err.Error())
+		return 1
+	}
+
+	h.UI.Output(string(jsonRep))
+	return 0
+}
+
+//Synopsis provides a concise description of the History command
+func (h *History) Synopsis() string {
+	return "List the revision history of a definition"
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"testing"
+)
+
+func testMakeHistoryStore() kvstore.KVStore {
+	var kvs, _ = kvstore.NewHashKVStore("")
+	r := &config.RouteConfig{Name: "r1", URIRoot: "/first"}
+	r.Store(config.WithAuthor(kvs, "alice"))
+	r.URIRoot = "/second"
+	r.Store(kvs)
+	return kvs
+}
+
+func testMakeHistory(kvs kvstore.KVStore) (*bytes.Buffer, *History) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &History{UI: ui, KVStore: kvs}
+}
+
+func TestHistory(t *testing.T) {
+	writer, history := testMakeHistory(testMakeHistoryStore())
+	status := history.Run([]string{"route", "r1"})
+	assert.Equal(t, 0, status)
+	out := writer.String()
+	assert.Contains(t, out, `"Revision":1`)
+	assert.Contains(t, out, `"Revision":2`)
+	assert.Contains(t, out, "alice")
+	assert.Contains(t, out, "/first")
+}
+
+func TestHistoryEmpty(t *testing.T) {
+	writer, history := testMakeHistory(testMakeHistoryStore())
+	status := history.Run([]string{"server", "nope"})
+	assert.Equal(t, 0, status)
+	assert.Contains(t, writer.String(), "[]")
+}
+
+func TestHistoryBadArgs(t *testing.T) {
+	_, history := testMakeHistory(testMakeHistoryStore())
+	assert.Equal(t, 1, history.Run([]string{"route"}))
+	assert.Equal(t, 1, history.Run([]string{"-name", "r1"}))
+	assert.Equal(t, 1, history.Run([]string{"gizmo", "r1"}))
+	assert.NotEmpty(t, history.Help())
+	assert.NotEmpty(t, history.Synopsis())
+}
+
+func testMakeRollback(kvs kvstore.KVStore) (*bytes.Buffer, *Rollback) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &Rollback{UI: ui, KVStore: kvs}
+}
+
+func TestRollback(t *testing.T) {
+	kvs := testMakeHistoryStore()
+	_, rollback := testMakeRollback(kvs)
+	status := rollback.Run([]string{"route", "r1", "-to", "1"})
+	assert.Equal(t, 0, status)
+
+	r, _ := config.ReadRouteConfig("r1", kvs)
+	assert.Equal(t, "/first", r.URIRoot)
+}
+
+func TestRollbackBadArgs(t *testing.T) {
+	_, rollback := testMakeRollback(testMakeHistoryStore())
+	assert.Equal(t, 1, rollback.Run([]string{"route", "r1"}))
+	assert.Equal(t, 1, rollback.Run([]string{"route"}))
+	assert.Equal(t, 1, rollback.Run([]string{"route", "r1", "-to", "x"}))
+	assert.Equal(t, 1, rollback.Run([]string{"route", "r1", "-to", "99"}))
+	assert.NotEmpty(t, rollback.Help())
+	assert.NotEmpty(t, rollback.Synopsis())
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//defaultNamespaceName is output in place of the empty default namespace name
+const defaultNamespaceName = "(default)"
+
+//NamespaceList command
+type NamespaceList struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the NamespaceList command
+func (nl *NamespaceList) Help() string {
+	helpText := `
+		Usage: xavi list-namespaces
+
+		Lists the namespaces holding definitions in the KV store. The namespace used
+		by the other commands is taken from the path of the XAVI_KVSTORE_URL, for example
+		consul://localhost:8500/teamA/prod, or from the namespace query parameter for
+		file based stores. Definitions stored without a namespace are listed as (default).
+		`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the NamespaceList command with the supplied args
+func (nl *NamespaceList) Run(args []string) int {
+	namespaces, err := config.ListNamespaces(nl.KVStore)
+	if err != nil {
+		nl.UI.Error(err.Error())
+		return 1
+	}
+
+	current := kvstore.NamespaceOf(nl.KVStore)
+
+	for _, ns := range namespaces {
+		name := ns
+		if name == "" {
+			name = defaultNamespaceName
+		}
+
+		if ns == current {
+			name += " *"
+		}
+
+		nl.UI.Output(name)
+	}
+
+	return 0
+}
+
+//Synopsis provides a concise description of the NamespaceList command
+func (nl *NamespaceList) Synopsis() string {
+	return "List config namespaces"
+}
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"testing"
+)
+
+func testMakeListNamespaces(faultyStore bool) (*bytes.Buffer, *NamespaceList) {
+	var hkvs, _ = kvstore.NewHashKVStore("")
+	var kvs, _ = kvstore.NewNamespacedKVStore(hkvs, "teamA/prod")
+
+	l := &config.ListenerConfig{Name: "l1"}
+	l.Store(hkvs)
+	l.Store(kvs)
+
+	if faultyStore {
+		hkvs.InjectFaults()
+	}
+
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &NamespaceList{
+		UI:      ui,
+		KVStore: kvs,
+	}
+}
+
+func TestListNamespaces(t *testing.T) {
+	writer, listNamespaces := testMakeListNamespaces(false)
+	var args []string
+	status := listNamespaces.Run(args)
+	assert.Equal(t, 0, status)
+	out := writer.String()
+	assert.Contains(t, out, defaultNamespaceName)
+	assert.Contains(t, out, "teamA/prod *")
+}
+
+func TestListNamespacesFaultyStore(t *testing.T) {
+	_, listNamespaces := testMakeListNamespaces(true)
+	var args []string
+	status := listNamespaces.Run(args)
+	assert.Equal(t, 1, status)
+}
+
+func TestListNamespacesHelp(t *testing.T) {
+	_, listNamespaces := testMakeListNamespaces(false)
+	assert.NotEmpty(t, listNamespaces.Help())
+}
+
+func TestListNamespacesSynopsis(t *testing.T) {
+	_, listNamespaces := testMakeListNamespaces(false)
+	assert.NotEmpty(t, listNamespaces.Synopsis())
+}
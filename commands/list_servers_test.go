@@ -22,7 +22,7 @@ func testMakeListServers(faultyStore bool, withServer bool) (*bytes.Buffer, *Ser
 	}
 
 	if withServer {
-		b := &config.ServerConfig{"name", "host", 123, "ping", "none", 0, 0}
+		b := &config.ServerConfig{Name: "name", Address: "host", Port: 123, PingURI: "ping", HealthCheck: "none"}
 		b.Store(kvs)
 	}
 
@@ -6,6 +6,7 @@ import (
 	"github.com/mitchellh/cli"
 	"github.com/xtracdev/xavi/config"
 	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/loadbalancer"
 	"github.com/xtracdev/xavi/service"
 	"os"
 	"os/signal"
@@ -29,6 +30,12 @@ func (l *Listen) Help() string {
 			-ln Listener name - name of listener definition to use
 			-address host:port to listen on
 			-cpuprofile Enable Go lang profiling and write to the file named in the argument
+			-retry-budget Percentage of requests that may be retried across all backends (default 20)
+			-allow-exec-health-checks Run the commands of exec health checks on this host. Without it
+				servers with exec health checks are marked down.
+			-active-config-address Optional host:port to serve the listener's active configuration on,
+				for xavi plan -active. Use an address clients of the listener cannot reach, such as
+				localhost:9090. Not served by default.
 			`
 
 	return strings.TrimSpace(helpText)
@@ -38,12 +45,17 @@ func (l *Listen) Help() string {
 func (l *Listen) Run(args []string) int {
 	config.ListenContext = true
 
-	var listener, address, cpuprofile string
+	var listener, address, cpuprofile, activeConfigAddress string
+	var retryBudget int
+	var allowExecHealthChecks bool
 	cmdFlags := flag.NewFlagSet("listen", flag.ContinueOnError)
 	cmdFlags.Usage = func() { l.UI.Error(l.Help()) }
 	cmdFlags.StringVar(&listener, "ln", "", "")
 	cmdFlags.StringVar(&address, "address", "", "")
 	cmdFlags.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to file")
+	cmdFlags.IntVar(&retryBudget, "retry-budget", service.DefaultRetryBudget, "")
+	cmdFlags.BoolVar(&allowExecHealthChecks, "allow-exec-health-checks", false, "")
+	cmdFlags.StringVar(&activeConfigAddress, "active-config-address", "", "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -62,6 +74,11 @@ func (l *Listen) Run(args []string) int {
 		argErr = true
 	}
 
+	if retryBudget < 0 {
+		l.UI.Error("Retry budget cannot be negative")
+		argErr = true
+	}
+
 	if argErr {
 		l.UI.Error("")
 		l.UI.Error(l.Help())
@@ -86,6 +103,17 @@ func (l *Listen) Run(args []string) int {
 	}
 
 	config.RecordActiveConfig(serviceConfig)
+	if activeConfigAddress != "" {
+		ln, err := service.ServeActiveConfig(listener, activeConfigAddress)
+		if err != nil {
+			l.UI.Error(fmt.Sprintf("Unable to serve the active configuration on %s: %s", activeConfigAddress, err.Error()))
+			return 1
+		}
+		defer ln.Close()
+	}
+
+	service.SetRetryBudget(retryBudget)
+	loadbalancer.AllowExecHealthChecks(allowExecHealthChecks)
 
 	//Build the service for the named listener
 	s, err := service.BuildServiceForListener(listener, address, l.KVStore)
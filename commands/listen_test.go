@@ -56,6 +56,14 @@ func TestListenerMissingArgs(t *testing.T) {
 	assert.Equal(t, 1, status)
 }
 
+func TestListenerNegativeRetryBudget(t *testing.T) {
+	writer, listener := testMakeListenCmd(false, true)
+	var args = []string{"-ln", "l1", "-address", "0.0.0.0:666", "-retry-budget", "-5"}
+	status := listener.Run(args)
+	assert.Equal(t, 1, status)
+	assert.Contains(t, writer.String(), "Retry budget cannot be negative")
+}
+
 func TestListenerArgsWithNonexistentDef(t *testing.T) {
 	_, listener := testMakeListenCmd(false, false)
 	var args = []string{"-ln", "larry", "-address", "0.0.0.0:666", "-cpuprofile", "cpuxxx"}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//MigrateConfig command
+type MigrateConfig struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the MigrateConfig command
+func (m *MigrateConfig) Help() string {
+	helpText := `
+	Usage: xavi migrate-config [-dry-run]
+
+		Upgrades every stored server, backend, route and listener definition to the
+		current schema version. Definitions stored with an earlier schema version are
+		upgraded whenever they are read, but migrating the store upgrades them once
+		and for all. Each upgrade is recorded in the definition's revision history.
+		No definitions are written if any of them cannot be migrated.
+
+	Options:
+		-dry-run List the definitions that would be upgraded without writing them
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the MigrateConfig command with the given arguments
+func (m *MigrateConfig) Run(args []string) int {
+	var dryRun bool
+	cmdFlags := flag.NewFlagSet("migrate-config", flag.ContinueOnError)
+	cmdFlags.Usage = func() { m.UI.Output(m.Help()) }
+	cmdFlags.BoolVar(&dryRun, "dry-run", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	results, err := config.MigrateDefinitions(m.KVStore, dryRun)
+	for _, r := range results {
+		m.UI.Output(fmt.Sprintf("%s %s: schema version %d -> %d", r.Kind, r.Name, r.FromVersion, r.ToVersion))
+	}
+
+	if err != nil {
+		m.UI.Error(err.Error())
+		return 1
+	}
+
+	if dryRun {
+		m.UI.Output(fmt.Sprintf("%d definitions would be migrated to schema version %d",
+			len(results), config.CurrentSchemaVersion()))
+		return 0
+	}
+
+	if err := m.KVStore.Flush(); err != nil {
+		m.UI.Error(err.Error())
+		return 1
+	}
+
+	m.UI.Output(fmt.Sprintf("%d definitions migrated to schema version %d", len(results), config.CurrentSchemaVersion()))
+	return 0
+}
+
+//Synopsis gives the synopsis of the MigrateConfig command
+func (m *MigrateConfig) Synopsis() string {
+	return "Upgrade stored definitions to the current schema version"
+}
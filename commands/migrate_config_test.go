@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
+	"testing"
+)
+
+func testMakeMigrateConfig(kvs kvstore.KVStore) (*bytes.Buffer, *MigrateConfig) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &MigrateConfig{UI: ui, KVStore: kvs}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	legacy := []byte(`{"Name":"s1","Address":"localhost","Port":3000}`)
+	kvs.Put("servers/s1", legacy)
+
+	writer, migrate := testMakeMigrateConfig(kvs)
+	assert.Equal(t, 0, migrate.Run([]string{"-dry-run"}))
+	assert.Contains(t, writer.String(), "server s1: schema version 0 -> 1")
+	assert.Contains(t, writer.String(), "1 definitions would be migrated")
+
+	stored, _ := kvs.Get("servers/s1")
+	assert.Equal(t, legacy, stored)
+
+	writer, migrate = testMakeMigrateConfig(kvs)
+	assert.Equal(t, 0, migrate.Run([]string{}))
+	assert.Contains(t, writer.String(), "1 definitions migrated to schema version 1")
+
+	stored, _ = kvs.Get("servers/s1")
+	assert.Contains(t, string(stored), `"SchemaVersion":1`)
+}
+
+func TestMigrateConfigErrors(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	kvs.Put("servers/s1", []byte(`{"Name":"s1","SchemaVersion":"one"}`))
+
+	writer, migrate := testMakeMigrateConfig(kvs)
+	assert.Equal(t, 1, migrate.Run([]string{}))
+	assert.Contains(t, writer.String(), "Unable to migrate server s1")
+
+	assert.Equal(t, 1, migrate.Run([]string{"-no-such-flag"}))
+	assert.NotEmpty(t, migrate.Help())
+	assert.NotEmpty(t, migrate.Synopsis())
+}
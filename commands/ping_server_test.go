@@ -28,10 +28,10 @@ func testMakePingServer(faultKVStore bool, writeServerDefs bool, port int) (*byt
 	if writeServerDefs {
 		if port == -1 {
 			port = 124
-			s := &config.ServerConfig{"unpingable", "host", port, "", "none", 0, 0}
+			s := &config.ServerConfig{Name: "unpingable", Address: "host", Port: port, PingURI: "", HealthCheck: "none"}
 			s.Store(kvs)
 		} else {
-			s := &config.ServerConfig{"pingable", "0.0.0.0", port, "/pingme", "none", 0, 0}
+			s := &config.ServerConfig{Name: "pingable", Address: "0.0.0.0", Port: port, PingURI: "/pingme", HealthCheck: "none"}
 			s.Store(kvs)
 		}
 	}
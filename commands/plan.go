@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/service"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//Plan command
+type Plan struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the Plan command
+func (p *Plan) Help() string {
+	helpText := `
+	Usage: xavi plan -listener <name> -f <file> [-active <host:port>]
+
+		Shows what applying the definitions in a file would change for a listener,
+		without storing anything. The listener's routes, backends and servers with
+		the definitions applied are compared to those currently stored, and the
+		routes, backends and servers added to or removed from the listener, or
+		modified, are listed. If the active config address of the running listener
+		is given, they are also compared to the listener's active configuration, which
+		differs from the stored definitions if these changed after the listener
+		started. Definitions that would introduce configuration errors are
+		reported as for xavi apply.
+
+	Options:
+		-listener Listener to plan the changes for
+		-f YAML or JSON file (.json) to read definitions from, - for stdin
+		-active Address the running listener serves its active configuration on,
+			as given to xavi listen -active-config-address
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the Plan command with the given arguments
+func (p *Plan) Run(args []string) int {
+	var listener, filename, activeAddress string
+	cmdFlags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	cmdFlags.Usage = func() { p.UI.Output(p.Help()) }
+	cmdFlags.StringVar(&listener, "listener", "", "")
+	cmdFlags.StringVar(&filename, "f", "", "")
+	cmdFlags.StringVar(&activeAddress, "active", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if listener == "" || filename == "" {
+		p.UI.Error("A listener and a file must be specified")
+		p.UI.Error("")
+		p.UI.Error(p.Help())
+		return 1
+	}
+
+	defs, err := readDefinitionsFile(filename)
+	if err != nil {
+		p.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := checkDefinitions(defs, p.KVStore); err != nil {
+		p.UI.Error(err.Error())
+		return 1
+	}
+
+	var active *config.ServiceConfig
+	if activeAddress != "" {
+		if active, err = fetchActiveConfig(listener, activeAddress); err != nil {
+			p.UI.Error(err.Error())
+			return 1
+		}
+	}
+
+	plan, err := config.PlanDefinitionsWithActive(listener, defs, p.KVStore, active)
+	if err != nil {
+		p.UI.Error(err.Error())
+		return 1
+	}
+
+	p.outputChanges(fmt.Sprintf("Changes to listener %s relative to the stored definitions:", listener), plan.Stored)
+	if plan.ActiveRecorded {
+		p.outputChanges(fmt.Sprintf("Changes to listener %s relative to its active configuration:", listener), plan.Active)
+	}
+
+	return 0
+}
+
+//fetchActiveConfig gets the active configuration of the named listener from the process
+//running it at the given address
+func fetchActiveConfig(listener, address string) (*config.ServiceConfig, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + address + service.ActiveConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the active configuration of listener %s: %s", listener, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to get the active configuration of listener %s: %s", listener, resp.Status)
+	}
+
+	var active config.ServiceConfig
+	if err := json.NewDecoder(resp.Body).Decode(&active); err != nil {
+		return nil, fmt.Errorf("Error reading the active configuration of listener %s: %s", listener, err.Error())
+	}
+
+	if active.Listener == nil || active.Listener.Name != listener {
+		return nil, fmt.Errorf("The listener running at %s is not listener %s", address, listener)
+	}
+
+	return &active, nil
+}
+
+var changeSymbols = map[string]string{
+	config.ChangeAdded:    "+",
+	config.ChangeRemoved:  "-",
+	config.ChangeModified: "~",
+}
+
+func (p *Plan) outputChanges(heading string, changes []*config.Change) {
+	p.UI.Output(heading)
+	if len(changes) == 0 {
+		p.UI.Output("  No changes")
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.Action]++
+		p.UI.Output(fmt.Sprintf("  %s %s %s", changeSymbols[c.Action], c.Kind, c.Name))
+		for _, f := range c.Fields {
+			p.UI.Output(fmt.Sprintf("      %s: %s -> %s", f.Field, f.Old, f.New))
+		}
+	}
+
+	p.UI.Output(fmt.Sprintf("%d to add, %d to modify, %d to remove",
+		counts[config.ChangeAdded], counts[config.ChangeModified], counts[config.ChangeRemoved]))
+}
+
+//Synopsis gives the synopsis of the Plan command
+func (p *Plan) Synopsis() string {
+	return "Show the changes definitions would make to a listener"
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testMakePlan(kvs kvstore.KVStore) (*bytes.Buffer, *Plan) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &Plan{UI: ui, KVStore: kvs}
+}
+
+func TestPlan(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	defs, _ := config.ParseDefinitions([]byte(testApplyJSON), config.FormatJSON)
+	if _, err := defs.Apply(kvs); !assert.Nil(t, err) {
+		return
+	}
+
+	filename, cleanup := testWriteDefinitionsFile(t, "change.yaml", `
+servers:
+- name: s1
+  address: localhost
+  port: 3001
+  healthcheck: none
+routes:
+- name: r2
+  uriroot: /two
+  backends: [b1]
+listeners:
+- name: l1
+  routenames: [r1, r2]
+`)
+	defer cleanup()
+
+	writer, plan := testMakePlan(kvs)
+	assert.Equal(t, 0, plan.Run([]string{"-listener", "l1", "-f", filename}), writer.String())
+	out := writer.String()
+	assert.Contains(t, out, "~ listener l1\n      RouteNames: [\"r1\"] -> [\"r1\",\"r2\"]")
+	assert.Contains(t, out, "+ route r2")
+	assert.Contains(t, out, "~ server s1\n      Port: 3000 -> 3001")
+	assert.Contains(t, out, "1 to add, 2 to modify, 0 to remove")
+
+	s, _ := config.ReadServerConfig("s1", kvs)
+	assert.Equal(t, 3000, s.Port, "Planning should not change the stored definitions")
+}
+
+func TestPlanAgainstRunningListener(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	defs, _ := config.ParseDefinitions([]byte(testApplyJSON), config.FormatJSON)
+	if _, err := defs.Apply(kvs); !assert.Nil(t, err) {
+		return
+	}
+
+	active, _ := config.ReadServiceConfig("l1", kvs)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, service.ActiveConfigPath, req.URL.Path)
+		json.NewEncoder(rw).Encode(active)
+	}))
+	defer ts.Close()
+	address := strings.TrimPrefix(ts.URL, "http://")
+
+	//The stored route changes after the listener started
+	(&config.RouteConfig{Name: "r1", URIRoot: "/uno", Backends: []string{"b1"}}).Store(kvs)
+
+	filename, cleanup := testWriteDefinitionsFile(t, "change.yaml", "servers:\n- name: s1\n  address: localhost\n  port: 3001\n  healthcheck: none\n")
+	defer cleanup()
+
+	writer, plan := testMakePlan(kvs)
+	assert.Equal(t, 0, plan.Run([]string{"-listener", "l1", "-f", filename, "-active", address}), writer.String())
+	out := writer.String()
+	assert.Contains(t, out, "relative to the stored definitions:\n  ~ server s1\n      Port: 3000 -> 3001\n0 to add, 1 to modify")
+	assert.Contains(t, out, "relative to its active configuration:\n  ~ route r1\n      URIRoot: \"/one\" -> \"/uno\"")
+	assert.Contains(t, out, "0 to add, 2 to modify, 0 to remove")
+
+	writer, plan = testMakePlan(kvs)
+	assert.Equal(t, 1, plan.Run([]string{"-listener", "l2", "-f", filename, "-active", address}))
+	assert.Contains(t, writer.String(), "is not listener l2")
+}
+
+func TestPlanErrors(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	_, plan := testMakePlan(kvs)
+	assert.Equal(t, 1, plan.Run([]string{"-f", "change.yaml"}))
+	assert.Equal(t, 1, plan.Run([]string{"-listener", "l1", "-f", "/no/such/change.yaml"}))
+
+	filename, cleanup := testWriteDefinitionsFile(t, "change.yaml", "routes:\n- name: r1\n  uriroot: /one\n  backends: [b1]\n")
+	defer cleanup()
+
+	writer, plan := testMakePlan(kvs)
+	assert.Equal(t, 1, plan.Run([]string{"-listener", "l1", "-f", filename}))
+	assert.Contains(t, writer.String(), "route r1 references backend b1, which is not defined")
+
+	assert.NotEmpty(t, plan.Help())
+	assert.NotEmpty(t, plan.Synopsis())
+}
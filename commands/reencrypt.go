@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//Reencrypt command
+type Reencrypt struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the Reencrypt command
+func (r *Reencrypt) Help() string {
+	helpText := `
+	Usage: xavi reencrypt
+
+		Re-encrypts the values under the encrypted key prefixes with the primary
+		encryption key. Run this after adding a new primary key to the keyring, or
+		after enabling encryption for a store with existing values. Once complete,
+		keys other than the primary key can be removed from the keyring.
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the Reencrypt command with the supplied args
+func (r *Reencrypt) Run(args []string) int {
+	ekvs := kvstore.FindEncryptingKVStore(r.KVStore)
+	if ekvs == nil {
+		r.UI.Error("Encryption is not enabled - no encryption keys are configured")
+		return 1
+	}
+
+	count, err := ekvs.Reencrypt()
+	if err != nil {
+		r.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := r.KVStore.Flush(); err != nil {
+		r.UI.Error(err.Error())
+		return 1
+	}
+
+	r.UI.Output(fmt.Sprintf("Re-encrypted %d values with key %s", count, ekvs.PrimaryKeyID()))
+	return 0
+}
+
+//Synopsis provides a concise description of the Reencrypt command
+func (r *Reencrypt) Synopsis() string {
+	return "Re-encrypt values with the primary encryption key"
+}
@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+	"testing"
+)
+
+func testMakeReencrypt(kvs kvstore.KVStore) (*bytes.Buffer, *Reencrypt) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &Reencrypt{UI: ui, KVStore: kvs}
+}
+
+func TestReencrypt(t *testing.T) {
+	hkvs, _ := kvstore.NewHashKVStore("")
+	hkvs.Put("servers/s1", []byte(`{"Name":"s1"}`))
+
+	keyring, _ := kvstore.ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	ekvs, _ := kvstore.NewEncryptingKVStore(hkvs, keyring, "servers/")
+
+	writer, reencrypt := testMakeReencrypt(ekvs)
+	status := reencrypt.Run(nil)
+	assert.Equal(t, 0, status)
+	assert.Contains(t, writer.String(), "Re-encrypted 1 values with key k1")
+
+	raw, _ := hkvs.Get("servers/s1")
+	assert.False(t, strings.Contains(string(raw), `"Name"`))
+}
+
+func TestReencryptNotEnabled(t *testing.T) {
+	hkvs, _ := kvstore.NewHashKVStore("")
+	_, reencrypt := testMakeReencrypt(hkvs)
+	assert.Equal(t, 1, reencrypt.Run(nil))
+	assert.NotEmpty(t, reencrypt.Help())
+	assert.NotEmpty(t, reencrypt.Synopsis())
+}
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//Rollback command
+type Rollback struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the Rollback command
+func (r *Rollback) Help() string {
+	helpText := `
+	Usage: xavi rollback <kind> <name> -to <revision>
+
+		Restores a definition to its value as of the given revision, as listed by
+		xavi history. Kind is one of server, backend, route or listener. The rollback
+		is recorded as a new revision.
+
+	Options:
+		-to Revision to roll back to
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the Rollback command with the given arguments
+func (r *Rollback) Run(args []string) int {
+	kind, name, rest, ok := kindAndName(args)
+	if !ok {
+		r.UI.Error("Kind and name must be specified")
+		r.UI.Error("")
+		r.UI.Error(r.Help())
+		return 1
+	}
+
+	var to int
+	cmdFlags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	cmdFlags.Usage = func() { r.UI.Output(r.Help()) }
+	cmdFlags.IntVar(&to, "to", 0, "")
+	if err := cmdFlags.Parse(rest); err != nil {
+		return 1
+	}
+
+	if to <= 0 {
+		r.UI.Error("A revision to roll back to must be specified")
+		r.UI.Error("")
+		r.UI.Error(r.Help())
+		return 1
+	}
+
+	if err := config.Rollback(kind, name, to, r.KVStore); err != nil {
+		r.UI.Error(err.Error())
+		return 1
+	}
+
+	if err := r.KVStore.Flush(); err != nil {
+		r.UI.Error(err.Error())
+		return 1
+	}
+
+	r.UI.Output(fmt.Sprintf("Rolled back %s %s to revision %d", kind, name, to))
+	return 0
+}
+
+//Synopsis gives the synopsis of the Rollback command
+func (r *Rollback) Synopsis() string {
+	return "Roll a definition back to an earlier revision"
+}
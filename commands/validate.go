@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/service"
+	"strings"
+)
+
+//Validate command
+type Validate struct {
+	UI      cli.Ui
+	KVStore kvstore.KVStore
+}
+
+//Help provides details on the expected arguments for the Validate command
+func (v *Validate) Help() string {
+	helpText := `
+	Usage: xavi validate [-listener <name>]
+
+		Checks the stored definitions for problems that would prevent a listener
+		from starting or serving requests correctly - references to undefined
+		definitions, unknown plugins, load balancer policies and health checks,
+		multiple unguarded routes for a URI root, malformed MsgProps and unreadable
+		CA certs - and reports every problem found. Definitions not referenced by
+		any other definition are reported as warnings. The exit status is 1 if any
+		errors are found.
+
+	Options:
+		-listener Only check the listener and the definitions it uses
+	`
+
+	return strings.TrimSpace(helpText)
+}
+
+//Run executes the Validate command with the given arguments
+func (v *Validate) Run(args []string) int {
+	var listener string
+	cmdFlags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	cmdFlags.Usage = func() { v.UI.Output(v.Help()) }
+	cmdFlags.StringVar(&listener, "listener", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	report, err := service.ValidateStore(listener, v.KVStore)
+	if err != nil {
+		v.UI.Error(err.Error())
+		return 1
+	}
+
+	for _, p := range report.Problems {
+		if p.Severity == service.SeverityError {
+			v.UI.Error(p.String())
+		} else {
+			v.UI.Warn(p.String())
+		}
+	}
+
+	errors := len(report.Errors())
+	warnings := len(report.Problems) - errors
+	if !report.Valid {
+		v.UI.Error(fmt.Sprintf("Configuration is invalid: %d errors, %d warnings", errors, warnings))
+		return 1
+	}
+
+	v.UI.Output(fmt.Sprintf("Configuration is valid: %d warnings", warnings))
+	return 0
+}
+
+//Synopsis gives the synopsis of the Validate command
+func (v *Validate) Synopsis() string {
+	return "Check definitions for problems"
+}
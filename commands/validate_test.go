@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"testing"
+)
+
+func testMakeValidate(kvs kvstore.KVStore) (*bytes.Buffer, *Validate) {
+	var writer = new(bytes.Buffer)
+	var ui = &cli.BasicUi{Writer: writer, ErrorWriter: writer}
+	return writer, &Validate{UI: ui, KVStore: kvs}
+}
+
+func testMakeValidateStore() kvstore.KVStore {
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&config.ServerConfig{Name: "s1", Address: "localhost", Port: 3000, HealthCheck: "none"}).Store(kvs)
+	(&config.ServerConfig{Name: "spare", Address: "localhost", Port: 3001, HealthCheck: "none"}).Store(kvs)
+	(&config.BackendConfig{Name: "b1", ServerNames: []string{"s1"}}).Store(kvs)
+	(&config.RouteConfig{Name: "r1", URIRoot: "/one", Backends: []string{"b1"}}).Store(kvs)
+	(&config.ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}).Store(kvs)
+	return kvs
+}
+
+func TestValidate(t *testing.T) {
+	kvs := testMakeValidateStore()
+
+	writer, validate := testMakeValidate(kvs)
+	assert.Equal(t, 0, validate.Run([]string{}))
+	assert.Contains(t, writer.String(), "warning: server spare: not referenced by any backend")
+	assert.Contains(t, writer.String(), "Configuration is valid: 1 warnings")
+
+	writer, validate = testMakeValidate(kvs)
+	assert.Equal(t, 0, validate.Run([]string{"-listener", "l1"}))
+	assert.Contains(t, writer.String(), "Configuration is valid: 0 warnings")
+
+	(&config.RouteConfig{Name: "r2", URIRoot: "/one", Backends: []string{"b2"}}).Store(kvs)
+	(&config.ListenerConfig{Name: "l1", RouteNames: []string{"r1", "r2"}}).Store(kvs)
+
+	writer, validate = testMakeValidate(kvs)
+	assert.Equal(t, 1, validate.Run([]string{"-listener", "l1"}))
+	assert.Contains(t, writer.String(), "error: route r2: references backend b2, which is not defined")
+	assert.Contains(t, writer.String(), "error: listener l1: routes r1, r2 all serve /one without a MsgProps guard")
+	assert.Contains(t, writer.String(), "Configuration is invalid: 2 errors, 0 warnings")
+}
+
+func TestValidateBadArgs(t *testing.T) {
+	_, validate := testMakeValidate(testMakeValidateStore())
+	assert.Equal(t, 1, validate.Run([]string{"-nope"}))
+	assert.Equal(t, 1, validate.Run([]string{"-listener", "no-such-listener"}))
+	assert.NotEmpty(t, validate.Help())
+	assert.NotEmpty(t, validate.Synopsis())
+}
@@ -1,19 +1,43 @@
 package config
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/kvstore"
+	"io/ioutil"
 )
 
 //BackendConfig defines the data stored for a Backend definition
 type BackendConfig struct {
-	Name               string
-	ServerNames        []string
-	LoadBalancerPolicy string
-	CACertPath         string
-	TLSOnly            bool
+	Name                  string
+	ServerNames           []string
+	LoadBalancerPolicy    string
+	CACertPath            string
+	CACert                string `json:",omitempty"` //Inline PEM data, usually a ${file:...} or ${env:...} reference
+	TLSOnly               bool
+	Timeout               int    `json:",omitempty"` //Per attempt timeout in milliseconds, 0 for none
+	ConnectTimeout        int    `json:",omitempty"` //In milliseconds, 0 for none
+	ResponseHeaderTimeout int    `json:",omitempty"` //In milliseconds, 0 for none
+	HashKey               string `json:",omitempty"` //header:<name>, cookie:<name> or ip for consistent-hash
+	AffinityCookie        string `json:",omitempty"` //Name of the cookie pinning clients to a server
+
+	//Outlier detection ejects servers failing live requests; it is enabled by either threshold
+	OutlierConsecutiveErrors  int `json:",omitempty"` //Errors in a row that eject a server
+	OutlierErrorRate          int `json:",omitempty"` //Error percentage that ejects a server
+	OutlierBaseEjectionTime   int `json:",omitempty"` //In milliseconds, doubling on each ejection
+	OutlierMaxEjectionPercent int `json:",omitempty"` //Most servers ejected at once, as a percentage
+
+	//Circuit breaking skips servers after a run of failures; it is enabled by the failure threshold
+	CircuitBreakerFailures      int `json:",omitempty"` //Failures in a row that open a server's circuit
+	CircuitBreakerOpenTime      int `json:",omitempty"` //In milliseconds before trial requests are let through
+	CircuitBreakerTrialRequests int `json:",omitempty"` //Successful trial requests needed to close the circuit
+
+	//Retries resend failed requests to other servers; they are enabled by more than one attempt
+	RetryAttempts      int    `json:",omitempty"` //Most attempts per request, including the first
+	RetryOn            string `json:",omitempty"` //Conditions to retry on, by default connect-error,502,503,504
+	RetryNonIdempotent bool   `json:",omitempty"` //Retry requests with methods such as POST too
+	RetryBackoff       int    `json:",omitempty"` //In milliseconds, doubling for each attempt, with jitter
 }
 
 //JSONToBackend unmarshals a JSON representation of a BackendCOnfig
@@ -24,7 +48,7 @@ func JSONToBackend(bytes []byte) *BackendConfig {
 	}
 
 	b = new(BackendConfig)
-	if err := json.Unmarshal(bytes, b); err != nil {
+	if err := decodeDefinition(BackendKind, bytes, b); err != nil {
 		log.Warn("Error unmarshalling BackendConfig:", err.Error())
 		b = nil
 	}
@@ -34,14 +58,14 @@ func JSONToBackend(bytes []byte) *BackendConfig {
 
 //Store persists a backend definition using the supplied key value store
 func (backendConfig *BackendConfig) Store(kvs kvstore.KVStore) error {
-	b, err := json.Marshal(backendConfig)
+	b, err := marshalDefinition(backendConfig)
 	if err != nil {
 		return err
 	}
 
 	key := fmt.Sprintf("backends/%s", backendConfig.Name)
 	log.Info(fmt.Sprintf("Adding %s under key %s", string(b), key))
-	err = kvs.Put(key, b)
+	err = storeDefinition(BackendKind, backendConfig.Name, b, kvs)
 	if err != nil {
 		return err
 	}
@@ -60,6 +84,57 @@ func ReadBackendConfig(name string, kvs kvstore.KVStore) (*BackendConfig, error)
 	return JSONToBackend(bv), nil
 }
 
+//ReadBackendConfigWithIndex retrieves the named backend definition along with the modify index
+//of the definition, which can be passed to StoreIfUnmodified. The index is 0 if the
+//definition does not exist.
+func ReadBackendConfigWithIndex(name string, kvs kvstore.KVStore) (*BackendConfig, uint64, error) {
+	bv, index, err := readKeyWithIndex("backends/"+name, kvs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return JSONToBackend(bv), index, nil
+}
+
+//StoreIfUnmodified persists the backend definition only if it has not been modified since
+//index was read. An index of 0 stores the definition only if it does not already exist.
+//ErrDefinitionModified is returned if the definition was modified.
+func (backendConfig *BackendConfig) StoreIfUnmodified(kvs kvstore.KVStore, index uint64) error {
+	b, err := marshalDefinition(backendConfig)
+	if err != nil {
+		return err
+	}
+
+	return storeIfUnmodified(BackendKind, backendConfig.Name, b, index, kvs)
+}
+
+//ResolveSecrets replaces secret references in the backend's CACertPath and CACert with their
+//resolved values
+func (backendConfig *BackendConfig) ResolveSecrets() error {
+	if err := resolveSecretField(BackendKind, backendConfig.Name, "CACertPath", &backendConfig.CACertPath); err != nil {
+		return err
+	}
+	return resolveSecretField(BackendKind, backendConfig.Name, "CACert", &backendConfig.CACert)
+}
+
+//ErrCACertAndPath is returned when a backend has both an inline CA certificate and a CA certificate path
+var ErrCACertAndPath = errors.New("Only one of CACert and CACertPath may be given")
+
+//ReadCACert returns the PEM data of a backend's CA certificates, given either inline as a CACert value
+//or as the path of a PEM file in a CACertPath value. It returns nil if neither is given.
+func ReadCACert(caCert, caCertPath string) ([]byte, error) {
+	switch {
+	case caCert != "" && caCertPath != "":
+		return nil, ErrCACertAndPath
+	case caCert != "":
+		return []byte(caCert), nil
+	case caCertPath != "":
+		return ioutil.ReadFile(caCertPath)
+	default:
+		return nil, nil
+	}
+}
+
 //ListBackendConfigs lists the backend definitions present in the supplied KVS
 func ListBackendConfigs(kvs kvstore.KVStore) ([]*BackendConfig, error) {
 	pairs, err := kvs.List("backends/")
@@ -74,3 +149,34 @@ func ListBackendConfigs(kvs kvstore.KVStore) ([]*BackendConfig, error) {
 
 	return backends, nil
 }
+
+//DeleteBackendConfig removes the named backend definition from the supplied KVS. If route
+//definitions still reference the backend an InUseError is returned, unless cascade is set,
+//in which case the backend is removed from the referencing routes in the same transaction.
+//Only routes left with no backends are deleted, cascading in turn to their listeners.
+func DeleteBackendConfig(name string, kvs kvstore.KVStore, cascade bool) error {
+	bc, err := ReadBackendConfig(name, kvs)
+	if err != nil {
+		return err
+	}
+
+	if bc == nil {
+		return ErrNoSuchBackend
+	}
+
+	routes, err := RoutesReferencingBackend(name, kvs)
+	if err != nil {
+		return err
+	}
+
+	if len(routes) > 0 {
+		if !cascade {
+			return &InUseError{Kind: "Backend", Name: name, ReferencedBy: keysFor("routes/", routes)}
+		}
+		return deleteCascading(BackendKind, name, kvs)
+	}
+
+	key := "backends/" + name
+	log.Info("deleting key ", key)
+	return deleteDefinition(BackendKind, name, kvs)
+}
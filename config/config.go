@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/kvstore"
 )
@@ -10,10 +11,19 @@ var (
 	NullJSON = []byte("null")
 )
 
+//ErrDefinitionModified is returned by conditional stores when the definition has been
+//modified since the index supplied by the caller was read
+var ErrDefinitionModified = errors.New("Definition has been modified since it was read")
+
 func readKey(key string, kvs kvstore.KVStore) ([]byte, error) {
 	log.Debug("Read key " + key)
 	return kvs.Get(key)
 }
 
+func readKeyWithIndex(key string, kvs kvstore.KVStore) ([]byte, uint64, error) {
+	log.Debug("Read key with index " + key)
+	return kvs.GetWithIndex(key)
+}
+
 //ListenContext is set to true if the listen command is being executed
 var ListenContext bool
@@ -0,0 +1,705 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/kvstore"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+)
+
+//Formats definitions can be read and written in
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+//Actions taken for a definition when definitions are applied
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+)
+
+//Definitions holds a set of server, backend, route and listener definitions, for example
+//a complete listener tree, in the format used to apply definitions from and export them to
+//files
+type Definitions struct {
+	Servers   []*ServerConfig   `json:",omitempty"`
+	Backends  []*BackendConfig  `json:",omitempty"`
+	Routes    []*RouteConfig    `json:",omitempty"`
+	Listeners []*ListenerConfig `json:",omitempty"`
+}
+
+//ApplyResult records the action taken for a definition when definitions are applied
+type ApplyResult struct {
+	Kind        string
+	Name        string
+	Action      string
+	Transaction int //Transaction the definition was written in, numbered from 1, or 0 if unchanged
+}
+
+//ValidationError lists the problems found when validating definitions
+type ValidationError struct {
+	Problems []string
+}
+
+func (v *ValidationError) Error() string {
+	return "Invalid definitions:\n\t" + strings.Join(v.Problems, "\n\t")
+}
+
+//FormatForFile returns the format implied by the file's extension: JSON for .json files
+//and YAML otherwise
+func FormatForFile(filename string) string {
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+//ParseDefinitions parses definitions in the given format. Field names are matched case
+//insensitively, and unknown fields are rejected to catch misspelled settings. Definitions
+//with an earlier schema version are migrated to the current version.
+func ParseDefinitions(data []byte, format string) (*Definitions, error) {
+	switch format {
+	case FormatJSON:
+	case FormatYAML:
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+
+		jsonDoc, err := yamlToJSONValue(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if data, err = json.Marshal(jsonDoc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported format '%s' - expected %s or %s", format, FormatJSON, FormatYAML)
+	}
+
+	data, err := migrateDefinitionsJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	defs := new(Definitions)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(defs); err != nil {
+		return nil, err
+	}
+
+	if err := defs.checkNotEmpty(); err != nil {
+		return nil, err
+	}
+
+	return defs, nil
+}
+
+//migrateDefinitionsJSON upgrades definitions written with an earlier schema version, for
+//example exported by an earlier version of xavi, to the current schema version. The
+//schema version is recorded once for the whole document, and is assumed to be current if
+//not given. The version is removed from the returned document.
+func migrateDefinitionsJSON(data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := decodeJSONObject(data, &doc); err != nil {
+		return nil, err
+	}
+
+	version := CurrentSchemaVersion()
+	for field, value := range doc {
+		if !strings.EqualFold(field, schemaVersionField) {
+			continue
+		}
+
+		var err error
+		if version, err = parseSchemaVersion(value); err != nil {
+			return nil, err
+		}
+		delete(doc, field)
+	}
+
+	kinds := map[string]string{
+		"servers": ServerKind, "backends": BackendKind, "routes": RouteKind, "listeners": ListenerKind,
+	}
+	for field, value := range doc {
+		kind, ok := kinds[strings.ToLower(field)]
+		entries, isList := value.([]interface{})
+		if !ok || !isList {
+			//Left for the decoder to report
+			continue
+		}
+
+		for _, entry := range entries {
+			if def, isObject := entry.(map[string]interface{}); isObject {
+				if err := migrate(kind, def, version); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+//checkNotEmpty checks there are no empty entries, for example from a null list item
+func (d *Definitions) checkNotEmpty() error {
+	for _, s := range d.Servers {
+		if s == nil {
+			return fmt.Errorf("Empty %s definition", ServerKind)
+		}
+	}
+	for _, b := range d.Backends {
+		if b == nil {
+			return fmt.Errorf("Empty %s definition", BackendKind)
+		}
+	}
+	for _, r := range d.Routes {
+		if r == nil {
+			return fmt.Errorf("Empty %s definition", RouteKind)
+		}
+	}
+	for _, l := range d.Listeners {
+		if l == nil {
+			return fmt.Errorf("Empty %s definition", ListenerKind)
+		}
+	}
+	return nil
+}
+
+//yamlToJSONValue converts the maps produced by the YAML parser, which can have keys of any
+//type, into maps with string keys that can be marshalled as JSON
+func yamlToJSONValue(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for k, item := range value {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("Unsupported non-string key %v", k)
+			}
+
+			converted, err := yamlToJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case []interface{}:
+		var items []interface{}
+		for _, item := range value {
+			converted, err := yamlToJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, converted)
+		}
+		return items, nil
+	default:
+		return v, nil
+	}
+}
+
+//Marshal returns the definitions in the given format, recording the current schema version
+func (d *Definitions) Marshal(format string) ([]byte, error) {
+	jsonRep, err := json.MarshalIndent(struct {
+		*Definitions
+		SchemaVersion int
+	}{d, CurrentSchemaVersion()}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJSON:
+		return jsonRep, nil
+	case FormatYAML:
+		//JSON is YAML, so reading it into a MapSlice keeps the order of the fields
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal(jsonRep, &doc); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(doc)
+	default:
+		return nil, fmt.Errorf("Unsupported format '%s' - expected %s or %s", format, FormatJSON, FormatYAML)
+	}
+}
+
+//Validate checks the definitions are complete: each definition must be named, names must be
+//unique for each kind of definition, and each definition referenced must be included in
+//the definitions or already be present in the supplied KVS. A ValidationError listing
+//the problems found is returned if the definitions are not valid.
+func (d *Definitions) Validate(kvs kvstore.KVStore) error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	names := make(map[string]map[string]bool)
+	checkName := func(kind, name string) {
+		if names[kind] == nil {
+			names[kind] = make(map[string]bool)
+		}
+
+		if name == "" {
+			problem("A %s definition has no name", kind)
+		} else if names[kind][name] {
+			problem("%s %s is defined more than once", kind, name)
+		}
+		names[kind][name] = true
+	}
+
+	checkRef := func(kind, name, refKind, ref string) {
+		if names[refKind][ref] {
+			return
+		}
+
+		stored, err := kvs.Get(kindPrefixes[refKind] + ref)
+		if err != nil {
+			problem("Unable to read %s %s referenced by %s %s: %s", refKind, ref, kind, name, err.Error())
+		} else if stored == nil {
+			problem("%s %s references %s %s, which is not defined", kind, name, refKind, ref)
+		}
+	}
+
+	for _, s := range d.Servers {
+		checkName(ServerKind, s.Name)
+		if s.Address == "" {
+			problem("server %s has no address", s.Name)
+		}
+		if s.Port <= 0 {
+			problem("server %s has no port", s.Name)
+		}
+	}
+
+	for _, b := range d.Backends {
+		checkName(BackendKind, b.Name)
+	}
+
+	for _, r := range d.Routes {
+		checkName(RouteKind, r.Name)
+		if r.URIRoot == "" {
+			problem("route %s has no URIRoot", r.Name)
+		}
+		if len(r.Backends) == 0 {
+			problem("route %s has no backends", r.Name)
+		}
+		if len(r.Backends) > 1 && r.MultiBackendAdapter == "" {
+			problem("route %s has multiple backends but no MultiBackendAdapter", r.Name)
+		}
+	}
+
+	for _, l := range d.Listeners {
+		checkName(ListenerKind, l.Name)
+	}
+
+	for _, b := range d.Backends {
+		for _, s := range b.ServerNames {
+			checkRef(BackendKind, b.Name, ServerKind, s)
+		}
+	}
+
+	for _, r := range d.Routes {
+		for _, b := range r.Backends {
+			checkRef(RouteKind, r.Name, BackendKind, b)
+		}
+	}
+
+	for _, l := range d.Listeners {
+		for _, r := range l.RouteNames {
+			checkRef(ListenerKind, l.Name, RouteKind, r)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+//Overlay returns the definitions that result from applying overrides on top of d: each
+//definition in overrides replaces the definition of the same kind and name in d, if any
+func (d *Definitions) Overlay(overrides *Definitions) *Definitions {
+	result := new(Definitions)
+
+	replaced := make(map[string]bool)
+	for _, e := range overrides.entries() {
+		replaced[e.kind+"/"+e.name] = true
+	}
+
+	for _, s := range d.Servers {
+		if !replaced[ServerKind+"/"+s.Name] {
+			result.Servers = append(result.Servers, s)
+		}
+	}
+	for _, b := range d.Backends {
+		if !replaced[BackendKind+"/"+b.Name] {
+			result.Backends = append(result.Backends, b)
+		}
+	}
+	for _, r := range d.Routes {
+		if !replaced[RouteKind+"/"+r.Name] {
+			result.Routes = append(result.Routes, r)
+		}
+	}
+	for _, l := range d.Listeners {
+		if !replaced[ListenerKind+"/"+l.Name] {
+			result.Listeners = append(result.Listeners, l)
+		}
+	}
+
+	result.Servers = append(result.Servers, overrides.Servers...)
+	result.Backends = append(result.Backends, overrides.Backends...)
+	result.Routes = append(result.Routes, overrides.Routes...)
+	result.Listeners = append(result.Listeners, overrides.Listeners...)
+
+	return result
+}
+
+//definitionEntry associates a definition with its kind and name
+type definitionEntry struct {
+	kind  string
+	name  string
+	value interface{}
+}
+
+//entries returns the definitions in the order they are stored, so definitions are stored
+//before the definitions that reference them
+func (d *Definitions) entries() []definitionEntry {
+	var entries []definitionEntry
+	for _, s := range d.Servers {
+		entries = append(entries, definitionEntry{ServerKind, s.Name, s})
+	}
+	for _, b := range d.Backends {
+		entries = append(entries, definitionEntry{BackendKind, b.Name, b})
+	}
+	for _, r := range d.Routes {
+		entries = append(entries, definitionEntry{RouteKind, r.Name, r})
+	}
+	for _, l := range d.Listeners {
+		entries = append(entries, definitionEntry{ListenerKind, l.Name, l})
+	}
+	return entries
+}
+
+//Apply validates the definitions and stores them in the supplied KVS in a single
+//transaction, so either all the changed definitions are stored or none are. Definitions
+//identical to those already stored are not rewritten, so applying the same definitions
+//again records no new revisions. The action taken for each definition is returned.
+//
+//If the changes do not fit in one transaction of the KV store, they are written in as few
+//transactions as fit, ordered servers, backends, routes then listeners so definitions are
+//stored before those referring to them. The apply is then not atomic: if a transaction fails
+//the definitions written by earlier transactions remain, and their results are returned
+//along with the error. The Transaction of each result tells which transaction wrote it.
+func (d *Definitions) Apply(kvs kvstore.KVStore) ([]*ApplyResult, error) {
+	if kvs == nil {
+		return nil, ErrNoKVStore
+	}
+
+	if err := d.Validate(kvs); err != nil {
+		return nil, err
+	}
+
+	entries := d.entries()
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		ops, results, err := applyOps(entries, kvs)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ops) > 0 {
+			log.Infof("Applying definitions in a transaction of %d operations", len(ops))
+			err = kvs.Txn(ops)
+		}
+
+		switch err {
+		case nil:
+			logApplied(results, 1)
+			return results, nil
+		case kvstore.ErrTxnConflict:
+			log.Info("Conflict applying definitions - retrying")
+		default:
+			if tooLarge, ok := err.(*kvstore.TxnTooLargeError); ok {
+				log.Warnf("Applying definitions takes %d transaction operations, more than the %d the KV store "+
+					"accepts in one transaction - applying them in several transactions, which is not atomic",
+					tooLarge.Ops, tooLarge.Limit)
+				return applyInTransactions(entries, tooLarge.Limit, kvs)
+			}
+			return nil, fmt.Errorf("Error applying definitions: %s", err.Error())
+		}
+	}
+
+	return nil, ErrWriteConflict
+}
+
+//applyInTransactions writes the definitions in order, in transactions of at most limit
+//operations. A transaction that conflicts with a concurrent write is retried on its own.
+func applyInTransactions(entries []definitionEntry, limit int, kvs kvstore.KVStore) ([]*ApplyResult, error) {
+	var applied []*ApplyResult
+	transaction := 0
+	for len(entries) > 0 {
+		transaction++
+		n, results, err := applyTransaction(entries, limit, transaction, kvs)
+		if err != nil {
+			return applied, fmt.Errorf("Error applying definitions in transaction %d, after %d definitions were "+
+				"applied: %s", transaction, len(applied), err.Error())
+		}
+
+		applied = append(applied, results...)
+		entries = entries[n:]
+	}
+
+	return applied, nil
+}
+
+//applyTransaction writes as many of the definitions as fit in a transaction of at most
+//limit operations, returning the number of definitions written and their results
+func applyTransaction(entries []definitionEntry, limit, transaction int, kvs kvstore.KVStore) (int, []*ApplyResult, error) {
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		var ops []*kvstore.TxnOp
+		var results []*ApplyResult
+		for _, e := range entries {
+			defOps, result, err := entryOps(e, kvs)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			if len(ops) > 0 && len(ops)+len(defOps) > limit {
+				break
+			}
+
+			ops = append(ops, defOps...)
+			results = append(results, result)
+		}
+
+		if len(ops) > 0 {
+			log.Infof("Applying definitions in transaction %d of %d operations", transaction, len(ops))
+			err := kvs.Txn(ops)
+			if err == kvstore.ErrTxnConflict {
+				log.Infof("Conflict applying definitions in transaction %d - retrying", transaction)
+				continue
+			}
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+
+		logApplied(results, transaction)
+		return len(results), results, nil
+	}
+
+	return 0, nil, ErrWriteConflict
+}
+
+//logApplied logs the results of the definitions written by a transaction, recording the
+//transaction in the results of the changed definitions
+func logApplied(results []*ApplyResult, transaction int) {
+	for _, r := range results {
+		if r.Action != ActionUnchanged {
+			r.Transaction = transaction
+		}
+		log.Infof("Apply: %s %s %s", r.Kind, r.Name, r.Action)
+	}
+}
+
+//applyOps returns the transaction operations that store the definitions, along with the
+//action taken for each definition if the operations are applied
+func applyOps(entries []definitionEntry, kvs kvstore.KVStore) ([]*kvstore.TxnOp, []*ApplyResult, error) {
+	var ops []*kvstore.TxnOp
+	var results []*ApplyResult
+	for _, e := range entries {
+		defOps, result, err := entryOps(e, kvs)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ops = append(ops, defOps...)
+		results = append(results, result)
+	}
+
+	return ops, results, nil
+}
+
+//entryOps returns the transaction operations that store a definition, along with the action
+//taken for the definition if the operations are applied
+func entryOps(e definitionEntry, kvs kvstore.KVStore) ([]*kvstore.TxnOp, *ApplyResult, error) {
+	value, err := marshalDefinition(e.value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defOps, index, err := definitionOps(e.kind, e.name, value, kvs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error applying %s %s: %s", e.kind, e.name, err.Error())
+	}
+
+	action := ActionUpdated
+	switch {
+	case defOps == nil:
+		action = ActionUnchanged
+	case index == 0:
+		action = ActionCreated
+	}
+
+	return defOps, &ApplyResult{Kind: e.kind, Name: e.name, Action: action}, nil
+}
+
+//ExportDefinitions returns the definitions stored in the supplied KVS. If a listener name
+//is given only the listener and the routes, backends and servers it uses are returned,
+//otherwise all definitions are returned. Definitions are sorted by name so exports of the
+//same configuration are identical. Secret references are exported as is.
+func ExportDefinitions(listenerName string, kvs kvstore.KVStore) (*Definitions, error) {
+	if kvs == nil {
+		return nil, ErrNoKVStore
+	}
+
+	var defs *Definitions
+	var err error
+	if listenerName == "" {
+		defs, err = exportAll(kvs)
+	} else {
+		defs, err = exportListener(listenerName, kvs)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(defs.Servers, func(i, j int) bool { return defs.Servers[i].Name < defs.Servers[j].Name })
+	sort.Slice(defs.Backends, func(i, j int) bool { return defs.Backends[i].Name < defs.Backends[j].Name })
+	sort.Slice(defs.Routes, func(i, j int) bool { return defs.Routes[i].Name < defs.Routes[j].Name })
+	sort.Slice(defs.Listeners, func(i, j int) bool { return defs.Listeners[i].Name < defs.Listeners[j].Name })
+
+	return defs, nil
+}
+
+//exportAll returns all the definitions in the store, skipping any that cannot be
+//unmarshalled
+func exportAll(kvs kvstore.KVStore) (*Definitions, error) {
+	defs := new(Definitions)
+
+	servers, err := ListServerConfigs(kvs)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range servers {
+		if s != nil {
+			defs.Servers = append(defs.Servers, s)
+		}
+	}
+
+	backends, err := ListBackendConfigs(kvs)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backends {
+		if b != nil {
+			defs.Backends = append(defs.Backends, b)
+		}
+	}
+
+	routes, err := ListRouteConfigs(kvs)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		if r != nil {
+			defs.Routes = append(defs.Routes, r)
+		}
+	}
+
+	listeners, err := ListListenerConfigs(kvs)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range listeners {
+		if l != nil {
+			defs.Listeners = append(defs.Listeners, l)
+		}
+	}
+
+	return defs, nil
+}
+
+func exportListener(listenerName string, kvs kvstore.KVStore) (*Definitions, error) {
+	defs := new(Definitions)
+	seen := make(map[string]bool)
+	firstVisit := func(kind, name string) bool {
+		if seen[kind+"/"+name] {
+			return false
+		}
+		seen[kind+"/"+name] = true
+		return true
+	}
+
+	listener, err := ReadListenerConfig(listenerName, kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	if listener == nil {
+		return nil, errors.New("Listener config '" + listenerName + "' not found")
+	}
+	defs.Listeners = append(defs.Listeners, listener)
+
+	for _, routeName := range listener.RouteNames {
+		if !firstVisit(RouteKind, routeName) {
+			continue
+		}
+
+		route, err := ReadRouteConfig(routeName, kvs)
+		if err != nil {
+			return nil, err
+		}
+
+		if route == nil {
+			return nil, errors.New("Route config '" + routeName + "' not found")
+		}
+		defs.Routes = append(defs.Routes, route)
+
+		for _, backendName := range route.Backends {
+			if !firstVisit(BackendKind, backendName) {
+				continue
+			}
+
+			backend, err := ReadBackendConfig(backendName, kvs)
+			if err != nil {
+				return nil, err
+			}
+
+			if backend == nil {
+				return nil, errors.New("Backend defnition for '" + backendName + "' not found")
+			}
+			defs.Backends = append(defs.Backends, backend)
+
+			for _, serverName := range backend.ServerNames {
+				if !firstVisit(ServerKind, serverName) {
+					continue
+				}
+
+				server, err := ReadServerConfig(serverName, kvs)
+				if err != nil {
+					return nil, err
+				}
+
+				if server == nil {
+					return nil, errors.New("No definition for server '" + serverName + "' found")
+				}
+				defs.Servers = append(defs.Servers, server)
+			}
+		}
+	}
+
+	return defs, nil
+}
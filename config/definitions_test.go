@@ -0,0 +1,279 @@
+package config
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+	"testing"
+)
+
+const testDefinitionsYAML = `
+servers:
+- name: s1
+  address: localhost
+  port: 3000
+  healthcheck: none
+backends:
+- name: b1
+  servernames: [s1]
+routes:
+- name: r1
+  uriroot: /one
+  backends: [b1]
+listeners:
+- name: l1
+  routenames: [r1]
+  healthendpoint: true
+`
+
+func TestParseDefinitionsYAML(t *testing.T) {
+	defs, err := ParseDefinitions([]byte(testDefinitionsYAML), FormatYAML)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "localhost", defs.Servers[0].Address)
+		assert.Equal(t, 3000, defs.Servers[0].Port)
+		assert.Equal(t, []string{"s1"}, defs.Backends[0].ServerNames)
+		assert.Equal(t, "/one", defs.Routes[0].URIRoot)
+		assert.True(t, defs.Listeners[0].HealthEndpoint)
+	}
+}
+
+func TestParseDefinitionsJSON(t *testing.T) {
+	defs, err := ParseDefinitions([]byte(`{"Servers":[{"Name":"s1","Address":"localhost","Port":3000}]}`), FormatJSON)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 1, len(defs.Servers))
+		assert.Equal(t, 3000, defs.Servers[0].Port)
+	}
+}
+
+func TestParseDefinitionsErrors(t *testing.T) {
+	_, err := ParseDefinitions([]byte("servers:\n- name: s1\n  adress: localhost\n"), FormatYAML)
+	assert.NotNil(t, err, "Misspelled fields should be rejected")
+
+	_, err = ParseDefinitions([]byte("servers:\n-\n"), FormatYAML)
+	assert.NotNil(t, err, "Empty definitions should be rejected")
+
+	_, err = ParseDefinitions([]byte("servers: [[["), FormatYAML)
+	assert.NotNil(t, err)
+
+	_, err = ParseDefinitions([]byte("{}"), "xml")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, FormatJSON, FormatForFile("gateway.JSON"))
+	assert.Equal(t, FormatYAML, FormatForFile("gateway.yml"))
+}
+
+func TestValidateDefinitions(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&BackendConfig{Name: "stored-backend"}).Store(kvs)
+
+	defs := &Definitions{
+		Servers:   []*ServerConfig{{Name: "s1", Address: "localhost", Port: 3000}, {Name: "s1"}},
+		Backends:  []*BackendConfig{{Name: "b1", ServerNames: []string{"s1", "s2"}}},
+		Routes:    []*RouteConfig{{Name: "r1", URIRoot: "/r1", Backends: []string{"b1", "stored-backend"}}},
+		Listeners: []*ListenerConfig{{RouteNames: []string{"r1"}}},
+	}
+
+	err := defs.Validate(kvs)
+	if assert.NotNil(t, err) {
+		problems := err.(*ValidationError).Problems
+		assert.Equal(t, 6, len(problems), strings.Join(problems, "\n"))
+		msg := err.Error()
+		assert.Contains(t, msg, "server s1 is defined more than once")
+		assert.Contains(t, msg, "server s1 has no address")
+		assert.Contains(t, msg, "server s1 has no port")
+		assert.Contains(t, msg, "backend b1 references server s2, which is not defined")
+		assert.Contains(t, msg, "route r1 has multiple backends but no MultiBackendAdapter")
+		assert.Contains(t, msg, "A listener definition has no name")
+		assert.NotContains(t, msg, "stored-backend")
+	}
+}
+
+func TestApplyAndExportDefinitions(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	defs, err := ParseDefinitions([]byte(testDefinitionsYAML), FormatYAML)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	results, err := defs.Apply(kvs)
+	if assert.Nil(t, err) && assert.Equal(t, 4, len(results)) {
+		assert.Equal(t, &ApplyResult{Kind: ServerKind, Name: "s1", Action: ActionCreated, Transaction: 1}, results[0])
+		assert.Equal(t, &ApplyResult{Kind: ListenerKind, Name: "l1", Action: ActionCreated, Transaction: 1}, results[3])
+	}
+
+	defs.Routes[0].URIRoot = "/uno"
+	results, err = defs.Apply(kvs)
+	if assert.Nil(t, err) {
+		assert.Equal(t, ActionUnchanged, results[0].Action)
+		assert.Equal(t, ActionUpdated, results[2].Action)
+	}
+
+	revisions, _ := ListRevisions(ServerKind, "s1", kvs)
+	assert.Equal(t, 1, len(revisions), "Unchanged definitions should not be rewritten")
+
+	(&ServerConfig{Name: "other", Address: "otherhost", Port: 4000}).Store(kvs)
+
+	exported, err := ExportDefinitions("l1", kvs)
+	if assert.Nil(t, err) {
+		assert.Equal(t, defs, exported)
+	}
+
+	all, err := ExportDefinitions("", kvs)
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(all.Servers)) {
+		assert.Equal(t, "other", all.Servers[0].Name)
+		assert.Equal(t, "s1", all.Servers[1].Name)
+	}
+
+	_, err = ExportDefinitions("nope", kvs)
+	assert.NotNil(t, err)
+}
+
+func TestApplyIsAllOrNothing(t *testing.T) {
+	hkvs, _ := kvstore.NewHashKVStore("")
+	kvs := &conflictingKVStore{HashKVStore: hkvs, conflicts: 1}
+	defs, err := ParseDefinitions([]byte(testDefinitionsYAML), FormatYAML)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	t.Log("Conflicting writes are retried")
+	results, err := defs.Apply(kvs)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(results))
+
+	t.Log("No definitions are stored when the transaction fails")
+	defs.Servers[0].Port = 3001
+	defs.Routes[0].URIRoot = "/uno"
+	kvs.conflicts = maxWriteAttempts
+	_, err = defs.Apply(kvs)
+	assert.Equal(t, ErrWriteConflict, err)
+
+	s, _ := ReadServerConfig("s1", kvs)
+	assert.Equal(t, 3000, s.Port)
+	revisions, _ := ListRevisions(RouteKind, "r1", kvs)
+	assert.Equal(t, 1, len(revisions))
+}
+
+//failingKVStore fails transactions after the given number have been applied
+type failingKVStore struct {
+	*limitedKVStore
+	remaining int
+}
+
+func (f *failingKVStore) Txn(ops []*kvstore.TxnOp) error {
+	if f.remaining == 0 {
+		return fmt.Errorf("Injected failure")
+	}
+	f.remaining--
+	return f.limitedKVStore.Txn(ops)
+}
+
+func testManyServerDefinitions(t *testing.T, servers int) *Definitions {
+	defs, err := ParseDefinitions([]byte(testDefinitionsYAML), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 2; i <= servers; i++ {
+		s := *defs.Servers[0]
+		s.Name = fmt.Sprintf("s%d", i)
+		defs.Servers = append(defs.Servers, &s)
+		defs.Backends[0].ServerNames = append(defs.Backends[0].ServerNames, s.Name)
+	}
+
+	return defs
+}
+
+func TestApplyInSeveralTransactions(t *testing.T) {
+	hkvs, _ := kvstore.NewHashKVStore("")
+	kvs := &limitedKVStore{HashKVStore: hkvs, limit: 64}
+
+	t.Log("Changes fitting in one transaction are applied in one")
+	results, err := testManyServerDefinitions(t, 1).Apply(kvs)
+	assert.Nil(t, err)
+	for _, r := range results {
+		assert.Equal(t, 1, r.Transaction)
+	}
+
+	t.Log("Creating 22 servers and updating the backend takes 69 operations")
+	results, err = testManyServerDefinitions(t, 23).Apply(kvs)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	transactions := make(map[string]int)
+	for _, r := range results {
+		transactions[r.Kind+" "+r.Name] = r.Transaction
+	}
+	assert.Equal(t, 1, transactions["server s2"])
+	assert.Equal(t, 2, transactions["server s23"])
+	assert.Equal(t, 2, transactions["backend b1"])
+	assert.Equal(t, 0, transactions["route r1"], "Unchanged definitions are not written")
+
+	b, _ := ReadBackendConfig("b1", kvs)
+	for _, name := range b.ServerNames {
+		s, _ := ReadServerConfig(name, kvs)
+		assert.NotNil(t, s, name)
+	}
+}
+
+func TestApplyInSeveralTransactionsFailure(t *testing.T) {
+	hkvs, _ := kvstore.NewHashKVStore("")
+	//The first transaction attempted holds all the changes and is rejected as too large
+	kvs := &failingKVStore{limitedKVStore: &limitedKVStore{HashKVStore: hkvs, limit: 64}, remaining: 2}
+
+	results, err := testManyServerDefinitions(t, 23).Apply(kvs)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "transaction 2, after 21 definitions were applied")
+	}
+
+	assert.Equal(t, 21, len(results), "The definitions written by the first transaction are returned")
+	s, _ := ReadServerConfig("s21", kvs)
+	assert.NotNil(t, s)
+	b, _ := ReadBackendConfig("b1", kvs)
+	assert.Nil(t, b, "Backends are written after their servers")
+}
+
+func TestMarshalDefinitionsRoundTrip(t *testing.T) {
+	defs, err := ParseDefinitions([]byte(testDefinitionsYAML), FormatYAML)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		out, err := defs.Marshal(format)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		parsed, err := ParseDefinitions(out, format)
+		if assert.Nil(t, err, string(out)) {
+			assert.Equal(t, defs, parsed)
+		}
+	}
+
+	out, _ := defs.Marshal(FormatYAML)
+	assert.True(t, strings.HasPrefix(string(out), "Servers:\n- Name: s1\n"), string(out))
+}
+
+func TestOverlayDefinitions(t *testing.T) {
+	stored := &Definitions{
+		Servers: []*ServerConfig{{Name: "s1", Port: 3000}, {Name: "s2", Port: 3001}},
+		Routes:  []*RouteConfig{{Name: "r1", URIRoot: "/one"}},
+	}
+
+	overlaid := stored.Overlay(&Definitions{
+		Servers:   []*ServerConfig{{Name: "s2", Port: 4001}, {Name: "s3", Port: 4002}},
+		Listeners: []*ListenerConfig{{Name: "l1"}},
+	})
+
+	if assert.Equal(t, 3, len(overlaid.Servers)) {
+		assert.Equal(t, 3000, overlaid.Servers[0].Port)
+		assert.Equal(t, 4001, overlaid.Servers[1].Port)
+		assert.Equal(t, "s3", overlaid.Servers[2].Name)
+	}
+	assert.Equal(t, stored.Routes, overlaid.Routes)
+	assert.Equal(t, 1, len(overlaid.Listeners))
+	assert.Equal(t, 2, len(stored.Servers), "The original definitions should not be modified")
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/xtracdev/xavi/kvstore"
+	"strconv"
+	"strings"
+)
+
+//Formats a topology graph can be rendered in
+const (
+	GraphFormatDOT     = "dot"
+	GraphFormatMermaid = "mermaid"
+	GraphFormatJSON    = "json"
+)
+
+//GraphNode is a listener, route, backend or server in a topology graph. Attributes hold the
+//settings shown for the node, such as a route's MsgProps guard or a server's health check.
+type GraphNode struct {
+	ID         string
+	Kind       string
+	Name       string
+	Attributes map[string]string `json:",omitempty"`
+
+	attributeOrder []string
+}
+
+//GraphEdge links a listener to a route, a route to a backend or a backend to a server
+type GraphEdge struct {
+	From string
+	To   string
+}
+
+//Graph is the listener, route, backend and server topology of one or more service configs
+type Graph struct {
+	Nodes []*GraphNode
+	Edges []*GraphEdge
+
+	nodes map[string]*GraphNode
+	edges map[GraphEdge]bool
+}
+
+//BuildGraph returns the topology of the supplied service configs. Definitions shared by
+//several listeners or routes appear once.
+func BuildGraph(configs ...*ServiceConfig) *Graph {
+	g := &Graph{
+		Nodes: []*GraphNode{},
+		Edges: []*GraphEdge{},
+		nodes: make(map[string]*GraphNode),
+		edges: make(map[GraphEdge]bool),
+	}
+	for _, sc := range configs {
+		l := sc.Listener
+		listener := g.addNode(ListenerKind, l.Name)
+		listener.addAttribute("HealthEndpoint", strconv.FormatBool(l.HealthEndpoint))
+
+		for _, r := range sc.Routes {
+			route := g.addNode(RouteKind, r.Route.Name)
+			route.addAttribute("URIRoot", r.Route.URIRoot)
+			route.addAttribute("MsgProps", r.Route.MsgProps)
+			route.addAttribute("Plugins", strings.Join(r.Route.Plugins, ", "))
+			route.addAttribute("MultiBackendAdapter", r.Route.MultiBackendAdapter)
+			if r.Route.Timeout > 0 {
+				route.addAttribute("Timeout", fmt.Sprintf("%dms", r.Route.Timeout))
+			}
+			if r.Route.RetryAttempts > 0 {
+				route.addAttribute("RetryAttempts", strconv.Itoa(r.Route.RetryAttempts))
+				route.addAttribute("RetryOn", r.Route.RetryOn)
+			}
+			g.addEdge(listener, route)
+
+			for _, b := range r.Backends {
+				backend := g.addNode(BackendKind, b.Backend.Name)
+				backend.addAttribute("LoadBalancerPolicy", b.Backend.LoadBalancerPolicy)
+				backend.addAttribute("CACertPath", b.Backend.CACertPath)
+				if b.Backend.CACert != "" && !ContainsSecretRefs(b.Backend.CACert) {
+					backend.addAttribute("CACert", "inline")
+				} else {
+					backend.addAttribute("CACert", b.Backend.CACert)
+				}
+				if b.Backend.TLSOnly {
+					backend.addAttribute("TLSOnly", "true")
+				}
+				backend.addAttribute("HashKey", b.Backend.HashKey)
+				backend.addAttribute("AffinityCookie", b.Backend.AffinityCookie)
+				if b.Backend.Timeout > 0 {
+					backend.addAttribute("Timeout", fmt.Sprintf("%dms", b.Backend.Timeout))
+				}
+				if b.Backend.ConnectTimeout > 0 {
+					backend.addAttribute("ConnectTimeout", fmt.Sprintf("%dms", b.Backend.ConnectTimeout))
+				}
+				if b.Backend.ResponseHeaderTimeout > 0 {
+					backend.addAttribute("ResponseHeaderTimeout", fmt.Sprintf("%dms", b.Backend.ResponseHeaderTimeout))
+				}
+				if b.Backend.OutlierConsecutiveErrors > 0 {
+					backend.addAttribute("OutlierConsecutiveErrors", strconv.Itoa(b.Backend.OutlierConsecutiveErrors))
+				}
+				if b.Backend.OutlierErrorRate > 0 {
+					backend.addAttribute("OutlierErrorRate", fmt.Sprintf("%d%%", b.Backend.OutlierErrorRate))
+				}
+				if b.Backend.CircuitBreakerFailures > 0 {
+					backend.addAttribute("CircuitBreakerFailures", strconv.Itoa(b.Backend.CircuitBreakerFailures))
+				}
+				if b.Backend.RetryAttempts > 1 {
+					backend.addAttribute("RetryAttempts", strconv.Itoa(b.Backend.RetryAttempts))
+					backend.addAttribute("RetryOn", b.Backend.RetryOn)
+				}
+				g.addEdge(route, backend)
+
+				for _, s := range b.Servers {
+					server := g.addNode(ServerKind, s.Name)
+					server.addAttribute("Address", fmt.Sprintf("%s:%d", s.Address, s.Port))
+					server.addAttribute("PingURI", s.PingURI)
+					server.addAttribute("HealthCheck", s.HealthCheck)
+					if s.Weight > 0 {
+						server.addAttribute("Weight", strconv.Itoa(s.Weight))
+					}
+					if s.HealthCheckInterval > 0 {
+						server.addAttribute("HealthCheckInterval", fmt.Sprintf("%dms", s.HealthCheckInterval))
+					}
+					if s.HealthCheckTimeout > 0 {
+						server.addAttribute("HealthCheckTimeout", fmt.Sprintf("%dms", s.HealthCheckTimeout))
+					}
+					server.addAttribute("HealthCheckMethod", s.HealthCheckMethod)
+					server.addAttribute("HealthCheckStatus", s.HealthCheckStatus)
+					server.addAttribute("HealthCheckMatch", s.HealthCheckMatch)
+					server.addAttribute("HealthCheckCommand", s.HealthCheckCommand)
+					if s.HealthCheckRise > 0 {
+						server.addAttribute("HealthCheckRise", strconv.Itoa(s.HealthCheckRise))
+					}
+					if s.HealthCheckFall > 0 {
+						server.addAttribute("HealthCheckFall", strconv.Itoa(s.HealthCheckFall))
+					}
+					g.addEdge(backend, server)
+				}
+			}
+		}
+	}
+
+	return g
+}
+
+func (g *Graph) addNode(kind, name string) *GraphNode {
+	id := kind + ":" + name
+	if node, ok := g.nodes[id]; ok {
+		return node
+	}
+
+	node := &GraphNode{ID: id, Kind: kind, Name: name}
+	g.nodes[id] = node
+	g.Nodes = append(g.Nodes, node)
+	return node
+}
+
+func (g *Graph) addEdge(from, to *GraphNode) {
+	edge := GraphEdge{From: from.ID, To: to.ID}
+	if g.edges[edge] {
+		return
+	}
+
+	g.edges[edge] = true
+	g.Edges = append(g.Edges, &edge)
+}
+
+//addAttribute records a setting for the node, unless it is empty or already recorded
+func (n *GraphNode) addAttribute(name, value string) {
+	if value == "" {
+		return
+	}
+
+	if n.Attributes == nil {
+		n.Attributes = make(map[string]string)
+	}
+
+	if _, ok := n.Attributes[name]; !ok {
+		n.attributeOrder = append(n.attributeOrder, name)
+	}
+	n.Attributes[name] = value
+}
+
+//labelLines returns the kind and name of the node followed by its attributes
+func (n *GraphNode) labelLines() []string {
+	lines := []string{n.Kind + " " + n.Name}
+	for _, name := range n.attributeOrder {
+		lines = append(lines, name+": "+n.Attributes[name])
+	}
+	return lines
+}
+
+//Render returns the graph in the given format: a Graphviz DOT digraph, a Mermaid flowchart,
+//or JSON
+func (g *Graph) Render(format string) ([]byte, error) {
+	switch format {
+	case GraphFormatDOT:
+		return g.dot(), nil
+	case GraphFormatMermaid:
+		return g.mermaid(), nil
+	case GraphFormatJSON:
+		return json.MarshalIndent(g, "", "  ")
+	default:
+		return nil, fmt.Errorf("Unsupported format '%s' - expected %s, %s or %s",
+			format, GraphFormatDOT, GraphFormatMermaid, GraphFormatJSON)
+	}
+}
+
+func (g *Graph) dot() []byte {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph xavi {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, n := range g.Nodes {
+		var lines []string
+		for _, line := range n.labelLines() {
+			lines = append(lines, quote.Replace(line))
+		}
+		fmt.Fprintf(&buf, "\t\"%s\" [label=\"%s\"];\n", quote.Replace(n.ID), strings.Join(lines, `\n`))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&buf, "\t\"%s\" -> \"%s\";\n", quote.Replace(e.From), quote.Replace(e.To))
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func (g *Graph) mermaid() []byte {
+	//Mermaid node IDs are restricted, so nodes are numbered and labelled with their names
+	quote := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", " ")
+	ids := make(map[string]string)
+
+	var buf bytes.Buffer
+	buf.WriteString("graph LR\n")
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+
+		var lines []string
+		for _, line := range n.labelLines() {
+			lines = append(lines, quote.Replace(line))
+		}
+		fmt.Fprintf(&buf, "    %s[\"%s\"]\n", ids[n.ID], strings.Join(lines, "<br/>"))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&buf, "    %s --> %s\n", ids[e.From], ids[e.To])
+	}
+	return buf.Bytes()
+}
+
+//ReadGraph returns the topology of the named listener, or of all listeners if no name is
+//given, from the definitions in the supplied KVS. Secret references are not resolved.
+func ReadGraph(listenerName string, kvs kvstore.KVStore) (*Graph, error) {
+	defs, err := ExportDefinitions(listenerName, kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []*ServiceConfig
+	for _, l := range defs.Listeners {
+		sc, err := defs.ServiceConfig(l.Name)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, sc)
+	}
+
+	return BuildGraph(configs...), nil
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+	"testing"
+)
+
+func testGraphKVStore() kvstore.KVStore {
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&ServerConfig{Name: "s1", Address: "localhost", Port: 3000, HealthCheck: "http-get", HealthCheckInterval: 5000}).Store(kvs)
+	(&BackendConfig{Name: "b1", ServerNames: []string{"s1"}, LoadBalancerPolicy: "round-robin"}).Store(kvs)
+	(&RouteConfig{Name: "r1", URIRoot: "/one", Backends: []string{"b1"}, Plugins: []string{"Logging", "Timing"}}).Store(kvs)
+	(&RouteConfig{Name: "r2", URIRoot: "/one", Backends: []string{"b1"}, MsgProps: `SOAPAction="foo"`}).Store(kvs)
+	(&ListenerConfig{Name: "l1", RouteNames: []string{"r1", "r2"}, HealthEndpoint: true}).Store(kvs)
+	(&ListenerConfig{Name: "l2", RouteNames: []string{"r1"}}).Store(kvs)
+	return kvs
+}
+
+func TestReadGraph(t *testing.T) {
+	kvs := testGraphKVStore()
+
+	g, err := ReadGraph("", kvs)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 6, len(g.Nodes), "Shared definitions should appear once")
+		assert.Equal(t, 6, len(g.Edges))
+	}
+
+	g, err = ReadGraph("l2", kvs)
+	if assert.Nil(t, err) && assert.Equal(t, 4, len(g.Nodes)) {
+		route := g.Nodes[1]
+		assert.Equal(t, "route:r1", route.ID)
+		assert.Equal(t, "Logging, Timing", route.Attributes["Plugins"])
+		assert.Equal(t, []string{"route r1", "URIRoot: /one", "Plugins: Logging, Timing"}, route.labelLines())
+		assert.Equal(t, "5000ms", g.Nodes[3].Attributes["HealthCheckInterval"])
+	}
+
+	_, err = ReadGraph("nope", kvs)
+	assert.NotNil(t, err)
+}
+
+func TestRenderGraph(t *testing.T) {
+	g, err := ReadGraph("l1", testGraphKVStore())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	dot, err := g.Render(GraphFormatDOT)
+	if assert.Nil(t, err) {
+		out := string(dot)
+		assert.True(t, strings.HasPrefix(out, "digraph xavi {\n"))
+		assert.Contains(t, out, `"route:r2" [label="route r2\nURIRoot: /one\nMsgProps: SOAPAction=\"foo\""];`)
+		assert.Contains(t, out, `"listener:l1" -> "route:r1";`)
+		assert.Contains(t, out, `label="backend b1\nLoadBalancerPolicy: round-robin"`)
+	}
+
+	mermaid, err := g.Render(GraphFormatMermaid)
+	if assert.Nil(t, err) {
+		out := string(mermaid)
+		assert.True(t, strings.HasPrefix(out, "graph LR\n"))
+		assert.Contains(t, out, `n4["route r2<br/>URIRoot: /one<br/>MsgProps: SOAPAction=#quot;foo#quot;"]`)
+		assert.Contains(t, out, "n0 --> n1\n")
+	}
+
+	out, err := g.Render(GraphFormatJSON)
+	if assert.Nil(t, err) {
+		var parsed Graph
+		assert.Nil(t, json.Unmarshal(out, &parsed))
+		assert.Equal(t, len(g.Nodes), len(parsed.Nodes))
+		assert.Equal(t, "true", parsed.Nodes[0].Attributes["HealthEndpoint"])
+	}
+
+	_, err = g.Render("png")
+	assert.NotNil(t, err)
+}
+
+func TestGraphTimeoutAndBalancingAttributes(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&ServerConfig{Name: "s1", Address: "localhost", Port: 3000, Weight: 3}).Store(kvs)
+	(&BackendConfig{Name: "b1", ServerNames: []string{"s1"}, LoadBalancerPolicy: "consistent-hash",
+		HashKey: "header:X-User", AffinityCookie: "sticky", Timeout: 500, ConnectTimeout: 100}).Store(kvs)
+	(&RouteConfig{Name: "r1", URIRoot: "/one", Backends: []string{"b1"}, Timeout: 2000}).Store(kvs)
+	(&ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}).Store(kvs)
+
+	g, err := ReadGraph("l1", kvs)
+	if assert.Nil(t, err) && assert.Equal(t, 4, len(g.Nodes)) {
+		assert.Equal(t, "2000ms", g.Nodes[1].Attributes["Timeout"])
+		assert.Equal(t, []string{"backend b1", "LoadBalancerPolicy: consistent-hash", "HashKey: header:X-User",
+			"AffinityCookie: sticky", "Timeout: 500ms", "ConnectTimeout: 100ms"}, g.Nodes[2].labelLines())
+		assert.Equal(t, "3", g.Nodes[3].Attributes["Weight"])
+	}
+}
@@ -0,0 +1,395 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/env"
+	"github.com/xtracdev/xavi/kvstore"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Definition kinds, as used to identify the definitions whose history is recorded
+const (
+	ServerKind   = "server"
+	BackendKind  = "backend"
+	RouteKind    = "route"
+	ListenerKind = "listener"
+)
+
+//DefinitionKinds lists the kinds of definition revision history is kept for
+var DefinitionKinds = []string{ServerKind, BackendKind, RouteKind, ListenerKind}
+
+//historyPrefix is the key prefix revisions are recorded under, for example
+//history/routes/r1/0000000003 is revision 3 of route r1
+const historyPrefix = kvstore.HistoryPrefix
+
+//latestRevisionPrefix is the key prefix the latest revision number of each definition is
+//kept under, for example history/latest/routes/r1 holds the latest revision of route r1
+const latestRevisionPrefix = historyPrefix + "latest/"
+
+//DefaultRevisionRetention is the number of revisions kept for each definition when
+//XAVI_HISTORY_RETENTION is not set. Older revisions are deleted as new ones are recorded.
+const DefaultRevisionRetention = 100
+
+//maxExpiredRevisionDeletes bounds the revisions deleted by a single write, so writes stay small
+//when a history is far over the retention, for example after XAVI_HISTORY_RETENTION is lowered.
+//The remaining expired revisions are deleted by later writes.
+const maxExpiredRevisionDeletes = 8
+
+//maxWriteAttempts is the number of times a definition write is retried when the
+//definition or its history is concurrently modified
+const maxWriteAttempts = 5
+
+var kindPrefixes = map[string]string{
+	ServerKind:   "servers/",
+	BackendKind:  "backends/",
+	RouteKind:    "routes/",
+	ListenerKind: "listeners/",
+}
+
+//Errors related to revision history
+var (
+	ErrNoSuchRevision = errors.New("No such revision")
+	ErrWriteConflict  = errors.New("Definition repeatedly modified by others while writing - try again")
+)
+
+//Revision records a change to a definition. Value holds the definition as stored by the
+//change, and Previous the definition it replaced. Value is empty when the change deleted
+//the definition, and Previous is empty when the change created the definition.
+type Revision struct {
+	Revision  int
+	Timestamp time.Time
+	Author    string          `json:",omitempty"`
+	Deleted   bool            `json:",omitempty"`
+	Previous  json.RawMessage `json:",omitempty"`
+	Value     json.RawMessage `json:",omitempty"`
+}
+
+//now is used to timestamp revisions
+var now = time.Now
+
+//authoredKVStore associates an author with changes made via the store
+type authoredKVStore struct {
+	kvstore.KVStore
+	author string
+}
+
+//Underlying returns the store the author is associated with
+func (a *authoredKVStore) Underlying() kvstore.KVStore {
+	return a.KVStore
+}
+
+//WithAuthor returns a KVStore that records the given author in the revisions of
+//definitions stored using it. Any author already associated with kvs is replaced, and
+//an empty author removes the association.
+func WithAuthor(kvs kvstore.KVStore, author string) kvstore.KVStore {
+	if a, ok := kvs.(*authoredKVStore); ok {
+		kvs = a.KVStore
+	}
+
+	if author == "" || kvs == nil {
+		return kvs
+	}
+	return &authoredKVStore{KVStore: kvs, author: author}
+}
+
+func authorOf(kvs kvstore.KVStore) string {
+	if a, ok := kvs.(*authoredKVStore); ok {
+		return a.author
+	}
+	return ""
+}
+
+func prefixForKind(kind string) (string, error) {
+	prefix, ok := kindPrefixes[kind]
+	if !ok {
+		return "", fmt.Errorf("Unknown definition kind '%s' - expected one of %v", kind, DefinitionKinds)
+	}
+	return prefix, nil
+}
+
+func revisionPrefix(kind, name string) string {
+	return historyPrefix + kindPrefixes[kind] + name + "/"
+}
+
+func revisionKey(kind, name string, revision int) string {
+	return fmt.Sprintf("%s%010d", revisionPrefix(kind, name), revision)
+}
+
+func latestRevisionKey(kind, name string) string {
+	return latestRevisionPrefix + kindPrefixes[kind] + name
+}
+
+//revisionRetention returns the number of revisions to keep for each definition
+func revisionRetention() int {
+	setting := os.Getenv(env.HistoryRetention)
+	if setting == "" {
+		return DefaultRevisionRetention
+	}
+
+	retention, err := strconv.Atoi(setting)
+	if err != nil || retention < 1 {
+		log.Warnf("Ignoring invalid %s setting '%s' - keeping %d revisions", env.HistoryRetention, setting,
+			DefaultRevisionRetention)
+		return DefaultRevisionRetention
+	}
+
+	return retention
+}
+
+//ListRevisions returns the revisions of the named definition, oldest first
+func ListRevisions(kind, name string, kvs kvstore.KVStore) ([]*Revision, error) {
+	if _, err := prefixForKind(kind); err != nil {
+		return nil, err
+	}
+
+	pairs, err := kvs.List(revisionPrefix(kind, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []*Revision
+	for _, p := range pairs {
+		r := new(Revision)
+		if err := json.Unmarshal(p.Value, r); err != nil {
+			log.Warn("Error unmarshalling revision ", p.Key, ": ", err.Error())
+			continue
+		}
+		revisions = append(revisions, r)
+	}
+
+	sort.Sort(byRevision(revisions))
+	return revisions, nil
+}
+
+type byRevision []*Revision
+
+func (r byRevision) Len() int           { return len(r) }
+func (r byRevision) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byRevision) Less(i, j int) bool { return r[i].Revision < r[j].Revision }
+
+//ReadRevision returns the given revision of the named definition
+func ReadRevision(kind, name string, revision int, kvs kvstore.KVStore) (*Revision, error) {
+	if _, err := prefixForKind(kind); err != nil {
+		return nil, err
+	}
+
+	b, err := readKey(revisionKey(kind, name, revision), kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	if b == nil {
+		return nil, ErrNoSuchRevision
+	}
+
+	r := new(Revision)
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//Rollback restores the named definition to its value as of the given revision. The
+//rollback is itself recorded as a new revision.
+func Rollback(kind, name string, revision int, kvs kvstore.KVStore) error {
+	r, err := ReadRevision(kind, name, revision, kvs)
+	if err != nil {
+		return err
+	}
+
+	if r.Deleted {
+		return fmt.Errorf("Revision %d of %s %s deleted the definition - there is nothing to roll back to",
+			revision, kind, name)
+	}
+
+	//The revision may predate the current schema version
+	value, _, err := upgradeDefinition(kind, r.Value)
+	if err != nil {
+		return err
+	}
+
+	log.Info(fmt.Sprintf("Rolling back %s %s to revision %d", kind, name, revision))
+	return storeDefinition(kind, name, value, kvs)
+}
+
+//nextRevision returns the number of the next revision of the named definition, and the
+//modify index of the key holding the latest revision number. Histories recorded before the
+//latest revision was kept are listed to find it.
+func nextRevision(kind, name string, kvs kvstore.KVStore) (int, uint64, error) {
+	b, index, err := kvs.GetWithIndex(latestRevisionKey(kind, name))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if b != nil {
+		latest, err := strconv.Atoi(string(b))
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid latest revision '%s' for %s %s", string(b), kind, name)
+		}
+		return latest + 1, index, nil
+	}
+
+	revisions, err := ListRevisions(kind, name, kvs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(revisions) == 0 {
+		return 1, index, nil
+	}
+
+	return revisions[len(revisions)-1].Revision + 1, index, nil
+}
+
+//definitionOps returns the transaction operations that write the definition value, or
+//delete the definition if value is nil, and record the change as a new revision. Revisions
+//falling outside the retained history are deleted. No operations are returned
+//if the definition already has the value. The operations fail if the definition or its
+//history is modified after being read here. A NewerSchemaError is returned rather than
+//overwrite a definition stored by a newer version of xavi.
+func definitionOps(kind, name string, value []byte, kvs kvstore.KVStore) ([]*kvstore.TxnOp, uint64, error) {
+	prefix, err := prefixForKind(kind)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key := prefix + name
+	previous, index, err := kvs.GetWithIndex(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if bytes.Equal(value, previous) {
+		return nil, index, nil
+	}
+
+	if value != nil {
+		if err := checkNotNewer(kind, previous); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	rev, latestIndex, err := nextRevision(kind, name, kvs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r := &Revision{
+		Revision:  rev,
+		Timestamp: now().UTC(),
+		Author:    authorOf(kvs),
+		Deleted:   value == nil,
+		Previous:  json.RawMessage(previous),
+		Value:     json.RawMessage(value),
+	}
+
+	rb, err := json.Marshal(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var defOp *kvstore.TxnOp
+	if value == nil {
+		defOp = &kvstore.TxnOp{Verb: kvstore.TxnDeleteCAS, Key: key, Index: index}
+	} else {
+		defOp = &kvstore.TxnOp{Verb: kvstore.TxnCAS, Key: key, Value: value, Index: index}
+	}
+
+	ops := []*kvstore.TxnOp{
+		defOp,
+		{Verb: kvstore.TxnCAS, Key: revisionKey(kind, name, rev), Value: rb},
+		{Verb: kvstore.TxnCAS, Key: latestRevisionKey(kind, name), Value: []byte(strconv.Itoa(rev)), Index: latestIndex},
+	}
+
+	if expired := rev - revisionRetention(); expired > 0 {
+		expiredOps, err := expiredRevisionOps(kind, name, expired, kvs)
+		if err != nil {
+			return nil, 0, err
+		}
+		ops = append(ops, expiredOps...)
+	}
+
+	return ops, index, nil
+}
+
+//expiredRevisionOps returns the operations deleting the oldest revisions of the named definition
+//up to and including the expired revision, at most maxExpiredRevisionDeletes of them
+func expiredRevisionOps(kind, name string, expired int, kvs kvstore.KVStore) ([]*kvstore.TxnOp, error) {
+	pairs, err := kvs.List(revisionPrefix(kind, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []int
+	for _, p := range pairs {
+		revision, err := strconv.Atoi(p.Key[strings.LastIndex(p.Key, "/")+1:])
+		if err != nil || revision > expired {
+			continue
+		}
+		revisions = append(revisions, revision)
+	}
+
+	sort.Ints(revisions)
+	if len(revisions) > maxExpiredRevisionDeletes {
+		revisions = revisions[:maxExpiredRevisionDeletes]
+	}
+
+	var ops []*kvstore.TxnOp
+	for _, revision := range revisions {
+		ops = append(ops, &kvstore.TxnOp{Verb: kvstore.TxnDelete, Key: revisionKey(kind, name, revision)})
+	}
+
+	return ops, nil
+}
+
+//writeDefinition writes or deletes a definition along with its revision. If conditional
+//is set the write only happens if the definition's modify index matches index.
+func writeDefinition(kind, name string, value []byte, conditional bool, index uint64, kvs kvstore.KVStore) error {
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		ops, currentIndex, err := definitionOps(kind, name, value, kvs)
+		if err != nil {
+			return err
+		}
+
+		if conditional && currentIndex != index {
+			return ErrDefinitionModified
+		}
+
+		if ops == nil {
+			return nil
+		}
+
+		err = kvs.Txn(ops)
+		if err != kvstore.ErrTxnConflict {
+			return err
+		}
+
+		log.Info(fmt.Sprintf("Conflict writing %s %s - retrying", kind, name))
+	}
+
+	return ErrWriteConflict
+}
+
+func storeDefinition(kind, name string, value []byte, kvs kvstore.KVStore) error {
+	return writeDefinition(kind, name, value, false, 0, kvs)
+}
+
+//storeIfUnmodified writes the definition if its modify index matches index. An index of 0
+//writes the definition only if it does not exist.
+func storeIfUnmodified(kind, name string, value []byte, index uint64, kvs kvstore.KVStore) error {
+	log.Info(fmt.Sprintf("adding %s %s if unmodified since index %d", kind, name, index))
+	return writeDefinition(kind, name, value, true, index, kvs)
+}
+
+func deleteDefinition(kind, name string, kvs kvstore.KVStore) error {
+	return writeDefinition(kind, name, nil, false, 0, kvs)
+}
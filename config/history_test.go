@@ -0,0 +1,266 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/env"
+	"github.com/xtracdev/xavi/kvstore"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRevisionsRecorded(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	authored := WithAuthor(kvs, "alice")
+
+	r := &RouteConfig{Name: "r1", URIRoot: "/one"}
+	assert.Nil(t, r.Store(authored))
+
+	r.URIRoot = "/two"
+	assert.Nil(t, r.Store(kvs))
+
+	revisions, err := ListRevisions(RouteKind, "r1", kvs)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(revisions)) {
+		first := revisions[0]
+		assert.Equal(t, 1, first.Revision)
+		assert.Equal(t, "alice", first.Author)
+		assert.Nil(t, first.Previous)
+		assert.False(t, first.Timestamp.IsZero())
+		assert.Equal(t, "/one", JSONToRoute(first.Value).URIRoot)
+
+		second := revisions[1]
+		assert.Equal(t, 2, second.Revision)
+		assert.Equal(t, "", second.Author)
+		assert.Equal(t, "/one", JSONToRoute(second.Previous).URIRoot)
+		assert.Equal(t, "/two", JSONToRoute(second.Value).URIRoot)
+	}
+
+	t.Log("History does not show up in definition listings")
+	routes, _ := ListRouteConfigs(kvs)
+	assert.Equal(t, 1, len(routes))
+}
+
+func TestRollback(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+
+	s := &ServerConfig{Name: "s1", Address: "localhost", Port: 3000}
+	s.Store(kvs)
+	s.Port = 4000
+	s.Store(kvs)
+
+	assert.Nil(t, Rollback(ServerKind, "s1", 1, kvs))
+	s, _ = ReadServerConfig("s1", kvs)
+	assert.Equal(t, 3000, s.Port)
+
+	revisions, _ := ListRevisions(ServerKind, "s1", kvs)
+	assert.Equal(t, 3, len(revisions))
+
+	assert.Equal(t, ErrNoSuchRevision, Rollback(ServerKind, "s1", 42, kvs))
+	assert.NotNil(t, Rollback("gizmo", "s1", 1, kvs))
+}
+
+func TestDeleteRecordsRevision(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+
+	l := &ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}
+	l.Store(kvs)
+	assert.Nil(t, DeleteListenerConfig("l1", kvs))
+
+	revisions, _ := ListRevisions(ListenerKind, "l1", kvs)
+	if assert.Equal(t, 2, len(revisions)) {
+		assert.True(t, revisions[1].Deleted)
+		assert.Nil(t, revisions[1].Value)
+		assert.NotNil(t, revisions[1].Previous)
+	}
+
+	t.Log("Cannot roll back to a deletion, but can roll back to before it")
+	assert.NotNil(t, Rollback(ListenerKind, "l1", 2, kvs))
+	assert.Nil(t, Rollback(ListenerKind, "l1", 1, kvs))
+	l, _ = ReadListenerConfig("l1", kvs)
+	assert.NotNil(t, l)
+}
+
+func TestServiceConfigStoreRecordsRevisions(t *testing.T) {
+	kvs := BuildKVStoreTestConfig(t)
+	sc, _ := ReadServiceConfig("listener", kvs)
+
+	t.Log("Storing unchanged definitions records no revisions")
+	assert.Nil(t, sc.Store(kvs))
+	assertRevisionCounts(t, sc, kvs, 1)
+
+	sc.Listener.HealthEndpoint = false
+	sc.Routes[0].Route.URIRoot = "/changed"
+	sc.Routes[0].Backends[0].Backend.LoadBalancerPolicy = "round-robin"
+	sc.Routes[0].Backends[0].Servers[0].Port = 4000
+	assert.Nil(t, sc.Store(kvs))
+	assertRevisionCounts(t, sc, kvs, 2)
+}
+
+func assertRevisionCounts(t *testing.T, sc *ServiceConfig, kvs kvstore.KVStore, expected int) {
+	for _, kind := range DefinitionKinds {
+		revisions, err := ListRevisions(kind, map[string]string{
+			ServerKind:   sc.Routes[0].Backends[0].Servers[0].Name,
+			BackendKind:  sc.Routes[0].Backends[0].Backend.Name,
+			RouteKind:    sc.Routes[0].Route.Name,
+			ListenerKind: sc.Listener.Name,
+		}[kind], kvs)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, len(revisions), kind)
+	}
+}
+
+func TestRevisionJSON(t *testing.T) {
+	r := &Revision{
+		Revision:  1,
+		Timestamp: time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC),
+		Value:     json.RawMessage(`{"Name":"r1"}`),
+	}
+	b, err := json.Marshal(r)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"Revision":1,"Timestamp":"2016-03-01T00:00:00Z","Value":{"Name":"r1"}}`, string(b))
+}
+
+func TestStoreRetriesConflicts(t *testing.T) {
+	hkvs, _ := kvstore.NewHashKVStore("")
+	kvs := &conflictingKVStore{HashKVStore: hkvs, conflicts: 2}
+
+	r := &RouteConfig{Name: "r1"}
+	assert.Nil(t, r.Store(kvs))
+
+	kvs.conflicts = maxWriteAttempts
+	r.URIRoot = "/changed"
+	assert.Equal(t, ErrWriteConflict, r.Store(kvs))
+}
+
+//conflictingKVStore fails the given number of transactions with a conflict
+type conflictingKVStore struct {
+	*kvstore.HashKVStore
+	conflicts int
+}
+
+func (c *conflictingKVStore) Txn(ops []*kvstore.TxnOp) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		return kvstore.ErrTxnConflict
+	}
+	return c.HashKVStore.Txn(ops)
+}
+
+func TestRevisionsOfEncryptedDefinitionsEncrypted(t *testing.T) {
+	hkvs, _ := kvstore.NewHashKVStore("")
+	keyring, err := kvstore.ParseKeyring("k1:MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=")
+	if !assert.Nil(t, err) {
+		return
+	}
+	ekvs, _ := kvstore.NewEncryptingKVStore(hkvs, keyring, "servers/")
+
+	s := &ServerConfig{Name: "s1", Address: "secret-host", Port: 3000}
+	assert.Nil(t, s.Store(ekvs))
+	s.Address = "other-secret-host"
+	assert.Nil(t, s.Store(ekvs))
+
+	raw, _ := hkvs.List("")
+	for _, p := range raw {
+		assert.NotContains(t, string(p.Value), "secret-host", p.Key)
+	}
+
+	revisions, err := ListRevisions(ServerKind, "s1", ekvs)
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(revisions)) {
+		assert.Equal(t, "secret-host", JSONToServer(revisions[1].Previous).Address)
+	}
+}
+
+func TestUnchangedDefinitionNotRewritten(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+
+	r := &RouteConfig{Name: "r1", URIRoot: "/one"}
+	assert.Nil(t, r.Store(kvs))
+	_, index, _ := kvs.GetWithIndex("routes/r1")
+
+	assert.Nil(t, r.Store(kvs))
+	_, unchangedIndex, _ := kvs.GetWithIndex("routes/r1")
+	assert.Equal(t, index, unchangedIndex)
+
+	revisions, _ := ListRevisions(RouteKind, "r1", kvs)
+	assert.Equal(t, 1, len(revisions))
+}
+
+func TestLatestRevisionKept(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+
+	r := &RouteConfig{Name: "r1", URIRoot: "/one"}
+	assert.Nil(t, r.Store(kvs))
+	r.URIRoot = "/two"
+	assert.Nil(t, r.Store(kvs))
+
+	latest, _ := kvs.Get(latestRevisionKey(RouteKind, "r1"))
+	assert.Equal(t, "2", string(latest))
+
+	t.Log("Revision numbers continue from the latest revision, not the revisions listed")
+	kvs.Delete(revisionKey(RouteKind, "r1", 2))
+	r.URIRoot = "/three"
+	assert.Nil(t, r.Store(kvs))
+	_, err := ReadRevision(RouteKind, "r1", 3, kvs)
+	assert.Nil(t, err)
+
+	t.Log("Histories recorded without the latest revision are listed to find it")
+	kvs.Delete(latestRevisionKey(RouteKind, "r1"))
+	r.URIRoot = "/four"
+	assert.Nil(t, r.Store(kvs))
+	_, err = ReadRevision(RouteKind, "r1", 4, kvs)
+	assert.Nil(t, err)
+}
+
+func TestRevisionRetention(t *testing.T) {
+	os.Setenv(env.HistoryRetention, "2")
+	defer os.Unsetenv(env.HistoryRetention)
+
+	kvs, _ := kvstore.NewHashKVStore("")
+	s := &ServerConfig{Name: "s1", Address: "localhost"}
+	for port := 3000; port < 3004; port++ {
+		s.Port = port
+		assert.Nil(t, s.Store(kvs))
+	}
+
+	revisions, _ := ListRevisions(ServerKind, "s1", kvs)
+	if assert.Equal(t, 2, len(revisions)) {
+		assert.Equal(t, 3, revisions[0].Revision)
+		assert.Equal(t, 4, revisions[1].Revision)
+	}
+	assert.Equal(t, ErrNoSuchRevision, Rollback(ServerKind, "s1", 1, kvs))
+
+	os.Setenv(env.HistoryRetention, "none")
+	assert.Equal(t, DefaultRevisionRetention, revisionRetention())
+}
+
+func TestLoweredRevisionRetention(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	s := &ServerConfig{Name: "s1", Address: "localhost"}
+	for port := 3000; port < 3020; port++ {
+		s.Port = port
+		assert.Nil(t, s.Store(kvs))
+	}
+
+	os.Setenv(env.HistoryRetention, "2")
+	defer os.Unsetenv(env.HistoryRetention)
+
+	t.Log("Each write deletes a bounded number of the expired revisions")
+	s.Port = 4000
+	assert.Nil(t, s.Store(kvs))
+	revisions, _ := ListRevisions(ServerKind, "s1", kvs)
+	assert.Equal(t, 21-maxExpiredRevisionDeletes, len(revisions))
+	assert.Equal(t, maxExpiredRevisionDeletes+1, revisions[0].Revision)
+
+	t.Log("Later writes delete the rest")
+	s.Port = 4001
+	assert.Nil(t, s.Store(kvs))
+	s.Port = 4002
+	assert.Nil(t, s.Store(kvs))
+	revisions, _ = ListRevisions(ServerKind, "s1", kvs)
+	if assert.Equal(t, 2, len(revisions)) {
+		assert.Equal(t, 22, revisions[0].Revision)
+		assert.Equal(t, 23, revisions[1].Revision)
+	}
+}
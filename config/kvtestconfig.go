@@ -25,13 +25,13 @@ func loadTestConfig1(kvs kvstore.KVStore, t *testing.T) {
 		t.Fatal(err)
 	}
 
-	serverConfig1 := &ServerConfig{"server1", "localhost", 3000, "/hello", "none", 0, 0}
+	serverConfig1 := &ServerConfig{Name: "server1", Address: "localhost", Port: 3000, PingURI: "/hello", HealthCheck: "none"}
 	err = serverConfig1.Store(kvs)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	serverConfig2 := &ServerConfig{"server2", "localhost", 3100, "/hello", "none", 0, 0}
+	serverConfig2 := &ServerConfig{Name: "server2", Address: "localhost", Port: 3100, PingURI: "/hello", HealthCheck: "none"}
 	err = serverConfig2.Store(kvs)
 	if err != nil {
 		t.Fatal(err)
@@ -66,13 +66,13 @@ func loadConfigTwoBackendsNoPluginNameSpecified(kvs kvstore.KVStore, t *testing.
 		t.Fatal(err)
 	}
 
-	serverConfig1 := &ServerConfig{"s1", "localhost", 3000, "/hello", "none", 0, 0}
+	serverConfig1 := &ServerConfig{Name: "s1", Address: "localhost", Port: 3000, PingURI: "/hello", HealthCheck: "none"}
 	err = serverConfig1.Store(kvs)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	serverConfig2 := &ServerConfig{"s2", "localhost", 3100, "/hello", "none", 0, 0}
+	serverConfig2 := &ServerConfig{Name: "s2", Address: "localhost", Port: 3100, PingURI: "/hello", HealthCheck: "none"}
 	err = serverConfig2.Store(kvs)
 	if err != nil {
 		t.Fatal(err)
@@ -1,7 +1,6 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/kvstore"
@@ -22,7 +21,7 @@ func JSONToListener(bytes []byte) *ListenerConfig {
 	}
 
 	l = new(ListenerConfig)
-	if err := json.Unmarshal(bytes, l); err != nil {
+	if err := decodeDefinition(ListenerKind, bytes, l); err != nil {
 		log.Warn("Error unmarshalling ListenerConfig:", err.Error())
 		l = nil
 	}
@@ -31,14 +30,14 @@ func JSONToListener(bytes []byte) *ListenerConfig {
 
 //Store persists the listener defintions in the supplied key value store
 func (listenerConfig *ListenerConfig) Store(kvs kvstore.KVStore) (err error) {
-	b, err := json.Marshal(listenerConfig)
+	b, err := marshalDefinition(listenerConfig)
 	if err != nil {
 		return err
 	}
 
 	key := fmt.Sprintf("listeners/%s", listenerConfig.Name)
 	log.Info(fmt.Sprintf("adding %s under key %s", string(b), key))
-	err = kvs.Put(key, b)
+	err = storeDefinition(ListenerKind, listenerConfig.Name, b, kvs)
 	return
 }
 
@@ -54,6 +53,30 @@ func ReadListenerConfig(name string, kvs kvstore.KVStore) (*ListenerConfig, erro
 	return JSONToListener(bv), nil
 }
 
+//ReadListenerConfigWithIndex retrieves the named listener definition along with the modify index
+//of the definition, which can be passed to StoreIfUnmodified. The index is 0 if the
+//definition does not exist.
+func ReadListenerConfigWithIndex(name string, kvs kvstore.KVStore) (*ListenerConfig, uint64, error) {
+	bv, index, err := readKeyWithIndex("listeners/"+name, kvs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return JSONToListener(bv), index, nil
+}
+
+//StoreIfUnmodified persists the listener definition only if it has not been modified since
+//index was read. An index of 0 stores the definition only if it does not already exist.
+//ErrDefinitionModified is returned if the definition was modified.
+func (listenerConfig *ListenerConfig) StoreIfUnmodified(kvs kvstore.KVStore, index uint64) error {
+	b, err := marshalDefinition(listenerConfig)
+	if err != nil {
+		return err
+	}
+
+	return storeIfUnmodified(ListenerKind, listenerConfig.Name, b, index, kvs)
+}
+
 //ListListenerConfigs retrieves the listener defs from the KVS
 func ListListenerConfigs(kvs kvstore.KVStore) ([]*ListenerConfig, error) {
 	pairs, err := kvs.List("listeners/")
@@ -68,3 +91,19 @@ func ListListenerConfigs(kvs kvstore.KVStore) ([]*ListenerConfig, error) {
 
 	return listeners, nil
 }
+
+//DeleteListenerConfig removes the named listener definition from the supplied KVS
+func DeleteListenerConfig(name string, kvs kvstore.KVStore) error {
+	lc, err := ReadListenerConfig(name, kvs)
+	if err != nil {
+		return err
+	}
+
+	if lc == nil {
+		return ErrNoSuchListener
+	}
+
+	key := "listeners/" + name
+	log.Info("deleting key ", key)
+	return deleteDefinition(ListenerKind, name, kvs)
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/xtracdev/xavi/kvstore"
+)
+
+//definitionPrefixes are the top level key prefixes used for the config definitions and
+//their revision history
+var definitionPrefixes = []string{"servers/", "backends/", "routes/", "listeners/", historyPrefix}
+
+//ListNamespaces returns the namespaces holding config definitions in the underlying
+//store. The default namespace, used when no namespace is configured, is returned as the
+//empty string.
+func ListNamespaces(kvs kvstore.KVStore) ([]string, error) {
+	return kvstore.ListNamespaces(kvs, definitionPrefixes...)
+}
+
+//CheckKVStoreAccess verifies the credentials of the supplied KVS allow the config
+//definitions to be read and written
+func CheckKVStoreAccess(kvs kvstore.KVStore) error {
+	return kvstore.CheckAccess(kvs, definitionPrefixes...)
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xtracdev/xavi/kvstore"
+	"reflect"
+	"sort"
+)
+
+//Actions recorded for the definitions in a listener's configuration that a plan changes
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+//FieldChange records the old and new values of a modified field, encoded as JSON
+type FieldChange struct {
+	Field string
+	Old   string `json:",omitempty"`
+	New   string `json:",omitempty"`
+}
+
+//Change records a definition added to, removed from or modified in a listener's
+//configuration. Added and removed definitions are added to or removed from the listener's
+//tree of definitions, not necessarily the KVStore.
+type Change struct {
+	Action string
+	Kind   string
+	Name   string
+	Fields []*FieldChange `json:",omitempty"`
+}
+
+//Plan lists the changes applying a set of definitions would make to a listener's
+//configuration, relative to the stored definitions and, if ActiveRecorded is set, the
+//listener's active configuration
+type Plan struct {
+	Listener       string
+	Stored         []*Change
+	Active         []*Change `json:",omitempty"`
+	ActiveRecorded bool      `json:",omitempty"`
+}
+
+//ServiceConfig links up the named listener with the routes, backends and servers it uses
+//taken from the definitions, in the same way ReadServiceConfig does for the definitions in
+//a KVStore. Secret references are not resolved.
+func (d *Definitions) ServiceConfig(listenerName string) (*ServiceConfig, error) {
+	if listenerName == "" {
+		return nil, ErrNoListenerName
+	}
+
+	index := d.index()
+	listener, ok := index[ListenerKind][listenerName].(*ListenerConfig)
+	if !ok {
+		return nil, errors.New("Listener config '" + listenerName + "' not found")
+	}
+
+	sc := &ServiceConfig{Listener: listener}
+	for _, routeName := range listener.RouteNames {
+		route, ok := index[RouteKind][routeName].(*RouteConfig)
+		if !ok {
+			return nil, errors.New("Route config '" + routeName + "' not found")
+		}
+
+		sr := &ServiceRoute{Route: route}
+		for _, backendName := range route.Backends {
+			backend, ok := index[BackendKind][backendName].(*BackendConfig)
+			if !ok {
+				return nil, errors.New("Backend defnition for '" + backendName + "' not found")
+			}
+
+			be := &ServiceBackend{Backend: backend}
+			for _, serverName := range backend.ServerNames {
+				server, ok := index[ServerKind][serverName].(*ServerConfig)
+				if !ok {
+					return nil, errors.New("Server config '" + serverName + "' not found")
+				}
+				be.Servers = append(be.Servers, server)
+			}
+
+			sr.Backends = append(sr.Backends, be)
+		}
+
+		sc.Routes = append(sc.Routes, sr)
+	}
+
+	return sc, nil
+}
+
+//index returns the definitions by kind and name
+func (d *Definitions) index() map[string]map[string]interface{} {
+	index := map[string]map[string]interface{}{
+		ServerKind: {}, BackendKind: {}, RouteKind: {}, ListenerKind: {},
+	}
+	for _, e := range d.entries() {
+		index[e.kind][e.name] = e.value
+	}
+	return index
+}
+
+//definitionsIn returns the definitions in a service config by kind and name
+func definitionsIn(sc *ServiceConfig) map[string]map[string]interface{} {
+	defs := map[string]map[string]interface{}{
+		ServerKind: {}, BackendKind: {}, RouteKind: {}, ListenerKind: {},
+	}
+	if sc == nil {
+		return defs
+	}
+
+	if sc.Listener != nil {
+		defs[ListenerKind][sc.Listener.Name] = sc.Listener
+	}
+	for _, r := range sc.Routes {
+		defs[RouteKind][r.Route.Name] = r.Route
+		for _, b := range r.Backends {
+			defs[BackendKind][b.Backend.Name] = b.Backend
+			for _, s := range b.Servers {
+				defs[ServerKind][s.Name] = s
+			}
+		}
+	}
+	return defs
+}
+
+//DiffServiceConfigs returns the changes that turn one service config into another. Either
+//config may be nil, in which case all the definitions in the other are added or removed.
+//Changes are listed by kind, from the listener down to the servers, and then by name.
+func DiffServiceConfigs(from, to *ServiceConfig) ([]*Change, error) {
+	fromDefs, toDefs := definitionsIn(from), definitionsIn(to)
+
+	var changes []*Change
+	for _, kind := range []string{ListenerKind, RouteKind, BackendKind, ServerKind} {
+		var names []string
+		for name := range fromDefs[kind] {
+			names = append(names, name)
+		}
+		for name := range toDefs[kind] {
+			if _, ok := fromDefs[kind][name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fromDef, inFrom := fromDefs[kind][name]
+			toDef, inTo := toDefs[kind][name]
+			switch {
+			case !inFrom:
+				changes = append(changes, &Change{Action: ChangeAdded, Kind: kind, Name: name})
+			case !inTo:
+				changes = append(changes, &Change{Action: ChangeRemoved, Kind: kind, Name: name})
+			default:
+				fields, err := diffFields(fromDef, toDef)
+				if err != nil {
+					return nil, err
+				}
+
+				if len(fields) == 0 {
+					continue
+				}
+
+				changes = append(changes, &Change{Action: ChangeModified, Kind: kind, Name: name, Fields: fields})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+//diffFields compares two definitions of the same type field by field
+func diffFields(from, to interface{}) ([]*FieldChange, error) {
+	fromValue, toValue := reflect.ValueOf(from).Elem(), reflect.ValueOf(to).Elem()
+	if fromValue.Type() != toValue.Type() {
+		return nil, fmt.Errorf("Cannot compare a %s to a %s", fromValue.Type(), toValue.Type())
+	}
+
+	var changes []*FieldChange
+	for i := 0; i < fromValue.NumField(); i++ {
+		oldValue, err := fieldJSON(fromValue.Field(i))
+		if err != nil {
+			return nil, err
+		}
+
+		newValue, err := fieldJSON(toValue.Field(i))
+		if err != nil {
+			return nil, err
+		}
+
+		if oldValue != newValue {
+			changes = append(changes, &FieldChange{Field: fromValue.Type().Field(i).Name, Old: oldValue, New: newValue})
+		}
+	}
+
+	return changes, nil
+}
+
+//fieldJSON returns the JSON encoding of a field. Empty slices are encoded the same way
+//whether they are nil or not.
+func fieldJSON(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Slice && v.Len() == 0 {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(v.Interface())
+	return string(b), err
+}
+
+//PlanDefinitions works out the changes applying the proposed definitions would make to the
+//named listener's configuration. The listener's configuration with the proposed definitions
+//applied is compared to its configuration in the supplied KVS, which may not yet include
+//the listener, and to the active configuration if one is recorded for the listener in this
+//process. Secret references are compared as stored, without being resolved.
+func PlanDefinitions(listenerName string, proposed *Definitions, kvs kvstore.KVStore) (*Plan, error) {
+	return PlanDefinitionsWithActive(listenerName, proposed, kvs, ActiveConfigForListener(listenerName))
+}
+
+//PlanDefinitionsWithActive works out the changes applying the proposed definitions would make
+//to the named listener's configuration as PlanDefinitions does, comparing them to the given
+//active configuration, for example one served by the process running the listener. The
+//active configuration may be nil if the listener is not running.
+func PlanDefinitionsWithActive(listenerName string, proposed *Definitions, kvs kvstore.KVStore, active *ServiceConfig) (*Plan, error) {
+	if listenerName == "" {
+		return nil, ErrNoListenerName
+	}
+
+	if kvs == nil {
+		return nil, ErrNoKVStore
+	}
+
+	stored, err := ExportDefinitions("", kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := stored.Overlay(proposed).ServiceConfig(listenerName)
+	if err != nil {
+		return nil, err
+	}
+
+	var from *ServiceConfig
+	if _, ok := stored.index()[ListenerKind][listenerName]; ok {
+		if from, err = stored.ServiceConfig(listenerName); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := &Plan{Listener: listenerName}
+	if plan.Stored, err = DiffServiceConfigs(from, to); err != nil {
+		return nil, err
+	}
+
+	if active != nil {
+		plan.ActiveRecorded = true
+		if plan.Active, err = DiffServiceConfigs(active, to); err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
+	"os"
+	"testing"
+)
+
+func testPlanKVStore(t *testing.T) kvstore.KVStore {
+	kvs, _ := kvstore.NewHashKVStore("")
+	defs, err := ParseDefinitions([]byte(testDefinitionsYAML), FormatYAML)
+	if assert.Nil(t, err) {
+		_, err = defs.Apply(kvs)
+		assert.Nil(t, err)
+	}
+	return kvs
+}
+
+func TestPlanDefinitions(t *testing.T) {
+	kvs := testPlanKVStore(t)
+
+	plan, err := PlanDefinitions("l1", &Definitions{
+		Servers:   []*ServerConfig{{Name: "s1", Address: "localhost", Port: 3001, HealthCheck: "none"}},
+		Routes:    []*RouteConfig{{Name: "r2", URIRoot: "/two", Backends: []string{"b1"}}},
+		Listeners: []*ListenerConfig{{Name: "l1", RouteNames: []string{"r2"}, HealthEndpoint: true}},
+	}, kvs)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	if assert.Equal(t, 4, len(plan.Stored)) {
+		assert.Equal(t, &Change{Action: ChangeModified, Kind: ListenerKind, Name: "l1",
+			Fields: []*FieldChange{{Field: "RouteNames", Old: `["r1"]`, New: `["r2"]`}}}, plan.Stored[0])
+		assert.Equal(t, &Change{Action: ChangeRemoved, Kind: RouteKind, Name: "r1"}, plan.Stored[1])
+		assert.Equal(t, &Change{Action: ChangeAdded, Kind: RouteKind, Name: "r2"}, plan.Stored[2])
+		assert.Equal(t, &Change{Action: ChangeModified, Kind: ServerKind, Name: "s1",
+			Fields: []*FieldChange{{Field: "Port", Old: "3000", New: "3001"}}}, plan.Stored[3])
+	}
+
+	plan, err = PlanDefinitions("l1", &Definitions{}, kvs)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(plan.Stored))
+	assert.False(t, plan.ActiveRecorded)
+}
+
+func TestPlanAgainstActiveConfig(t *testing.T) {
+	kvs := testPlanKVStore(t)
+	active, err := ReadServiceConfig("l1", kvs)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	//The stored definitions change after the listener started
+	(&RouteConfig{Name: "r1", URIRoot: "/uno", Backends: []string{"b1"}}).Store(kvs)
+
+	plan, err := PlanDefinitionsWithActive("l1", &Definitions{
+		Servers: []*ServerConfig{{Name: "s1", Address: "localhost", Port: 3001, HealthCheck: "none"}},
+	}, kvs, active)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.True(t, plan.ActiveRecorded)
+	assert.Equal(t, 1, len(plan.Stored))
+	if assert.Equal(t, 2, len(plan.Active)) {
+		assert.Equal(t, &Change{Action: ChangeModified, Kind: RouteKind, Name: "r1",
+			Fields: []*FieldChange{{Field: "URIRoot", Old: `"/one"`, New: `"/uno"`}}}, plan.Active[0])
+		assert.Equal(t, ServerKind, plan.Active[1].Kind)
+	}
+
+	t.Log("The active configuration recorded in this process is used by default")
+	RecordActiveConfig(active)
+	defer delete(activeConfig, "l1")
+	plan, err = PlanDefinitions("l1", &Definitions{}, kvs)
+	assert.Nil(t, err)
+	assert.True(t, plan.ActiveRecorded)
+	assert.Equal(t, 1, len(plan.Active))
+}
+
+func TestPlanNewListener(t *testing.T) {
+	kvs := testPlanKVStore(t)
+
+	plan, err := PlanDefinitions("l2", &Definitions{
+		Listeners: []*ListenerConfig{{Name: "l2", RouteNames: []string{"r1"}}},
+	}, kvs)
+	if assert.Nil(t, err) && assert.Equal(t, 4, len(plan.Stored)) {
+		for _, c := range plan.Stored {
+			assert.Equal(t, ChangeAdded, c.Action)
+		}
+	}
+
+	_, err = PlanDefinitions("l2", &Definitions{
+		Listeners: []*ListenerConfig{{Name: "l2", RouteNames: []string{"r2"}}},
+	}, kvs)
+	assert.NotNil(t, err, "Plans referencing undefined routes cannot be resolved")
+
+	_, err = PlanDefinitions("", &Definitions{}, kvs)
+	assert.Equal(t, ErrNoListenerName, err)
+}
+
+func TestPlanShowsSecretReferences(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&ServerConfig{Name: "plan-s1", Address: "localhost", Port: 3000}).Store(kvs)
+	(&BackendConfig{Name: "plan-b1", ServerNames: []string{"plan-s1"}}).Store(kvs)
+	(&RouteConfig{Name: "plan-r1", URIRoot: "/one", Backends: []string{"plan-b1"}}).Store(kvs)
+	(&ListenerConfig{Name: "plan-l1", RouteNames: []string{"plan-r1"}}).Store(kvs)
+
+	os.Setenv("XAVI_PLAN_TEST_ADDRESS", "otherhost")
+	defer os.Unsetenv("XAVI_PLAN_TEST_ADDRESS")
+
+	plan, err := PlanDefinitions("plan-l1", &Definitions{
+		Servers: []*ServerConfig{{Name: "plan-s1", Address: "${env:XAVI_PLAN_TEST_ADDRESS}", Port: 3000}},
+	}, kvs)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	if assert.Equal(t, 1, len(plan.Stored)) {
+		assert.Equal(t, `"${env:XAVI_PLAN_TEST_ADDRESS}"`, plan.Stored[0].Fields[0].New,
+			"Secret references are shown unresolved")
+	}
+}
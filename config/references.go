@@ -0,0 +1,285 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/kvstore"
+	"strings"
+)
+
+//ErrNoSuchRoute is returned when deleting a route definition that does not exist
+var ErrNoSuchRoute = errors.New("Route definition not found")
+
+//ErrNoSuchListener is returned when deleting a listener definition that does not exist
+var ErrNoSuchListener = errors.New("Listener definition not found")
+
+//InUseError is returned when a definition cannot be deleted because other definitions
+//still refer to it
+type InUseError struct {
+	Kind         string
+	Name         string
+	ReferencedBy []string
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("%s definition '%s' is referenced by %s", e.Kind, e.Name, strings.Join(e.ReferencedBy, ", "))
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func keysFor(prefix string, names []string) []string {
+	var keys []string
+	for _, n := range names {
+		keys = append(keys, prefix+n)
+	}
+	return keys
+}
+
+//BackendsReferencingServer returns the names of the backend definitions that include
+//the named server
+func BackendsReferencingServer(name string, kvs kvstore.KVStore) ([]string, error) {
+	backends, err := ListBackendConfigs(kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, b := range backends {
+		if b != nil && contains(b.ServerNames, name) {
+			names = append(names, b.Name)
+		}
+	}
+
+	return names, nil
+}
+
+//RoutesReferencingBackend returns the names of the route definitions that include
+//the named backend
+func RoutesReferencingBackend(name string, kvs kvstore.KVStore) ([]string, error) {
+	routes, err := ListRouteConfigs(kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, r := range routes {
+		if r != nil && contains(r.Backends, name) {
+			names = append(names, r.Name)
+		}
+	}
+
+	return names, nil
+}
+
+//ListenersReferencingRoute returns the names of the listener definitions that include
+//the named route
+func ListenersReferencingRoute(name string, kvs kvstore.KVStore) ([]string, error) {
+	listeners, err := ListListenerConfigs(kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, l := range listeners {
+		if l != nil && contains(l.RouteNames, name) {
+			names = append(names, l.Name)
+		}
+	}
+
+	return names, nil
+}
+
+//cascadeDeletion collects the changes made by a delete with cascade. Deleting a definition
+//removes the reference to it from each definition that refers to it, and only the definitions
+//left referring to nothing are deleted in turn.
+type cascadeDeletion struct {
+	kvs     kvstore.KVStore
+	changes []*cascadeChange
+	byKey   map[string]*cascadeChange
+}
+
+//cascadeChange is a definition deleted or updated by a cascade, along with the modify index
+//it was read at
+type cascadeChange struct {
+	kind    string
+	name    string
+	def     interface{}
+	index   uint64
+	deleted bool
+}
+
+func newCascadeDeletion(kvs kvstore.KVStore) *cascadeDeletion {
+	return &cascadeDeletion{kvs: kvs, byKey: make(map[string]*cascadeChange)}
+}
+
+//read returns the change for the named definition, reading the definition the first
+//time it is changed by the cascade
+func (cd *cascadeDeletion) read(kind, name string) (*cascadeChange, error) {
+	if c, ok := cd.byKey[kind+"/"+name]; ok {
+		return c, nil
+	}
+
+	c := &cascadeChange{kind: kind, name: name}
+	var err error
+	switch kind {
+	case ServerKind:
+		c.def, c.index, err = ReadServerConfigWithIndex(name, cd.kvs)
+	case BackendKind:
+		c.def, c.index, err = ReadBackendConfigWithIndex(name, cd.kvs)
+	case RouteKind:
+		c.def, c.index, err = ReadRouteConfigWithIndex(name, cd.kvs)
+	case ListenerKind:
+		c.def, c.index, err = ReadListenerConfigWithIndex(name, cd.kvs)
+	default:
+		err = fmt.Errorf("Unknown definition kind %s", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cd.byKey[kind+"/"+name] = c
+	cd.changes = append(cd.changes, c)
+	return c, nil
+}
+
+//delete deletes the named definition, removing it from the definitions that refer to it
+func (cd *cascadeDeletion) delete(kind, name string) error {
+	c, err := cd.read(kind, name)
+	if err != nil {
+		return err
+	}
+
+	if c.deleted {
+		return nil
+	}
+	c.deleted = true
+
+	var parentKind string
+	var referencing []string
+	switch kind {
+	case ServerKind:
+		parentKind = BackendKind
+		referencing, err = BackendsReferencingServer(name, cd.kvs)
+	case BackendKind:
+		parentKind = RouteKind
+		referencing, err = RoutesReferencingBackend(name, cd.kvs)
+	case RouteKind:
+		parentKind = ListenerKind
+		referencing, err = ListenersReferencingRoute(name, cd.kvs)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, p := range referencing {
+		parent, err := cd.read(parentKind, p)
+		if err != nil {
+			return err
+		}
+
+		if parent.deleted {
+			continue
+		}
+
+		if parent.removeReference(name) > 0 {
+			log.Infof("Removing %s %s from %s %s", kind, name, parentKind, p)
+			continue
+		}
+
+		log.Infof("Deleting %s %s, which only referenced %s %s", parentKind, p, kind, name)
+		if err := cd.delete(parentKind, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//removeReference removes the named definition from the definitions referenced by the
+//changed definition, returning the number of references left
+func (c *cascadeChange) removeReference(name string) int {
+	without := func(names []string) []string {
+		var remaining []string
+		for _, n := range names {
+			if n != name {
+				remaining = append(remaining, n)
+			}
+		}
+		return remaining
+	}
+
+	switch def := c.def.(type) {
+	case *BackendConfig:
+		def.ServerNames = without(def.ServerNames)
+		return len(def.ServerNames)
+	case *RouteConfig:
+		def.Backends = without(def.Backends)
+		return len(def.Backends)
+	case *ListenerConfig:
+		def.RouteNames = without(def.RouteNames)
+		return len(def.RouteNames)
+	}
+
+	return 0
+}
+
+//ops returns the transaction operations that make the changes. ErrTxnConflict is returned
+//if a changed definition has been modified since the cascade read it.
+func (cd *cascadeDeletion) ops() ([]*kvstore.TxnOp, error) {
+	var ops []*kvstore.TxnOp
+	for _, c := range cd.changes {
+		var value []byte
+		if !c.deleted {
+			var err error
+			if value, err = marshalDefinition(c.def); err != nil {
+				return nil, err
+			}
+		}
+
+		defOps, index, err := definitionOps(c.kind, c.name, value, cd.kvs)
+		if err != nil {
+			return nil, err
+		}
+
+		if index != c.index {
+			return nil, kvstore.ErrTxnConflict
+		}
+
+		ops = append(ops, defOps...)
+	}
+
+	return ops, nil
+}
+
+//deleteCascading deletes the named definition along with the references to it in a single
+//transaction, so either the whole cascade is applied or none of it is
+func deleteCascading(kind, name string, kvs kvstore.KVStore) error {
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		cd := newCascadeDeletion(kvs)
+		if err := cd.delete(kind, name); err != nil {
+			return err
+		}
+
+		ops, err := cd.ops()
+		if err == nil {
+			log.Infof("Deleting %s %s with cascade in a transaction of %d operations", kind, name, len(ops))
+			err = kvs.Txn(ops)
+		}
+
+		if err != kvstore.ErrTxnConflict {
+			return txnError(err, len(cd.changes))
+		}
+
+		log.Infof("Conflict deleting %s %s with cascade - retrying", kind, name)
+	}
+
+	return ErrWriteConflict
+}
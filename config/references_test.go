@@ -0,0 +1,137 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
+	"testing"
+)
+
+func loadDeleteTestConfig(t *testing.T) kvstore.KVStore {
+	kvs, _ := kvstore.NewHashKVStore("")
+
+	s1 := &ServerConfig{Name: "s1", Address: "localhost", Port: 3000, HealthCheck: "none"}
+	s2 := &ServerConfig{Name: "s2", Address: "localhost", Port: 3100, HealthCheck: "none"}
+	b1 := &BackendConfig{Name: "b1", ServerNames: []string{"s1"}}
+	b2 := &BackendConfig{Name: "b2", ServerNames: []string{"s1", "s2"}}
+	r1 := &RouteConfig{Name: "r1", URIRoot: "/one", Backends: []string{"b1"}}
+	r2 := &RouteConfig{Name: "r2", URIRoot: "/two", Backends: []string{"b2"}}
+	l1 := &ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}
+	l2 := &ListenerConfig{Name: "l2", RouteNames: []string{"r2"}}
+
+	for _, err := range []error{s1.Store(kvs), s2.Store(kvs), b1.Store(kvs), b2.Store(kvs),
+		r1.Store(kvs), r2.Store(kvs), l1.Store(kvs), l2.Store(kvs)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return kvs
+}
+
+func TestReferencingDefinitions(t *testing.T) {
+	kvs := loadDeleteTestConfig(t)
+
+	backends, err := BackendsReferencingServer("s1", kvs)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(backends))
+
+	routes, err := RoutesReferencingBackend("b2", kvs)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"r2"}, routes)
+
+	listeners, err := ListenersReferencingRoute("r1", kvs)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"l1"}, listeners)
+
+	listeners, err = ListenersReferencingRoute("nope", kvs)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(listeners))
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	kvs := loadDeleteTestConfig(t)
+	assert.Equal(t, ErrNoSuchServer, DeleteServerConfig("nope", kvs, false))
+	assert.Equal(t, ErrNoSuchBackend, DeleteBackendConfig("nope", kvs, false))
+	assert.Equal(t, ErrNoSuchRoute, DeleteRouteConfig("nope", kvs, false))
+	assert.Equal(t, ErrNoSuchListener, DeleteListenerConfig("nope", kvs))
+}
+
+func TestDeleteReferencedRefused(t *testing.T) {
+	kvs := loadDeleteTestConfig(t)
+
+	err := DeleteServerConfig("s2", kvs, false)
+	if assert.NotNil(t, err) {
+		inUse, ok := err.(*InUseError)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"backends/b2"}, inUse.ReferencedBy)
+	}
+
+	s, err := ReadServerConfig("s2", kvs)
+	assert.Nil(t, err)
+	assert.NotNil(t, s)
+}
+
+func TestDeleteUnreferenced(t *testing.T) {
+	kvs := loadDeleteTestConfig(t)
+
+	err := DeleteListenerConfig("l1", kvs)
+	assert.Nil(t, err)
+
+	err = DeleteRouteConfig("r1", kvs, false)
+	assert.Nil(t, err)
+
+	err = DeleteBackendConfig("b1", kvs, false)
+	assert.Nil(t, err)
+
+	r, err := ReadRouteConfig("r1", kvs)
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+}
+
+func TestDeleteCascade(t *testing.T) {
+	kvs := loadDeleteTestConfig(t)
+
+	err := DeleteServerConfig("s1", kvs, true)
+	assert.Nil(t, err)
+
+	servers, _ := ListServerConfigs(kvs)
+	assert.Equal(t, 1, len(servers))
+
+	t.Log("Backends with other servers only lose the reference")
+	b2, _ := ReadBackendConfig("b2", kvs)
+	if assert.NotNil(t, b2) {
+		assert.Equal(t, []string{"s2"}, b2.ServerNames)
+	}
+	l2, _ := ReadListenerConfig("l2", kvs)
+	assert.NotNil(t, l2)
+
+	t.Log("Definitions left empty are deleted in turn")
+	b1, _ := ReadBackendConfig("b1", kvs)
+	assert.Nil(t, b1)
+	r1, _ := ReadRouteConfig("r1", kvs)
+	assert.Nil(t, r1)
+	l1, _ := ReadListenerConfig("l1", kvs)
+	assert.Nil(t, l1)
+
+	revisions, _ := ListRevisions(BackendKind, "b2", kvs)
+	assert.Equal(t, 2, len(revisions))
+}
+
+func TestDeleteCascadeIsAllOrNothing(t *testing.T) {
+	hkvs := loadDeleteTestConfig(t).(*kvstore.HashKVStore)
+	kvs := &conflictingKVStore{HashKVStore: hkvs, conflicts: maxWriteAttempts}
+
+	assert.Equal(t, ErrWriteConflict, DeleteBackendConfig("b1", kvs, true))
+
+	b1, _ := ReadBackendConfig("b1", kvs)
+	assert.NotNil(t, b1)
+	r1, _ := ReadRouteConfig("r1", kvs)
+	assert.NotNil(t, r1)
+	l1, _ := ReadListenerConfig("l1", kvs)
+	assert.NotNil(t, l1)
+
+	kvs.conflicts = 1
+	assert.Nil(t, DeleteRouteConfig("r1", kvs, true))
+	l1, _ = ReadListenerConfig("l1", kvs)
+	assert.Nil(t, l1)
+}
@@ -1,7 +1,6 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/kvstore"
@@ -15,6 +14,14 @@ type RouteConfig struct {
 	Plugins             []string
 	MultiBackendAdapter string
 	MsgProps            string
+	Timeout             int `json:",omitempty"` //Overall request timeout in milliseconds, 0 for none
+
+	//Retry settings overriding those of the route's backends when RetryAttempts is set.
+	//RetryAttempts of 1 turns retries off for the route.
+	RetryAttempts      int    `json:",omitempty"`
+	RetryOn            string `json:",omitempty"`
+	RetryNonIdempotent bool   `json:",omitempty"`
+	RetryBackoff       int    `json:",omitempty"`
 }
 
 //JSONToRoute unmarshals the JSON representation of a route definition
@@ -25,7 +32,7 @@ func JSONToRoute(bytes []byte) *RouteConfig {
 	}
 
 	r = new(RouteConfig)
-	if err := json.Unmarshal(bytes, r); err != nil {
+	if err := decodeDefinition(RouteKind, bytes, r); err != nil {
 		log.Warn("Error unmarshalling RouteConfig: ", err.Error())
 		r = nil
 	}
@@ -35,14 +42,14 @@ func JSONToRoute(bytes []byte) *RouteConfig {
 
 //Store persists the route definition using the supplied KVS
 func (routeConfig *RouteConfig) Store(kvs kvstore.KVStore) error {
-	b, err := json.Marshal(routeConfig)
+	b, err := marshalDefinition(routeConfig)
 	if err != nil {
 		return nil
 	}
 
 	key := fmt.Sprintf("routes/%s", routeConfig.Name)
 	log.Info(fmt.Sprintf("adding %s under key %s", string(b), key))
-	err = kvs.Put(key, b)
+	err = storeDefinition(RouteKind, routeConfig.Name, b, kvs)
 	if err != nil {
 		return err
 	}
@@ -61,6 +68,36 @@ func ReadRouteConfig(name string, kvs kvstore.KVStore) (*RouteConfig, error) {
 	return JSONToRoute(bv), nil
 }
 
+//ReadRouteConfigWithIndex retrieves the named route definition along with the modify index
+//of the definition, which can be passed to StoreIfUnmodified. The index is 0 if the
+//definition does not exist.
+func ReadRouteConfigWithIndex(name string, kvs kvstore.KVStore) (*RouteConfig, uint64, error) {
+	bv, index, err := readKeyWithIndex("routes/"+name, kvs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return JSONToRoute(bv), index, nil
+}
+
+//StoreIfUnmodified persists the route definition only if it has not been modified since
+//index was read. An index of 0 stores the definition only if it does not already exist.
+//ErrDefinitionModified is returned if the definition was modified.
+func (routeConfig *RouteConfig) StoreIfUnmodified(kvs kvstore.KVStore, index uint64) error {
+	b, err := marshalDefinition(routeConfig)
+	if err != nil {
+		return err
+	}
+
+	return storeIfUnmodified(RouteKind, routeConfig.Name, b, index, kvs)
+}
+
+//ResolveSecrets replaces secret references in the route's MsgProps with their
+//resolved values
+func (routeConfig *RouteConfig) ResolveSecrets() error {
+	return resolveSecretField(RouteKind, routeConfig.Name, "MsgProps", &routeConfig.MsgProps)
+}
+
 //ListRouteConfigs returns the route configs in the key value store
 func ListRouteConfigs(kvs kvstore.KVStore) ([]*RouteConfig, error) {
 	pairs, err := kvs.List("routes/")
@@ -75,3 +112,34 @@ func ListRouteConfigs(kvs kvstore.KVStore) ([]*RouteConfig, error) {
 
 	return routes, nil
 }
+
+//DeleteRouteConfig removes the named route definition from the supplied KVS. If listener
+//definitions still reference the route an InUseError is returned, unless cascade is set,
+//in which case the route is removed from the referencing listeners in the same transaction.
+//Only listeners left with no routes are deleted.
+func DeleteRouteConfig(name string, kvs kvstore.KVStore, cascade bool) error {
+	rc, err := ReadRouteConfig(name, kvs)
+	if err != nil {
+		return err
+	}
+
+	if rc == nil {
+		return ErrNoSuchRoute
+	}
+
+	listeners, err := ListenersReferencingRoute(name, kvs)
+	if err != nil {
+		return err
+	}
+
+	if len(listeners) > 0 {
+		if !cascade {
+			return &InUseError{Kind: "Route", Name: name, ReferencedBy: keysFor("listeners/", listeners)}
+		}
+		return deleteCascading(RouteKind, name, kvs)
+	}
+
+	key := "routes/" + name
+	log.Info("deleting key ", key)
+	return deleteDefinition(RouteKind, name, kvs)
+}
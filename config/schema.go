@@ -0,0 +1,321 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/kvstore"
+	"sort"
+	"strings"
+)
+
+//schemaVersionField is the name of the field recording the schema version in the stored
+//representation of a definition. Definitions stored before schema versions were introduced
+//have no such field, and are treated as version 0.
+const schemaVersionField = "SchemaVersion"
+
+//Migration upgrades the JSON representation of a definition of the given kind by one schema
+//version, modifying def in place. The same migration is applied to all kinds of
+//definition, so migrations that only concern one kind should check kind. Field names in
+//definitions read from files may be in any case.
+type Migration func(kind string, def map[string]interface{}) error
+
+//migrations holds the registered migrations in order - migrations[v] upgrades a
+//definition from version v to v+1
+var migrations = []Migration{
+	//Version 1 records the schema version on definitions, there is nothing else to change
+	func(kind string, def map[string]interface{}) error { return nil },
+}
+
+//NewerSchemaError is returned when a stored definition has a schema version newer than
+//this version of xavi supports
+type NewerSchemaError struct {
+	Kind    string
+	Version int
+}
+
+func (e *NewerSchemaError) Error() string {
+	return fmt.Sprintf("%s definition has schema version %d, but only versions up to %d are supported",
+		e.Kind, e.Version, CurrentSchemaVersion())
+}
+
+//MigrationResult describes the upgrade of a stored definition to the current schema version
+type MigrationResult struct {
+	Kind        string
+	Name        string
+	FromVersion int
+	ToVersion   int
+}
+
+//CurrentSchemaVersion returns the schema version definitions are stored with
+func CurrentSchemaVersion() int {
+	return len(migrations)
+}
+
+//RegisterMigration registers the migration upgrading definitions from fromVersion to the
+//next version. Migrations must be registered in version order, so fromVersion must be the
+//current schema version.
+func RegisterMigration(fromVersion int, migration Migration) error {
+	if migration == nil {
+		return fmt.Errorf("No migration given for schema version %d", fromVersion)
+	}
+
+	if fromVersion != CurrentSchemaVersion() {
+		return fmt.Errorf("Migrations must be registered in order - expected a migration from version %d, got %d",
+			CurrentSchemaVersion(), fromVersion)
+	}
+
+	migrations = append(migrations, migration)
+	return nil
+}
+
+//schemaVersionOf returns the schema version recorded in the stored representation of a
+//definition
+func schemaVersionOf(def map[string]interface{}) (int, error) {
+	value, ok := def[schemaVersionField]
+	if !ok {
+		return 0, nil
+	}
+
+	return parseSchemaVersion(value)
+}
+
+func parseSchemaVersion(value interface{}) (int, error) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a number", schemaVersionField)
+	}
+
+	version, err := n.Int64()
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("Invalid %s %s", schemaVersionField, n)
+	}
+
+	return int(version), nil
+}
+
+//migrate applies the migrations needed to upgrade def from the given schema version to the
+//current version
+func migrate(kind string, def map[string]interface{}, version int) error {
+	if version > CurrentSchemaVersion() {
+		return &NewerSchemaError{Kind: kind, Version: version}
+	}
+
+	for v := version; v < CurrentSchemaVersion(); v++ {
+		if err := migrations[v](kind, def); err != nil {
+			return fmt.Errorf("Error migrating %s definition from schema version %d: %s",
+				kind, v, err.Error())
+		}
+	}
+
+	return nil
+}
+
+//decodeJSONObject decodes JSON into a generic map, keeping numbers as json.Numbers so
+//they are not changed when the map is marshalled again
+func decodeJSONObject(value []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+//migrateJSON upgrades the stored representation of a definition to the current schema
+//version, returning the upgraded JSON and the version the definition had. The JSON is
+//returned unmodified if it is already current.
+func migrateJSON(kind string, value []byte) ([]byte, int, error) {
+	var def map[string]interface{}
+	if err := decodeJSONObject(value, &def); err != nil {
+		return nil, 0, err
+	}
+
+	if def == nil {
+		return value, CurrentSchemaVersion(), nil
+	}
+
+	version, err := schemaVersionOf(def)
+	if err != nil || version == CurrentSchemaVersion() {
+		return value, version, err
+	}
+
+	if err := migrate(kind, def, version); err != nil {
+		return nil, version, err
+	}
+
+	def[schemaVersionField] = CurrentSchemaVersion()
+	migrated, err := json.Marshal(def)
+	return migrated, version, err
+}
+
+//decodeDefinition unmarshals the JSON representation of a definition into def, migrating
+//it to the current schema version first. Definitions with a newer schema version are
+//decoded as is, ignoring any fields that are not understood. Such definitions are read
+//only - see checkNotNewer.
+func decodeDefinition(kind string, value []byte, def interface{}) error {
+	migrated, _, err := migrateJSON(kind, value)
+	if err != nil {
+		if _, newer := err.(*NewerSchemaError); !newer {
+			return err
+		}
+
+		log.Warn(err.Error())
+		migrated = value
+	}
+
+	return json.Unmarshal(migrated, def)
+}
+
+//checkNotNewer returns a NewerSchemaError if the stored representation of a definition has
+//a newer schema version than this version of xavi supports. Writing over such a definition
+//would downgrade it, losing the fields only newer versions understand. Stored values that
+//cannot be decoded are not checked, so they can be overwritten.
+func checkNotNewer(kind string, stored []byte) error {
+	var def map[string]interface{}
+	if stored == nil || decodeJSONObject(stored, &def) != nil || def == nil {
+		return nil
+	}
+
+	version, err := schemaVersionOf(def)
+	if err == nil && version > CurrentSchemaVersion() {
+		return &NewerSchemaError{Kind: kind, Version: version}
+	}
+
+	return nil
+}
+
+//marshalDefinition returns the stored representation of a definition, which records the
+//current schema version after the definition's fields
+func marshalDefinition(def interface{}) ([]byte, error) {
+	switch d := def.(type) {
+	case *ServerConfig:
+		return json.Marshal(struct {
+			*ServerConfig
+			SchemaVersion int
+		}{d, CurrentSchemaVersion()})
+	case *BackendConfig:
+		return json.Marshal(struct {
+			*BackendConfig
+			SchemaVersion int
+		}{d, CurrentSchemaVersion()})
+	case *RouteConfig:
+		return json.Marshal(struct {
+			*RouteConfig
+			SchemaVersion int
+		}{d, CurrentSchemaVersion()})
+	case *ListenerConfig:
+		return json.Marshal(struct {
+			*ListenerConfig
+			SchemaVersion int
+		}{d, CurrentSchemaVersion()})
+	default:
+		return nil, fmt.Errorf("Unsupported definition type %T", def)
+	}
+}
+
+func newDefinition(kind string) (interface{}, error) {
+	switch kind {
+	case ServerKind:
+		return new(ServerConfig), nil
+	case BackendKind:
+		return new(BackendConfig), nil
+	case RouteKind:
+		return new(RouteConfig), nil
+	case ListenerKind:
+		return new(ListenerConfig), nil
+	default:
+		return nil, fmt.Errorf("Unknown definition kind %s", kind)
+	}
+}
+
+//upgradeDefinition returns the stored representation of a definition migrated to the
+//current schema version, along with the version it was stored with. Migrated definitions
+//are round tripped through their config type so they are stored just as if written by Store.
+func upgradeDefinition(kind string, value []byte) ([]byte, int, error) {
+	migrated, version, err := migrateJSON(kind, value)
+	if err != nil || version == CurrentSchemaVersion() {
+		return migrated, version, err
+	}
+
+	def, err := newDefinition(kind)
+	if err != nil {
+		return nil, version, err
+	}
+
+	if err := json.Unmarshal(migrated, def); err != nil {
+		return nil, version, err
+	}
+
+	upgraded, err := marshalDefinition(def)
+	return upgraded, version, err
+}
+
+//MigrateDefinitions upgrades every definition in the supplied KVS to the current schema
+//version, returning the definitions that were upgraded. All definitions are checked before
+//any are written, so a definition that cannot be migrated leaves the store unmodified. If
+//dryRun is set the definitions that would be upgraded are returned but nothing is written.
+func MigrateDefinitions(kvs kvstore.KVStore, dryRun bool) ([]*MigrationResult, error) {
+	if kvs == nil {
+		return nil, ErrNoKVStore
+	}
+
+	var results []*MigrationResult
+	for _, kind := range DefinitionKinds {
+		prefix := kindPrefixes[kind]
+		pairs, err := kvs.List(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		for _, p := range pairs {
+			name := strings.TrimPrefix(p.Key, prefix)
+			_, version, err := upgradeDefinition(kind, p.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to migrate %s %s: %s", kind, name, err.Error())
+			}
+
+			if version != CurrentSchemaVersion() {
+				results = append(results, &MigrationResult{
+					Kind: kind, Name: name, FromVersion: version, ToVersion: CurrentSchemaVersion(),
+				})
+			}
+		}
+	}
+
+	if dryRun {
+		return results, nil
+	}
+
+	for i, r := range results {
+		if err := migrateStoredDefinition(r, kvs); err != nil {
+			return results[:i], fmt.Errorf("Error migrating %s %s: %s", r.Kind, r.Name, err.Error())
+		}
+	}
+
+	return results, nil
+}
+
+//migrateStoredDefinition upgrades a stored definition, retrying if it is concurrently
+//modified. The upgrade is recorded in the definition's revision history.
+func migrateStoredDefinition(r *MigrationResult, kvs kvstore.KVStore) error {
+	key := kindPrefixes[r.Kind] + r.Name
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		value, index, err := readKeyWithIndex(key, kvs)
+		if err != nil || value == nil {
+			return err
+		}
+
+		upgraded, version, err := upgradeDefinition(r.Kind, value)
+		if err != nil || version == CurrentSchemaVersion() {
+			return err
+		}
+
+		log.Infof("Migrating %s %s from schema version %d to %d", r.Kind, r.Name, version, CurrentSchemaVersion())
+		err = storeIfUnmodified(r.Kind, r.Name, upgraded, index, kvs)
+		if err != ErrDefinitionModified {
+			return err
+		}
+	}
+
+	return ErrWriteConflict
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
+	"testing"
+)
+
+//testRegisterGuardMigration registers a migration renaming the route field Guard to
+//MsgProps, returning a function that removes it again
+func testRegisterGuardMigration(t *testing.T) func() {
+	saved := migrations
+	err := RegisterMigration(CurrentSchemaVersion(), func(kind string, def map[string]interface{}) error {
+		if kind != RouteKind {
+			return nil
+		}
+
+		for field, value := range def {
+			if field == "Guard" || field == "guard" {
+				def["MsgProps"] = value
+				delete(def, field)
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	return func() { migrations = saved }
+}
+
+func TestStoreRecordsSchemaVersion(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&ServerConfig{Name: "s1", Address: "localhost", Port: 3000}).Store(kvs)
+
+	stored, _ := kvs.Get("servers/s1")
+	assert.Contains(t, string(stored), `"SchemaVersion":1}`)
+
+	s, _ := ReadServerConfig("s1", kvs)
+	if assert.NotNil(t, s) {
+		assert.Equal(t, 3000, s.Port)
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	assert.NotNil(t, RegisterMigration(CurrentSchemaVersion(), nil))
+	assert.NotNil(t, RegisterMigration(0, func(string, map[string]interface{}) error { return nil }))
+
+	defer testRegisterGuardMigration(t)()
+	assert.Equal(t, 2, CurrentSchemaVersion())
+}
+
+func TestMigrateDefinitions(t *testing.T) {
+	defer testRegisterGuardMigration(t)()
+
+	kvs, _ := kvstore.NewHashKVStore("")
+	legacy := []byte(`{"Name":"r1","URIRoot":"/one","Backends":["b1"],"Guard":"SOAPAction=foo"}`)
+	kvs.Put("routes/r1", legacy)
+	kvs.Put("listeners/l1", []byte(`{"Name":"l1","RouteNames":["r1"],"SchemaVersion":1}`))
+	(&ServerConfig{Name: "s1", Address: "localhost", Port: 3000}).Store(kvs)
+
+	t.Log("Definitions with an earlier schema version are migrated when read")
+	r, _ := ReadRouteConfig("r1", kvs)
+	if assert.NotNil(t, r) {
+		assert.Equal(t, "SOAPAction=foo", r.MsgProps)
+	}
+
+	results, err := MigrateDefinitions(kvs, true)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(results)) {
+		assert.Equal(t, &MigrationResult{Kind: RouteKind, Name: "r1", FromVersion: 0, ToVersion: 2}, results[0])
+		assert.Equal(t, &MigrationResult{Kind: ListenerKind, Name: "l1", FromVersion: 1, ToVersion: 2}, results[1])
+	}
+
+	stored, _ := kvs.Get("routes/r1")
+	assert.Equal(t, legacy, stored, "A dry run should not modify the store")
+
+	results, err = MigrateDefinitions(kvs, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+
+	stored, _ = kvs.Get("routes/r1")
+	assert.Equal(t, `{"Name":"r1","URIRoot":"/one","Backends":["b1"],"Plugins":null,"MultiBackendAdapter":"","MsgProps":"SOAPAction=foo","SchemaVersion":2}`,
+		string(stored))
+
+	revisions, _ := ListRevisions(RouteKind, "r1", kvs)
+	if assert.Equal(t, 1, len(revisions)) {
+		assert.Equal(t, legacy, []byte(revisions[0].Previous))
+	}
+
+	results, err = MigrateDefinitions(kvs, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(results))
+}
+
+func TestMigrateNewerSchemaVersion(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	kvs.Put("routes/r1", []byte(`{"Name":"r1","URIRoot":"/one","Guard":"x"}`))
+	kvs.Put("servers/s1", []byte(`{"Name":"s1","Address":"localhost","Port":3000,"Weight":3,"SchemaVersion":99}`))
+
+	t.Log("Definitions with a newer schema version are read as well as possible")
+	s, _ := ReadServerConfig("s1", kvs)
+	if assert.NotNil(t, s) {
+		assert.Equal(t, 3000, s.Port)
+	}
+
+	_, err := MigrateDefinitions(kvs, false)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "schema version 99")
+	}
+
+	stored, _ := kvs.Get("routes/r1")
+	assert.Equal(t, `{"Name":"r1","URIRoot":"/one","Guard":"x"}`, string(stored),
+		"Nothing should be migrated when a definition cannot be")
+}
+
+func TestNewerSchemaVersionNotOverwritten(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	newer := `{"Name":"s1","Address":"localhost","Port":3000,"Weight":3,"SchemaVersion":99}`
+	kvs.Put("servers/s1", []byte(newer))
+
+	s, _ := ReadServerConfig("s1", kvs)
+	if !assert.NotNil(t, s) {
+		return
+	}
+
+	s.Port = 3100
+	assert.Equal(t, &NewerSchemaError{Kind: ServerKind, Version: 99}, s.Store(kvs))
+
+	sc := &ServiceConfig{
+		Listener: &ListenerConfig{Name: "l1", RouteNames: []string{"r1"}},
+		Routes: []*ServiceRoute{{
+			Route:    &RouteConfig{Name: "r1", URIRoot: "/one", Backends: []string{"b1"}},
+			Backends: []*ServiceBackend{{Backend: &BackendConfig{Name: "b1", ServerNames: []string{"s1"}}, Servers: []*ServerConfig{s}}},
+		}},
+	}
+	assert.Equal(t, &NewerSchemaError{Kind: ServerKind, Version: 99}, sc.Store(kvs))
+
+	stored, _ := kvs.Get("servers/s1")
+	assert.Equal(t, newer, string(stored))
+	l, _ := ReadListenerConfig("l1", kvs)
+	assert.Nil(t, l, "Nothing is stored when a definition is newer")
+
+	t.Log("Newer definitions can still be deleted")
+	assert.Nil(t, DeleteServerConfig("s1", kvs, false))
+}
+
+func TestRollbackMigratesRevision(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	r := &RouteConfig{Name: "r1", URIRoot: "/one", MsgProps: "SOAPAction=foo"}
+	r.Store(kvs)
+
+	defer testRegisterGuardMigration(t)()
+	r.MsgProps = "SOAPAction=bar"
+	r.Store(kvs)
+
+	assert.Nil(t, Rollback(RouteKind, "r1", 1, kvs))
+	stored, _ := kvs.Get("routes/r1")
+	assert.Contains(t, string(stored), `"MsgProps":"SOAPAction=foo","SchemaVersion":2}`)
+}
+
+func TestParseDefinitionsMigratesSchemaVersion(t *testing.T) {
+	defer testRegisterGuardMigration(t)()
+
+	defs, err := ParseDefinitions([]byte("schemaversion: 1\nroutes:\n- name: r1\n  guard: SOAPAction=foo\n"), FormatYAML)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "SOAPAction=foo", defs.Routes[0].MsgProps)
+	}
+
+	_, err = ParseDefinitions([]byte("routes:\n- name: r1\n  guard: SOAPAction=foo\n"), FormatYAML)
+	assert.NotNil(t, err, "Files without a schema version are assumed to be current")
+
+	_, err = ParseDefinitions([]byte(`{"SchemaVersion": 99, "Routes": [{"Name": "r1"}]}`), FormatJSON)
+	assert.NotNil(t, err)
+
+	out, err := defs.Marshal(FormatYAML)
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(out), "SchemaVersion: 2\n")
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+//SecretResolver resolves the reference portion of a secret reference. For the reference
+//${file:/etc/xavi/key.pem}, the resolver registered for the file scheme is passed
+///etc/xavi/key.pem.
+type SecretResolver interface {
+	ResolveSecret(ref string) (string, error)
+}
+
+//SecretResolverFunc adapts a function to the SecretResolver interface
+type SecretResolverFunc func(ref string) (string, error)
+
+//ResolveSecret calls f(ref)
+func (f SecretResolverFunc) ResolveSecret(ref string) (string, error) {
+	return f(ref)
+}
+
+//Schemes of the resolvers registered by default
+const (
+	FileSecretScheme = "file"
+	EnvSecretScheme  = "env"
+)
+
+//secretRefPattern matches secret references of the form ${scheme:ref}
+var secretRefPattern = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_-]*):([^}]*)\}`)
+
+var secretResolvers = map[string]SecretResolver{
+	FileSecretScheme: SecretResolverFunc(resolveFileSecret),
+	EnvSecretScheme:  SecretResolverFunc(resolveEnvSecret),
+}
+
+//RegisterSecretResolver registers the resolver used for secret references with the
+//given scheme, replacing any resolver previously registered for the scheme.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) error {
+	if scheme == "" {
+		return fmt.Errorf("Empty scheme passed to RegisterSecretResolver")
+	}
+
+	if resolver == nil {
+		return fmt.Errorf("No resolver passed to RegisterSecretResolver for scheme %s", scheme)
+	}
+
+	secretResolvers[scheme] = resolver
+	return nil
+}
+
+//resolveFileSecret returns the contents of the named file. Trailing line breaks are
+//removed so files written with a final newline can hold tokens and passwords.
+func resolveFileSecret(ref string) (string, error) {
+	content, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+//resolveEnvSecret returns the value of the named environment variable
+func resolveEnvSecret(ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("Environment variable %s is not set", ref)
+	}
+	return value, nil
+}
+
+//ContainsSecretRefs indicates if the value contains any secret references
+func ContainsSecretRefs(value string) bool {
+	return secretRefPattern.MatchString(value)
+}
+
+//ResolveSecretRefs replaces each secret reference in the value, for example
+//${env:API_TOKEN}, with the value returned by the resolver registered for the
+//reference's scheme. Values without references are returned unchanged.
+func ResolveSecretRefs(value string) (string, error) {
+	var resolveErr error
+	resolved := secretRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		if resolveErr != nil {
+			return ref
+		}
+
+		parts := secretRefPattern.FindStringSubmatch(ref)
+		scheme, name := parts[1], parts[2]
+
+		resolver, ok := secretResolvers[scheme]
+		if !ok {
+			resolveErr = fmt.Errorf("No secret resolver registered for scheme %s", scheme)
+			return ref
+		}
+
+		secret, err := resolver.ResolveSecret(name)
+		if err != nil {
+			resolveErr = fmt.Errorf("Unable to resolve %s secret %s: %s", scheme, name, err.Error())
+			return ref
+		}
+
+		return secret
+	})
+
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+
+	return resolved, nil
+}
+
+//resolveSecretField resolves the secret references in the named field of a definition
+func resolveSecretField(kind, name, field string, value *string) error {
+	resolved, err := ResolveSecretRefs(*value)
+	if err != nil {
+		return fmt.Errorf("Error resolving %s of %s %s: %s", field, kind, name, err.Error())
+	}
+
+	*value = resolved
+	return nil
+}
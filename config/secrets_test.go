@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeSecretFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "xavi-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestResolveSecretRefs(t *testing.T) {
+	os.Setenv("XAVI_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("XAVI_TEST_SECRET")
+
+	secretFile := writeSecretFile(t, "from-file\n")
+	defer os.Remove(secretFile)
+
+	resolved, err := ResolveSecretRefs("no refs here")
+	assert.Nil(t, err)
+	assert.Equal(t, "no refs here", resolved)
+
+	resolved, err = ResolveSecretRefs("${env:XAVI_TEST_SECRET}")
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cret", resolved)
+
+	resolved, err = ResolveSecretRefs("token=${env:XAVI_TEST_SECRET};other=${file:" + secretFile + "}")
+	assert.Nil(t, err)
+	assert.Equal(t, "token=s3cret;other=from-file", resolved)
+}
+
+func TestResolveSecretRefsErrors(t *testing.T) {
+	os.Unsetenv("XAVI_TEST_UNSET_SECRET")
+
+	_, err := ResolveSecretRefs("${env:XAVI_TEST_UNSET_SECRET}")
+	assert.NotNil(t, err)
+
+	_, err = ResolveSecretRefs("${file:/no/such/xavi/secret}")
+	assert.NotNil(t, err)
+
+	_, err = ResolveSecretRefs("${nosuchscheme:foo}")
+	assert.NotNil(t, err)
+}
+
+func TestRegisterSecretResolver(t *testing.T) {
+	assert.NotNil(t, RegisterSecretResolver("", SecretResolverFunc(resolveEnvSecret)))
+	assert.NotNil(t, RegisterSecretResolver("test-vault", nil))
+
+	secrets := map[string]string{"db/password": "pw"}
+	err := RegisterSecretResolver("test-vault", SecretResolverFunc(func(ref string) (string, error) {
+		secret, ok := secrets[ref]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return secret, nil
+	}))
+	assert.Nil(t, err)
+	defer delete(secretResolvers, "test-vault")
+
+	resolved, err := ResolveSecretRefs("${test-vault:db/password}")
+	assert.Nil(t, err)
+	assert.Equal(t, "pw", resolved)
+
+	_, err = ResolveSecretRefs("${test-vault:db/nope}")
+	assert.NotNil(t, err)
+}
+
+func TestActiveConfigKeepsSecretRefs(t *testing.T) {
+	os.Setenv("XAVI_TEST_SERVER_ADDRESS", "10.0.0.1")
+	os.Setenv("XAVI_TEST_SOAP_ACTION", "foo")
+	defer os.Unsetenv("XAVI_TEST_SERVER_ADDRESS")
+	defer os.Unsetenv("XAVI_TEST_SOAP_ACTION")
+
+	kvs, _ := kvstore.NewHashKVStore("")
+	s1 := &ServerConfig{Name: "s1", Address: "${env:XAVI_TEST_SERVER_ADDRESS}", Port: 3000}
+	b1 := &BackendConfig{Name: "b1", ServerNames: []string{"s1"}}
+	r1 := &RouteConfig{Name: "r1", URIRoot: "/one", Backends: []string{"b1"},
+		MsgProps: `SOAPAction:"${env:XAVI_TEST_SOAP_ACTION}"`}
+	l1 := &ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}
+	for _, def := range []interface {
+		Store(kvstore.KVStore) error
+	}{s1, b1, r1, l1} {
+		if err := def.Store(kvs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	sc, err := ReadServiceConfig("l1", kvs)
+	if !assert.Nil(t, err) {
+		return
+	}
+	RecordActiveConfig(sc)
+	defer delete(activeConfig, "l1")
+
+	assert.Equal(t, "${env:XAVI_TEST_SERVER_ADDRESS}", sc.Routes[0].Backends[0].Servers[0].Address)
+	assert.Equal(t, `SOAPAction:"${env:XAVI_TEST_SOAP_ACTION}"`, sc.Routes[0].Route.MsgProps)
+
+	//The active config is logged without the resolved values
+	assert.Contains(t, logged.String(), "${env:XAVI_TEST_SERVER_ADDRESS}")
+	assert.NotContains(t, logged.String(), "10.0.0.1")
+	assert.NotContains(t, logged.String(), `SOAPAction:"foo"`)
+}
+
+func TestReadCACert(t *testing.T) {
+	pem := "-----BEGIN CERTIFICATE-----\nabc\n-----END CERTIFICATE-----\n"
+	data, err := ReadCACert(pem, "")
+	assert.Nil(t, err)
+	assert.Equal(t, pem, string(data))
+
+	pemFile := writeSecretFile(t, pem)
+	defer os.Remove(pemFile)
+
+	data, err = ReadCACert("", pemFile)
+	assert.Nil(t, err)
+	assert.Equal(t, pem, string(data))
+
+	//A path is always read as a path, even if it holds PEM data
+	_, err = ReadCACert("", pem)
+	assert.NotNil(t, err)
+
+	_, err = ReadCACert(pem, pemFile)
+	assert.Equal(t, ErrCACertAndPath, err)
+
+	data, err = ReadCACert("", "")
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+}
@@ -49,7 +49,7 @@ func TestServerStoreAndRetrieve(t *testing.T) {
 	assert.Nil(t, servers)
 
 	//Store
-	server = &ServerConfig{"s1", "0.0.0.0", 5000, "/ping", "none", 0, 0}
+	server = &ServerConfig{Name: "s1", Address: "0.0.0.0", Port: 5000, PingURI: "/ping", HealthCheck: "none"}
 	err = server.Store(testKVS)
 	assert.Nil(t, err)
 
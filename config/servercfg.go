@@ -1,7 +1,6 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/kvstore"
@@ -16,6 +15,21 @@ type ServerConfig struct {
 	HealthCheck         string
 	HealthCheckInterval int //In milliseconds
 	HealthCheckTimeout  int //In milliseconds
+	Weight              int `json:",omitempty"` //Relative share of requests for weighted policies, 0 means 1
+
+	//The request http health checks send and the responses they accept, by default a GET answered with a 200
+	HealthCheckMethod  string            `json:",omitempty"`
+	HealthCheckHeaders map[string]string `json:",omitempty"`
+	HealthCheckBody    string            `json:",omitempty"`
+	HealthCheckStatus  string            `json:",omitempty"` //Healthy status codes and ranges, e.g. 200-299,304
+	HealthCheckMatch   string            `json:",omitempty"` //Body substring, regex:<expression> or json:<path>[=<value>]
+
+	//The command exec health checks run, healthy when it exits with status 0. tcp health checks send the
+	//HealthCheckBody, if any, and expect a reply containing the HealthCheckMatch, if any.
+	HealthCheckCommand string `json:",omitempty"`
+
+	HealthCheckRise int `json:",omitempty"` //Passing checks in a row that mark a server up, 0 means 1
+	HealthCheckFall int `json:",omitempty"` //Failing checks in a row that mark a server down, 0 means 1
 }
 
 //JSONToServer unmarshals a JSON representation of a server definition
@@ -26,7 +40,7 @@ func JSONToServer(bytes []byte) *ServerConfig {
 	}
 
 	s = new(ServerConfig)
-	if err := json.Unmarshal(bytes, s); err != nil {
+	if err := decodeDefinition(ServerKind, bytes, s); err != nil {
 		log.Warn("Error unmarshalling ServerConfig:", err.Error())
 		s = nil
 	}
@@ -35,14 +49,14 @@ func JSONToServer(bytes []byte) *ServerConfig {
 
 //Store persists the server configuration definition in the supplied KVS
 func (serverConfig *ServerConfig) Store(kvs kvstore.KVStore) (err error) {
-	b, err := json.Marshal(serverConfig)
+	b, err := marshalDefinition(serverConfig)
 	if err != nil {
 		return
 	}
 
 	key := fmt.Sprintf("servers/%s", serverConfig.Name)
 	log.Info(fmt.Sprintf("adding %s under key %s", string(b), key))
-	err = kvs.Put(key, b)
+	err = storeDefinition(ServerKind, serverConfig.Name, b, kvs)
 	return
 }
 
@@ -58,6 +72,39 @@ func ReadServerConfig(name string, kvs kvstore.KVStore) (*ServerConfig, error) {
 	return JSONToServer(bv), nil
 }
 
+//ReadServerConfigWithIndex retrieves the named server definition along with the modify index
+//of the definition, which can be passed to StoreIfUnmodified. The index is 0 if the
+//definition does not exist.
+func ReadServerConfigWithIndex(name string, kvs kvstore.KVStore) (*ServerConfig, uint64, error) {
+	bv, index, err := readKeyWithIndex("servers/"+name, kvs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return JSONToServer(bv), index, nil
+}
+
+//StoreIfUnmodified persists the server definition only if it has not been modified since
+//index was read. An index of 0 stores the definition only if it does not already exist.
+//ErrDefinitionModified is returned if the definition was modified.
+func (serverConfig *ServerConfig) StoreIfUnmodified(kvs kvstore.KVStore, index uint64) error {
+	b, err := marshalDefinition(serverConfig)
+	if err != nil {
+		return err
+	}
+
+	return storeIfUnmodified(ServerKind, serverConfig.Name, b, index, kvs)
+}
+
+//ResolveSecrets replaces secret references in the server's Address and PingURI with
+//their resolved values
+func (serverConfig *ServerConfig) ResolveSecrets() error {
+	if err := resolveSecretField(ServerKind, serverConfig.Name, "Address", &serverConfig.Address); err != nil {
+		return err
+	}
+	return resolveSecretField(ServerKind, serverConfig.Name, "PingURI", &serverConfig.PingURI)
+}
+
 //ListServerConfigs returns a list of the server configurations
 //present in the supplied KVS
 func ListServerConfigs(kvs kvstore.KVStore) ([]*ServerConfig, error) {
@@ -73,3 +120,34 @@ func ListServerConfigs(kvs kvstore.KVStore) ([]*ServerConfig, error) {
 
 	return servers, nil
 }
+
+//DeleteServerConfig removes the named server definition from the supplied KVS. If backend
+//definitions still reference the server an InUseError is returned, unless cascade is set,
+//in which case the server is removed from the referencing backends in the same transaction.
+//Only backends left with no servers are deleted, cascading in turn to their routes.
+func DeleteServerConfig(name string, kvs kvstore.KVStore, cascade bool) error {
+	sc, err := ReadServerConfig(name, kvs)
+	if err != nil {
+		return err
+	}
+
+	if sc == nil {
+		return ErrNoSuchServer
+	}
+
+	backends, err := BackendsReferencingServer(name, kvs)
+	if err != nil {
+		return err
+	}
+
+	if len(backends) > 0 {
+		if !cascade {
+			return &InUseError{Kind: "Server", Name: name, ReferencedBy: keysFor("backends/", backends)}
+		}
+		return deleteCascading(ServerKind, name, kvs)
+	}
+
+	key := "servers/" + name
+	log.Info("deleting key ", key)
+	return deleteDefinition(ServerKind, name, kvs)
+}
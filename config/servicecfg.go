@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/kvstore"
 )
@@ -34,7 +35,8 @@ var (
 )
 
 //ReadServiceConfig reads all configuration for a given listener and links all the definitions
-//together
+//together. Secret references are left unresolved, so the service config can be logged and shared
+//with plugins without exposing secrets.
 func ReadServiceConfig(listenerName string, kvs kvstore.KVStore) (*ServiceConfig, error) {
 	log.Infof("ReadServiceConfig: Reading service configuration for listener %s", listenerName)
 
@@ -56,7 +58,7 @@ func ReadServiceConfig(listenerName string, kvs kvstore.KVStore) (*ServiceConfig
 }
 
 func readStartingWithListener(sc *ServiceConfig, listenerName string, kvs kvstore.KVStore) error {
-	log.Info("ReadServiceConfig: Read listener configuration")
+	log.Debug("ReadServiceConfig: Read listener configuration")
 
 	//Read the listener def from the kv store
 	lc, err := ReadListenerConfig(listenerName, kvs)
@@ -84,7 +86,7 @@ func readStartingWithListener(sc *ServiceConfig, listenerName string, kvs kvstor
 }
 
 func readRouteForListener(sc *ServiceConfig, routeName string, kvs kvstore.KVStore) (*ServiceRoute, error) {
-	log.Infof("ReadServiceConfig: Read route config for %s", routeName)
+	log.Debugf("ReadServiceConfig: Read route config for %s", routeName)
 	routeConfig, err := ReadRouteConfig(routeName, kvs)
 	if err != nil {
 		return nil, err
@@ -111,7 +113,7 @@ func readRouteForListener(sc *ServiceConfig, routeName string, kvs kvstore.KVSto
 }
 
 func readBackendForRoute(sr *ServiceRoute, backendName string, kvs kvstore.KVStore) (*ServiceBackend, error) {
-	log.Infof("ReadServiceConfig: Read backend config for %s", backendName)
+	log.Debugf("ReadServiceConfig: Read backend config for %s", backendName)
 
 	backendConfig, err := ReadBackendConfig(backendName, kvs)
 	if err != nil {
@@ -138,7 +140,7 @@ func readBackendForRoute(sr *ServiceRoute, backendName string, kvs kvstore.KVSto
 }
 
 func readServerForBackend(be *ServiceBackend, serverName string, kvs kvstore.KVStore) (*ServerConfig, error) {
-	log.Infof("ReadServiceConfig: Read server config for %s", serverName)
+	log.Debugf("ReadServiceConfig: Read server config for %s", serverName)
 
 	serverConfig, err := ReadServerConfig(serverName, kvs)
 	if err != nil {
@@ -149,7 +151,7 @@ func readServerForBackend(be *ServiceBackend, serverName string, kvs kvstore.KVS
 		return nil, errors.New("No definition for server '" + serverName + "' found")
 	}
 
-	return serverConfig, err
+	return serverConfig, nil
 }
 
 //LogConfig logs information associated with the ServiceConfig
@@ -165,3 +167,119 @@ func (sc *ServiceConfig) LogConfig() {
 		}
 	}
 }
+
+//TooManyChangesError is returned when the definitions changed by a single write take more
+//transaction operations than the KV store accepts in one transaction. Each changed definition
+//takes three operations, plus one for each expired revision it deletes, so with consul's limit
+//of 64 only around 16 definitions can change in one write. Nothing is written; the changes have
+//to be made in smaller writes.
+type TooManyChangesError struct {
+	Changed int //Number of changed definitions
+	Ops     int //Number of transaction operations needed to write them
+	Limit   int //Number of operations the KV store accepts in one transaction
+}
+
+func (e *TooManyChangesError) Error() string {
+	return fmt.Sprintf("Writing %d changed definitions takes %d transaction operations, more than the %d "+
+		"the KV store accepts in one transaction - make the changes in smaller sets", e.Changed, e.Ops, e.Limit)
+}
+
+//txnError returns a TooManyChangesError if the transaction writing the changed definitions
+//was too large for the KV store, otherwise the error as is
+func txnError(err error, changed int) error {
+	if tooLarge, ok := err.(*kvstore.TxnTooLargeError); ok {
+		return &TooManyChangesError{Changed: changed, Ops: tooLarge.Ops, Limit: tooLarge.Limit}
+	}
+
+	return err
+}
+
+//Store writes the listener, route, backend and server definitions of the service
+//configuration to the supplied KVS in a single transaction, so either the whole listener
+//tree is stored or none of it is. A revision is recorded for each definition written;
+//definitions already stored with the same value are left unchanged. A TooManyChangesError
+//is returned if the changed definitions do not fit in one transaction of the KV store.
+func (sc *ServiceConfig) Store(kvs kvstore.KVStore) error {
+	if kvs == nil {
+		return ErrNoKVStore
+	}
+
+	if sc.Listener == nil || sc.Listener.Name == "" {
+		return ErrNoListenerName
+	}
+
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		ops, changed, err := sc.storeOps(kvs)
+		if err != nil {
+			return err
+		}
+
+		if len(ops) == 0 {
+			log.Infof("Service configuration for listener %s unchanged", sc.Listener.Name)
+			return nil
+		}
+
+		log.Infof("Storing service configuration for listener %s in a transaction of %d operations",
+			sc.Listener.Name, len(ops))
+		err = kvs.Txn(ops)
+		if err != kvstore.ErrTxnConflict {
+			return txnError(err, changed)
+		}
+
+		log.Infof("Conflict storing service configuration for listener %s - retrying", sc.Listener.Name)
+	}
+
+	return ErrWriteConflict
+}
+
+//storeOps returns the transaction operations that store the service configuration, along
+//with the number of definitions they change
+func (sc *ServiceConfig) storeOps(kvs kvstore.KVStore) ([]*kvstore.TxnOp, int, error) {
+	var ops []*kvstore.TxnOp
+	changed := 0
+	added := make(map[string]bool)
+	addOps := func(kind, name string, def interface{}) error {
+		if added[kind+"/"+name] {
+			return nil
+		}
+
+		b, err := marshalDefinition(def)
+		if err != nil {
+			return err
+		}
+
+		defOps, _, err := definitionOps(kind, name, b, kvs)
+		if err != nil {
+			return err
+		}
+
+		added[kind+"/"+name] = true
+		if defOps != nil {
+			changed++
+			ops = append(ops, defOps...)
+		}
+		return nil
+	}
+
+	for _, r := range sc.Routes {
+		for _, b := range r.Backends {
+			for _, s := range b.Servers {
+				if err := addOps(ServerKind, s.Name, s); err != nil {
+					return nil, 0, err
+				}
+			}
+			if err := addOps(BackendKind, b.Backend.Name, b.Backend); err != nil {
+				return nil, 0, err
+			}
+		}
+		if err := addOps(RouteKind, r.Route.Name, r.Route); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if err := addOps(ListenerKind, sc.Listener.Name, sc.Listener); err != nil {
+		return nil, 0, err
+	}
+
+	return ops, changed, nil
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/kvstore"
 	"testing"
 )
 
@@ -59,3 +61,98 @@ func TestActiveConfigLookup(t *testing.T) {
 	assert.Equal(t, 1, len(activeListeners))
 	assert.Equal(t, "listener", activeListeners[0])
 }
+
+func TestStoreServiceConfig(t *testing.T) {
+	kvs := BuildKVStoreTestConfig(t)
+	sc, err := ReadServiceConfig("listener", kvs)
+	assert.Nil(t, err)
+
+	copyKVS, _ := kvstore.NewHashKVStore("")
+	err = sc.Store(copyKVS)
+	assert.Nil(t, err)
+
+	sc2, err := ReadServiceConfig("listener", copyKVS)
+	assert.Nil(t, err)
+	assert.Equal(t, sc, sc2)
+}
+
+func TestStoreServiceConfigErrors(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+
+	sc := &ServiceConfig{}
+	assert.Equal(t, ErrNoKVStore, sc.Store(nil))
+	assert.Equal(t, ErrNoListenerName, sc.Store(kvs))
+
+	sc.Listener = &ListenerConfig{Name: "l1"}
+	kvs.InjectFaults()
+	assert.NotNil(t, sc.Store(kvs))
+}
+
+//limitedKVStore rejects transactions of more operations than its limit, as consul does
+type limitedKVStore struct {
+	*kvstore.HashKVStore
+	limit int
+}
+
+func (l *limitedKVStore) Txn(ops []*kvstore.TxnOp) error {
+	if len(ops) > l.limit {
+		return &kvstore.TxnTooLargeError{Ops: len(ops), Limit: l.limit}
+	}
+	return l.HashKVStore.Txn(ops)
+}
+
+func TestStoreTooManyChanges(t *testing.T) {
+	hkvs, _ := kvstore.NewHashKVStore("")
+	kvs := &limitedKVStore{HashKVStore: hkvs, limit: 64}
+
+	backend := &ServiceBackend{Backend: &BackendConfig{Name: "b1"}}
+	for i := 0; i < 22; i++ {
+		name := fmt.Sprintf("s%d", i)
+		backend.Backend.ServerNames = append(backend.Backend.ServerNames, name)
+		backend.Servers = append(backend.Servers, &ServerConfig{Name: name, Address: "localhost", Port: 3000 + i})
+	}
+	sc := &ServiceConfig{
+		Listener: &ListenerConfig{Name: "l1", RouteNames: []string{"r1"}},
+		Routes: []*ServiceRoute{
+			{Route: &RouteConfig{Name: "r1", URIRoot: "/foo", Backends: []string{"b1"}}, Backends: []*ServiceBackend{backend}},
+		},
+	}
+
+	err := sc.Store(kvs)
+	assert.Equal(t, &TooManyChangesError{Changed: 25, Ops: 75, Limit: 64}, err)
+	l, _ := ReadListenerConfig("l1", kvs)
+	assert.Nil(t, l, "Nothing is stored")
+
+	t.Log("Storing the same configuration again writes nothing")
+	kvs.limit = 100
+	assert.Nil(t, sc.Store(kvs))
+	kvs.limit = 0
+	assert.Nil(t, sc.Store(kvs))
+}
+
+func TestStoreIfUnmodified(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+
+	route := &RouteConfig{Name: "r1", URIRoot: "/foo"}
+	assert.Nil(t, route.StoreIfUnmodified(kvs, 0))
+	assert.Equal(t, ErrDefinitionModified, route.StoreIfUnmodified(kvs, 0))
+
+	r1, index, err := ReadRouteConfigWithIndex("r1", kvs)
+	assert.Nil(t, err)
+	assert.NotEqual(t, uint64(0), index)
+
+	t.Log("Another operator updates the route")
+	r2, _, _ := ReadRouteConfigWithIndex("r1", kvs)
+	r2.URIRoot = "/bar"
+	assert.Nil(t, r2.StoreIfUnmodified(kvs, index))
+
+	r1.URIRoot = "/baz"
+	assert.Equal(t, ErrDefinitionModified, r1.StoreIfUnmodified(kvs, index))
+
+	r, _ := ReadRouteConfig("r1", kvs)
+	assert.Equal(t, "/bar", r.URIRoot)
+
+	_, index, err = ReadServerConfigWithIndex("nope", kvs)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), index)
+}
@@ -3,6 +3,8 @@ package env
 //Environment variable names used to pick up configuration are defined here.
 const (
 	KVStoreURL       = "XAVI_KVSTORE_URL"
+	KVStoreCache     = "XAVI_KVSTORE_CACHE"
+	Author           = "XAVI_AUTHOR"
 	LoggingOpts      = "XAVI_LOGGING_OPTS"
 	StatsdEndpoint   = "XAVI_STATSD_ADDRESS"
 	LoggingLevel     = "XAVI_LOGGING_LEVEL"
@@ -25,3 +27,27 @@ const (
 
 //Address to listen to http pprof requests on, for example localhost:6060
 const PProfEndpoint = "XAVI_PPROF_ENDPOINT"
+
+//Environment variables for configuring access to consul when consul is used as the KV store.
+//Equivalent query parameters in the XAVI_KVSTORE_URL take precedence over these.
+const (
+	ConsulToken       = "XAVI_CONSUL_TOKEN"
+	ConsulScheme      = "XAVI_CONSUL_SCHEME"
+	ConsulCACert      = "XAVI_CONSUL_CACERT"
+	ConsulClientCert  = "XAVI_CONSUL_CLIENT_CERT"
+	ConsulClientKey   = "XAVI_CONSUL_CLIENT_KEY"
+	ConsulDatacenter  = "XAVI_CONSUL_DATACENTER"
+	ConsulConsistency = "XAVI_CONSUL_CONSISTENCY"
+)
+
+//Environment variables for encrypting values in the KV store. Keys are given as id:base64-key,
+//comma separated, with the first key used for encryption. XAVI_ENCRYPT_PREFIXES lists the key
+//prefixes to encrypt values under, comma separated; all values are encrypted if not set.
+const (
+	EncryptionKeys    = "XAVI_ENCRYPTION_KEYS"
+	EncryptionKeyFile = "XAVI_ENCRYPTION_KEY_FILE"
+	EncryptPrefixes   = "XAVI_ENCRYPT_PREFIXES"
+)
+
+//Number of revisions of each definition to keep in the revision history
+const HistoryRetention = "XAVI_HISTORY_RETENTION"
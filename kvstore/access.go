@@ -0,0 +1,70 @@
+package kvstore
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+//AccessChecker is implemented by stores that can verify up front that the credentials they
+//were configured with allow the store to be used, rather than failing on first use.
+type AccessChecker interface {
+	CheckAccess(prefix string) error
+}
+
+//AccessDeniedError indicates the store credentials do not allow an operation on a key prefix
+type AccessDeniedError struct {
+	Operation string
+	Prefix    string
+	Reason    string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return fmt.Sprintf("Access denied: KV store credentials do not allow %s access to keys under '%s' (%s)",
+		e.Operation, e.Prefix, e.Reason)
+}
+
+//CheckAccess verifies the store allows access to each of the given key prefixes. Stores
+//that do not implement AccessChecker are assumed to allow access.
+func CheckAccess(kvs KVStore, prefixes ...string) error {
+	checker, ok := kvs.(AccessChecker)
+	if !ok {
+		return nil
+	}
+
+	for _, prefix := range prefixes {
+		if err := checker.CheckAccess(prefix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//CheckAccess checks the consul ACL token allows keys under the prefix to be read and
+//written. Write access is probed with a check-and-set using an index no key can have, so
+//the check never modifies the store. Errors unrelated to permissions, for example consul
+//being unreachable, are returned as is.
+func (kvs *ConsulKVStore) CheckAccess(prefix string) error {
+	if _, _, err := kvs.KV.Get(prefix, kvs.queryOptions()); err != nil {
+		return consulAccessError("read", prefix, err)
+	}
+
+	if _, err := kvs.CAS(prefix, nil, math.MaxUint64); err != nil {
+		return consulAccessError("write", prefix, err)
+	}
+
+	return nil
+}
+
+func consulAccessError(operation, prefix string, err error) error {
+	if strings.Contains(err.Error(), "response code: 403") {
+		return &AccessDeniedError{Operation: operation, Prefix: prefix, Reason: err.Error()}
+	}
+	return err
+}
+
+//CheckAccess checks access to the prefix within the namespace
+func (nkvs *NamespacedKVStore) CheckAccess(prefix string) error {
+	return CheckAccess(nkvs.kvs, nkvs.key(prefix))
+}
@@ -0,0 +1,262 @@
+package kvstore
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+//CachingKVStore is a read-through cache in front of a KVStore. Gets and Lists are served
+//from memory once read, and cached entries are invalidated by watching the underlying
+//store: for consul the watch detects changes using modify indexes, and the hash store
+//notifies its own writes. Writes made through the cache invalidate the affected entries
+//immediately, without waiting for the watch notification. Only keys under the watched
+//prefixes are cached; reads of other keys go to the underlying store.
+type CachingKVStore struct {
+	kvs         KVStore
+	prefixes    []string
+	stopWatches []func()
+
+	mu         sync.RWMutex
+	generation uint64
+	entries    map[string]*cacheEntry
+	lists      map[string][]*KVPair
+}
+
+type cacheEntry struct {
+	value []byte
+	index uint64
+}
+
+//DefinitionPrefixes are the key prefixes definitions and their revision history are stored under
+var DefinitionPrefixes = []string{"listeners/", "routes/", "backends/", "servers/", HistoryPrefix}
+
+//NewCachingKVStore creates a cache in front of the supplied store, caching the keys under the
+//given prefixes, or under DefinitionPrefixes if none are given. Each prefix is watched separately
+//so writes elsewhere in the store do not cause the watches to re-list.
+func NewCachingKVStore(kvs KVStore, prefixes ...string) (*CachingKVStore, error) {
+	if len(prefixes) == 0 {
+		prefixes = DefinitionPrefixes
+	}
+
+	cache := &CachingKVStore{
+		kvs:      kvs,
+		prefixes: prefixes,
+		entries:  make(map[string]*cacheEntry),
+		lists:    make(map[string][]*KVPair),
+	}
+
+	for _, prefix := range prefixes {
+		stop, err := kvs.Watch(prefix, cache.handleWatchEvent)
+		if err != nil {
+			cache.Close()
+			return nil, err
+		}
+		cache.stopWatches = append(cache.stopWatches, stop)
+	}
+
+	return cache, nil
+}
+
+//Underlying returns the store the cache is in front of
+func (c *CachingKVStore) Underlying() KVStore {
+	return c.kvs
+}
+
+//Close stops invalidation of the cache. The cache should not be used after Close is called.
+func (c *CachingKVStore) Close() {
+	for _, stop := range c.stopWatches {
+		stop()
+	}
+}
+
+//caches returns true if the key, or all the keys under a list prefix, are under a watched prefix
+func (c *CachingKVStore) caches(key string) bool {
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CachingKVStore) handleWatchEvent(e *WatchEvent) {
+	log.Debug("Cache invalidating key ", e.Key)
+	c.invalidate(e.Key)
+}
+
+//invalidate removes the cached value of the key along with any cached lists containing it
+func (c *CachingKVStore) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.entries, key)
+	for prefix := range c.lists {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.lists, prefix)
+		}
+	}
+}
+
+//invalidatePrefix removes all cached values and lists that could include keys under the prefix
+func (c *CachingKVStore) invalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+	for listPrefix := range c.lists {
+		if strings.HasPrefix(listPrefix, prefix) || strings.HasPrefix(prefix, listPrefix) {
+			delete(c.lists, listPrefix)
+		}
+	}
+}
+
+//currentGeneration returns the invalidation generation. Values read from the underlying
+//store are only cached if no invalidation happened while they were being read, as the
+//value read may predate the invalidation.
+func (c *CachingKVStore) currentGeneration() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation
+}
+
+func (c *CachingKVStore) cachedEntry(key string) (*cacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *CachingKVStore) readEntry(key string) (*cacheEntry, error) {
+	if !c.caches(key) {
+		value, index, err := c.kvs.GetWithIndex(key)
+		if err != nil {
+			return nil, err
+		}
+		return &cacheEntry{value: value, index: index}, nil
+	}
+
+	if entry, ok := c.cachedEntry(key); ok {
+		return entry, nil
+	}
+
+	generation := c.currentGeneration()
+	value, index, err := c.kvs.GetWithIndex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{value: value, index: index}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	return entry, nil
+}
+
+//Get returns the value (if any) under the given key, reading it from the underlying
+//store if it is not cached
+func (c *CachingKVStore) Get(key string) ([]byte, error) {
+	entry, err := c.readEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	return entry.value, nil
+}
+
+//GetWithIndex returns the value (if any) under the given key along with its modify index,
+//reading it from the underlying store if it is not cached
+func (c *CachingKVStore) GetWithIndex(key string) ([]byte, uint64, error) {
+	entry, err := c.readEntry(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entry.value, entry.index, nil
+}
+
+//List returns the values stored under the given key, reading them from the underlying
+//store if the list is not cached
+func (c *CachingKVStore) List(key string) ([]*KVPair, error) {
+	if !c.caches(key) {
+		return c.kvs.List(key)
+	}
+
+	c.mu.RLock()
+	pairs, ok := c.lists[key]
+	c.mu.RUnlock()
+	if ok {
+		return pairs, nil
+	}
+
+	generation := c.currentGeneration()
+	pairs, err := c.kvs.List(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.lists[key] = pairs
+	}
+	c.mu.Unlock()
+
+	return pairs, nil
+}
+
+//Put stores the value in the underlying store
+func (c *CachingKVStore) Put(key string, value []byte) error {
+	defer c.invalidate(key)
+	return c.kvs.Put(key, value)
+}
+
+//CAS performs a check-and-set on the underlying store
+func (c *CachingKVStore) CAS(key string, value []byte, index uint64) (bool, error) {
+	defer c.invalidate(key)
+	return c.kvs.CAS(key, value, index)
+}
+
+//Txn applies the transaction to the underlying store
+func (c *CachingKVStore) Txn(ops []*TxnOp) error {
+	defer func() {
+		for _, op := range ops {
+			c.invalidate(op.Key)
+		}
+	}()
+	return c.kvs.Txn(ops)
+}
+
+//Delete removes the key from the underlying store
+func (c *CachingKVStore) Delete(key string) error {
+	defer c.invalidate(key)
+	return c.kvs.Delete(key)
+}
+
+//DeletePrefix removes the keys under the prefix from the underlying store
+func (c *CachingKVStore) DeletePrefix(prefix string) error {
+	defer c.invalidatePrefix(prefix)
+	return c.kvs.DeletePrefix(prefix)
+}
+
+//Watch registers a watch with the underlying store
+func (c *CachingKVStore) Watch(prefix string, fn WatchFunc) (func(), error) {
+	return c.kvs.Watch(prefix, fn)
+}
+
+//Flush flushes the underlying store
+func (c *CachingKVStore) Flush() error {
+	return c.kvs.Flush()
+}
+
+//CheckAccess checks access using the underlying store
+func (c *CachingKVStore) CheckAccess(prefix string) error {
+	return CheckAccess(c.kvs, prefix)
+}
@@ -0,0 +1,221 @@
+package kvstore
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+//countingKVStore counts the reads made against the wrapped store
+type countingKVStore struct {
+	*HashKVStore
+	gets  int
+	lists int
+}
+
+func (c *countingKVStore) GetWithIndex(key string) ([]byte, uint64, error) {
+	c.gets++
+	return c.HashKVStore.GetWithIndex(key)
+}
+
+func (c *countingKVStore) List(key string) ([]*KVPair, error) {
+	c.lists++
+	return c.HashKVStore.List(key)
+}
+
+func testMakeCache(t *testing.T) (*countingKVStore, *CachingKVStore) {
+	hkvs, _ := NewHashKVStore("")
+	counting := &countingKVStore{HashKVStore: hkvs}
+	cache, err := NewCachingKVStore(counting, "")
+	assert.Nil(t, err)
+	return counting, cache
+}
+
+func TestCacheGet(t *testing.T) {
+	counting, cache := testMakeCache(t)
+	defer cache.Close()
+
+	counting.Put("a", []byte("a1"))
+
+	for i := 0; i < 3; i++ {
+		v, err := cache.Get("a")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("a1"), v)
+	}
+	assert.Equal(t, 1, counting.gets)
+
+	t.Log("Absent keys are cached too")
+	v, _ := cache.Get("b")
+	assert.Nil(t, v)
+	cache.Get("b")
+	assert.Equal(t, 2, counting.gets)
+
+	t.Log("Writes to the underlying store invalidate via the watch")
+	counting.Put("a", []byte("a2"))
+	v, _, _ = cache.GetWithIndex("a")
+	assert.Equal(t, []byte("a2"), v)
+	assert.Equal(t, 3, counting.gets)
+
+	t.Log("Writes through the cache invalidate")
+	cache.Put("b", []byte("b1"))
+	v, _ = cache.Get("b")
+	assert.Equal(t, []byte("b1"), v)
+}
+
+func TestCacheList(t *testing.T) {
+	counting, cache := testMakeCache(t)
+	defer cache.Close()
+
+	counting.Put("routes/r1", []byte("r1"))
+
+	pairs, _ := cache.List("routes/")
+	assert.Equal(t, 1, len(pairs))
+	cache.List("routes/")
+	assert.Equal(t, 1, counting.lists)
+
+	t.Log("Writes outside the listed prefix leave the list cached")
+	counting.Put("servers/s1", []byte("s1"))
+	cache.List("routes/")
+	assert.Equal(t, 1, counting.lists)
+
+	counting.Put("routes/r2", []byte("r2"))
+	pairs, _ = cache.List("routes/")
+	assert.Equal(t, 2, len(pairs))
+	assert.Equal(t, 2, counting.lists)
+
+	cache.DeletePrefix("routes/")
+	pairs, _ = cache.List("routes/")
+	assert.Equal(t, 0, len(pairs))
+
+	cache.Txn([]*TxnOp{{Verb: TxnSet, Key: "routes/r3", Value: []byte("r3")}})
+	pairs, _ = cache.List("routes/")
+	assert.Equal(t, 1, len(pairs))
+
+	cache.Delete("routes/r3")
+	pairs, _ = cache.List("routes/")
+	assert.Equal(t, 0, len(pairs))
+}
+
+func TestCacheCAS(t *testing.T) {
+	_, cache := testMakeCache(t)
+	defer cache.Close()
+
+	ok, err := cache.CAS("a", []byte("a1"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	v, index, _ := cache.GetWithIndex("a")
+	assert.Equal(t, []byte("a1"), v)
+
+	ok, _ = cache.CAS("a", []byte("a2"), index)
+	assert.True(t, ok)
+	ok, _ = cache.CAS("a", []byte("a3"), index)
+	assert.False(t, ok)
+
+	v, _ = cache.Get("a")
+	assert.Equal(t, []byte("a2"), v)
+}
+
+func TestCacheErrors(t *testing.T) {
+	hkvs, _ := NewHashKVStore("")
+	hkvs.InjectFaults()
+	_, err := NewCachingKVStore(hkvs)
+	assert.NotNil(t, err)
+
+	hkvs.ClearFaults()
+	cache, _ := NewCachingKVStore(hkvs)
+	hkvs.InjectFaults()
+	_, err = cache.Get("a")
+	assert.NotNil(t, err)
+	_, _, err = cache.GetWithIndex("a")
+	assert.NotNil(t, err)
+	_, err = cache.List("a")
+	assert.NotNil(t, err)
+
+	hkvs.ClearFaults()
+	v, err := cache.Get("a")
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+}
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	_, cache := testMakeCache(t)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("k%d", i%3)
+			for j := 0; j < 100; j++ {
+				cache.Put(key, []byte(fmt.Sprintf("%d", j)))
+				cache.Get(key)
+				cache.List("k")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("k%d", i)
+		v, _ := cache.Get(key)
+		assert.Equal(t, []byte("99"), v)
+	}
+}
+
+func TestCacheFromFactory(t *testing.T) {
+	f, err := ioutil.TempFile("./", "tst")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	wd, _ := os.Getwd()
+
+	kvs, err := NewKVStore(fmt.Sprintf("file:///%s/%s?cache=true&namespace=teamA", wd, f.Name()))
+	assert.Nil(t, err)
+	_, ok := kvs.(*CachingKVStore)
+	assert.True(t, ok)
+	assert.Equal(t, "teamA", NamespaceOf(kvs))
+
+	kvs.Put("routes/r1", []byte("r1"))
+	namespaces, err := ListNamespaces(kvs, "routes/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"teamA"}, namespaces)
+
+	_, err = NewKVStore(fmt.Sprintf("file:///%s/%s?cache=maybe", wd, f.Name()))
+	assert.NotNil(t, err)
+}
+
+func TestCacheScopedToDefinitionPrefixes(t *testing.T) {
+	hkvs, _ := NewHashKVStore("")
+	counting := &countingKVStore{HashKVStore: hkvs}
+	cache, err := NewCachingKVStore(counting)
+	assert.Nil(t, err)
+	defer cache.Close()
+
+	counting.Put("routes/r1", []byte("r1"))
+	counting.Put("unrelated/u1", []byte("u1"))
+
+	cache.Get("routes/r1")
+	cache.Get("routes/r1")
+	cache.List("routes/")
+	cache.List("routes/")
+	assert.Equal(t, 1, counting.gets)
+	assert.Equal(t, 1, counting.lists)
+
+	t.Log("Keys outside the definition prefixes are read from the underlying store")
+	v, _ := cache.Get("unrelated/u1")
+	assert.Equal(t, []byte("u1"), v)
+	cache.Get("unrelated/u1")
+	cache.List("unrelated/")
+	cache.List("")
+	assert.Equal(t, 3, counting.gets)
+	assert.Equal(t, 3, counting.lists)
+
+	t.Log("Writes under the definition prefixes still invalidate via the watch")
+	counting.Put("routes/r1", []byte("r1b"))
+	v, _ = cache.Get("routes/r1")
+	assert.Equal(t, []byte("r1b"), v)
+}
@@ -1,14 +1,137 @@
 package kvstore
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/xtracdev/xavi/env"
+	"io/ioutil"
 	"net/url"
+	"os"
 )
 
+//Query parameters recognized in consul KV store URLs, for example
+//consul://host:8500/teamA?scheme=https&ca-cert=/etc/consul/ca.pem&datacenter=dc2
+const (
+	consulTokenParam       = "token"
+	consulSchemeParam      = "scheme"
+	consulCACertParam      = "ca-cert"
+	consulClientCertParam  = "client-cert"
+	consulClientKeyParam   = "client-key"
+	consulDatacenterParam  = "datacenter"
+	consulConsistencyParam = "consistency"
+)
+
+//Consistency modes for reads from consul
+const (
+	ConsistencyDefault    = "default"
+	ConsistencyStale      = "stale"
+	ConsistencyConsistent = "consistent"
+)
+
+//consulSetting returns the value of the named URL query parameter, or the value of the
+//environment variable if the parameter is not present
+func consulSetting(u *url.URL, param string, envVar string) string {
+	if value := u.Query().Get(param); value != "" {
+		return value
+	}
+	return os.Getenv(envVar)
+}
+
 func consulConfigFromEnv(u *url.URL) (*consulapi.Config, error) {
 	config := consulapi.DefaultConfig()
 	log.Info("Setting consul address: ", u.Host)
 	config.Address = u.Host
+
+	if token := consulSetting(u, consulTokenParam, env.ConsulToken); token != "" {
+		log.Info("Using consul ACL token")
+		config.Token = token
+	}
+
+	if datacenter := consulSetting(u, consulDatacenterParam, env.ConsulDatacenter); datacenter != "" {
+		log.Info("Using consul datacenter ", datacenter)
+		config.Datacenter = datacenter
+	}
+
+	switch scheme := consulSetting(u, consulSchemeParam, env.ConsulScheme); scheme {
+	case "":
+	case "http", "https":
+		config.Scheme = scheme
+	default:
+		return nil, fmt.Errorf("Unsupported consul scheme '%s' - expected http or https", scheme)
+	}
+
+	tlsConfig, err := consulTLSConfig(
+		consulSetting(u, consulCACertParam, env.ConsulCACert),
+		consulSetting(u, consulClientCertParam, env.ConsulClientCert),
+		consulSetting(u, consulClientKeyParam, env.ConsulClientKey),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		log.Info("Using TLS for consul connections")
+		config.Scheme = "https"
+		transport := cleanhttp.DefaultTransport()
+		transport.TLSClientConfig = tlsConfig
+		config.HttpClient.Transport = transport
+	}
+
+	log.Info("Using consul scheme ", config.Scheme)
 	return config, nil
 }
+
+//consulTLSConfig builds the TLS configuration for the given CA and client cert files. Nil
+//is returned if no files are given.
+func consulTLSConfig(caCertPath, clientCertPath, clientKeyPath string) (*tls.Config, error) {
+	if caCertPath == "" && clientCertPath == "" && clientKeyPath == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if caCertPath != "" {
+		caCert, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read consul CA cert file: %s", err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("No certificates found in consul CA cert file %s", caCertPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if clientCertPath != "" || clientKeyPath != "" {
+		if clientCertPath == "" || clientKeyPath == "" {
+			return nil, fmt.Errorf("Both a client cert and client key must be specified for consul")
+		}
+
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load consul client cert and key: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+//consulConsistencyFromEnv returns the consistency mode to use for reads from consul
+func consulConsistencyFromEnv(u *url.URL) (string, error) {
+	switch consistency := consulSetting(u, consulConsistencyParam, env.ConsulConsistency); consistency {
+	case "", ConsistencyDefault:
+		return ConsistencyDefault, nil
+	case ConsistencyStale, ConsistencyConsistent:
+		log.Info("Using consul consistency mode ", consistency)
+		return consistency, nil
+	default:
+		return "", fmt.Errorf("Unsupported consul consistency mode '%s' - expected %s, %s or %s",
+			consistency, ConsistencyDefault, ConsistencyStale, ConsistencyConsistent)
+	}
+}
@@ -2,7 +2,11 @@ package kvstore
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/env"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"testing"
 )
 
@@ -21,3 +25,146 @@ func TestEnvConfig(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "127.0.1.1:9876", config.Address)
 }
+
+func TestURLSettings(t *testing.T) {
+	u, err := url.Parse("consul://127.0.0.1:8500/ns?token=t1&datacenter=dc2&scheme=https")
+	assert.Nil(t, err)
+	config, err := consulConfigFromEnv(u)
+	assert.Nil(t, err)
+	assert.Equal(t, "t1", config.Token)
+	assert.Equal(t, "dc2", config.Datacenter)
+	assert.Equal(t, "https", config.Scheme)
+}
+
+func TestEnvSettings(t *testing.T) {
+	os.Setenv(env.ConsulToken, "env-token")
+	os.Setenv(env.ConsulDatacenter, "env-dc")
+	os.Setenv(env.ConsulConsistency, ConsistencyStale)
+	defer func() {
+		os.Unsetenv(env.ConsulToken)
+		os.Unsetenv(env.ConsulDatacenter)
+		os.Unsetenv(env.ConsulConsistency)
+	}()
+
+	u, _ := url.Parse("consul://127.0.0.1:8500?datacenter=dc2")
+	config, err := consulConfigFromEnv(u)
+	assert.Nil(t, err)
+	assert.Equal(t, "env-token", config.Token)
+	assert.Equal(t, "dc2", config.Datacenter, "URL settings take precedence over the environment")
+
+	consistency, err := consulConsistencyFromEnv(u)
+	assert.Nil(t, err)
+	assert.Equal(t, ConsistencyStale, consistency)
+}
+
+func TestBadSettings(t *testing.T) {
+	for _, s := range []string{
+		"consul://127.0.0.1:8500?scheme=ftp",
+		"consul://127.0.0.1:8500?ca-cert=/no/such/file.pem",
+		"consul://127.0.0.1:8500?ca-cert=consul_config_test.go",
+		"consul://127.0.0.1:8500?client-cert=../service/cert.pem",
+	} {
+		u, _ := url.Parse(s)
+		_, err := consulConfigFromEnv(u)
+		assert.NotNil(t, err, s)
+	}
+
+	u, _ := url.Parse("consul://127.0.0.1:8500?consistency=sometimes")
+	_, err := consulConsistencyFromEnv(u)
+	assert.NotNil(t, err)
+
+	_, err = NewKVStore("consul://127.0.0.1:8500?consistency=sometimes")
+	assert.NotNil(t, err)
+}
+
+func TestTLSSettings(t *testing.T) {
+	u, _ := url.Parse("consul://127.0.0.1:8500?ca-cert=../service/cert.pem")
+	config, err := consulConfigFromEnv(u)
+	assert.Nil(t, err)
+	assert.Equal(t, "https", config.Scheme)
+	if transport, ok := config.HttpClient.Transport.(*http.Transport); assert.True(t, ok) {
+		assert.NotNil(t, transport.TLSClientConfig.RootCAs)
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	kvs := &ConsulKVStore{}
+	assert.Nil(t, kvs.queryOptions())
+
+	kvs.Consistency = ConsistencyStale
+	assert.True(t, kvs.queryOptions().AllowStale)
+
+	kvs.Consistency = ConsistencyConsistent
+	assert.True(t, kvs.queryOptions().RequireConsistent)
+}
+
+func testConsulStoreForHandler(t *testing.T, handler http.HandlerFunc) (KVStore, func()) {
+	ts := httptest.NewServer(handler)
+	tsURL, _ := url.Parse(ts.URL)
+	kvs, err := NewKVStore("consul://" + tsURL.Host + "/teamA")
+	assert.Nil(t, err)
+	return kvs, ts.Close
+}
+
+func TestCheckAccessDenied(t *testing.T) {
+	kvs, stop := testConsulStoreForHandler(t, func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+		rw.Write([]byte("Permission denied"))
+	})
+	defer stop()
+
+	err := CheckAccess(kvs, "routes/")
+	if assert.NotNil(t, err) {
+		denied, ok := err.(*AccessDeniedError)
+		if assert.True(t, ok) {
+			assert.Equal(t, "read", denied.Operation)
+			assert.Equal(t, "teamA/routes/", denied.Prefix)
+		}
+	}
+}
+
+func TestCheckAccessWriteDenied(t *testing.T) {
+	kvs, stop := testConsulStoreForHandler(t, func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == "PUT" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	})
+	defer stop()
+
+	err := CheckAccess(kvs, "routes/")
+	if denied, ok := err.(*AccessDeniedError); assert.True(t, ok) {
+		assert.Equal(t, "write", denied.Operation)
+	}
+}
+
+func TestCheckAccessAllowed(t *testing.T) {
+	var casIndex string
+	kvs, stop := testConsulStoreForHandler(t, func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == "PUT" {
+			casIndex = req.URL.Query().Get("cas")
+			rw.Write([]byte("false"))
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	})
+	defer stop()
+
+	assert.Nil(t, CheckAccess(kvs, "routes/", "servers/"))
+	assert.NotEqual(t, "", casIndex)
+	assert.NotEqual(t, "0", casIndex)
+}
+
+func TestCheckAccessUnreachable(t *testing.T) {
+	kvs, _ := NewKVStore("consul://127.0.0.1:1")
+	err := CheckAccess(kvs, "routes/")
+	assert.NotNil(t, err)
+	_, denied := err.(*AccessDeniedError)
+	assert.False(t, denied)
+}
+
+func TestCheckAccessHashStore(t *testing.T) {
+	kvs, _ := NewHashKVStore("")
+	assert.Nil(t, CheckAccess(kvs, "routes/"))
+}
@@ -1,15 +1,23 @@
 package kvstore
 
 import (
+	"bytes"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	consulapi "github.com/hashicorp/consul/api"
 	"net/url"
+	"sync"
+	"time"
 )
 
+//watchRetryInterval is how long a watch waits before retrying a failed blocking query
+var watchRetryInterval = 5 * time.Second
+
 //ConsulKVStore is an implementation of KVStore using Consul.
 type ConsulKVStore struct {
-	KV *consulapi.KV
+	KV          *consulapi.KV
+	Client      *consulapi.Client
+	Consistency string
 }
 
 //NewConsulKVStore creates a new instance of the ConsulKVStore
@@ -19,16 +27,36 @@ func NewConsulKVStore(u *url.URL) (*ConsulKVStore, error) {
 		return nil, err
 	}
 
+	consistency, err := consulConsistencyFromEnv(u)
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := consulapi.NewClient(consulConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ConsulKVStore{
-		KV: client.KV(),
+		KV:          client.KV(),
+		Client:      client,
+		Consistency: consistency,
 	}, nil
 }
 
+//queryOptions returns the options for reads from consul based on the consistency mode
+//of the store
+func (kvs *ConsulKVStore) queryOptions() *consulapi.QueryOptions {
+	switch kvs.Consistency {
+	case ConsistencyStale:
+		return &consulapi.QueryOptions{AllowStale: true}
+	case ConsistencyConsistent:
+		return &consulapi.QueryOptions{RequireConsistent: true}
+	default:
+		return nil
+	}
+}
+
 //Put stores a value using the given key in consul
 func (kvs *ConsulKVStore) Put(key string, value []byte) error {
 	p := &consulapi.KVPair{Key: key, Value: value}
@@ -39,8 +67,8 @@ func (kvs *ConsulKVStore) Put(key string, value []byte) error {
 //Get returns the value, if any, stored under the given key. A
 //nil value is returned if not present in the KVS
 func (kvs *ConsulKVStore) Get(key string) ([]byte, error) {
-	log.Info(fmt.Sprintf("Retrieving %s from consul store", key))
-	kvPair, _, err := kvs.KV.Get(key, nil)
+	log.Debug(fmt.Sprintf("Retrieving %s from consul store", key))
+	kvPair, _, err := kvs.KV.Get(key, kvs.queryOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -52,11 +80,34 @@ func (kvs *ConsulKVStore) Get(key string) ([]byte, error) {
 
 }
 
+//GetWithIndex returns the value, if any, stored under the given key along with the
+//consul modify index of the key. A nil value and 0 index is returned if the key is not
+//present in the KVS
+func (kvs *ConsulKVStore) GetWithIndex(key string) ([]byte, uint64, error) {
+	kvPair, _, err := kvs.KV.Get(key, kvs.queryOptions())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if kvPair != nil {
+		return kvPair.Value, kvPair.ModifyIndex, nil
+	}
+
+	return nil, 0, nil
+}
+
+//CAS stores the value under the given key using a consul check-and-set operation
+func (kvs *ConsulKVStore) CAS(key string, value []byte, index uint64) (bool, error) {
+	p := &consulapi.KVPair{Key: key, Value: value, ModifyIndex: index}
+	ok, _, err := kvs.KV.CAS(p, nil)
+	return ok, err
+}
+
 //List returns a list of the objects stored under the given key. Nil
 //is returned if nothing is present for the given key
 func (kvs *ConsulKVStore) List(key string) ([]*KVPair, error) {
 	log.Info("List values under ", key)
-	pairs, _, err := kvs.KV.List(key, nil)
+	pairs, _, err := kvs.KV.List(key, kvs.queryOptions())
 	if err != nil {
 		log.Info("Error listing keys: ", err.Error())
 		return nil, err
@@ -70,6 +121,124 @@ func (kvs *ConsulKVStore) List(key string) ([]*KVPair, error) {
 	return kvpairs, nil
 }
 
+//Delete removes the given key from consul
+func (kvs *ConsulKVStore) Delete(key string) error {
+	log.Info("Delete key ", key)
+	_, err := kvs.KV.Delete(key, nil)
+	return err
+}
+
+//DeletePrefix removes all keys under the given prefix from consul
+func (kvs *ConsulKVStore) DeletePrefix(prefix string) error {
+	log.Info("Delete keys under ", prefix)
+	_, err := kvs.KV.DeleteTree(prefix, nil)
+	return err
+}
+
+//Watch registers fn to be called each time a key under the given prefix changes. The
+//watch is implemented using consul blocking queries on the prefix, with changes detected
+//by comparing the modify index of each key against the previous result. The returned
+//function cancels the watch; a blocking query in progress is allowed to complete
+//but no further notifications are delivered.
+func (kvs *ConsulKVStore) Watch(prefix string, fn WatchFunc) (func(), error) {
+	pairs, meta, err := kvs.KV.List(prefix, kvs.queryOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	stopChan := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() { close(stopChan) })
+	}
+
+	go kvs.watchPrefix(prefix, fn, indexPairs(pairs), meta.LastIndex, stopChan)
+
+	return stop, nil
+}
+
+func (kvs *ConsulKVStore) watchPrefix(prefix string, fn WatchFunc, known map[string]*consulapi.KVPair,
+	index uint64, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		opts := &consulapi.QueryOptions{WaitIndex: index}
+		if baseOpts := kvs.queryOptions(); baseOpts != nil {
+			opts.AllowStale = baseOpts.AllowStale
+			opts.RequireConsistent = baseOpts.RequireConsistent
+		}
+
+		pairs, meta, err := kvs.KV.List(prefix, opts)
+		if err != nil {
+			log.Warn("Error watching keys under ", prefix, ": ", err.Error())
+			select {
+			case <-stop:
+				return
+			case <-time.After(watchRetryInterval):
+			}
+			continue
+		}
+
+		if meta.LastIndex == index {
+			continue
+		}
+
+		//Consul indexes can go backwards, for example when a snapshot is restored. In that
+		//case we start over with a fresh blocking query.
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		current := indexPairs(pairs)
+		events := diffPairs(known, current)
+		known = current
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		for _, e := range events {
+			fn(e)
+		}
+	}
+}
+
+func indexPairs(pairs consulapi.KVPairs) map[string]*consulapi.KVPair {
+	indexed := make(map[string]*consulapi.KVPair)
+	for _, p := range pairs {
+		indexed[p.Key] = p
+	}
+	return indexed
+}
+
+//diffPairs returns the watch events needed to go from the previous set of pairs to
+//the current set of pairs
+func diffPairs(previous, current map[string]*consulapi.KVPair) []*WatchEvent {
+	var events []*WatchEvent
+	for k, p := range current {
+		old, ok := previous[k]
+		if !ok || old.ModifyIndex != p.ModifyIndex || !bytes.Equal(old.Value, p.Value) {
+			events = append(events, &WatchEvent{Key: k, Value: p.Value})
+		}
+	}
+
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			events = append(events, &WatchEvent{Key: k, Deleted: true})
+		}
+	}
+
+	return events
+}
+
 //Flush is a no-op for consul backed KVStores
 func (kvs *ConsulKVStore) Flush() error {
 	return nil
@@ -66,3 +66,33 @@ func TestFlush(t *testing.T) {
 	err := consulKV.Flush()
 	assert.Nil(t, err)
 }
+
+func TestKVDelete(t *testing.T) {
+	client, server := makeClient(t)
+	defer server.Stop()
+	consulKV := &ConsulKVStore{
+		KV: client.KV(),
+	}
+
+	consulKV.Put("d/a", []byte("a"))
+	consulKV.Put("d/b", []byte("b"))
+	consulKV.Put("e/a", []byte("a"))
+
+	err := consulKV.Delete("d/a")
+	assert.Nil(t, err)
+
+	b, err := consulKV.Get("d/a")
+	assert.Nil(t, err)
+	assert.Nil(t, b)
+
+	err = consulKV.DeletePrefix("d/")
+	assert.Nil(t, err)
+
+	kvp, err := consulKV.List("d/")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(kvp))
+
+	kvp, err = consulKV.List("e/")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(kvp))
+}
@@ -0,0 +1,58 @@
+package kvstore
+
+import (
+	"errors"
+	"strings"
+)
+
+//maxConsulTxnOps is the maximum number of operations consul accepts in a single transaction
+const maxConsulTxnOps = 64
+
+var errNoConsulClient = errors.New("Consul client not configured for KV store - transactions not available")
+
+type consulTxnKVOp struct {
+	Verb  string
+	Key   string
+	Value []byte `json:",omitempty"`
+	Index uint64 `json:",omitempty"`
+}
+
+type consulTxnOp struct {
+	KV *consulTxnKVOp
+}
+
+//Txn applies the given operations atomically using the consul transaction endpoint. If
+//any conditional operation fails ErrTxnConflict is returned and none of the operations
+//are applied. Transactions of more than 64 operations are rejected with a TxnTooLargeError.
+func (kvs *ConsulKVStore) Txn(ops []*TxnOp) error {
+	if kvs.Client == nil {
+		return errNoConsulClient
+	}
+
+	if err := validateTxnOps(ops); err != nil {
+		return err
+	}
+
+	if len(ops) > maxConsulTxnOps {
+		return &TxnTooLargeError{Ops: len(ops), Limit: maxConsulTxnOps}
+	}
+
+	var txnOps []*consulTxnOp
+	for _, op := range ops {
+		txnOps = append(txnOps, &consulTxnOp{
+			KV: &consulTxnKVOp{Verb: op.Verb, Key: op.Key, Value: op.Value, Index: op.Index},
+		})
+	}
+
+	_, err := kvs.Client.Raw().Write("/v1/txn", txnOps, nil, nil)
+	if err != nil {
+		//The consul api client reports the 409 returned for a rolled back transaction
+		//as an unexpected response code
+		if strings.Contains(err.Error(), "response code: 409") {
+			return ErrTxnConflict
+		}
+		return err
+	}
+
+	return nil
+}
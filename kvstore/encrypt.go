@@ -0,0 +1,421 @@
+package kvstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+//encryptedValuePrefix marks values written by the EncryptingKVStore. The prefix is followed
+//by the id of the key used to encrypt the value, a colon, and the base64 encoded nonce and
+//ciphertext. Values without the prefix are returned as is, so encryption can be enabled for
+//a store already holding plaintext values.
+const encryptedValuePrefix = "xavi-enc:v1:"
+
+//ErrNoEncryptionKeys is returned when a keyring without any keys is used
+var ErrNoEncryptionKeys = errors.New("No encryption keys specified")
+
+//Keyring holds the keys used by an EncryptingKVStore. Values are encrypted with the primary
+//key, and can be decrypted with any key in the ring, which allows keys to be rotated by
+//adding a new primary key while keeping the previous keys until values have been
+//re-encrypted.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+//ParseKeyring parses keys in the form id:base64-key, separated by newlines or commas. The
+//first key is the primary key. Keys must be 16, 24 or 32 bytes long, selecting AES-128,
+//AES-192 or AES-256. Blank lines and lines starting with # are ignored.
+func ParseKeyring(spec string) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == '\n' || r == ',' })
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Malformed encryption key - expected id:base64-key")
+		}
+
+		id := parts[0]
+		if _, dup := kr.keys[id]; dup {
+			return nil, fmt.Errorf("Duplicate encryption key id %s", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Encryption key %s is not valid base64: %s", id, err.Error())
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("Encryption key %s is not a valid AES key: %s", id, err.Error())
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		kr.keys[id] = gcm
+		if kr.primary == "" {
+			kr.primary = id
+		}
+	}
+
+	if kr.primary == "" {
+		return nil, ErrNoEncryptionKeys
+	}
+
+	return kr, nil
+}
+
+//ReadKeyringFile reads a keyring from the given file in the format accepted by ParseKeyring
+func ReadKeyringFile(filename string) (*Keyring, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read encryption key file: %s", err.Error())
+	}
+	return ParseKeyring(string(content))
+}
+
+//PrimaryKeyID returns the id of the key used to encrypt values
+func (kr *Keyring) PrimaryKeyID() string {
+	return kr.primary
+}
+
+//encrypt encrypts the value using the primary key. The key the value is stored under is
+//used as additional authenticated data, so an encrypted value cannot be moved to another key.
+func (kr *Keyring) encrypt(key string, value []byte) ([]byte, error) {
+	gcm := kr.keys[kr.primary]
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, value, []byte(key))
+
+	var buf bytes.Buffer
+	buf.WriteString(encryptedValuePrefix)
+	buf.WriteString(kr.primary)
+	buf.WriteString(":")
+	buf.WriteString(base64.StdEncoding.EncodeToString(sealed))
+	return buf.Bytes(), nil
+}
+
+//decrypt decrypts values written by encrypt, returning other values unchanged. The returned
+//key id is empty for values that were not encrypted.
+func (kr *Keyring) decrypt(key string, value []byte) ([]byte, string, error) {
+	if !bytes.HasPrefix(value, []byte(encryptedValuePrefix)) {
+		return value, "", nil
+	}
+
+	parts := strings.SplitN(string(value[len(encryptedValuePrefix):]), ":", 2)
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("Malformed encrypted value for key %s", key)
+	}
+
+	id := parts[0]
+	gcm, ok := kr.keys[id]
+	if !ok {
+		return nil, "", fmt.Errorf("Value for key %s is encrypted with unknown key %s", key, id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, "", fmt.Errorf("Malformed encrypted value for key %s", key)
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to decrypt value for key %s: %s", key, err.Error())
+	}
+
+	return plaintext, id, nil
+}
+
+//HistoryPrefix is the key prefix definition revisions are recorded under. Revisions hold copies of
+//definitions, so values under HistoryPrefix followed by an encrypted prefix are encrypted too.
+const HistoryPrefix = "history/"
+
+//EncryptingKVStore encrypts values written under a set of key prefixes using AES-GCM, and
+//decrypts encrypted values when they are read, so encryption is transparent to users of
+//the store.
+type EncryptingKVStore struct {
+	kvs      KVStore
+	keyring  *Keyring
+	prefixes []string
+}
+
+//NewEncryptingKVStore wraps the store so values under the given prefixes, and the revision history
+//of values under them, are encrypted with the primary key of the keyring. If no prefixes are given
+//all values are encrypted.
+func NewEncryptingKVStore(kvs KVStore, keyring *Keyring, prefixes ...string) (*EncryptingKVStore, error) {
+	if keyring == nil {
+		return nil, ErrNoEncryptionKeys
+	}
+
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	prefixes = withHistoryPrefixes(prefixes)
+
+	return &EncryptingKVStore{
+		kvs:      kvs,
+		keyring:  keyring,
+		prefixes: prefixes,
+	}, nil
+}
+
+//withHistoryPrefixes adds the history prefix of each prefix to the prefixes. The empty prefix and
+//prefixes under the history prefix already cover their history.
+func withHistoryPrefixes(prefixes []string) []string {
+	all := make([]string, 0, 2*len(prefixes))
+	seen := make(map[string]bool)
+	add := func(prefix string) {
+		if !seen[prefix] {
+			seen[prefix] = true
+			all = append(all, prefix)
+		}
+	}
+
+	for _, p := range prefixes {
+		add(p)
+		if p != "" && !strings.HasPrefix(p, HistoryPrefix) {
+			add(HistoryPrefix + p)
+		}
+	}
+
+	return all
+}
+
+//Underlying returns the wrapped store
+func (ekvs *EncryptingKVStore) Underlying() KVStore {
+	return ekvs.kvs
+}
+
+func (ekvs *EncryptingKVStore) encrypted(key string) bool {
+	for _, p := range ekvs.prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ekvs *EncryptingKVStore) encode(key string, value []byte) ([]byte, error) {
+	if !ekvs.encrypted(key) {
+		return value, nil
+	}
+	return ekvs.keyring.encrypt(key, value)
+}
+
+func (ekvs *EncryptingKVStore) decode(key string, value []byte) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	plaintext, _, err := ekvs.keyring.decrypt(key, value)
+	return plaintext, err
+}
+
+//Put stores the value, encrypted if the key is under an encrypted prefix
+func (ekvs *EncryptingKVStore) Put(key string, value []byte) error {
+	encoded, err := ekvs.encode(key, value)
+	if err != nil {
+		return err
+	}
+	return ekvs.kvs.Put(key, encoded)
+}
+
+//Get returns the decrypted value (if any) stored under the given key
+func (ekvs *EncryptingKVStore) Get(key string) ([]byte, error) {
+	value, err := ekvs.kvs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return ekvs.decode(key, value)
+}
+
+//GetWithIndex returns the decrypted value (if any) stored under the given key along with
+//its modify index
+func (ekvs *EncryptingKVStore) GetWithIndex(key string) ([]byte, uint64, error) {
+	value, index, err := ekvs.kvs.GetWithIndex(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	plaintext, err := ekvs.decode(key, value)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return plaintext, index, nil
+}
+
+//CAS stores the value, encrypted if the key is under an encrypted prefix, if the key's
+//modify index matches the given index
+func (ekvs *EncryptingKVStore) CAS(key string, value []byte, index uint64) (bool, error) {
+	encoded, err := ekvs.encode(key, value)
+	if err != nil {
+		return false, err
+	}
+	return ekvs.kvs.CAS(key, encoded, index)
+}
+
+//Txn applies the operations, encrypting the values written under encrypted prefixes
+func (ekvs *EncryptingKVStore) Txn(ops []*TxnOp) error {
+	var encodedOps []*TxnOp
+	for _, op := range ops {
+		encodedOp := *op
+		switch op.Verb {
+		case TxnSet, TxnCAS:
+			encoded, err := ekvs.encode(op.Key, op.Value)
+			if err != nil {
+				return err
+			}
+			encodedOp.Value = encoded
+		}
+		encodedOps = append(encodedOps, &encodedOp)
+	}
+
+	return ekvs.kvs.Txn(encodedOps)
+}
+
+//List returns the decrypted values stored under the given key
+func (ekvs *EncryptingKVStore) List(key string) ([]*KVPair, error) {
+	pairs, err := ekvs.kvs.List(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var kvpairs []*KVPair
+	for _, p := range pairs {
+		value, err := ekvs.decode(p.Key, p.Value)
+		if err != nil {
+			return nil, err
+		}
+		kvpairs = append(kvpairs, &KVPair{p.Key, value})
+	}
+
+	return kvpairs, nil
+}
+
+//Delete removes the given key
+func (ekvs *EncryptingKVStore) Delete(key string) error {
+	return ekvs.kvs.Delete(key)
+}
+
+//DeletePrefix removes all keys under the given prefix
+func (ekvs *EncryptingKVStore) DeletePrefix(prefix string) error {
+	return ekvs.kvs.DeletePrefix(prefix)
+}
+
+//Watch registers fn to be called with the decrypted values of changed keys under the prefix
+func (ekvs *EncryptingKVStore) Watch(prefix string, fn WatchFunc) (func(), error) {
+	return ekvs.kvs.Watch(prefix, func(e *WatchEvent) {
+		event := *e
+		value, err := ekvs.decode(e.Key, e.Value)
+		if err != nil {
+			log.Warn("Unable to decrypt watched value: ", err.Error())
+			return
+		}
+		event.Value = value
+		fn(&event)
+	})
+}
+
+//Flush flushes the underlying store
+func (ekvs *EncryptingKVStore) Flush() error {
+	return ekvs.kvs.Flush()
+}
+
+//PrimaryKeyID returns the id of the key used to encrypt values
+func (ekvs *EncryptingKVStore) PrimaryKeyID() string {
+	return ekvs.keyring.PrimaryKeyID()
+}
+
+//CheckAccess checks access using the underlying store
+func (ekvs *EncryptingKVStore) CheckAccess(prefix string) error {
+	return CheckAccess(ekvs.kvs, prefix)
+}
+
+//Reencrypt rewrites values under the encrypted prefixes that are not encrypted with the
+//primary key, either because they were written before encryption was enabled or with a
+//key that has since been rotated out. Values are rewritten with a check-and-set so
+//concurrent updates are not lost, and keys deleted while re-encrypting are skipped. The
+//number of values rewritten is returned.
+func (ekvs *EncryptingKVStore) Reencrypt() (int, error) {
+	rewritten := 0
+	for _, prefix := range ekvs.prefixes {
+		pairs, err := ekvs.kvs.List(prefix)
+		if err != nil {
+			return rewritten, err
+		}
+
+		for _, p := range pairs {
+			_, id, err := ekvs.keyring.decrypt(p.Key, p.Value)
+			if err != nil {
+				return rewritten, err
+			}
+
+			if id == ekvs.keyring.primary {
+				continue
+			}
+
+			value, index, err := ekvs.GetWithIndex(p.Key)
+			if err != nil {
+				return rewritten, err
+			}
+
+			//A CAS with index 0 would recreate a key deleted since it was listed
+			if value == nil || index == 0 {
+				log.Info("Key ", p.Key, " deleted while re-encrypting - skipping")
+				continue
+			}
+
+			ok, err := ekvs.CAS(p.Key, value, index)
+			if err != nil {
+				return rewritten, err
+			}
+
+			if ok {
+				rewritten++
+			} else {
+				log.Info("Key ", p.Key, " modified while re-encrypting - skipping")
+			}
+		}
+	}
+
+	return rewritten, nil
+}
+
+//FindEncryptingKVStore returns the EncryptingKVStore in the chain of stores wrapped by kvs,
+//or nil if there is none
+func FindEncryptingKVStore(kvs KVStore) *EncryptingKVStore {
+	for {
+		switch s := kvs.(type) {
+		case *EncryptingKVStore:
+			return s
+		case wrappingStore:
+			kvs = s.Underlying()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/env"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func testMakeEncryptingStore(t *testing.T, keys string, prefixes ...string) (*HashKVStore, *EncryptingKVStore) {
+	hkvs, _ := NewHashKVStore("")
+	keyring, err := ParseKeyring(keys)
+	assert.Nil(t, err)
+	ekvs, err := NewEncryptingKVStore(hkvs, keyring, prefixes...)
+	assert.Nil(t, err)
+	return hkvs, ekvs
+}
+
+func TestParseKeyring(t *testing.T) {
+	kr, err := ParseKeyring("# comment\nk2:" + testKey(2) + "\n\nk1:" + testKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, "k2", kr.PrimaryKeyID())
+
+	kr, err = ParseKeyring("k1:" + testKey(1) + ",k0:" + base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", kr.PrimaryKeyID())
+
+	for _, spec := range []string{
+		"",
+		"# nothing",
+		"nokey",
+		":" + testKey(1),
+		"k1:not base64!",
+		"k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1:" + testKey(1) + ",k1:" + testKey(2),
+	} {
+		_, err := ParseKeyring(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestEncryptPrefixes(t *testing.T) {
+	hkvs, ekvs := testMakeEncryptingStore(t, "k1:"+testKey(1), "secrets/")
+
+	assert.Nil(t, ekvs.Put("secrets/s1", []byte("top secret")))
+	assert.Nil(t, ekvs.Put("plain/p1", []byte("not secret")))
+
+	raw, _ := hkvs.Get("secrets/s1")
+	assert.True(t, strings.HasPrefix(string(raw), encryptedValuePrefix+"k1:"))
+	assert.False(t, strings.Contains(string(raw), "top secret"))
+
+	raw, _ = hkvs.Get("plain/p1")
+	assert.Equal(t, []byte("not secret"), raw)
+
+	v, err := ekvs.Get("secrets/s1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("top secret"), v)
+
+	v, _, err = ekvs.GetWithIndex("secrets/s1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("top secret"), v)
+
+	pairs, err := ekvs.List("")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pairs))
+	for _, p := range pairs {
+		assert.False(t, strings.HasPrefix(string(p.Value), encryptedValuePrefix))
+	}
+
+	v, _ = ekvs.Get("nope")
+	assert.Nil(t, v)
+}
+
+func TestEncryptHistoryOfEncryptedPrefixes(t *testing.T) {
+	hkvs, ekvs := testMakeEncryptingStore(t, "k1:"+testKey(1), "secrets/")
+
+	assert.Nil(t, ekvs.Put("history/secrets/s1/0000000001", []byte("top secret")))
+	assert.Nil(t, ekvs.Put("history/plain/p1/0000000001", []byte("not secret")))
+
+	raw, _ := hkvs.Get("history/secrets/s1/0000000001")
+	assert.True(t, strings.HasPrefix(string(raw), encryptedValuePrefix+"k1:"))
+	raw, _ = hkvs.Get("history/plain/p1/0000000001")
+	assert.Equal(t, []byte("not secret"), raw)
+
+	_, ekvs = testMakeEncryptingStore(t, "k1:"+testKey(1), "secrets/", "history/secrets/")
+	assert.Equal(t, []string{"secrets/", "history/secrets/"}, ekvs.prefixes)
+}
+
+func TestEncryptAllByDefault(t *testing.T) {
+	hkvs, ekvs := testMakeEncryptingStore(t, "k1:"+testKey(1))
+	ekvs.Put("a", []byte("a"))
+	raw, _ := hkvs.Get("a")
+	assert.True(t, strings.HasPrefix(string(raw), encryptedValuePrefix))
+}
+
+func TestEncryptedValueBoundToKey(t *testing.T) {
+	hkvs, ekvs := testMakeEncryptingStore(t, "k1:"+testKey(1))
+	ekvs.Put("a", []byte("a"))
+
+	raw, _ := hkvs.Get("a")
+	hkvs.Put("b", raw)
+	_, err := ekvs.Get("b")
+	assert.NotNil(t, err)
+
+	hkvs.Put("c", []byte(encryptedValuePrefix+"k1:garbage"))
+	_, err = ekvs.Get("c")
+	assert.NotNil(t, err)
+
+	hkvs.Put("d", []byte(encryptedValuePrefix+"unknown:"+base64.StdEncoding.EncodeToString([]byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"))))
+	_, err = ekvs.Get("d")
+	assert.NotNil(t, err)
+
+	_, err = ekvs.List("")
+	assert.NotNil(t, err)
+}
+
+func TestEncryptTxnAndCAS(t *testing.T) {
+	hkvs, ekvs := testMakeEncryptingStore(t, "k1:"+testKey(1))
+
+	ok, err := ekvs.CAS("a", []byte("a1"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	err = ekvs.Txn([]*TxnOp{
+		{Verb: TxnSet, Key: "b", Value: []byte("b1")},
+		{Verb: TxnDelete, Key: "a"},
+	})
+	assert.Nil(t, err)
+
+	raw, _ := hkvs.Get("b")
+	assert.True(t, strings.HasPrefix(string(raw), encryptedValuePrefix))
+	v, _ := ekvs.Get("b")
+	assert.Equal(t, []byte("b1"), v)
+	v, _ = ekvs.Get("a")
+	assert.Nil(t, v)
+}
+
+func TestEncryptWatch(t *testing.T) {
+	_, ekvs := testMakeEncryptingStore(t, "k1:"+testKey(1))
+
+	var values []string
+	cancel, err := ekvs.Watch("", func(e *WatchEvent) {
+		values = append(values, string(e.Value))
+	})
+	assert.Nil(t, err)
+	defer cancel()
+
+	ekvs.Put("a", []byte("a1"))
+	assert.Equal(t, []string{"a1"}, values)
+}
+
+func TestKeyRotation(t *testing.T) {
+	hkvs, ekvs := testMakeEncryptingStore(t, "k1:"+testKey(1), "secrets/")
+	ekvs.Put("secrets/s1", []byte("s1"))
+	hkvs.Put("secrets/s2", []byte("written before encryption"))
+
+	t.Log("Rotate in a new primary key, keeping the old one for decryption")
+	keyring, _ := ParseKeyring("k2:" + testKey(2) + ",k1:" + testKey(1))
+	rotated, _ := NewEncryptingKVStore(hkvs, keyring, "secrets/")
+
+	v, err := rotated.Get("secrets/s1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("s1"), v)
+
+	count, err := rotated.Reencrypt()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	for _, key := range []string{"secrets/s1", "secrets/s2"} {
+		raw, _ := hkvs.Get(key)
+		assert.True(t, strings.HasPrefix(string(raw), encryptedValuePrefix+"k2:"), key)
+	}
+
+	count, _ = rotated.Reencrypt()
+	assert.Equal(t, 0, count)
+
+	t.Log("Old key can now be retired")
+	keyring, _ = ParseKeyring("k2:" + testKey(2))
+	retired, _ := NewEncryptingKVStore(hkvs, keyring, "secrets/")
+	v, err = retired.Get("secrets/s2")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("written before encryption"), v)
+}
+
+//deletingKVStore deletes a key when it is first read, as if deleted by another client
+type deletingKVStore struct {
+	*HashKVStore
+	key string
+}
+
+func (d *deletingKVStore) GetWithIndex(key string) ([]byte, uint64, error) {
+	if key == d.key {
+		d.HashKVStore.Delete(key)
+		d.key = ""
+	}
+	return d.HashKVStore.GetWithIndex(key)
+}
+
+func TestReencryptSkipsDeletedKeys(t *testing.T) {
+	hkvs, _ := NewHashKVStore("")
+	hkvs.Put("secrets/s1", []byte("s1"))
+	hkvs.Put("secrets/s2", []byte("s2"))
+
+	keyring, _ := ParseKeyring("k1:" + testKey(1))
+	ekvs, _ := NewEncryptingKVStore(&deletingKVStore{HashKVStore: hkvs, key: "secrets/s1"}, keyring, "secrets/")
+
+	count, err := ekvs.Reencrypt()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	v, _ := hkvs.Get("secrets/s1")
+	assert.Nil(t, v)
+	raw, _ := hkvs.Get("secrets/s2")
+	assert.True(t, strings.HasPrefix(string(raw), encryptedValuePrefix+"k1:"))
+}
+
+func TestEncryptionFromFactory(t *testing.T) {
+	f, err := ioutil.TempFile("./", "tst")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	wd, _ := os.Getwd()
+
+	keyFile, _ := ioutil.TempFile("./", "key")
+	defer os.Remove(keyFile.Name())
+	keyFile.WriteString("k1:" + testKey(1) + "\n")
+	keyFile.Close()
+
+	storeURL := fmt.Sprintf("file:///%s/%s?namespace=teamA&cache=true&encrypt-prefixes=servers/&encryption-key-file=%s",
+		wd, f.Name(), keyFile.Name())
+	kvs, err := NewKVStore(storeURL)
+	assert.Nil(t, err)
+
+	ekvs := FindEncryptingKVStore(kvs)
+	if assert.NotNil(t, ekvs) {
+		assert.Equal(t, []string{"servers/", "history/servers/"}, ekvs.prefixes)
+	}
+	assert.Equal(t, "teamA", NamespaceOf(kvs))
+
+	kvs.Put("servers/s1", []byte("s1"))
+	v, _ := kvs.Get("servers/s1")
+	assert.Equal(t, []byte("s1"), v)
+
+	os.Setenv(env.EncryptionKeys, "bad")
+	defer os.Unsetenv(env.EncryptionKeys)
+	_, err = NewKVStore(fmt.Sprintf("file:///%s/%s", wd, f.Name()))
+	assert.NotNil(t, err)
+
+	_, err = NewKVStore(fmt.Sprintf("file:///%s/%s?encryption-key-file=/no/such/file", wd, f.Name()))
+	assert.NotNil(t, err)
+
+	hkvs, _ := NewHashKVStore("")
+	assert.Nil(t, FindEncryptingKVStore(hkvs))
+}
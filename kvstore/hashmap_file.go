@@ -0,0 +1,155 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//storeFileVersion is the version of the backing file format written by DumpToFile. Files
+//without a version are assumed to use the original key#value line format.
+const storeFileVersion = 1
+
+//storeFile is the on-disk representation of a HashKVStore
+type storeFile struct {
+	Version int
+	Pairs   []storeFilePair
+}
+
+//storeFilePair holds a single key value pair. Values are written as strings so the
+//file remains readable, unless they are not valid UTF-8, in which case they are
+//written base64 encoded in the Binary field.
+type storeFilePair struct {
+	Key    string
+	Value  string `json:",omitempty"`
+	Binary []byte `json:",omitempty"`
+}
+
+func encodeStoreFile(store map[string][]byte) ([]byte, error) {
+	sf := storeFile{Version: storeFileVersion}
+
+	var keys []string
+	for k := range store {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := store[k]
+		if utf8.Valid(v) {
+			sf.Pairs = append(sf.Pairs, storeFilePair{Key: k, Value: string(v)})
+		} else {
+			sf.Pairs = append(sf.Pairs, storeFilePair{Key: k, Binary: v})
+		}
+	}
+
+	return json.MarshalIndent(&sf, "", "  ")
+}
+
+//decodeStoreFile decodes the content of a backing file, returning the key value pairs and
+//a flag indicating if the content was in the original key#value line format.
+func decodeStoreFile(content []byte) (map[string][]byte, bool, error) {
+	store := make(map[string][]byte)
+
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 {
+		return store, false, nil
+	}
+
+	if trimmed[0] != '{' {
+		legacyStore, err := decodeLegacyStoreFile(content)
+		return legacyStore, true, err
+	}
+
+	var sf storeFile
+	if err := json.Unmarshal(trimmed, &sf); err != nil {
+		return nil, false, fmt.Errorf("Unable to read KV store backing file: %s", err.Error())
+	}
+
+	if sf.Version > storeFileVersion {
+		return nil, false, fmt.Errorf("KV store backing file version %d is newer than supported version %d",
+			sf.Version, storeFileVersion)
+	}
+
+	for _, p := range sf.Pairs {
+		if p.Binary != nil {
+			store[p.Key] = p.Binary
+		} else {
+			store[p.Key] = []byte(p.Value)
+		}
+	}
+
+	return store, false, nil
+}
+
+//decodeLegacyStoreFile reads the original key#value line format. Only the first # is
+//treated as the separator so values containing # are preserved.
+func decodeLegacyStoreFile(content []byte) (map[string][]byte, error) {
+	store := make(map[string][]byte)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, "#", 2)
+		if len(parts) != 2 {
+			log.Info("Line did not split into two parts - skipping: ", line)
+			continue
+		}
+		store[parts[0]] = []byte(parts[1])
+	}
+
+	return store, scanner.Err()
+}
+
+//writeFileAtomically writes content to a temporary file alongside filename, then renames
+//the temporary file to filename.
+func writeFileAtomically(filename string, content []byte) error {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpName := f.Name()
+	cleanUp := func() {
+		f.Close()
+		os.Remove(tmpName)
+	}
+
+	if info, err := os.Stat(filename); err == nil {
+		if err := f.Chmod(info.Mode()); err != nil {
+			cleanUp()
+			return err
+		}
+	}
+
+	if _, err := f.Write(content); err != nil {
+		cleanUp()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		cleanUp()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, filename)
+}
@@ -0,0 +1,138 @@
+package kvstore
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDumpAndLoadValuesWithHash(t *testing.T) {
+	f, err := ioutil.TempFile("./", "tst")
+	assert.Nil(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	kvs, err := NewHashKVStore(f.Name())
+	assert.Nil(t, err)
+
+	hashValue := []byte(`{"URIRoot":"/foo#bar","Header":"a#b#c"}`)
+	binaryValue := []byte{0xff, 0xfe, '#', '\n', 0x00}
+	kvs.Put("routes/r1", hashValue)
+	kvs.Put("bin/b1", binaryValue)
+	kvs.Put("empty/e1", []byte{})
+
+	err = kvs.Flush()
+	assert.Nil(t, err)
+
+	reloaded, err := NewHashKVStore(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(reloaded.Store))
+
+	v, _ := reloaded.Get("routes/r1")
+	assert.Equal(t, hashValue, v)
+	v, _ = reloaded.Get("bin/b1")
+	assert.Equal(t, binaryValue, v)
+	v, _ = reloaded.Get("empty/e1")
+	assert.Equal(t, 0, len(v))
+}
+
+func TestLegacyFileMigrated(t *testing.T) {
+	f, err := ioutil.TempFile("./", "tst")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString(`routes/r1#{"URIRoot":"/foo#bar"}`)
+	f.WriteString("\n")
+	f.WriteString("no separator here\n")
+	f.Close()
+
+	kvs, err := NewHashKVStore(f.Name())
+	assert.Nil(t, err)
+
+	v, err := kvs.Get("routes/r1")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"URIRoot":"/foo#bar"}`, string(v))
+
+	t.Log("Verify the file has been rewritten in the current format")
+	content, err := ioutil.ReadFile(f.Name())
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "{"))
+	assert.True(t, strings.Contains(string(content), fmt.Sprintf(`"Version": %d`, storeFileVersion)))
+}
+
+func TestNewerFileVersionRejected(t *testing.T) {
+	f, err := ioutil.TempFile("./", "tst")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"Version":99,"Pairs":[]}`)
+	f.Close()
+
+	_, err = NewHashKVStore(f.Name())
+	assert.NotNil(t, err)
+}
+
+func TestCorruptFileRejected(t *testing.T) {
+	f, err := ioutil.TempFile("./", "tst")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"Version":1,"Pairs":[`)
+	f.Close()
+
+	_, err = NewHashKVStore(f.Name())
+	assert.NotNil(t, err)
+}
+
+func TestDumpLeavesNoTempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "tstdir")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	kvs, err := NewHashKVStore(dir + "/kvs")
+	assert.Nil(t, err)
+	kvs.Put("a", []byte("a"))
+	assert.Nil(t, kvs.Flush())
+	assert.Nil(t, kvs.Flush())
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	f, err := ioutil.TempFile("./", "tst")
+	assert.Nil(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	kvs, err := NewHashKVStore(f.Name())
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("k/%d/%d", i, j)
+				kvs.Put(key, []byte(key))
+				kvs.Get(key)
+				kvs.List("k/")
+				if j%10 == 0 {
+					kvs.Flush()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Nil(t, kvs.Flush())
+	reloaded, err := NewHashKVStore(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(reloaded.Store))
+}
@@ -1,19 +1,28 @@
 package kvstore
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 )
 
-//HashKVStore implements the KVStore interface using a hashmap
+//HashKVStore implements the KVStore interface using a hashmap. The store is safe for
+//use by multiple goroutines.
 type HashKVStore struct {
 	faulty      bool
 	Store       map[string][]byte
 	backingFile string
+	watchers    watcherRegistry
+	mu          sync.RWMutex
+	flushMu     sync.Mutex
+
+	//Modify indexes are tracked in memory only, and are reassigned when the store is loaded
+	indexes   map[string]uint64
+	lastIndex uint64
 }
 
 func createBackingFileIfNeeded(filename string) error {
@@ -49,6 +58,7 @@ func NewHashKVStore(backingFile string) (*HashKVStore, error) {
 		faulty:      false,
 		Store:       make(map[string][]byte),
 		backingFile: backingFile,
+		indexes:     make(map[string]uint64),
 	}
 
 	if backingFile == "" {
@@ -70,25 +80,36 @@ func NewHashKVStore(backingFile string) (*HashKVStore, error) {
 
 //InjectFaults forces Gets and Puts to return errors
 func (hkvs *HashKVStore) InjectFaults() {
+	hkvs.mu.Lock()
 	hkvs.faulty = true
+	hkvs.mu.Unlock()
 }
 
 //ClearFaults resets store to non-fault state
 func (hkvs *HashKVStore) ClearFaults() {
+	hkvs.mu.Lock()
 	hkvs.faulty = false
+	hkvs.mu.Unlock()
 }
 
 //Put stores a value under the given key
 func (hkvs *HashKVStore) Put(key string, value []byte) error {
+	hkvs.mu.Lock()
 	if hkvs.faulty {
+		hkvs.mu.Unlock()
 		return errors.New("Faulty store does not put ur key/val pair, ok?")
 	}
-	hkvs.Store[key] = value
+	hkvs.set(key, value)
+	hkvs.mu.Unlock()
+
+	hkvs.watchers.notify(&WatchEvent{Key: key, Value: value})
 	return nil
 }
 
 //Get returns the value (if any) under the given key
 func (hkvs *HashKVStore) Get(key string) ([]byte, error) {
+	hkvs.mu.RLock()
+	defer hkvs.mu.RUnlock()
 	if hkvs.faulty {
 		return nil, errors.New("Faulty store does not get ur key, ok?")
 	}
@@ -97,6 +118,8 @@ func (hkvs *HashKVStore) Get(key string) ([]byte, error) {
 
 //List returns all the values (if any) stored under the given key
 func (hkvs *HashKVStore) List(key string) ([]*KVPair, error) {
+	hkvs.mu.RLock()
+	defer hkvs.mu.RUnlock()
 
 	if hkvs.faulty {
 		return nil, errors.New("You can haz list? Nope.")
@@ -111,59 +134,187 @@ func (hkvs *HashKVStore) List(key string) ([]*KVPair, error) {
 	return kvpairs, nil
 }
 
-//DumpToFile writes the content of the kv store to the backing file configured for the store.
-func (hkvs *HashKVStore) DumpToFile() error {
-	if hkvs.backingFile == "" {
-		return fmt.Errorf("No path to backing file specified")
+//Delete removes the value (if any) stored under the given key
+func (hkvs *HashKVStore) Delete(key string) error {
+	hkvs.mu.Lock()
+	if hkvs.faulty {
+		hkvs.mu.Unlock()
+		return errors.New("Faulty store does not delete ur key, ok?")
 	}
+	_, present := hkvs.Store[key]
+	hkvs.remove(key)
+	hkvs.mu.Unlock()
 
-	f, err := os.Create(hkvs.backingFile)
-	if err != nil {
+	if present {
+		hkvs.watchers.notify(&WatchEvent{Key: key, Deleted: true})
+	}
+	return nil
+}
+
+//DeletePrefix removes all the values (if any) stored under the given key prefix
+func (hkvs *HashKVStore) DeletePrefix(prefix string) error {
+	hkvs.mu.Lock()
+	if hkvs.faulty {
+		hkvs.mu.Unlock()
+		return errors.New("Faulty store does not delete ur keys, ok?")
+	}
+	var events []*WatchEvent
+	for k := range hkvs.Store {
+		if strings.HasPrefix(k, prefix) {
+			hkvs.remove(k)
+			events = append(events, &WatchEvent{Key: k, Deleted: true})
+		}
+	}
+	hkvs.mu.Unlock()
+
+	hkvs.watchers.notify(events...)
+	return nil
+}
+
+//set stores the value and assigns it a new modify index. The caller must hold the write lock.
+func (hkvs *HashKVStore) set(key string, value []byte) {
+	hkvs.lastIndex++
+	hkvs.Store[key] = value
+	hkvs.indexes[key] = hkvs.lastIndex
+}
+
+//remove deletes the key and its modify index. The caller must hold the write lock.
+func (hkvs *HashKVStore) remove(key string) {
+	delete(hkvs.Store, key)
+	delete(hkvs.indexes, key)
+}
+
+//GetWithIndex returns the value (if any) under the given key along with its modify index. The
+//index is 0 if the key is not present.
+func (hkvs *HashKVStore) GetWithIndex(key string) ([]byte, uint64, error) {
+	hkvs.mu.RLock()
+	defer hkvs.mu.RUnlock()
+	if hkvs.faulty {
+		return nil, 0, errors.New("Faulty store does not get ur key, ok?")
+	}
+	return hkvs.Store[key], hkvs.indexes[key], nil
+}
+
+//CAS stores the value under the given key if the key's modify index matches the given
+//index. An index of 0 stores the value only if the key is not present. The return value
+//indicates whether the value was stored.
+func (hkvs *HashKVStore) CAS(key string, value []byte, index uint64) (bool, error) {
+	err := hkvs.Txn([]*TxnOp{{Verb: TxnCAS, Key: key, Value: value, Index: index}})
+	switch err {
+	case nil:
+		return true, nil
+	case ErrTxnConflict:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+//Txn applies the given operations atomically. If any conditional operation fails
+//ErrTxnConflict is returned and none of the operations are applied.
+func (hkvs *HashKVStore) Txn(ops []*TxnOp) error {
+	if err := validateTxnOps(ops); err != nil {
 		return err
 	}
 
-	defer f.Close()
+	hkvs.mu.Lock()
+	if hkvs.faulty {
+		hkvs.mu.Unlock()
+		return errors.New("Faulty store does not do transactions, ok?")
+	}
 
-	for key, value := range hkvs.Store {
-		line := fmt.Sprintf("%s#%s\n", key, value)
-		_, err := f.Write([]byte(line))
-		if err != nil {
-			return err
+	for _, op := range ops {
+		switch op.Verb {
+		case TxnCAS, TxnDeleteCAS, TxnCheckIndex:
+			if hkvs.indexes[op.Key] != op.Index {
+				hkvs.mu.Unlock()
+				return ErrTxnConflict
+			}
 		}
 	}
 
+	var events []*WatchEvent
+	for _, op := range ops {
+		switch op.Verb {
+		case TxnSet, TxnCAS:
+			hkvs.set(op.Key, op.Value)
+			events = append(events, &WatchEvent{Key: op.Key, Value: op.Value})
+		case TxnDelete, TxnDeleteCAS:
+			if _, present := hkvs.Store[op.Key]; present {
+				hkvs.remove(op.Key)
+				events = append(events, &WatchEvent{Key: op.Key, Deleted: true})
+			}
+		}
+	}
+	hkvs.mu.Unlock()
+
+	hkvs.watchers.notify(events...)
 	return nil
+}
 
+//Watch registers fn to be called each time a key under the given prefix is put or
+//deleted. Notifications are delivered in-process on the goroutine making the change,
+//so fn should not block. The returned function cancels the watch.
+func (hkvs *HashKVStore) Watch(prefix string, fn WatchFunc) (func(), error) {
+	hkvs.mu.RLock()
+	defer hkvs.mu.RUnlock()
+	if hkvs.faulty {
+		return nil, errors.New("No watching for you, ok?")
+	}
+	return hkvs.watchers.add(prefix, fn), nil
 }
 
-//LoadFromFile loads the flushed KVStore representation from file into memory
-func (hkvs *HashKVStore) LoadFromFile() error {
+//DumpToFile writes the content of the kv store to the backing file configured for the store. The
+//content is written to a temporary file in the same directory which is then renamed over the
+//backing file, so a failure part way through a dump leaves the previous content intact.
+func (hkvs *HashKVStore) DumpToFile() error {
 	if hkvs.backingFile == "" {
 		return fmt.Errorf("No path to backing file specified")
 	}
 
-	f, err := os.Open(hkvs.backingFile)
+	hkvs.flushMu.Lock()
+	defer hkvs.flushMu.Unlock()
+
+	hkvs.mu.RLock()
+	content, err := encodeStoreFile(hkvs.Store)
+	hkvs.mu.RUnlock()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	loadedMap := make(map[string][]byte)
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.Split(line, "#")
-		if len(parts) != 2 {
-			log.Info("Line did not split into two parts - skipping: ", line)
-			continue
-		}
-		loadedMap[parts[0]] = []byte(parts[1])
+	return writeFileAtomically(hkvs.backingFile, content)
+}
+
+//LoadFromFile loads the flushed KVStore representation from file into memory. Files
+//written in the original key#value line format are converted to the current format.
+func (hkvs *HashKVStore) LoadFromFile() error {
+	if hkvs.backingFile == "" {
+		return fmt.Errorf("No path to backing file specified")
 	}
-	if err := scanner.Err(); err != nil {
+
+	content, err := ioutil.ReadFile(hkvs.backingFile)
+	if err != nil {
 		return err
 	}
 
+	loadedMap, legacy, err := decodeStoreFile(content)
+	if err != nil {
+		return err
+	}
+
+	hkvs.mu.Lock()
 	hkvs.Store = loadedMap
+	hkvs.indexes = make(map[string]uint64)
+	for k := range loadedMap {
+		hkvs.lastIndex++
+		hkvs.indexes[k] = hkvs.lastIndex
+	}
+	hkvs.mu.Unlock()
+
+	if legacy {
+		log.Info("Converting ", hkvs.backingFile, " from key#value format to version ", storeFileVersion)
+		return hkvs.DumpToFile()
+	}
 
 	return nil
 }
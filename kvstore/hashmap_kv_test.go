@@ -246,3 +246,39 @@ func TestBenignFlushWithNoBackingStore(t *testing.T) {
 	err = kvs.Flush()
 	assert.Nil(t, err)
 }
+
+func TestDelete(t *testing.T) {
+	kvs, err := NewHashKVStore("")
+	assert.Nil(t, err)
+
+	kvs.Put("foos/foo1", []byte("foo1"))
+	kvs.Put("foos/foo2", []byte("foo2"))
+	kvs.Put("bars/bar1", []byte("bar1"))
+
+	t.Log("Delete a single key")
+	err = kvs.Delete("foos/foo1")
+	assert.Nil(t, err)
+	v, err := kvs.Get("foos/foo1")
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+
+	t.Log("Deleting a key that is not present is not an error")
+	err = kvs.Delete("foos/foo1")
+	assert.Nil(t, err)
+
+	t.Log("Delete by prefix")
+	err = kvs.DeletePrefix("foos/")
+	assert.Nil(t, err)
+	kvpairs, err := kvs.List("foos/")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(kvpairs))
+
+	v, err = kvs.Get("bars/bar1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar1"), v)
+
+	kvs.InjectFaults()
+	assert.NotNil(t, kvs.Delete("bars/bar1"))
+	assert.NotNil(t, kvs.DeletePrefix("bars/"))
+	kvs.ClearFaults()
+}
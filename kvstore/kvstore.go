@@ -10,6 +10,12 @@ type KVPair struct {
 type KVStore interface {
 	Put(string, []byte) error
 	Get(string) ([]byte, error)
+	GetWithIndex(string) ([]byte, uint64, error)
+	CAS(string, []byte, uint64) (bool, error)
+	Txn([]*TxnOp) error
 	List(string) ([]*KVPair, error)
+	Delete(string) error
+	DeletePrefix(string) error
+	Watch(string, WatchFunc) (func(), error)
 	Flush() error
 }
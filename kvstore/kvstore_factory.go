@@ -2,24 +2,108 @@ package kvstore
 
 import (
 	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/env"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 )
 
-//NewKVStore instantiates a KV store implementation based on the url scheme associated with the given url
+//KV store URL query parameters for the optional store wrappers
+const (
+	//cacheParam enables caching, e.g. consul://host:8500?cache=true
+	cacheParam = "cache"
+
+	//encryptionKeyFileParam names the file holding the encryption keyring
+	encryptionKeyFileParam = "encryption-key-file"
+
+	//encryptPrefixesParam lists the key prefixes to encrypt values under, comma separated
+	encryptPrefixesParam = "encrypt-prefixes"
+)
+
+//NewKVStore instantiates a KV store implementation based on the url scheme associated with the given url.
+//If the url specifies a namespace the store is wrapped so all keys are prefixed with the namespace, if
+//encryption keys are configured the store is wrapped to encrypt values, and if caching is enabled the
+//store is wrapped with a read-through cache.
 func NewKVStore(envURL string) (KVStore, error) {
 	u, err := url.Parse(envURL)
 	if err != nil {
 		return nil, err
 	}
 
+	var kvs KVStore
 	switch u.Scheme {
 	default:
 		return nil, fmt.Errorf("Unrecognized scheme for KVStore URL: %v", u.Scheme)
 	case "consul":
-		return makeConsulKVStore(u)
+		kvs, err = makeConsulKVStore(u)
 	case "file":
-		return makeHashMapKVStore(u)
+		kvs, err = makeHashMapKVStore(u)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace := namespaceFromURL(u); namespace != "" {
+		log.Info("Using KV store namespace ", namespace)
+		nkvs, err := NewNamespacedKVStore(kvs, namespace)
+		if err != nil {
+			return nil, err
+		}
+		kvs = nkvs
+	}
+
+	keyring, prefixes, err := encryptionSettings(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyring != nil {
+		log.Info("Encrypting KV store values under prefixes ", prefixes, " with key ", keyring.PrimaryKeyID())
+		ekvs, err := NewEncryptingKVStore(kvs, keyring, prefixes...)
+		if err != nil {
+			return nil, err
+		}
+		kvs = ekvs
+	}
+
+	enabled, err := cachingEnabled(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		log.Info("Using KV store cache")
+		ckvs, err := NewCachingKVStore(kvs)
+		if err != nil {
+			return nil, err
+		}
+		kvs = ckvs
 	}
+
+	return kvs, nil
+}
+
+//cachingEnabled returns true if caching is enabled by the cache query parameter of the
+//KV store URL, or by the XAVI_KVSTORE_CACHE environment variable
+func cachingEnabled(u *url.URL) (bool, error) {
+	setting := u.Query().Get(cacheParam)
+	if setting == "" {
+		setting = os.Getenv(env.KVStoreCache)
+	}
+
+	if setting == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(setting)
+	if err != nil {
+		return false, fmt.Errorf("Invalid KV store cache setting '%s'", setting)
+	}
+
+	return enabled, nil
 }
 
 func makeConsulKVStore(u *url.URL) (KVStore, error) {
@@ -37,3 +121,44 @@ func makeHashMapKVStore(u *url.URL) (KVStore, error) {
 	}
 	return KVStore(hkvs), nil
 }
+
+//encryptionSettings returns the keyring and key prefixes to use to encrypt values. The keyring
+//is read from the file named by the encryption-key-file query parameter or the
+//XAVI_ENCRYPTION_KEY_FILE environment variable, or taken from the XAVI_ENCRYPTION_KEYS
+//environment variable. A nil keyring is returned if no keys are configured.
+func encryptionSettings(u *url.URL) (*Keyring, []string, error) {
+	var keyring *Keyring
+	var err error
+
+	keyFile := u.Query().Get(encryptionKeyFileParam)
+	if keyFile == "" {
+		keyFile = os.Getenv(env.EncryptionKeyFile)
+	}
+
+	switch {
+	case keyFile != "":
+		keyring, err = ReadKeyringFile(keyFile)
+	case os.Getenv(env.EncryptionKeys) != "":
+		keyring, err = ParseKeyring(os.Getenv(env.EncryptionKeys))
+	default:
+		return nil, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefixSetting := u.Query().Get(encryptPrefixesParam)
+	if prefixSetting == "" {
+		prefixSetting = os.Getenv(env.EncryptPrefixes)
+	}
+
+	var prefixes []string
+	for _, p := range strings.Split(prefixSetting, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	return keyring, prefixes, nil
+}
@@ -0,0 +1,223 @@
+package kvstore
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+//NamespacedKVStore wraps a KVStore, transparently prefixing all keys with a namespace. This
+//allows multiple gateway environments to share a single underlying store, for example
+//a consul cluster, without their definitions colliding.
+type NamespacedKVStore struct {
+	kvs       KVStore
+	namespace string
+	prefix    string
+}
+
+//NewNamespacedKVStore returns a KVStore that stores keys under the given namespace in
+//the supplied store. Leading and trailing slashes in the namespace are ignored.
+func NewNamespacedKVStore(kvs KVStore, namespace string) (*NamespacedKVStore, error) {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		return nil, fmt.Errorf("Empty namespace specified")
+	}
+
+	for _, segment := range strings.Split(namespace, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return nil, fmt.Errorf("Invalid namespace: %s", namespace)
+		}
+	}
+
+	return &NamespacedKVStore{
+		kvs:       kvs,
+		namespace: namespace,
+		prefix:    namespace + "/",
+	}, nil
+}
+
+//namespaceFromURL returns the namespace specified by the KV store URL. For consul the
+//namespace is taken from the path, e.g. consul://host:8500/teamA/prod. For file stores
+//the path names the backing file, so the namespace is given by the namespace query
+//parameter, e.g. file:///tmp/xavi.json?namespace=teamA/prod
+func namespaceFromURL(u *url.URL) string {
+	switch u.Scheme {
+	case "consul":
+		return strings.Trim(u.Path, "/")
+	default:
+		return strings.Trim(u.Query().Get("namespace"), "/")
+	}
+}
+
+//Namespace returns the namespace the store's keys are prefixed with
+func (nkvs *NamespacedKVStore) Namespace() string {
+	return nkvs.namespace
+}
+
+//Underlying returns the wrapped store
+func (nkvs *NamespacedKVStore) Underlying() KVStore {
+	return nkvs.kvs
+}
+
+func (nkvs *NamespacedKVStore) key(key string) string {
+	return nkvs.prefix + key
+}
+
+func (nkvs *NamespacedKVStore) unprefixed(key string) string {
+	return strings.TrimPrefix(key, nkvs.prefix)
+}
+
+//Put stores a value under the given key in the namespace
+func (nkvs *NamespacedKVStore) Put(key string, value []byte) error {
+	return nkvs.kvs.Put(nkvs.key(key), value)
+}
+
+//Get returns the value (if any) under the given key in the namespace
+func (nkvs *NamespacedKVStore) Get(key string) ([]byte, error) {
+	return nkvs.kvs.Get(nkvs.key(key))
+}
+
+//GetWithIndex returns the value (if any) under the given key in the namespace along with
+//its modify index
+func (nkvs *NamespacedKVStore) GetWithIndex(key string) ([]byte, uint64, error) {
+	return nkvs.kvs.GetWithIndex(nkvs.key(key))
+}
+
+//CAS stores the value under the given key in the namespace if the key's modify index
+//matches the given index
+func (nkvs *NamespacedKVStore) CAS(key string, value []byte, index uint64) (bool, error) {
+	return nkvs.kvs.CAS(nkvs.key(key), value, index)
+}
+
+//Txn applies the given operations atomically to keys in the namespace
+func (nkvs *NamespacedKVStore) Txn(ops []*TxnOp) error {
+	var prefixedOps []*TxnOp
+	for _, op := range ops {
+		prefixedOp := *op
+		prefixedOp.Key = nkvs.key(op.Key)
+		prefixedOps = append(prefixedOps, &prefixedOp)
+	}
+
+	return nkvs.kvs.Txn(prefixedOps)
+}
+
+//List returns the values stored under the given key in the namespace. The keys of the
+//returned pairs are relative to the namespace.
+func (nkvs *NamespacedKVStore) List(key string) ([]*KVPair, error) {
+	pairs, err := nkvs.kvs.List(nkvs.key(key))
+	if err != nil {
+		return nil, err
+	}
+
+	var kvpairs []*KVPair
+	for _, p := range pairs {
+		kvpairs = append(kvpairs, &KVPair{nkvs.unprefixed(p.Key), p.Value})
+	}
+
+	return kvpairs, nil
+}
+
+//Delete removes the given key from the namespace
+func (nkvs *NamespacedKVStore) Delete(key string) error {
+	return nkvs.kvs.Delete(nkvs.key(key))
+}
+
+//DeletePrefix removes all keys under the given prefix from the namespace
+func (nkvs *NamespacedKVStore) DeletePrefix(prefix string) error {
+	return nkvs.kvs.DeletePrefix(nkvs.key(prefix))
+}
+
+//Watch registers fn to be called each time a key under the given prefix in the namespace
+//changes. The keys of the events are relative to the namespace.
+func (nkvs *NamespacedKVStore) Watch(prefix string, fn WatchFunc) (func(), error) {
+	return nkvs.kvs.Watch(nkvs.key(prefix), func(e *WatchEvent) {
+		event := *e
+		event.Key = nkvs.unprefixed(e.Key)
+		fn(&event)
+	})
+}
+
+//Flush flushes the underlying store
+func (nkvs *NamespacedKVStore) Flush() error {
+	return nkvs.kvs.Flush()
+}
+
+//wrappingStore is implemented by stores that decorate another store
+type wrappingStore interface {
+	Underlying() KVStore
+}
+
+//NamespaceOf returns the namespace used by the store, or the empty string if the store
+//is not namespaced
+func NamespaceOf(kvs KVStore) string {
+	for {
+		switch s := kvs.(type) {
+		case *NamespacedKVStore:
+			return s.Namespace()
+		case wrappingStore:
+			kvs = s.Underlying()
+		default:
+			return ""
+		}
+	}
+}
+
+//ListNamespaces returns the namespaces present in the store. Namespaces are identified by
+//the keys that contain one of the given top level prefixes after the namespace, for
+//example teamA/prod/routes/r1 for the routes/ prefix. Keys stored directly under a
+//prefix are reported as the default namespace, represented as the empty string. If kvs
+//wraps another store, for example to namespace or cache it, all namespaces of the
+//underlying store are returned.
+func ListNamespaces(kvs KVStore, prefixes ...string) ([]string, error) {
+	for {
+		wrapper, ok := kvs.(wrappingStore)
+		if !ok {
+			break
+		}
+		kvs = wrapper.Underlying()
+	}
+
+	pairs, err := kvs.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	for _, p := range pairs {
+		if namespace, ok := namespaceForKey(p.Key, prefixes); ok {
+			found[namespace] = true
+		}
+	}
+
+	var namespaces []string
+	for ns := range found {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	log.Debug("Found namespaces ", namespaces)
+	return namespaces, nil
+}
+
+//namespaceForKey returns the namespace of the key, which is the portion of the key
+//before the earliest occurrence of one of the prefixes
+func namespaceForKey(key string, prefixes []string) (string, bool) {
+	namespaceEnd := -1
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return "", true
+		}
+
+		if idx := strings.Index(key, "/"+prefix); idx > 0 && (namespaceEnd == -1 || idx < namespaceEnd) {
+			namespaceEnd = idx
+		}
+	}
+
+	if namespaceEnd == -1 {
+		return "", false
+	}
+
+	return key[:namespaceEnd], true
+}
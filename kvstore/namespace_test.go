@@ -0,0 +1,129 @@
+package kvstore
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNamespacedKVStore(t *testing.T) {
+	hkvs, _ := NewHashKVStore("")
+	kvs, err := NewNamespacedKVStore(hkvs, "/teamA/prod/")
+	assert.Nil(t, err)
+	assert.Equal(t, "teamA/prod", kvs.Namespace())
+
+	assert.Nil(t, kvs.Put("routes/r1", []byte("r1")))
+	v, _ := hkvs.Get("teamA/prod/routes/r1")
+	assert.Equal(t, []byte("r1"), v)
+
+	v, _ = kvs.Get("routes/r1")
+	assert.Equal(t, []byte("r1"), v)
+
+	hkvs.Put("routes/r1", []byte("default"))
+	hkvs.Put("teamB/routes/r1", []byte("other"))
+
+	pairs, err := kvs.List("routes/")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(pairs)) {
+		assert.Equal(t, "routes/r1", pairs[0].Key)
+		assert.Equal(t, []byte("r1"), pairs[0].Value)
+	}
+
+	_, index, _ := kvs.GetWithIndex("routes/r1")
+	ok, err := kvs.CAS("routes/r1", []byte("r1-updated"), index)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	err = kvs.Txn([]*TxnOp{{Verb: TxnSet, Key: "servers/s1", Value: []byte("s1")}})
+	assert.Nil(t, err)
+	v, _ = hkvs.Get("teamA/prod/servers/s1")
+	assert.Equal(t, []byte("s1"), v)
+
+	assert.Nil(t, kvs.Delete("servers/s1"))
+	v, _ = hkvs.Get("teamA/prod/servers/s1")
+	assert.Nil(t, v)
+
+	assert.Nil(t, kvs.DeletePrefix("routes/"))
+	v, _ = hkvs.Get("teamA/prod/routes/r1")
+	assert.Nil(t, v)
+	v, _ = hkvs.Get("routes/r1")
+	assert.Equal(t, []byte("default"), v)
+
+	assert.Nil(t, kvs.Flush())
+}
+
+func TestNamespacedKVStoreWatch(t *testing.T) {
+	hkvs, _ := NewHashKVStore("")
+	kvs, _ := NewNamespacedKVStore(hkvs, "teamA")
+
+	var events []*WatchEvent
+	cancel, err := kvs.Watch("routes/", func(e *WatchEvent) {
+		events = append(events, e)
+	})
+	assert.Nil(t, err)
+	defer cancel()
+
+	hkvs.Put("routes/r1", []byte("default"))
+	kvs.Put("routes/r1", []byte("r1"))
+
+	if assert.Equal(t, 1, len(events)) {
+		assert.Equal(t, "routes/r1", events[0].Key)
+	}
+}
+
+func TestInvalidNamespace(t *testing.T) {
+	hkvs, _ := NewHashKVStore("")
+	for _, ns := range []string{"", "/", "a//b", "a/../b"} {
+		_, err := NewNamespacedKVStore(hkvs, ns)
+		assert.NotNil(t, err, ns)
+	}
+}
+
+func TestListNamespaces(t *testing.T) {
+	hkvs, _ := NewHashKVStore("")
+	hkvs.Put("routes/r1", nil)
+	hkvs.Put("teamA/prod/servers/s1", nil)
+	hkvs.Put("teamA/prod/routes/r1", nil)
+	hkvs.Put("teamB/listeners/l1", nil)
+	hkvs.Put("unrelated/key", nil)
+
+	kvs, _ := NewNamespacedKVStore(hkvs, "teamB")
+
+	namespaces, err := ListNamespaces(kvs, "servers/", "routes/", "listeners/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "teamA/prod", "teamB"}, namespaces)
+
+	hkvs.InjectFaults()
+	_, err = ListNamespaces(hkvs, "servers/")
+	assert.NotNil(t, err)
+}
+
+func TestNamespaceFromFactory(t *testing.T) {
+	f, err := ioutil.TempFile("./", "tst")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	wd, _ := os.Getwd()
+
+	kvs, err := NewKVStore(fmt.Sprintf("file:///%s/%s?namespace=teamA/prod", wd, f.Name()))
+	assert.Nil(t, err)
+	if nkvs, ok := kvs.(*NamespacedKVStore); assert.True(t, ok) {
+		assert.Equal(t, "teamA/prod", nkvs.Namespace())
+	}
+
+	kvs, err = NewKVStore("consul://localhost:1/teamA/prod/")
+	assert.Nil(t, err)
+	if nkvs, ok := kvs.(*NamespacedKVStore); assert.True(t, ok) {
+		assert.Equal(t, "teamA/prod", nkvs.Namespace())
+	}
+
+	kvs, err = NewKVStore("consul://localhost:1/a/../b")
+	assert.NotNil(t, err)
+	assert.Nil(t, kvs)
+
+	kvs, err = NewKVStore("consul://localhost:1")
+	assert.Nil(t, err)
+	_, ok := kvs.(*NamespacedKVStore)
+	assert.False(t, ok)
+}
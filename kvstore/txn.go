@@ -0,0 +1,60 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+//Transaction verbs. The names match the verbs used by the consul transaction API.
+const (
+	//TxnSet unconditionally sets the value of a key
+	TxnSet = "set"
+
+	//TxnCAS sets the value of a key if its modify index matches the index of the
+	//operation. An index of 0 sets the key only if it does not already exist.
+	TxnCAS = "cas"
+
+	//TxnDelete unconditionally deletes a key
+	TxnDelete = "delete"
+
+	//TxnDeleteCAS deletes a key if its modify index matches the index of the operation
+	TxnDeleteCAS = "delete-cas"
+
+	//TxnCheckIndex fails the transaction unless the modify index of the key matches the
+	//index of the operation
+	TxnCheckIndex = "check-index"
+)
+
+//ErrTxnConflict is returned when a conditional operation fails because the key has
+//been modified. No operations in the transaction are applied.
+var ErrTxnConflict = errors.New("Transaction aborted: key modified since it was read")
+
+//TxnTooLargeError is returned when a transaction has more operations than the KV store
+//accepts in a single transaction. None of the operations are applied.
+type TxnTooLargeError struct {
+	Ops   int
+	Limit int
+}
+
+func (e *TxnTooLargeError) Error() string {
+	return fmt.Sprintf("Transaction of %d operations exceeds the limit of %d operations", e.Ops, e.Limit)
+}
+
+//TxnOp is a single operation in a transaction
+type TxnOp struct {
+	Verb  string
+	Key   string
+	Value []byte
+	Index uint64
+}
+
+func validateTxnOps(ops []*TxnOp) error {
+	for _, op := range ops {
+		switch op.Verb {
+		case TxnSet, TxnCAS, TxnDelete, TxnDeleteCAS, TxnCheckIndex:
+		default:
+			return fmt.Errorf("Unknown transaction verb '%s' for key %s", op.Verb, op.Key)
+		}
+	}
+	return nil
+}
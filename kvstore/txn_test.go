@@ -0,0 +1,152 @@
+package kvstore
+
+import (
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHashKVStoreGetWithIndex(t *testing.T) {
+	kvs, _ := NewHashKVStore("")
+
+	v, index, err := kvs.GetWithIndex("a")
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+	assert.Equal(t, uint64(0), index)
+
+	kvs.Put("a", []byte("a1"))
+	_, index1, _ := kvs.GetWithIndex("a")
+	assert.NotEqual(t, uint64(0), index1)
+
+	kvs.Put("a", []byte("a2"))
+	v, index2, _ := kvs.GetWithIndex("a")
+	assert.Equal(t, []byte("a2"), v)
+	assert.True(t, index2 > index1)
+}
+
+func TestHashKVStoreCAS(t *testing.T) {
+	kvs, _ := NewHashKVStore("")
+
+	t.Log("Index 0 only stores when the key is absent")
+	ok, err := kvs.CAS("a", []byte("a1"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = kvs.CAS("a", []byte("a2"), 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, index, _ := kvs.GetWithIndex("a")
+
+	t.Log("Someone else updates the key")
+	kvs.Put("a", []byte("other"))
+
+	ok, err = kvs.CAS("a", []byte("mine"), index)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	v, index, _ := kvs.GetWithIndex("a")
+	assert.Equal(t, []byte("other"), v)
+
+	ok, err = kvs.CAS("a", []byte("mine"), index)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestHashKVStoreTxn(t *testing.T) {
+	kvs, _ := NewHashKVStore("")
+	kvs.Put("c", []byte("c"))
+	_, cIndex, _ := kvs.GetWithIndex("c")
+
+	err := kvs.Txn([]*TxnOp{
+		{Verb: TxnSet, Key: "a", Value: []byte("a")},
+		{Verb: TxnCAS, Key: "b", Value: []byte("b"), Index: 0},
+		{Verb: TxnDeleteCAS, Key: "c", Index: cIndex},
+	})
+	assert.Nil(t, err)
+
+	v, _ := kvs.Get("a")
+	assert.Equal(t, []byte("a"), v)
+	v, _ = kvs.Get("b")
+	assert.Equal(t, []byte("b"), v)
+	v, _ = kvs.Get("c")
+	assert.Nil(t, v)
+
+	t.Log("A failed check means nothing is applied")
+	_, aIndex, _ := kvs.GetWithIndex("a")
+	err = kvs.Txn([]*TxnOp{
+		{Verb: TxnSet, Key: "d", Value: []byte("d")},
+		{Verb: TxnDelete, Key: "a"},
+		{Verb: TxnCheckIndex, Key: "b", Index: aIndex + 100},
+	})
+	assert.Equal(t, ErrTxnConflict, err)
+
+	v, _ = kvs.Get("d")
+	assert.Nil(t, v)
+	v, _ = kvs.Get("a")
+	assert.NotNil(t, v)
+}
+
+func TestHashKVStoreTxnErrors(t *testing.T) {
+	kvs, _ := NewHashKVStore("")
+
+	err := kvs.Txn([]*TxnOp{{Verb: "frobnicate", Key: "a"}})
+	assert.NotNil(t, err)
+
+	kvs.InjectFaults()
+	err = kvs.Txn([]*TxnOp{{Verb: TxnSet, Key: "a"}})
+	assert.NotNil(t, err)
+	_, err = kvs.CAS("a", nil, 0)
+	assert.NotNil(t, err)
+	_, _, err = kvs.GetWithIndex("a")
+	assert.NotNil(t, err)
+}
+
+func TestConsulTxnNoClient(t *testing.T) {
+	consulKV := &ConsulKVStore{}
+	err := consulKV.Txn([]*TxnOp{{Verb: TxnSet, Key: "a"}})
+	assert.Equal(t, errNoConsulClient, err)
+}
+
+func TestConsulTxnTooLarge(t *testing.T) {
+	client, _ := consulapi.NewClient(consulapi.DefaultConfig())
+	consulKV := &ConsulKVStore{KV: client.KV(), Client: client}
+
+	var ops []*TxnOp
+	for i := 0; i <= maxConsulTxnOps; i++ {
+		ops = append(ops, &TxnOp{Verb: TxnSet, Key: "a"})
+	}
+
+	err := consulKV.Txn(ops)
+	assert.Equal(t, &TxnTooLargeError{Ops: 65, Limit: 64}, err)
+}
+
+func TestConsulCASAndTxn(t *testing.T) {
+	client, server := makeClient(t)
+	defer server.Stop()
+	consulKV := &ConsulKVStore{
+		KV:     client.KV(),
+		Client: client,
+	}
+
+	ok, err := consulKV.CAS("t/a", []byte("a"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, index, err := consulKV.GetWithIndex("t/a")
+	assert.Nil(t, err)
+
+	err = consulKV.Txn([]*TxnOp{
+		{Verb: TxnCAS, Key: "t/a", Value: []byte("a2"), Index: index},
+		{Verb: TxnSet, Key: "t/b", Value: []byte("b")},
+	})
+	assert.Nil(t, err)
+
+	err = consulKV.Txn([]*TxnOp{
+		{Verb: TxnCAS, Key: "t/a", Value: []byte("a3"), Index: index},
+	})
+	assert.Equal(t, ErrTxnConflict, err)
+
+	v, _ := consulKV.Get("t/a")
+	assert.Equal(t, []byte("a2"), v)
+}
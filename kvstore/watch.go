@@ -0,0 +1,66 @@
+package kvstore
+
+import (
+	"strings"
+	"sync"
+)
+
+//WatchEvent describes a change to a key under a watched prefix. Value holds the
+//new value of the key, and is nil if the key was deleted.
+type WatchEvent struct {
+	Key     string
+	Value   []byte
+	Deleted bool
+}
+
+//WatchFunc is the callback invoked for each change to a key under a watched prefix
+type WatchFunc func(*WatchEvent)
+
+//watcherRegistry keeps track of the in-process watches registered against a store, and
+//dispatches change notifications to the watches whose prefix matches the changed key.
+type watcherRegistry struct {
+	mu       sync.RWMutex
+	nextID   int
+	watchers map[int]*watcher
+}
+
+type watcher struct {
+	prefix string
+	fn     WatchFunc
+}
+
+func (wr *watcherRegistry) add(prefix string, fn WatchFunc) func() {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if wr.watchers == nil {
+		wr.watchers = make(map[int]*watcher)
+	}
+
+	id := wr.nextID
+	wr.nextID++
+	wr.watchers[id] = &watcher{prefix: prefix, fn: fn}
+
+	return func() {
+		wr.mu.Lock()
+		delete(wr.watchers, id)
+		wr.mu.Unlock()
+	}
+}
+
+func (wr *watcherRegistry) notify(events ...*WatchEvent) {
+	wr.mu.RLock()
+	var watchers []*watcher
+	for _, w := range wr.watchers {
+		watchers = append(watchers, w)
+	}
+	wr.mu.RUnlock()
+
+	for _, e := range events {
+		for _, w := range watchers {
+			if strings.HasPrefix(e.Key, w.prefix) {
+				w.fn(e)
+			}
+		}
+	}
+}
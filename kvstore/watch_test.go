@@ -0,0 +1,96 @@
+package kvstore
+
+import (
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHashKVStoreWatch(t *testing.T) {
+	kvs, _ := NewHashKVStore("")
+
+	var events []*WatchEvent
+	stop, err := kvs.Watch("routes/", func(e *WatchEvent) {
+		events = append(events, e)
+	})
+	assert.Nil(t, err)
+
+	kvs.Put("routes/r1", []byte("r1"))
+	kvs.Put("servers/s1", []byte("s1"))
+	kvs.Delete("routes/r1")
+	kvs.Delete("routes/not-there")
+
+	if assert.Equal(t, 2, len(events)) {
+		assert.Equal(t, "routes/r1", events[0].Key)
+		assert.Equal(t, []byte("r1"), events[0].Value)
+		assert.False(t, events[0].Deleted)
+		assert.Equal(t, "routes/r1", events[1].Key)
+		assert.True(t, events[1].Deleted)
+	}
+
+	kvs.Put("routes/r2", []byte("r2"))
+	kvs.Put("routes/r3", []byte("r3"))
+	kvs.DeletePrefix("routes/")
+	assert.Equal(t, 6, len(events))
+
+	stop()
+	kvs.Put("routes/r4", []byte("r4"))
+	assert.Equal(t, 6, len(events))
+}
+
+func TestHashKVStoreWatchFaulty(t *testing.T) {
+	kvs, _ := NewHashKVStore("")
+	kvs.InjectFaults()
+	_, err := kvs.Watch("routes/", func(e *WatchEvent) {})
+	assert.NotNil(t, err)
+}
+
+func TestDiffPairs(t *testing.T) {
+	previous := indexPairs(consulapi.KVPairs{
+		&consulapi.KVPair{Key: "a", Value: []byte("a"), ModifyIndex: 1},
+		&consulapi.KVPair{Key: "b", Value: []byte("b"), ModifyIndex: 2},
+		&consulapi.KVPair{Key: "c", Value: []byte("c"), ModifyIndex: 3},
+	})
+
+	current := indexPairs(consulapi.KVPairs{
+		&consulapi.KVPair{Key: "a", Value: []byte("a"), ModifyIndex: 1},
+		&consulapi.KVPair{Key: "b", Value: []byte("b2"), ModifyIndex: 4},
+		&consulapi.KVPair{Key: "d", Value: []byte("d"), ModifyIndex: 5},
+	})
+
+	events := make(map[string]*WatchEvent)
+	for _, e := range diffPairs(previous, current) {
+		events[e.Key] = e
+	}
+
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, []byte("b2"), events["b"].Value)
+	assert.Equal(t, []byte("d"), events["d"].Value)
+	assert.True(t, events["c"].Deleted)
+}
+
+func TestConsulWatch(t *testing.T) {
+	client, server := makeClient(t)
+	defer server.Stop()
+	consulKV := &ConsulKVStore{
+		KV: client.KV(),
+	}
+
+	eventChan := make(chan *WatchEvent, 10)
+	stop, err := consulKV.Watch("w/", func(e *WatchEvent) {
+		eventChan <- e
+	})
+	assert.Nil(t, err)
+	defer stop()
+
+	consulKV.Put("w/a", []byte("a"))
+
+	select {
+	case e := <-eventChan:
+		assert.Equal(t, "w/a", e.Key)
+		assert.Equal(t, []byte("a"), e.Value)
+	case <-time.After(5 * time.Second):
+		t.Fail()
+	}
+}
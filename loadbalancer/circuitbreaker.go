@@ -0,0 +1,300 @@
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/xtracdev/xavi/config"
+)
+
+//Circuit breaker defaults, used when the backend definition leaves a setting at zero
+const (
+	DefaultCircuitBreakerOpenTime      = 10000 //In milliseconds
+	DefaultCircuitBreakerTrialRequests = 1
+)
+
+//Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+//Outcome is the outcome of a request, reported to the circuit breaker the request was reserved on
+type Outcome int
+
+//Request outcomes. A request cancelled by the client says nothing about the endpoint, so a cancelled
+//trial request only gives its trial back.
+const (
+	Succeeded Outcome = iota
+	Failed
+	Cancelled
+)
+
+//ErrCircuitOpen is returned when an endpoint's circuit breaker does not let a request through
+var ErrCircuitOpen = errors.New("Circuit breaker open - request not let through")
+
+//circuitBreaker holds the circuit breaker state of an endpoint. It is guarded by the endpoint lock.
+type circuitBreaker struct {
+	backend          string
+	failureThreshold int
+	openTime         time.Duration
+	trialRequests    int
+
+	state     string
+	failures  int
+	openedAt  time.Time
+	opened    int //Number of times the circuit has opened, so late outcomes of old trials can be told apart
+	trials    int //Trial requests in flight
+	successes int //Successful trial requests since the circuit went half-open
+}
+
+//stateAt returns the state of the circuit at the given time. An open circuit is half-open once its open
+//time has passed, even before the first trial request moves it there.
+func (cb *circuitBreaker) stateAt(now time.Time) string {
+	if cb.state == CircuitOpen && !now.Before(cb.openedAt.Add(cb.openTime)) {
+		return CircuitHalfOpen
+	}
+
+	return cb.state
+}
+
+//allows returns true if the circuit lets a request through at the given time. A nil circuit breaker
+//allows every request.
+func (cb *circuitBreaker) allows(now time.Time) bool {
+	if cb == nil {
+		return true
+	}
+
+	switch cb.stateAt(now) {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return cb.trials < cb.trialRequests
+	default:
+		return true
+	}
+}
+
+func (cb *circuitBreaker) setState(address, state string, now time.Time) {
+	log.Infof("Circuit breaker for %s in backend %s is %s", address, cb.backend, state)
+	cb.state = state
+	cb.trials, cb.successes = 0, 0
+
+	var gauge float32
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = now
+		cb.opened++
+		gauge = 2.0
+		metrics.IncrCounter([]string{"circuit-breaker-open", cb.backend}, 1.0)
+	case CircuitHalfOpen:
+		gauge = 1.0
+	case CircuitClosed:
+		cb.failures = 0
+	}
+
+	metrics.SetGauge([]string{"circuit-breaker", cb.backend, address}, gauge)
+}
+
+//CircuitBreakers trip a circuit breaker for each endpoint of a backend after a run of failed requests,
+//so requests skip the endpoint at once instead of waiting for it to fail. Once the open time has passed the
+//circuit is half-open, letting through a limited number of trial requests. It closes when that many trial
+//requests succeed, and opens again if any of them fail.
+type CircuitBreakers struct {
+	backend   string
+	endpoints []*LoadBalancerEndpoint
+}
+
+//NewCircuitBreakers attaches circuit breakers to the endpoints of the load balancer of the backend, returning
+//nil if the backend definition does not enable circuit breaking or the load balancer does not list its endpoints
+func NewCircuitBreakers(lb LoadBalancer, backendConfig *config.BackendConfig) *CircuitBreakers {
+	if backendConfig.CircuitBreakerFailures <= 0 {
+		return nil
+	}
+
+	lister, ok := lb.(EndpointLister)
+	if !ok {
+		log.Warnf("Circuit breaking is not supported by the load balancer of backend %s", backendConfig.Name)
+		return nil
+	}
+
+	openTime := time.Duration(backendConfig.CircuitBreakerOpenTime) * time.Millisecond
+	if openTime <= 0 {
+		openTime = DefaultCircuitBreakerOpenTime * time.Millisecond
+	}
+
+	trialRequests := backendConfig.CircuitBreakerTrialRequests
+	if trialRequests <= 0 {
+		trialRequests = DefaultCircuitBreakerTrialRequests
+	}
+
+	cbs := &CircuitBreakers{backend: backendConfig.Name, endpoints: lister.Endpoints()}
+	for _, e := range cbs.endpoints {
+		e.mu.Lock()
+		e.breaker = &circuitBreaker{
+			backend:          backendConfig.Name,
+			failureThreshold: backendConfig.CircuitBreakerFailures,
+			openTime:         openTime,
+			trialRequests:    trialRequests,
+			state:            CircuitClosed,
+		}
+		e.mu.Unlock()
+	}
+
+	return cbs
+}
+
+func (cbs *CircuitBreakers) endpoint(connectAddress string) *LoadBalancerEndpoint {
+	for _, e := range cbs.endpoints {
+		if e.Address == connectAddress {
+			return e
+		}
+	}
+
+	return nil
+}
+
+//RequestStarted notes the start of a request to the connect address, counting it as a trial request if the
+//circuit is half-open and has a trial request left. It returns the function to call with the outcome of the
+//request. The outcomes of requests the circuit does not let through are ignored. RequestStarted may be
+//called on nil circuit breakers, which ignore outcomes.
+func (cbs *CircuitBreakers) RequestStarted(connectAddress string) func(outcome Outcome) {
+	reportOutcome, err := cbs.Reserve(connectAddress)
+	if err != nil {
+		return func(Outcome) {}
+	}
+
+	return reportOutcome
+}
+
+//Reserve starts a request to the connect address if its circuit lets the request through, taking one of the
+//trial requests of a half-open circuit. The check and the reservation are made under the endpoint lock, so
+//concurrent requests cannot take more trial requests than the circuit allows. It returns the function to
+//call with the outcome of the request, or ErrCircuitOpen if the circuit is open or has no trial requests
+//left. Reserve may be called on nil circuit breakers, which let every request through.
+func (cbs *CircuitBreakers) Reserve(connectAddress string) (func(outcome Outcome), error) {
+	if cbs == nil {
+		return func(Outcome) {}, nil
+	}
+
+	e := cbs.endpoint(connectAddress)
+	if e == nil {
+		return func(Outcome) {}, nil
+	}
+
+	e.mu.Lock()
+	cb := e.breaker
+	now := time.Now()
+	if !cb.allows(now) {
+		e.mu.Unlock()
+		return nil, ErrCircuitOpen
+	}
+
+	trial := cb.stateAt(now) == CircuitHalfOpen
+	if trial {
+		if cb.state == CircuitOpen {
+			cb.setState(e.Address, CircuitHalfOpen, now)
+		}
+		cb.trials++
+	}
+	opened := cb.opened
+	e.mu.Unlock()
+
+	return func(outcome Outcome) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		switch {
+		case trial:
+			if cb.state != CircuitHalfOpen || cb.opened != opened {
+				return
+			}
+
+			cb.trials--
+			switch outcome {
+			case Cancelled:
+				return
+			case Failed:
+				cb.setState(e.Address, CircuitOpen, time.Now())
+				return
+			}
+
+			cb.successes++
+			if cb.successes >= cb.trialRequests {
+				cb.setState(e.Address, CircuitClosed, time.Now())
+			}
+		case cb.state == CircuitClosed:
+			switch outcome {
+			case Cancelled:
+				return
+			case Succeeded:
+				cb.failures = 0
+				return
+			}
+
+			cb.failures++
+			if cb.failures >= cb.failureThreshold {
+				cb.setState(e.Address, CircuitOpen, time.Now())
+			}
+		}
+	}, nil
+}
+
+//ConnectAddress picks the endpoint for a request from the load balancer, skipping the excluded endpoints,
+//and reserves the request on the endpoint's circuit breaker. Load balancers only pick endpoints whose
+//circuits let requests through, but concurrent requests may take the last trial request of a half-open
+//circuit between the pick and the reservation, so an endpoint that cannot be reserved is skipped in favour
+//of another. ConnectAddress may be called on nil circuit breakers.
+func (cbs *CircuitBreakers) ConnectAddress(lb LoadBalancer, attributes *RequestAttributes, excluded map[string]bool) (string, func(outcome Outcome), error) {
+	skipped := make(map[string]bool, len(excluded))
+	for address := range excluded {
+		skipped[address] = true
+	}
+
+	for {
+		address, err := GetConnectAddressExcluding(lb, attributes, skipped)
+		if err != nil {
+			return "", nil, err
+		}
+
+		reportOutcome, err := cbs.Reserve(address)
+		if err == nil {
+			return address, reportOutcome, nil
+		}
+
+		if skipped[address] {
+			return "", nil, err
+		}
+		skipped[address] = true
+	}
+}
+
+//States describes the endpoints whose circuits are not closed, e.g. localhost:3000 state=open retry-in=8s
+//or localhost:3000 state=half-open trials=1 successes=0
+func (cbs *CircuitBreakers) States() []string {
+	if cbs == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var states []string
+	for _, e := range cbs.endpoints {
+		e.mu.RLock()
+		cb := e.breaker
+		switch cb.stateAt(now) {
+		case CircuitOpen:
+			retryIn := cb.openedAt.Add(cb.openTime).Sub(now).Round(time.Second)
+			states = append(states, fmt.Sprintf("%s state=%s retry-in=%s", e.Address, CircuitOpen, retryIn))
+		case CircuitHalfOpen:
+			states = append(states, fmt.Sprintf("%s state=%s trials=%d successes=%d", e.Address,
+				CircuitHalfOpen, cb.trials, cb.successes))
+		}
+		e.mu.RUnlock()
+	}
+
+	return states
+}
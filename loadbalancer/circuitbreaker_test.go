@@ -0,0 +1,172 @@
+package loadbalancer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"sync"
+	"testing"
+)
+
+func makeTestCircuitBreakers(t *testing.T, backendConfig *config.BackendConfig) (LoadBalancer, *CircuitBreakers) {
+	lb, err := new(RoundRobinLoadBalancerFactory).NewLoadBalancer("backend", "", makeTestHashServers())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	backendConfig.Name = "backend"
+	cbs := NewCircuitBreakers(lb, backendConfig)
+	if !assert.NotNil(t, cbs) {
+		t.FailNow()
+	}
+
+	return lb, cbs
+}
+
+//expireOpenTime moves the time the endpoint's circuit opened back by its open time
+func expireOpenTime(e *LoadBalancerEndpoint) {
+	e.mu.Lock()
+	e.breaker.openedAt = e.breaker.openedAt.Add(-e.breaker.openTime)
+	e.mu.Unlock()
+}
+
+func TestCircuitBreakersDisabled(t *testing.T) {
+	lb, _ := new(RoundRobinLoadBalancerFactory).NewLoadBalancer("backend", "", makeTestHashServers())
+	cbs := NewCircuitBreakers(lb, &config.BackendConfig{Name: "backend"})
+	assert.Nil(t, cbs)
+
+	cbs.RequestStarted("a.domain.com:11000")(Failed)
+	assert.Nil(t, cbs.States())
+}
+
+func TestCircuitOpensAfterFailures(t *testing.T) {
+	lb, cbs := makeTestCircuitBreakers(t, &config.BackendConfig{CircuitBreakerFailures: 3})
+
+	cbs.RequestStarted("a.domain.com:11000")(Failed)
+	cbs.RequestStarted("a.domain.com:11000")(Failed)
+	cbs.RequestStarted("a.domain.com:11000")(Succeeded)
+	cbs.RequestStarted("a.domain.com:11000")(Failed)
+	cbs.RequestStarted("a.domain.com:11000")(Failed)
+	assert.Nil(t, cbs.States(), "A success resets the run of failures")
+
+	cbs.RequestStarted("a.domain.com:11000")(Failed)
+	assert.Equal(t, []string{"a.domain.com:11000 state=open retry-in=10s"}, cbs.States())
+
+	for i := 0; i < 8; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		assert.NotEqual(t, "a.domain.com:11000", address)
+	}
+
+	cbs.RequestStarted("nope.domain.com:11000")(Failed)
+	assert.Equal(t, 1, len(cbs.States()))
+}
+
+func TestCircuitHalfOpenTrials(t *testing.T) {
+	lb, cbs := makeTestCircuitBreakers(t, &config.BackendConfig{CircuitBreakerFailures: 1, CircuitBreakerTrialRequests: 2})
+	e := lb.(EndpointLister).Endpoints()[0]
+
+	cbs.RequestStarted(e.Address)(Failed)
+	assert.False(t, e.Available())
+
+	//Once the open time passes a limited number of trial requests are let through
+	expireOpenTime(e)
+	assert.True(t, e.Available())
+	assert.Equal(t, []string{e.Address + " state=half-open trials=0 successes=0"}, cbs.States())
+
+	first := cbs.RequestStarted(e.Address)
+	second := cbs.RequestStarted(e.Address)
+	assert.False(t, e.Available(), "No more trials are let through while the trials are in flight")
+	assert.Equal(t, []string{e.Address + " state=half-open trials=2 successes=0"}, cbs.States())
+
+	first(Succeeded)
+	assert.Equal(t, []string{e.Address + " state=half-open trials=1 successes=1"}, cbs.States())
+	second(Succeeded)
+	assert.Nil(t, cbs.States())
+	assert.True(t, e.Available())
+}
+
+func TestCancelledTrialKeepsCircuitHalfOpen(t *testing.T) {
+	lb, cbs := makeTestCircuitBreakers(t, &config.BackendConfig{CircuitBreakerFailures: 1})
+	e := lb.(EndpointLister).Endpoints()[0]
+
+	cbs.RequestStarted(e.Address)(Failed)
+	expireOpenTime(e)
+
+	//A cancelled trial neither closes nor reopens the circuit, but lets another trial through
+	trial := cbs.RequestStarted(e.Address)
+	assert.False(t, e.Available())
+	trial(Cancelled)
+	assert.Equal(t, []string{e.Address + " state=half-open trials=0 successes=0"}, cbs.States())
+	assert.True(t, e.Available())
+
+	cbs.RequestStarted(e.Address)(Succeeded)
+	assert.Nil(t, cbs.States())
+}
+
+func TestCircuitReopensOnFailedTrial(t *testing.T) {
+	lb, cbs := makeTestCircuitBreakers(t, &config.BackendConfig{CircuitBreakerFailures: 1, CircuitBreakerOpenTime: 5000,
+		CircuitBreakerTrialRequests: 2})
+	e := lb.(EndpointLister).Endpoints()[1]
+
+	//Outcomes of requests started before the circuit opened are ignored
+	late := cbs.RequestStarted(e.Address)
+	cbs.RequestStarted(e.Address)(Failed)
+	late(Succeeded)
+	assert.Equal(t, []string{e.Address + " state=open retry-in=5s"}, cbs.States())
+
+	expireOpenTime(e)
+	first := cbs.RequestStarted(e.Address)
+	second := cbs.RequestStarted(e.Address)
+	first(Failed)
+	assert.Equal(t, []string{e.Address + " state=open retry-in=5s"}, cbs.States())
+	assert.False(t, e.Available())
+
+	second(Succeeded)
+	assert.Equal(t, []string{e.Address + " state=open retry-in=5s"}, cbs.States(), "Trials from before the circuit reopened are ignored")
+}
+
+func TestCircuitHalfOpenTrialsReservedConcurrently(t *testing.T) {
+	lb, cbs := makeTestCircuitBreakers(t, &config.BackendConfig{CircuitBreakerFailures: 1})
+	endpoints := lb.(EndpointLister).Endpoints()
+	e := endpoints[0]
+	cbs.RequestStarted(e.Address)(Failed)
+	expireOpenTime(e)
+
+	//Leave the recovering endpoint as the only one up, so every request picks it
+	for _, other := range endpoints[1:] {
+		lb.MarkEndpointDown(other.Address)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reserved int
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			address, _, err := cbs.ConnectAddress(lb, nil, nil)
+			if err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+				assert.Equal(t, e.Address, address)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, reserved, "Only one trial request is let through")
+	assert.Equal(t, []string{e.Address + " state=half-open trials=1 successes=0"}, cbs.States())
+
+	//Requests that find no trial left go to another endpoint when there is one
+	lb.MarkEndpointUp(endpoints[1].Address)
+	address, _, err := cbs.ConnectAddress(lb, nil, map[string]bool{})
+	assert.Nil(t, err)
+	assert.Equal(t, endpoints[1].Address, address)
+
+	_, err = cbs.Reserve(e.Address)
+	assert.Equal(t, ErrCircuitOpen, err)
+	cbs.RequestStarted(e.Address)(Succeeded)
+	assert.Equal(t, []string{e.Address + " state=half-open trials=1 successes=0"}, cbs.States(),
+		"Requests started without a trial left are not counted")
+}
@@ -0,0 +1,263 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/config"
+)
+
+//Sources of the key a consistent hash load balancer hashes requests by
+const (
+	HashKeyHeader = "header"
+	HashKeyCookie = "cookie"
+	HashKeyIP     = "ip"
+)
+
+//ringReplicas is the number of points each unit of server weight has on the hash ring. More points
+//spread requests more evenly between servers, and spread the requests of a server that goes down
+//more evenly among the remaining servers.
+const ringReplicas = 160
+
+type hashKey struct {
+	source string
+	name   string
+}
+
+//parseHashKey parses a hash key of the form header:<name>, cookie:<name> or ip. An empty hash key
+//hashes by client IP.
+func parseHashKey(key string) (hashKey, error) {
+	if key == "" || key == HashKeyIP {
+		return hashKey{source: HashKeyIP}, nil
+	}
+
+	parts := strings.SplitN(key, ":", 2)
+	if len(parts) == 2 && parts[1] != "" && (parts[0] == HashKeyHeader || parts[0] == HashKeyCookie) {
+		return hashKey{source: parts[0], name: parts[1]}, nil
+	}
+
+	return hashKey{}, fmt.Errorf("Invalid hash key '%s' - expected header:<name>, cookie:<name> or ip", key)
+}
+
+//ValidateHashKey returns an error if the hash key is not of the form header:<name>, cookie:<name> or ip
+func ValidateHashKey(key string) error {
+	_, err := parseHashKey(key)
+	return err
+}
+
+//value returns the key of the request, falling back to the client IP when the request has no
+//value for a header or cookie key
+func (hk hashKey) value(attributes *RequestAttributes) string {
+	var value string
+	switch hk.source {
+	case HashKeyHeader:
+		value = attributes.Header.Get(hk.name)
+	case HashKeyCookie:
+		value = attributes.Cookie(hk.name)
+	}
+
+	if value == "" {
+		value = attributes.ClientIP
+	}
+
+	return value
+}
+
+type ringPoint struct {
+	hash     uint64
+	endpoint *LoadBalancerEndpoint
+}
+
+//ConsistentHashLoadBalancer sends requests with the same key to the same server using a hash ring.
+//When a server goes down only the keys it served move to other servers, and they move back when it
+//comes up again. When configured with an affinity cookie, clients are given a cookie naming the server
+//that served them, and requests carrying the cookie go to that server while it is up.
+type ConsistentHashLoadBalancer struct {
+	backend        string
+	key            hashKey
+	affinityCookie string
+	endpoints      []*LoadBalancerEndpoint
+	ring           []ringPoint
+	cookieValues   map[string]*LoadBalancerEndpoint
+	keyless        uint64
+}
+
+//ConsistentHashLoadBalancerFactory is the method receiver for the consistent hash load balancer factory methods
+type ConsistentHashLoadBalancerFactory struct{}
+
+//NewLoadBalancer creates a consistent hash load balancer that hashes requests by client IP
+func (cf *ConsistentHashLoadBalancerFactory) NewLoadBalancer(backendName, caCertPath string, servers []config.ServerConfig) (LoadBalancer, error) {
+	return cf.NewLoadBalancerForBackend(&config.BackendConfig{Name: backendName, CACertPath: caCertPath}, servers)
+}
+
+//NewLoadBalancerForBackend creates a consistent hash load balancer using the HashKey and AffinityCookie
+//settings of the backend definition. Servers have points on the ring in proportion to their weights.
+func (cf *ConsistentHashLoadBalancerFactory) NewLoadBalancerForBackend(backendConfig *config.BackendConfig, servers []config.ServerConfig) (LoadBalancer, error) {
+	if backendConfig.Name == "" {
+		return nil, fmt.Errorf("Expected non-empty backend name")
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("Expected at least one server in servers argument")
+	}
+
+	key, err := parseHashKey(backendConfig.HashKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := &ConsistentHashLoadBalancer{
+		backend:        backendConfig.Name,
+		key:            key,
+		affinityCookie: backendConfig.AffinityCookie,
+		cookieValues:   make(map[string]*LoadBalancerEndpoint),
+	}
+
+	for _, s := range servers {
+		if s.Weight < 0 {
+			return nil, fmt.Errorf("Server %s has a negative weight", s.Name)
+		}
+
+		lbEndpoint := newLoadBalancerEndpoint(s, backendConfig)
+		log.Debug("Adding server with address ", lbEndpoint.Address)
+		ch.endpoints = append(ch.endpoints, lbEndpoint)
+		ch.cookieValues[affinityCookieValue(lbEndpoint.Address)] = lbEndpoint
+
+		weight := s.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		for i := 0; i < ringReplicas*weight; i++ {
+			ch.ring = append(ch.ring, ringPoint{hash: hashOf(lbEndpoint.Address + "#" + strconv.Itoa(i)), endpoint: lbEndpoint})
+		}
+	}
+
+	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i].hash < ch.ring[j].hash })
+
+	return ch, nil
+}
+
+//hashOf returns a 64 bit hash of the key. FNV-1a is finalized with the SplitMix64 mixer, as FNV alone
+//leaves keys differing only in their last characters close together on the ring.
+func hashOf(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+//affinityCookieValue identifies an endpoint in an affinity cookie without revealing its address
+func affinityCookieValue(address string) string {
+	return strconv.FormatUint(hashOf(address), 36)
+}
+
+//lookup returns the address of the first healthy endpoint at or after the key's point on the ring
+func (ch *ConsistentHashLoadBalancer) lookup(key string) (string, error) {
+	h := hashOf(key)
+	start := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i].hash >= h })
+	for i := 0; i < len(ch.ring); i++ {
+		e := ch.ring[(start+i)%len(ch.ring)].endpoint
+		if e.Available() {
+			return e.Address, nil
+		}
+	}
+
+	return "", fmt.Errorf("All servers in backend %s are marked down", ch.backend)
+}
+
+//GetConnectAddressForRequest returns the address of the server for the request's affinity cookie if the
+//server is up, otherwise the address of the server for the request's hash key
+func (ch *ConsistentHashLoadBalancer) GetConnectAddressForRequest(attributes *RequestAttributes) (string, error) {
+	if ch.affinityCookie != "" {
+		if e, ok := ch.cookieValues[attributes.Cookie(ch.affinityCookie)]; ok && e.Available() {
+			return e.Address, nil
+		}
+	}
+
+	return ch.lookup(ch.key.value(attributes))
+}
+
+//GetConnectAddress is used when no request is available, spreading calls around the ring
+func (ch *ConsistentHashLoadBalancer) GetConnectAddress() (string, error) {
+	return ch.lookup(strconv.FormatUint(atomic.AddUint64(&ch.keyless, 1), 10))
+}
+
+//AffinityCookie returns the affinity cookie for the connect address if the backend issues affinity
+//cookies and the request does not already have it
+func (ch *ConsistentHashLoadBalancer) AffinityCookie(attributes *RequestAttributes, connectAddress string) *http.Cookie {
+	if ch.affinityCookie == "" {
+		return nil
+	}
+
+	value := affinityCookieValue(connectAddress)
+	if attributes.Cookie(ch.affinityCookie) == value {
+		return nil
+	}
+
+	return &http.Cookie{Name: ch.affinityCookie, Value: value, Path: "/", HttpOnly: true}
+}
+
+//MarkEndpointUp marks the endpoint in the load balancer pool associated with the
+//connect address as up.
+func (ch *ConsistentHashLoadBalancer) MarkEndpointUp(connectAddress string) error {
+	log.Infof("mark %s up", connectAddress)
+	return ch.changeEndpointStatus(connectAddress, true)
+}
+
+//MarkEndpointDown marks the endpoint in the load balancer pool associated with the
+//connect address as down.
+func (ch *ConsistentHashLoadBalancer) MarkEndpointDown(connectAddress string) error {
+	log.Infof("mark %s down", connectAddress)
+	return ch.changeEndpointStatus(connectAddress, false)
+}
+
+func (ch *ConsistentHashLoadBalancer) changeEndpointStatus(connectAddress string, status bool) error {
+	if connectAddress == "" {
+		return fmt.Errorf("Non-empty connectAddress expected")
+	}
+
+	addrParts := strings.Split(connectAddress, ":")
+	if len(addrParts) != 2 {
+		return fmt.Errorf("Expected connect address in the form of host:port (%s)", connectAddress)
+	}
+
+	for _, e := range ch.endpoints {
+		if e.Address == connectAddress {
+			e.MarkLoadBalancerEndpointUp(status)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Address not found in load balancing pool: %s", connectAddress)
+}
+
+//Endpoints returns the load balancer's endpoints
+func (ch *ConsistentHashLoadBalancer) Endpoints() []*LoadBalancerEndpoint {
+	return ch.endpoints
+}
+
+//GetEndpoints returns the endpoints associated with the load balancer, partitioning
+//the set of endpoints into healthy and unhealthy endpoints
+func (ch *ConsistentHashLoadBalancer) GetEndpoints() ([]string, []string) {
+	var healthy, unhealthy []string
+	for _, e := range ch.endpoints {
+		if e.IsUp() {
+			healthy = append(healthy, e.Address)
+		} else {
+			unhealthy = append(unhealthy, e.Address)
+		}
+	}
+
+	return healthy, unhealthy
+}
@@ -0,0 +1,192 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func makeTestHashServers() []config.ServerConfig {
+	return []config.ServerConfig{
+		{Name: "a", Address: "a.domain.com", Port: 11000},
+		{Name: "b", Address: "b.domain.com", Port: 11000},
+		{Name: "c", Address: "c.domain.com", Port: 11000},
+		{Name: "d", Address: "d.domain.com", Port: 11000},
+	}
+}
+
+func makeTestConsistentHash(t *testing.T, hashKey, affinityCookie string) *ConsistentHashLoadBalancer {
+	lb, err := new(ConsistentHashLoadBalancerFactory).NewLoadBalancerForBackend(
+		&config.BackendConfig{Name: "backend", HashKey: hashKey, AffinityCookie: affinityCookie}, makeTestHashServers())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	return lb.(*ConsistentHashLoadBalancer)
+}
+
+func userAttributes(user string) *RequestAttributes {
+	return &RequestAttributes{Header: http.Header{"X-User": []string{user}}, ClientIP: "10.0.0.1"}
+}
+
+func TestConsistentHashRegistered(t *testing.T) {
+	assert.True(t, IsKnownLoadBalancerPolicy("consistent-hash"))
+}
+
+func TestValidateHashKey(t *testing.T) {
+	for _, key := range []string{"", "ip", "header:X-User", "cookie:session"} {
+		assert.Nil(t, ValidateHashKey(key), key)
+	}
+
+	for _, key := range []string{"header", "header:", "cookie", "body:foo", "IP"} {
+		assert.NotNil(t, ValidateHashKey(key), key)
+	}
+}
+
+func TestConsistentHashGuardrails(t *testing.T) {
+	factory := new(ConsistentHashLoadBalancerFactory)
+
+	_, err := factory.NewLoadBalancer("", "", makeTestHashServers())
+	assert.NotNil(t, err)
+
+	_, err = factory.NewLoadBalancer("backend", "", nil)
+	assert.NotNil(t, err)
+
+	_, err = factory.NewLoadBalancerForBackend(&config.BackendConfig{Name: "backend", HashKey: "body"}, makeTestHashServers())
+	assert.NotNil(t, err)
+}
+
+func TestConsistentHashSameKeySameServer(t *testing.T) {
+	lb := makeTestConsistentHash(t, "header:X-User", "")
+
+	used := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		user := fmt.Sprintf("user%d", i)
+		first, err := lb.GetConnectAddressForRequest(userAttributes(user))
+		assert.Nil(t, err)
+		used[first] = true
+
+		again, _ := lb.GetConnectAddressForRequest(userAttributes(user))
+		assert.Equal(t, first, again)
+	}
+
+	assert.Equal(t, 4, len(used), "Keys should be spread across every server")
+}
+
+func TestConsistentHashMinimalRemapping(t *testing.T) {
+	lb := makeTestConsistentHash(t, "header:X-User", "")
+
+	before := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		user := fmt.Sprintf("user%d", i)
+		before[user], _ = lb.GetConnectAddressForRequest(userAttributes(user))
+	}
+
+	assert.Nil(t, lb.MarkEndpointDown("b.domain.com:11000"))
+	for user, address := range before {
+		after, err := lb.GetConnectAddressForRequest(userAttributes(user))
+		assert.Nil(t, err)
+		if address == "b.domain.com:11000" {
+			assert.NotEqual(t, address, after)
+		} else {
+			assert.Equal(t, address, after, "Only keys of the server that went down should move")
+		}
+	}
+
+	assert.Nil(t, lb.MarkEndpointUp("b.domain.com:11000"))
+	for user, address := range before {
+		after, _ := lb.GetConnectAddressForRequest(userAttributes(user))
+		assert.Equal(t, address, after, "Keys should move back when the server comes up")
+	}
+}
+
+func TestConsistentHashKeySources(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.7:5555"
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	attributes := NewRequestAttributes(req)
+	assert.Equal(t, "10.0.0.7", attributes.ClientIP)
+	assert.Equal(t, "abc", attributes.Cookie("session"))
+	assert.Equal(t, "", attributes.Cookie("nope"))
+
+	assert.Equal(t, "abc", hashKey{source: HashKeyCookie, name: "session"}.value(attributes))
+	assert.Equal(t, "10.0.0.7", hashKey{source: HashKeyHeader, name: "X-User"}.value(attributes),
+		"Requests without the key fall back to the client IP")
+	assert.Equal(t, "10.0.0.7", hashKey{source: HashKeyIP}.value(attributes))
+}
+
+func TestConsistentHashAffinityCookie(t *testing.T) {
+	lb := makeTestConsistentHash(t, "", "sticky")
+
+	attributes := &RequestAttributes{Header: http.Header{}, ClientIP: "10.0.0.1"}
+	address, err := GetConnectAddressForRequest(lb, attributes)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	cookie := AffinityCookie(lb, attributes, address)
+	if !assert.NotNil(t, cookie) {
+		return
+	}
+	assert.Equal(t, "sticky", cookie.Name)
+	assert.NotContains(t, cookie.Value, "domain.com")
+
+	//A request from another client carrying the cookie goes to the same server, and is not issued it again
+	other := &RequestAttributes{Header: http.Header{"Cookie": []string{cookie.String()}}, ClientIP: "10.9.9.9"}
+	for i := 0; i < 5; i++ {
+		pinned, err := GetConnectAddressForRequest(lb, other)
+		assert.Nil(t, err)
+		assert.Equal(t, address, pinned)
+	}
+	assert.Nil(t, AffinityCookie(lb, other, address))
+
+	//When the pinned server goes down the request is hashed elsewhere and the cookie replaced
+	lb.MarkEndpointDown(address)
+	moved, err := GetConnectAddressForRequest(lb, other)
+	assert.Nil(t, err)
+	assert.NotEqual(t, address, moved)
+	assert.NotNil(t, AffinityCookie(lb, other, moved))
+}
+
+func TestConsistentHashWithoutRequest(t *testing.T) {
+	lb := makeTestConsistentHash(t, "", "")
+
+	used := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		used[address] = true
+	}
+	assert.True(t, len(used) > 1)
+
+	for _, s := range makeTestHashServers() {
+		lb.MarkEndpointDown(fmt.Sprintf("%s:%d", s.Address, s.Port))
+	}
+	_, err := lb.GetConnectAddress()
+	assert.NotNil(t, err)
+
+	h, u := lb.GetEndpoints()
+	assert.Equal(t, 0, len(h))
+	assert.Equal(t, 4, len(u))
+	assert.NotNil(t, lb.MarkEndpointUp("nope:1"))
+}
+
+func TestConsistentHashRetriesElsewhere(t *testing.T) {
+	lb := makeTestConsistentHash(t, "header:X-User", "")
+
+	first, _ := lb.GetConnectAddressForRequest(userAttributes("user1"))
+	excluded := map[string]bool{first: true}
+	for i := 0; i < 3; i++ {
+		address, err := GetConnectAddressExcluding(lb, userAttributes("user1"), excluded)
+		assert.Nil(t, err)
+		assert.False(t, excluded[address], "Each retry should go to a server not yet tried")
+		excluded[address] = true
+	}
+
+	//With every server tried the key's own server is used again
+	address, _ := GetConnectAddressExcluding(lb, userAttributes("user1"), excluded)
+	assert.Equal(t, first, address)
+}
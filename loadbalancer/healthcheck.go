@@ -31,6 +31,10 @@ func IsKnownHealthCheck(healthcheck string) bool {
 		return true
 	case "custom-https":
 		return true
+	case "tcp":
+		return true
+	case "exec":
+		return true
 	default:
 		return false
 	}
@@ -38,10 +42,16 @@ func IsKnownHealthCheck(healthcheck string) bool {
 
 //KnownHealthChecks returns the names of the health checks supported bt the toolkit
 func KnownHealthChecks() string {
-	return "none, http-get, https-get, custom-http, custom-https"
+	return "none, http-get, https-get, custom-http, custom-https, tcp, exec"
 }
 
 func createHealthCheckFnWithTimeout(healthCheckTimeout time.Duration) config.HealthCheckFn {
+	return createHealthCheckFn(defaultHealthCheckSpec, healthCheckTimeout)
+}
+
+//createHealthCheckFn returns a health check sending the request described by the spec and checking the
+//response against it
+func createHealthCheckFn(spec *httpHealthCheckSpec, healthCheckTimeout time.Duration) config.HealthCheckFn {
 	return func(endpoint string, transport *http.Transport) <-chan bool {
 		statusChannel := make(chan bool)
 
@@ -52,7 +62,14 @@ func createHealthCheckFnWithTimeout(healthCheckTimeout time.Duration) config.Hea
 
 		go func() {
 
-			resp, err := client.Get(endpoint)
+			req, err := spec.newRequest(endpoint)
+			if err != nil {
+				log.Warn("Error creating healthcheck request for ", endpoint, " : ", err.Error())
+				statusChannel <- false
+				return
+			}
+
+			resp, err := client.Do(req)
 			if err != nil {
 				log.Warn("Error doing get on healthcheck endpoint ", endpoint, " : ", err.Error())
 
@@ -77,24 +94,24 @@ func createHealthCheckFnWithTimeout(healthCheckTimeout time.Duration) config.Hea
 			//of 5000/2000 ms respectively) to see file handles in use - without the close and read the
 			//connections in grow without being released.
 			defer resp.Body.Close()
-			_, err = ioutil.ReadAll(resp.Body)
+			body, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
 				log.Warnf("Error reading health check response: %v", err)
 				statusChannel <- false
 				return
 			}
 
-			statusChannel <- resp.StatusCode == 200
+			statusChannel <- spec.accepts(resp.StatusCode, body)
 		}()
 
 		return statusChannel
 	}
 }
 
-func makeCertPool(caCertPath string) *x509.CertPool {
+func makeCertPool(lbEndpoint *LoadBalancerEndpoint) *x509.CertPool {
 	pool := x509.NewCertPool()
 
-	pemData, err := ioutil.ReadFile(caCertPath)
+	pemData, err := config.ReadCACert(lbEndpoint.CACert, lbEndpoint.CACertPath)
 	if err != nil {
 		log.Warn("Error creating CA Cert Poll for health check: ", err.Error())
 		return nil
@@ -109,20 +126,20 @@ func makeCertPool(caCertPath string) *x509.CertPool {
 	return pool
 }
 
-func makeTransportForHealthCheck(https bool, caCertPath string) *http.Transport {
+func makeTransportForHealthCheck(https bool, lbEndpoint *LoadBalancerEndpoint) *http.Transport {
 	defaultTransport := &http.Transport{DisableKeepAlives: false, DisableCompression: false, Proxy: http.ProxyFromEnvironment}
 	//Non-https case
 	if https == false {
 		return defaultTransport
 	}
 
-	if caCertPath == "" {
+	if lbEndpoint.CACertPath == "" && lbEndpoint.CACert == "" {
 		log.Info("Using default transport for https health check - will work only for known CAs")
 		log.Info("For self signed certs specify -cacert-path in your backend configuration.")
 		return defaultTransport
 	}
 
-	pool := makeCertPool(caCertPath)
+	pool := makeCertPool(lbEndpoint)
 	if pool == nil {
 		log.Warn("Unable to create cert pool based on configuration - using default transport")
 		return defaultTransport
@@ -137,7 +154,7 @@ func makeTransportForHealthCheck(https bool, caCertPath string) *http.Transport
 func httpGet(lbEndpoint *LoadBalancerEndpoint, serverConfig config.ServerConfig, loop bool, https bool, hcfn config.HealthCheckFn) func() {
 
 	var url string
-	transport := makeTransportForHealthCheck(https, lbEndpoint.CACertPath)
+	transport := makeTransportForHealthCheck(https, lbEndpoint)
 	if https {
 		url = fmt.Sprintf("https://%s:%d%s", serverConfig.Address, serverConfig.Port, serverConfig.PingURI)
 	} else {
@@ -145,29 +162,29 @@ func httpGet(lbEndpoint *LoadBalancerEndpoint, serverConfig config.ServerConfig,
 	}
 
 	log.Debug("Setting healthcheck url to ", url)
+	return checkLoop(lbEndpoint, serverConfig, loop, func() <-chan bool {
+		return hcfn(url, transport)
+	})
+}
+
+//checkLoop returns a function that runs the check at the server's health check interval, marking the
+//endpoint up or down according to the results and the server's rise and fall counts. Checks that take
+//longer than the health check timeout fail.
+func checkLoop(lbEndpoint *LoadBalancerEndpoint, serverConfig config.ServerConfig, loop bool, check func() <-chan bool) func() {
 	healthCheckInterval := time.Duration(serverConfig.HealthCheckInterval) * time.Millisecond
+	thresholds := newHealthThresholds(lbEndpoint, serverConfig)
 
 	return func() {
 		for {
 			time.Sleep(healthCheckInterval)
 			log.Debug("checking health")
 			select {
-			case healthStatus := <-hcfn(url, transport):
-				if !healthStatus {
-					if lbEndpoint.IsUp() {
-						log.Warn("Endpoint ", serverConfig.Address, ":", serverConfig.Port, " is not healthy")
-						lbEndpoint.MarkLoadBalancerEndpointUp(false)
-					}
-				} else {
-					if !lbEndpoint.IsUp() {
-						log.Debug("Endpoint is up: ", serverConfig.Address, ":", serverConfig.Port)
-						lbEndpoint.MarkLoadBalancerEndpointUp(true)
-					}
-				}
+			case healthStatus := <-check():
+				thresholds.record(healthStatus)
 
 			case <-time.After(time.Duration(serverConfig.HealthCheckTimeout) * time.Millisecond):
-				log.Warn("Health check timed out - marking endpoint ", serverConfig.Address, ":", serverConfig.Port, " as not healthy")
-				lbEndpoint.MarkLoadBalancerEndpointUp(false)
+				log.Warn("Health check timed out for endpoint ", serverConfig.Address, ":", serverConfig.Port)
+				thresholds.record(false)
 			}
 
 			if loop == false {
@@ -179,6 +196,34 @@ func httpGet(lbEndpoint *LoadBalancerEndpoint, serverConfig config.ServerConfig,
 
 func noop() {}
 
+//healthCheckTimeout returns the server's health check timeout, or the default if it has none
+func healthCheckTimeout(serverConfig config.ServerConfig) time.Duration {
+	if serverConfig.HealthCheckTimeout > 0 {
+		return time.Duration(serverConfig.HealthCheckTimeout) * time.Millisecond
+	}
+
+	return DefaultHealthCheckTimeout * time.Millisecond
+}
+
+//healthCheckFnForServer returns an http health check using the server's health check spec and timeout
+func healthCheckFnForServer(serverConfig config.ServerConfig) (config.HealthCheckFn, error) {
+	spec, err := newHTTPHealthCheckSpec(&serverConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return createHealthCheckFn(spec, healthCheckTimeout(serverConfig)), nil
+}
+
+//invalidHealthCheck marks the endpoint of a server with an invalid health check down, and returns a no-op
+//health check so the endpoint stays down until the server configuration is fixed
+func invalidHealthCheck(lbEndpoint *LoadBalancerEndpoint, serverConfig config.ServerConfig, err error) func() {
+	log.Errorf("Invalid health check for %s: %s - marking the server down until its configuration is fixed",
+		serverConfig.Name, err.Error())
+	lbEndpoint.MarkLoadBalancerEndpointUp(false)
+	return noop
+}
+
 //MakeHealthCheck returns a health check function based on the server configuration and load balancer endpoint. The
 //loop arguement is meant to enable testability - normal health check functions run until the listener is shutdown,
 //unit test health checks run once typically.
@@ -188,22 +233,13 @@ func MakeHealthCheck(lbEndpoint *LoadBalancerEndpoint, serverConfig config.Serve
 	default:
 		log.Debug("returning no-op health check")
 		return noop
-	case "http-get":
-		log.Debug("returning http-get health check")
-		healthCheckTimeout := time.Duration(DefaultHealthCheckTimeout)
-		if serverConfig.HealthCheckTimeout > 0 {
-			healthCheckTimeout = time.Duration(serverConfig.HealthCheckTimeout) * time.Millisecond
+	case "http-get", "https-get":
+		log.Debugf("returning %s health check", serverConfig.HealthCheck)
+		hcfn, err := healthCheckFnForServer(serverConfig)
+		if err != nil {
+			return invalidHealthCheck(lbEndpoint, serverConfig, err)
 		}
-		return httpGet(lbEndpoint, serverConfig, loop, false,
-			createHealthCheckFnWithTimeout(healthCheckTimeout))
-	case "https-get":
-		log.Debug("returning https-get health check")
-		healthCheckTimeout := time.Duration(DefaultHealthCheckTimeout)
-		if serverConfig.HealthCheckTimeout > 0 {
-			healthCheckTimeout = time.Duration(serverConfig.HealthCheckTimeout) * time.Millisecond
-		}
-		return httpGet(lbEndpoint, serverConfig, loop, true,
-			createHealthCheckFnWithTimeout(healthCheckTimeout))
+		return httpGet(lbEndpoint, serverConfig, loop, serverConfig.HealthCheck == "https-get", hcfn)
 	case "custom-http":
 		log.Debug("returning custom http-get health check")
 		hcfn := config.HealthCheckForServer(serverConfig.Name)
@@ -220,5 +256,12 @@ func MakeHealthCheck(lbEndpoint *LoadBalancerEndpoint, serverConfig config.Serve
 				serverConfig.Name)
 		}
 		return httpGet(lbEndpoint, serverConfig, loop, true, hcfn)
+	case "tcp", "exec":
+		log.Debugf("returning %s health check", serverConfig.HealthCheck)
+		check, err := checkForServer(serverConfig)
+		if err != nil {
+			return invalidHealthCheck(lbEndpoint, serverConfig, err)
+		}
+		return checkLoop(lbEndpoint, serverConfig, loop, check)
 	}
 }
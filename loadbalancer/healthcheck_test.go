@@ -34,6 +34,8 @@ func TestHCKnownHealthChecks(t *testing.T) {
 	assert.NotEmpty(t, healthChecks)
 	assert.True(t, strings.Contains(healthChecks, "none"))
 	assert.True(t, strings.Contains(healthChecks, "http-get"))
+	assert.True(t, strings.Contains(healthChecks, "tcp"))
+	assert.True(t, strings.Contains(healthChecks, "exec"))
 }
 
 func TestHCHealthy(t *testing.T) {
@@ -147,6 +149,7 @@ func TestHCCustomHealthy(t *testing.T) {
 	healthcheckFn()
 
 	assert.True(t, called)
+	assert.True(t, customerHeaderPresent)
 	assert.True(t, lbEndpoint.Up)
 
 }
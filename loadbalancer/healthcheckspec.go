@@ -0,0 +1,278 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/config"
+)
+
+//Prefixes of health check body matches. A match without a prefix is a substring match.
+const (
+	HealthCheckMatchRegex = "regex:"
+	HealthCheckMatchJSON  = "json:"
+)
+
+type statusRange struct {
+	low, high int
+}
+
+//httpHealthCheckSpec describes the request an http health check sends and the responses it accepts
+type httpHealthCheckSpec struct {
+	method  string
+	headers map[string]string
+	body    string
+	status  []statusRange
+	match   func(body []byte) bool
+}
+
+//defaultHealthCheckSpec sends a GET and accepts a 200 response
+var defaultHealthCheckSpec = &httpHealthCheckSpec{
+	method: http.MethodGet,
+	status: []statusRange{{low: http.StatusOK, high: http.StatusOK}},
+}
+
+//parseStatusRanges parses a comma separated list of status codes and ranges, e.g. 200-299,304. An empty
+//list accepts only 200.
+func parseStatusRanges(status string) ([]statusRange, error) {
+	if status == "" {
+		return defaultHealthCheckSpec.status, nil
+	}
+
+	var ranges []statusRange
+	for _, part := range strings.Split(status, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		low, err := strconv.Atoi(bounds[0])
+		high := low
+		if err == nil && len(bounds) == 2 {
+			high, err = strconv.Atoi(bounds[1])
+		}
+
+		if err != nil || low < 100 || high > 599 || low > high {
+			return nil, fmt.Errorf("Invalid health check status '%s' - expected status codes or ranges such as 200-299,304", part)
+		}
+
+		ranges = append(ranges, statusRange{low: low, high: high})
+	}
+
+	return ranges, nil
+}
+
+//parseBodyMatch parses a health check body match: regex:<expression>, json:<path> to require a value that
+//is not null or false, json:<path>=<value> to require a value, or otherwise a substring. An empty match
+//accepts any body.
+func parseBodyMatch(match string) (func([]byte) bool, error) {
+	switch {
+	case match == "":
+		return nil, nil
+	case strings.HasPrefix(match, HealthCheckMatchRegex):
+		re, err := regexp.Compile(strings.TrimPrefix(match, HealthCheckMatchRegex))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid health check match regular expression: %s", err.Error())
+		}
+		return re.Match, nil
+	case strings.HasPrefix(match, HealthCheckMatchJSON):
+		pathAndValue := strings.SplitN(strings.TrimPrefix(match, HealthCheckMatchJSON), "=", 2)
+		path := parseJSONPath(pathAndValue[0])
+		if len(path) == 0 {
+			return nil, fmt.Errorf("Invalid health check match '%s' - expected json:<path> or json:<path>=<value>", match)
+		}
+		return func(body []byte) bool {
+			value, ok := jsonPathValue(body, path)
+			if len(pathAndValue) == 2 {
+				return ok && jsonValueString(value) == pathAndValue[1]
+			}
+			return ok && value != nil && value != false
+		}, nil
+	default:
+		return func(body []byte) bool {
+			return strings.Contains(string(body), match)
+		}, nil
+	}
+}
+
+//parseJSONPath splits a path such as $.checks[0].status or checks.0.status into its keys and indexes
+func parseJSONPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+
+	var keys []string
+	for _, key := range strings.Split(path, ".") {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+//jsonPathValue returns the value at the path in the JSON document, and whether there is one
+func jsonPathValue(body []byte, path []string) (interface{}, bool) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, false
+	}
+
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func jsonValueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return "null"
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+//newHTTPHealthCheckSpec returns the http health check spec of the server definition
+func newHTTPHealthCheckSpec(serverConfig *config.ServerConfig) (*httpHealthCheckSpec, error) {
+	status, err := parseStatusRanges(serverConfig.HealthCheckStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	match, err := parseBodyMatch(serverConfig.HealthCheckMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &httpHealthCheckSpec{
+		method:  strings.ToUpper(serverConfig.HealthCheckMethod),
+		headers: serverConfig.HealthCheckHeaders,
+		body:    serverConfig.HealthCheckBody,
+		status:  status,
+		match:   match,
+	}
+
+	if spec.method == "" {
+		spec.method = http.MethodGet
+	}
+
+	return spec, nil
+}
+
+//ValidateHealthCheckSpec returns an error if the health check status ranges, body match or rise and fall
+//counts of the server definition are invalid, or if it has an exec health check without a command
+func ValidateHealthCheckSpec(serverConfig *config.ServerConfig) error {
+	if serverConfig.HealthCheckRise < 0 || serverConfig.HealthCheckFall < 0 {
+		return fmt.Errorf("Health check rise and fall counts cannot be negative")
+	}
+
+	if serverConfig.HealthCheck == "exec" {
+		if _, err := createExecHealthCheck(*serverConfig); err != nil {
+			return err
+		}
+	}
+
+	_, err := newHTTPHealthCheckSpec(serverConfig)
+	return err
+}
+
+//accepts returns true if the health check response status and body are healthy
+func (spec *httpHealthCheckSpec) accepts(statusCode int, body []byte) bool {
+	statusOK := false
+	for _, r := range spec.status {
+		if statusCode >= r.low && statusCode <= r.high {
+			statusOK = true
+			break
+		}
+	}
+
+	return statusOK && (spec.match == nil || spec.match(body))
+}
+
+//newRequest creates a health check request for the endpoint
+func (spec *httpHealthCheckSpec) newRequest(endpoint string) (*http.Request, error) {
+	req, err := http.NewRequest(spec.method, endpoint, strings.NewReader(spec.body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range spec.headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+
+	return req, nil
+}
+
+//healthThresholds applies a server's rise and fall counts to health check results, so that the endpoint
+//is only marked up after rise passing checks in a row and down after fall failing checks in a row
+type healthThresholds struct {
+	endpoint *LoadBalancerEndpoint
+	rise     int
+	fall     int
+	passes   int
+	failures int
+}
+
+func newHealthThresholds(lbEndpoint *LoadBalancerEndpoint, serverConfig config.ServerConfig) *healthThresholds {
+	ht := &healthThresholds{
+		endpoint: lbEndpoint,
+		rise:     serverConfig.HealthCheckRise,
+		fall:     serverConfig.HealthCheckFall,
+	}
+
+	if ht.rise <= 0 {
+		ht.rise = 1
+	}
+
+	if ht.fall <= 0 {
+		ht.fall = 1
+	}
+
+	return ht
+}
+
+//record records the result of a health check, marking the endpoint up or down once the rise or fall
+//count is reached
+func (ht *healthThresholds) record(healthy bool) {
+	if healthy {
+		ht.passes++
+		ht.failures = 0
+		if ht.passes >= ht.rise && !ht.endpoint.IsUp() {
+			log.Debug("Endpoint is up: ", ht.endpoint.Address)
+			ht.endpoint.MarkLoadBalancerEndpointUp(true)
+		}
+		return
+	}
+
+	ht.failures++
+	ht.passes = 0
+	if ht.failures >= ht.fall && ht.endpoint.IsUp() {
+		log.Warn("Endpoint ", ht.endpoint.Address, " is not healthy")
+		ht.endpoint.MarkLoadBalancerEndpointUp(false)
+	}
+}
@@ -0,0 +1,139 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHCStatusRanges(t *testing.T) {
+	spec, err := newHTTPHealthCheckSpec(&config.ServerConfig{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "GET", spec.method)
+		assert.True(t, spec.accepts(200, nil))
+		assert.False(t, spec.accepts(204, nil))
+	}
+
+	spec, err = newHTTPHealthCheckSpec(&config.ServerConfig{HealthCheckStatus: "200-299, 304"})
+	if assert.Nil(t, err) {
+		assert.True(t, spec.accepts(204, nil))
+		assert.True(t, spec.accepts(304, nil))
+		assert.False(t, spec.accepts(301, nil))
+		assert.False(t, spec.accepts(503, nil))
+	}
+
+	for _, status := range []string{"2xx", "299-200", "99", "200-600", "200,"} {
+		assert.NotNil(t, ValidateHealthCheckSpec(&config.ServerConfig{HealthCheckStatus: status}), status)
+	}
+}
+
+func TestHCBodyMatch(t *testing.T) {
+	body := []byte(`{"status":"UP","checks":[{"name":"db","up":true,"latency":12.5}],"degraded":false,"note":null}`)
+
+	for match, expected := range map[string]bool{
+		`"UP"`:                        true,
+		"DOWN":                        false,
+		`regex:"status":\s*"UP"`:      true,
+		"regex:^DOWN":                 false,
+		"json:status=UP":              true,
+		"json:$.status=DOWN":          false,
+		"json:checks[0].name=db":      true,
+		"json:checks.0.up":            true,
+		"json:checks[0].latency":      true,
+		"json:checks[0].latency=12.5": true,
+		"json:checks[1].name":         false,
+		"json:degraded":               false,
+		"json:note":                   false,
+		"json:note=null":              true,
+		"json:missing":                false,
+	} {
+		spec, err := newHTTPHealthCheckSpec(&config.ServerConfig{HealthCheckMatch: match})
+		if assert.Nil(t, err, match) {
+			assert.Equal(t, expected, spec.accepts(200, body), match)
+		}
+	}
+
+	spec, _ := newHTTPHealthCheckSpec(&config.ServerConfig{HealthCheckMatch: "json:status=UP"})
+	assert.False(t, spec.accepts(200, []byte("UP")), "Bodies that are not JSON do not match JSON paths")
+	assert.False(t, spec.accepts(500, body), "The status must be accepted too")
+
+	assert.NotNil(t, ValidateHealthCheckSpec(&config.ServerConfig{HealthCheckMatch: "regex:("}))
+	assert.NotNil(t, ValidateHealthCheckSpec(&config.ServerConfig{HealthCheckMatch: "json:"}))
+}
+
+func TestHCRequestFromSpec(t *testing.T) {
+	var method, probe, host, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, probe, host = r.Method, r.Header.Get("X-Probe"), r.Host
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"status":"UP"}`)
+	}))
+	defer ts.Close()
+
+	spec, err := newHTTPHealthCheckSpec(&config.ServerConfig{
+		HealthCheckMethod:  "post",
+		HealthCheckHeaders: map[string]string{"X-Probe": "deep", "Host": "status.local"},
+		HealthCheckBody:    "ping",
+		HealthCheckStatus:  "202",
+		HealthCheckMatch:   "json:status=UP",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	select {
+	case status := <-createHealthCheckFn(spec, 500*time.Millisecond)(ts.URL+"/status", standardTransport):
+		assert.True(t, status)
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+
+	assert.Equal(t, "POST", method)
+	assert.Equal(t, "deep", probe)
+	assert.Equal(t, "status.local", host)
+	assert.Equal(t, "ping", body)
+}
+
+func TestHCRiseAndFall(t *testing.T) {
+	lbEndpoint := &LoadBalancerEndpoint{Address: "localhost:3000", Up: true}
+	ht := newHealthThresholds(lbEndpoint, config.ServerConfig{HealthCheckRise: 2, HealthCheckFall: 3})
+
+	ht.record(false)
+	ht.record(false)
+	ht.record(true)
+	ht.record(false)
+	ht.record(false)
+	assert.True(t, lbEndpoint.IsUp(), "A success resets the run of failures")
+
+	ht.record(false)
+	assert.False(t, lbEndpoint.IsUp())
+
+	ht.record(true)
+	assert.False(t, lbEndpoint.IsUp())
+	ht.record(true)
+	assert.True(t, lbEndpoint.IsUp())
+
+	//Without rise and fall counts a single check flips the endpoint
+	ht = newHealthThresholds(lbEndpoint, config.ServerConfig{})
+	ht.record(false)
+	assert.False(t, lbEndpoint.IsUp())
+	ht.record(true)
+	assert.True(t, lbEndpoint.IsUp())
+}
+
+func TestHCInvalidSpecMarksServerDown(t *testing.T) {
+	lbEndpoint := &LoadBalancerEndpoint{Address: "localhost:9876", Up: true}
+	serverConfig := config.ServerConfig{Name: "testcfg", Address: "localhost", Port: 9876, PingURI: "/foo",
+		HealthCheck: "http-get", HealthCheckInterval: 200, HealthCheckTimeout: 100, HealthCheckMatch: "regex:("}
+
+	healthcheckFn := MakeHealthCheck(lbEndpoint, serverConfig, false)
+	healthcheckFn()
+	assert.False(t, lbEndpoint.IsUp())
+}
@@ -0,0 +1,153 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/config"
+)
+
+//maxTCPHealthCheckReply is the most a tcp health check reads while looking for its expected reply
+const maxTCPHealthCheckReply = 64 << 10
+
+//ErrExecHealthChecksNotAllowed is returned for exec health checks in processes that have not allowed them
+var ErrExecHealthChecksNotAllowed = errors.New("exec health checks are not allowed - start the listener with -allow-exec-health-checks to run them")
+
+//execHealthChecksAllowed is set when the operator of the host opts in to running exec health checks
+var execHealthChecksAllowed bool
+
+//AllowExecHealthChecks sets whether exec health checks are run in this process. Exec health checks run a
+//command taken from the server definition, so anyone able to write definitions could otherwise run
+//commands on every listener host. Servers with exec health checks are marked down when they are not
+//allowed.
+func AllowExecHealthChecks(allow bool) {
+	execHealthChecksAllowed = allow
+}
+
+//createTCPHealthCheck returns a health check that connects to the server, sends the health check body if
+//there is one, and reads the reply until it matches the health check match if there is one. A server
+//that accepts the connection is healthy when there is nothing to match.
+func createTCPHealthCheck(serverConfig config.ServerConfig) (func() <-chan bool, error) {
+	match, err := parseBodyMatch(serverConfig.HealthCheckMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(serverConfig.Address, strconv.Itoa(serverConfig.Port))
+	timeout := healthCheckTimeout(serverConfig)
+
+	return func() <-chan bool {
+		statusChannel := make(chan bool, 1)
+
+		go func() {
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				log.Warn("Error connecting to healthcheck address ", address, " : ", err.Error())
+				statusChannel <- false
+				return
+			}
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(timeout))
+
+			if serverConfig.HealthCheckBody != "" {
+				if _, err := conn.Write([]byte(serverConfig.HealthCheckBody)); err != nil {
+					log.Warn("Error sending healthcheck to ", address, " : ", err.Error())
+					statusChannel <- false
+					return
+				}
+			}
+
+			if match == nil {
+				statusChannel <- true
+				return
+			}
+
+			statusChannel <- readUntilMatch(conn, match, address)
+		}()
+
+		return statusChannel
+	}, nil
+}
+
+//readUntilMatch reads from the connection until what has been read matches, returning false if the
+//connection is closed, times out or sends more than maxTCPHealthCheckReply first
+func readUntilMatch(conn net.Conn, match func([]byte) bool, address string) bool {
+	var reply []byte
+	buf := make([]byte, 4096)
+	for len(reply) < maxTCPHealthCheckReply {
+		n, err := conn.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if n > 0 && match(reply) {
+			return true
+		}
+
+		if err != nil {
+			log.Warn("Healthcheck reply from ", address, " did not match: ", err.Error())
+			return false
+		}
+	}
+
+	log.Warn("Healthcheck reply from ", address, " did not match")
+	return false
+}
+
+//createExecHealthCheck returns a health check that runs the health check command, killing it if it runs
+//longer than the health check timeout. The server is healthy if the command exits with status 0. The
+//command is run with the server's name, address and port in the XAVI_SERVER_NAME, XAVI_SERVER_ADDRESS
+//and XAVI_SERVER_PORT environment variables.
+func createExecHealthCheck(serverConfig config.ServerConfig) (func() <-chan bool, error) {
+	args := strings.Fields(serverConfig.HealthCheckCommand)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("The exec health check needs a health check command")
+	}
+
+	env := append(os.Environ(),
+		"XAVI_SERVER_NAME="+serverConfig.Name,
+		"XAVI_SERVER_ADDRESS="+serverConfig.Address,
+		"XAVI_SERVER_PORT="+strconv.Itoa(serverConfig.Port),
+	)
+	timeout := healthCheckTimeout(serverConfig)
+
+	return func() <-chan bool {
+		statusChannel := make(chan bool, 1)
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+			cmd.Env = env
+			if err := cmd.Run(); err != nil {
+				log.Warn("Healthcheck command for ", serverConfig.Name, " failed: ", err.Error())
+				statusChannel <- false
+				return
+			}
+
+			statusChannel <- true
+		}()
+
+		return statusChannel
+	}, nil
+}
+
+//checkForServer returns the tcp or exec health check of the server
+func checkForServer(serverConfig config.ServerConfig) (func() <-chan bool, error) {
+	switch serverConfig.HealthCheck {
+	case "exec":
+		if !execHealthChecksAllowed {
+			return nil, ErrExecHealthChecksNotAllowed
+		}
+		return createExecHealthCheck(serverConfig)
+	default:
+		return createTCPHealthCheck(serverConfig)
+	}
+}
@@ -0,0 +1,126 @@
+package loadbalancer
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//startTCPServer starts a server that answers each line it reads with the reply, returning its address
+//and port
+func startTCPServer(t *testing.T, reply string) (net.Listener, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				if _, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+					conn.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+
+	return listener, listener.Addr().(*net.TCPAddr).Port
+}
+
+func runTCPExecHealthCheck(serverConfig config.ServerConfig) bool {
+	serverConfig.Name = "testcfg"
+	serverConfig.HealthCheckInterval = 10
+	serverConfig.HealthCheckTimeout = 200
+
+	lbEndpoint := &LoadBalancerEndpoint{Address: serverConfig.Address, Up: true}
+	MakeHealthCheck(lbEndpoint, serverConfig, false)()
+	return lbEndpoint.IsUp()
+}
+
+func TestHCTCPConnect(t *testing.T) {
+	listener, port := startTCPServer(t, "")
+	assert.True(t, runTCPExecHealthCheck(config.ServerConfig{HealthCheck: "tcp", Address: "127.0.0.1", Port: port}))
+
+	listener.Close()
+	assert.False(t, runTCPExecHealthCheck(config.ServerConfig{HealthCheck: "tcp", Address: "127.0.0.1", Port: port}))
+}
+
+func TestHCTCPSendExpect(t *testing.T) {
+	listener, port := startTCPServer(t, "+PONG\r\n")
+	defer listener.Close()
+
+	serverConfig := config.ServerConfig{HealthCheck: "tcp", Address: "127.0.0.1", Port: port,
+		HealthCheckBody: "PING\r\n", HealthCheckMatch: "PONG"}
+	assert.True(t, runTCPExecHealthCheck(serverConfig))
+
+	serverConfig.HealthCheckMatch = "regex:^\\+PONG"
+	assert.True(t, runTCPExecHealthCheck(serverConfig))
+
+	serverConfig.HealthCheckMatch = "OK"
+	assert.False(t, runTCPExecHealthCheck(serverConfig))
+
+	//A server that never replies times out
+	serverConfig.HealthCheckBody = "no newline"
+	serverConfig.HealthCheckMatch = "PONG"
+	assert.False(t, runTCPExecHealthCheck(serverConfig))
+}
+
+func TestHCExecNotAllowed(t *testing.T) {
+	assert.False(t, runTCPExecHealthCheck(config.ServerConfig{HealthCheck: "exec", HealthCheckCommand: "true"}),
+		"Servers with exec health checks are down unless the operator allows them")
+}
+
+func TestHCExec(t *testing.T) {
+	AllowExecHealthChecks(true)
+	defer AllowExecHealthChecks(false)
+
+	assert.True(t, runTCPExecHealthCheck(config.ServerConfig{HealthCheck: "exec", HealthCheckCommand: "true"}))
+	assert.False(t, runTCPExecHealthCheck(config.ServerConfig{HealthCheck: "exec", HealthCheckCommand: "false"}))
+	assert.False(t, runTCPExecHealthCheck(config.ServerConfig{HealthCheck: "exec", HealthCheckCommand: "no-such-command"}))
+
+	//The server is passed to the command in the environment
+	dir, err := ioutil.TempDir("", "xavi-hc")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "check.sh")
+	err = ioutil.WriteFile(script,
+		[]byte("#!/bin/sh\ntest \"$XAVI_SERVER_NAME:$XAVI_SERVER_ADDRESS:$XAVI_SERVER_PORT\" = testcfg:127.0.0.1:42\n"), 0755)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.True(t, runTCPExecHealthCheck(config.ServerConfig{HealthCheck: "exec", HealthCheckCommand: script,
+		Address: "127.0.0.1", Port: 42}))
+	assert.False(t, runTCPExecHealthCheck(config.ServerConfig{HealthCheck: "exec", HealthCheckCommand: script,
+		Address: "127.0.0.1", Port: 43}))
+
+	//Commands running longer than the timeout are killed
+	check, err := createExecHealthCheck(config.ServerConfig{HealthCheckCommand: "sleep 5", HealthCheckTimeout: 50})
+	if assert.Nil(t, err) {
+		assert.False(t, <-check())
+	}
+}
+
+func TestHCValidateTCPExec(t *testing.T) {
+	assert.True(t, IsKnownHealthCheck("tcp"))
+	assert.True(t, IsKnownHealthCheck("exec"))
+
+	assert.Nil(t, ValidateHealthCheckSpec(&config.ServerConfig{HealthCheck: "tcp"}))
+	assert.NotNil(t, ValidateHealthCheckSpec(&config.ServerConfig{HealthCheck: "tcp", HealthCheckMatch: "regex:("}))
+	assert.Nil(t, ValidateHealthCheckSpec(&config.ServerConfig{HealthCheck: "exec", HealthCheckCommand: "/bin/true"}))
+	assert.NotNil(t, ValidateHealthCheckSpec(&config.ServerConfig{HealthCheck: "exec"}))
+}
@@ -20,6 +20,20 @@ func init() {
 	//Prefer Local
 	var prefefLocalFactory LoadBalancerFactory = new(PreferLocalLoadBalancerFactory)
 	RegisterLoadBalancer("prefer-local", prefefLocalFactory)
+
+	//Weighted Round Robin
+	var weightedRoundRobinFactory LoadBalancerFactory = new(WeightedRoundRobinLoadBalancerFactory)
+	RegisterLoadBalancer("weighted-round-robin", weightedRoundRobinFactory)
+
+	//Least Requests, comparing every endpoint or two picked at random
+	RegisterLoadBalancer("least-requests", new(LeastRequestsLoadBalancerFactory))
+	RegisterLoadBalancer("least-requests-p2c", &LeastRequestsLoadBalancerFactory{PowerOfTwoChoices: true})
+
+	//Consistent Hash
+	RegisterLoadBalancer("consistent-hash", new(ConsistentHashLoadBalancerFactory))
+
+	//Peak EWMA, favoring endpoints with low latency and few requests in flight
+	RegisterLoadBalancer("peak-ewma", new(PeakEWMALoadBalancerFactory))
 }
 
 //RegisterLoadBalancer registers a load balancer factory with a given load balancer
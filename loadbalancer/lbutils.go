@@ -1,22 +1,26 @@
 package loadbalancer
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/config"
 	"golang.org/x/net/context/ctxhttp"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"time"
 )
 
 type BackendLoadBalancer struct {
-	LoadBalancer   LoadBalancer
-	BackendConfig  *config.BackendConfig
-	CertPool       *x509.CertPool
-	httpTransport  *http.Transport
-	httpsTransport *http.Transport
+	LoadBalancer    LoadBalancer
+	BackendConfig   *config.BackendConfig
+	CertPool        *x509.CertPool
+	OutlierDetector *OutlierDetector
+	CircuitBreakers *CircuitBreakers
+	httpTransport   *http.Transport
+	httpsTransport  *http.Transport
 }
 
 var ErrBackendNotFound = errors.New("Given backed end not found in active listener config")
@@ -38,23 +42,28 @@ func findBackend(backend string) (*config.ServiceBackend, error) {
 	return nil, ErrBackendNotFound
 }
 
-func serversForBackend(backend *config.ServiceBackend) []config.ServerConfig {
+//serversForBackend returns copies of the backend's servers with their secret references resolved
+func serversForBackend(backend *config.ServiceBackend) ([]config.ServerConfig, error) {
 	servers := make([]config.ServerConfig, 0)
 
 	if backend == nil {
-		return servers
+		return servers, nil
 	}
 
 	for _, s := range backend.Servers {
 		log.Infof("server config for %s:", s.Name)
-		servers = append(servers, *s)
+		server := *s
+		if err := server.ResolveSecrets(); err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
 	}
 
-	return servers
+	return servers, nil
 }
 
 func createCertPool(backendConfig *config.BackendConfig) (*x509.CertPool, error) {
-	if backendConfig.CACertPath == "" {
+	if backendConfig.CACertPath == "" && backendConfig.CACert == "" {
 		return nil, nil
 	}
 
@@ -62,7 +71,7 @@ func createCertPool(backendConfig *config.BackendConfig) (*x509.CertPool, error)
 
 	pool := x509.NewCertPool()
 
-	pemData, err := ioutil.ReadFile(backendConfig.CACertPath)
+	pemData, err := config.ReadCACert(backendConfig.CACert, backendConfig.CACertPath)
 	if err != nil {
 		return nil, err
 	}
@@ -84,9 +93,18 @@ func NewBackendLoadBalancer(backendName string) (*BackendLoadBalancer, error) {
 		return nil, err
 	}
 
-	servers := serversForBackend(backend)
+	servers, err := serversForBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	//The active config holds secret references, so resolve them in a copy of the backend config
+	resolved := *backend.Backend
+	if err := resolved.ResolveSecrets(); err != nil {
+		return nil, err
+	}
 
-	backendConfig := backend.Backend
+	backendConfig := &resolved
 	factory := ObtainFactoryForLoadBalancer(backendConfig.LoadBalancerPolicy)
 	if factory == nil {
 		factory = new(RoundRobinLoadBalancerFactory)
@@ -104,19 +122,28 @@ func NewBackendLoadBalancer(backendName string) (*BackendLoadBalancer, error) {
 	//Create non-TLS transport
 	httpTransport := &http.Transport{DisableKeepAlives: false, DisableCompression: false}
 
-	lb, err := factory.NewLoadBalancer(backendConfig.Name, backendConfig.CACertPath, servers)
+	lb, err := NewLoadBalancerForBackend(factory, backendConfig, servers)
+
+	var outlierDetector *OutlierDetector
+	var circuitBreakers *CircuitBreakers
+	if err == nil {
+		outlierDetector = NewOutlierDetector(lb, backendConfig)
+		circuitBreakers = NewCircuitBreakers(lb, backendConfig)
+	}
 
 	return &BackendLoadBalancer{
-		LoadBalancer:   lb,
-		BackendConfig:  backendConfig,
-		CertPool:       certPool,
-		httpsTransport: httpsTransport,
-		httpTransport:  httpTransport,
+		LoadBalancer:    lb,
+		BackendConfig:   backendConfig,
+		CertPool:        certPool,
+		OutlierDetector: outlierDetector,
+		CircuitBreakers: circuitBreakers,
+		httpsTransport:  httpsTransport,
+		httpTransport:   httpTransport,
 	}, err
 }
 
 func (lb *BackendLoadBalancer) DoWithLoadBalancer(req *http.Request, useTLS bool) (*http.Response, error) {
-	connectString, err := lb.LoadBalancer.GetConnectAddress()
+	connectString, reportOutcome, err := lb.CircuitBreakers.ConnectAddress(lb.LoadBalancer, NewRequestAttributes(req), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -141,5 +168,58 @@ func (lb *BackendLoadBalancer) DoWithLoadBalancer(req *http.Request, useTLS bool
 	}
 
 	req.RequestURI = "" //Must clear when using http.Client
-	return ctxhttp.Do(req.Context(), client, req)
+	done := TrackRequest(lb.LoadBalancer, connectString)
+	start := time.Now()
+	resp, err := ctxhttp.Do(req.Context(), client, req)
+	latency := time.Since(start)
+	backendTimeout := lb.backendTimeout()
+	if err != nil {
+		done()
+		if err != context.Canceled {
+			RecordLatency(lb.LoadBalancer, connectString, FailureLatency(latency, backendTimeout))
+			lb.OutlierDetector.ReportOutcome(connectString, true)
+		}
+		if err == context.Canceled {
+			reportOutcome(Cancelled)
+		} else {
+			reportOutcome(Failed)
+		}
+		return nil, err
+	}
+
+	failed := resp.StatusCode >= http.StatusInternalServerError
+	if failed {
+		latency = FailureLatency(latency, backendTimeout)
+	}
+	RecordLatency(lb.LoadBalancer, connectString, latency)
+	lb.OutlierDetector.ReportOutcome(connectString, failed)
+	if failed {
+		reportOutcome(Failed)
+	} else {
+		reportOutcome(Succeeded)
+	}
+
+	//The request stays in flight until the caller is done with the response body
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+//backendTimeout returns the timeout configured for the backend, or 0 if it has none
+func (lb *BackendLoadBalancer) backendTimeout() time.Duration {
+	if lb.BackendConfig == nil {
+		return 0
+	}
+
+	return time.Duration(lb.BackendConfig.Timeout) * time.Millisecond
+}
+
+//trackedBody reports a request finished when its response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (tb *trackedBody) Close() error {
+	defer tb.done()
+	return tb.ReadCloser.Close()
 }
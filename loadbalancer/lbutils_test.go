@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strconv"
 	"testing"
 )
@@ -37,6 +38,32 @@ func TestLBUtilsBuildFromConfig(t *testing.T) {
 	}
 }
 
+func TestLBUtilsResolvesSecretRefs(t *testing.T) {
+	os.Setenv("XAVI_TEST_LB_ADDRESS", "10.0.0.2")
+	defer os.Unsetenv("XAVI_TEST_LB_ADDRESS")
+
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&config.ServerConfig{Name: "secret-s1", Address: "${env:XAVI_TEST_LB_ADDRESS}", Port: 3000}).Store(kvs)
+	(&config.BackendConfig{Name: "secret-backend", ServerNames: []string{"secret-s1"}}).Store(kvs)
+	(&config.RouteConfig{Name: "secret-route", URIRoot: "/secret", Backends: []string{"secret-backend"}}).Store(kvs)
+	(&config.ListenerConfig{Name: "secret-listener", RouteNames: []string{"secret-route"}}).Store(kvs)
+
+	sc, err := config.ReadServiceConfig("secret-listener", kvs)
+	if !assert.Nil(t, err) {
+		return
+	}
+	config.RecordActiveConfig(sc)
+
+	lb, err := NewBackendLoadBalancer("secret-backend")
+	if assert.Nil(t, err) {
+		h, _ := lb.LoadBalancer.GetEndpoints()
+		assert.Equal(t, []string{"10.0.0.2:3000"}, h)
+	}
+
+	assert.Equal(t, "${env:XAVI_TEST_LB_ADDRESS}", sc.Routes[0].Backends[0].Servers[0].Address,
+		"The active config keeps the reference")
+}
+
 func TestLBUtilsNoSuchBackend(t *testing.T) {
 	kvs := config.BuildKVStoreTestConfig(t)
 	assert.NotNil(t, kvs)
@@ -70,7 +97,7 @@ func buildTestConfigForLBCall(t *testing.T, server1Url, server2Url string) kvsto
 	portVal, err := strconv.Atoi(port)
 	assert.Nil(t, err)
 
-	serverConfig1 := &config.ServerConfig{"lbcserver1", host, portVal, "/hello", "none", 0, 0}
+	serverConfig1 := &config.ServerConfig{Name: "lbcserver1", Address: host, Port: portVal, PingURI: "/hello", HealthCheck: "none"}
 	err = serverConfig1.Store(kvs)
 	if err != nil {
 		t.Fatal(err)
@@ -84,7 +111,7 @@ func buildTestConfigForLBCall(t *testing.T, server1Url, server2Url string) kvsto
 	portVal, err = strconv.Atoi(port)
 	assert.Nil(t, err)
 
-	serverConfig2 := &config.ServerConfig{"lbcserver2", host, portVal, "/hello", "none", 0, 0}
+	serverConfig2 := &config.ServerConfig{Name: "lbcserver2", Address: host, Port: portVal, PingURI: "/hello", HealthCheck: "none"}
 	err = serverConfig2.Store(kvs)
 	if err != nil {
 		t.Fatal(err)
@@ -167,3 +194,32 @@ func TestLBUtilsCallSvc(t *testing.T) {
 	assert.True(t, server1Called, "Expected server 1 to be called")
 	assert.True(t, server2Called, "Expected server 2 to be called")
 }
+
+func TestDoWithLoadBalancerTracksRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	portVal, _ := strconv.Atoi(port)
+	lb, err := new(LeastRequestsLoadBalancerFactory).NewLoadBalancer("backend", "",
+		[]config.ServerConfig{{Name: "s1", Address: host, Port: portVal}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	blb := &BackendLoadBalancer{LoadBalancer: lb, httpTransport: &http.Transport{}}
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := blb.DoWithLoadBalancer(req, false)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	leastRequests := lb.(*LeastRequestsLoadBalancer)
+	assert.Equal(t, int64(1), leastRequests.InFlight(ts.Listener.Addr().String()),
+		"The request is in flight until the response body is closed")
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, int64(0), leastRequests.InFlight(ts.Listener.Addr().String()))
+}
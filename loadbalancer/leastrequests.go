@@ -0,0 +1,197 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/config"
+)
+
+//LeastRequestsLoadBalancer hands out the address of the healthy endpoint with the fewest requests
+//in flight. With power of two choices enabled it compares two healthy endpoints picked at random
+//instead of every endpoint, which spreads load nearly as well for large pools while avoiding
+//sending every new request to the same momentarily idle endpoint.
+type LeastRequestsLoadBalancer struct {
+	backend    string
+	powerOfTwo bool
+	mu         sync.Mutex
+	endpoints  []*LoadBalancerEndpoint
+	next       int
+	rand       *rand.Rand
+	loadFn     func(*LoadBalancerEndpoint) float64
+}
+
+//LeastRequestsLoadBalancerFactory is the method receiver for the least requests load balancer factory
+//method. Set PowerOfTwoChoices for the power of two choices variant.
+type LeastRequestsLoadBalancerFactory struct {
+	PowerOfTwoChoices bool
+}
+
+//NewLoadBalancer creates a new instance of a least requests load balancer
+func (lf *LeastRequestsLoadBalancerFactory) NewLoadBalancer(backendName, caCertPath string, servers []config.ServerConfig) (LoadBalancer, error) {
+	return lf.NewLoadBalancerForBackend(&config.BackendConfig{Name: backendName, CACertPath: caCertPath}, servers)
+}
+
+//NewLoadBalancerForBackend creates a least requests load balancer using the CA certificate settings
+//of the backend definition for health checks
+func (lf *LeastRequestsLoadBalancerFactory) NewLoadBalancerForBackend(backendConfig *config.BackendConfig, servers []config.ServerConfig) (LoadBalancer, error) {
+	if backendConfig.Name == "" {
+		return nil, fmt.Errorf("Expected non-empty backend name")
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("Expected at least one server in servers argument")
+	}
+
+	lr := &LeastRequestsLoadBalancer{
+		backend:    backendConfig.Name,
+		powerOfTwo: lf.PowerOfTwoChoices,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, s := range servers {
+		lbEndpoint := newLoadBalancerEndpoint(s, backendConfig)
+		log.Debug("Adding server with address ", lbEndpoint.Address)
+		lr.endpoints = append(lr.endpoints, lbEndpoint)
+	}
+
+	return lr, nil
+}
+
+//GetConnectAddress returns the address of the least loaded healthy endpoint. Ties go to the
+//endpoints in turn.
+func (lr *LeastRequestsLoadBalancer) GetConnectAddress() (string, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	var healthy []*LoadBalancerEndpoint
+	for i := range lr.endpoints {
+		e := lr.endpoints[(lr.next+i)%len(lr.endpoints)]
+		if e.Available() {
+			healthy = append(healthy, e)
+		}
+	}
+	lr.next = (lr.next + 1) % len(lr.endpoints)
+
+	if len(healthy) == 0 {
+		return "", fmt.Errorf("All servers in backend %s are marked down", lr.backend)
+	}
+
+	if lr.powerOfTwo && len(healthy) > 2 {
+		first := lr.rand.Intn(len(healthy))
+		second := lr.rand.Intn(len(healthy) - 1)
+		if second >= first {
+			second++
+		}
+		healthy = []*LoadBalancerEndpoint{healthy[first], healthy[second]}
+	}
+
+	selected := healthy[0]
+	selectedLoad := lr.load(selected)
+	for _, e := range healthy[1:] {
+		if load := lr.load(e); load < selectedLoad {
+			selected, selectedLoad = e, load
+		}
+	}
+
+	return selected.Address, nil
+}
+
+//load returns how busy the endpoint is, by default its number of requests in flight
+func (lr *LeastRequestsLoadBalancer) load(e *LoadBalancerEndpoint) float64 {
+	if lr.loadFn != nil {
+		return lr.loadFn(e)
+	}
+
+	return float64(e.InFlight())
+}
+
+//RequestStarted counts a request to the endpoint with the given connect address as in flight
+func (lr *LeastRequestsLoadBalancer) RequestStarted(connectAddress string) {
+	if e := lr.endpoint(connectAddress); e != nil {
+		e.requestStarted()
+	}
+}
+
+//RequestFinished counts a request to the endpoint with the given connect address as no longer in flight
+func (lr *LeastRequestsLoadBalancer) RequestFinished(connectAddress string) {
+	if e := lr.endpoint(connectAddress); e != nil {
+		e.requestFinished()
+	}
+}
+
+//InFlight returns the number of requests in flight to the endpoint with the given connect address
+func (lr *LeastRequestsLoadBalancer) InFlight(connectAddress string) int64 {
+	if e := lr.endpoint(connectAddress); e != nil {
+		return e.InFlight()
+	}
+
+	return 0
+}
+
+func (lr *LeastRequestsLoadBalancer) endpoint(connectAddress string) *LoadBalancerEndpoint {
+	for _, e := range lr.endpoints {
+		if e.Address == connectAddress {
+			return e
+		}
+	}
+
+	return nil
+}
+
+//MarkEndpointUp marks the endpoint in the load balancer pool associated with the
+//connect address as up.
+func (lr *LeastRequestsLoadBalancer) MarkEndpointUp(connectAddress string) error {
+	log.Infof("mark %s up", connectAddress)
+	return lr.changeEndpointStatus(connectAddress, true)
+}
+
+//MarkEndpointDown marks the endpoint in the load balancer pool associated with the
+//connect address as down.
+func (lr *LeastRequestsLoadBalancer) MarkEndpointDown(connectAddress string) error {
+	log.Infof("mark %s down", connectAddress)
+	return lr.changeEndpointStatus(connectAddress, false)
+}
+
+func (lr *LeastRequestsLoadBalancer) changeEndpointStatus(connectAddress string, status bool) error {
+	if connectAddress == "" {
+		return fmt.Errorf("Non-empty connectAddress expected")
+	}
+
+	addrParts := strings.Split(connectAddress, ":")
+	if len(addrParts) != 2 {
+		return fmt.Errorf("Expected connect address in the form of host:port (%s)", connectAddress)
+	}
+
+	e := lr.endpoint(connectAddress)
+	if e == nil {
+		return fmt.Errorf("Address not found in load balancing pool: %s", connectAddress)
+	}
+
+	e.MarkLoadBalancerEndpointUp(status)
+	return nil
+}
+
+//Endpoints returns the load balancer's endpoints
+func (lr *LeastRequestsLoadBalancer) Endpoints() []*LoadBalancerEndpoint {
+	return lr.endpoints
+}
+
+//GetEndpoints returns the endpoints associated with the load balancer, partitioning
+//the set of endpoints into healthy and unhealthy endpoints
+func (lr *LeastRequestsLoadBalancer) GetEndpoints() ([]string, []string) {
+	var healthy, unhealthy []string
+	for _, e := range lr.endpoints {
+		if e.IsUp() {
+			healthy = append(healthy, e.Address)
+		} else {
+			unhealthy = append(unhealthy, e.Address)
+		}
+	}
+
+	return healthy, unhealthy
+}
@@ -0,0 +1,124 @@
+package loadbalancer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"testing"
+)
+
+func makeTestLeastRequestsServers() []config.ServerConfig {
+	return []config.ServerConfig{
+		{Name: "a", Address: "a.domain.com", Port: 11000},
+		{Name: "b", Address: "b.domain.com", Port: 11000},
+		{Name: "c", Address: "c.domain.com", Port: 11000},
+	}
+}
+
+func TestLeastRequestsRegistered(t *testing.T) {
+	assert.True(t, IsKnownLoadBalancerPolicy("least-requests"))
+	assert.True(t, IsKnownLoadBalancerPolicy("least-requests-p2c"))
+}
+
+func TestLeastRequestsGuardrails(t *testing.T) {
+	factory := new(LeastRequestsLoadBalancerFactory)
+
+	_, err := factory.NewLoadBalancer("", "", makeTestLeastRequestsServers())
+	assert.NotNil(t, err)
+
+	_, err = factory.NewLoadBalancer("backend", "", nil)
+	assert.NotNil(t, err)
+}
+
+func TestLeastRequestsSelection(t *testing.T) {
+	factory := new(LeastRequestsLoadBalancerFactory)
+	lb, err := factory.NewLoadBalancer("backend", "", makeTestLeastRequestsServers())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	//Hold requests open on the first two endpoints picked
+	var dones []func()
+	picked := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		picked[address] = true
+		dones = append(dones, TrackRequest(lb, address))
+	}
+	assert.Equal(t, 2, len(picked), "Idle endpoints are used before busy ones")
+
+	var idle string
+	for _, address := range []string{"a.domain.com:11000", "b.domain.com:11000", "c.domain.com:11000"} {
+		if !picked[address] {
+			idle = address
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		assert.Equal(t, idle, address)
+	}
+
+	//Once the requests finish every endpoint is equally loaded again
+	for _, done := range dones {
+		done()
+		done()
+	}
+	for _, address := range []string{"a.domain.com:11000", "b.domain.com:11000", "c.domain.com:11000"} {
+		assert.Equal(t, int64(0), lb.(*LeastRequestsLoadBalancer).InFlight(address))
+	}
+}
+
+func TestLeastRequestsMarkDown(t *testing.T) {
+	factory := new(LeastRequestsLoadBalancerFactory)
+	lb, err := factory.NewLoadBalancer("backend", "", makeTestLeastRequestsServers())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Nil(t, lb.MarkEndpointDown("a.domain.com:11000"))
+	assert.Nil(t, lb.MarkEndpointDown("b.domain.com:11000"))
+	for i := 0; i < 3; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		assert.Equal(t, "c.domain.com:11000", address)
+	}
+
+	h, u := lb.GetEndpoints()
+	assert.Equal(t, []string{"c.domain.com:11000"}, h)
+	assert.Equal(t, []string{"a.domain.com:11000", "b.domain.com:11000"}, u)
+
+	lb.MarkEndpointDown("c.domain.com:11000")
+	_, err = lb.GetConnectAddress()
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, lb.MarkEndpointUp("nope.domain.com:11000"))
+	assert.NotNil(t, lb.MarkEndpointUp(""))
+}
+
+func TestLeastRequestsPowerOfTwoChoices(t *testing.T) {
+	factory := &LeastRequestsLoadBalancerFactory{PowerOfTwoChoices: true}
+	lb, err := factory.NewLoadBalancer("backend", "", makeTestLeastRequestsServers())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	//With one endpoint busy, whichever pair is compared the busy endpoint never wins
+	done := TrackRequest(lb, "a.domain.com:11000")
+	defer done()
+	for i := 0; i < 20; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		assert.NotEqual(t, "a.domain.com:11000", address)
+	}
+}
+
+func TestTrackRequestWithoutTracker(t *testing.T) {
+	var roundRobinFactory LoadBalancerFactory = new(RoundRobinLoadBalancerFactory)
+	lb, err := roundRobinFactory.NewLoadBalancer("backend", "", makeTestLeastRequestsServers())
+	if assert.Nil(t, err) {
+		done := TrackRequest(lb, "a.domain.com:11000")
+		done()
+	}
+}
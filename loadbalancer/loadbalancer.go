@@ -1,8 +1,16 @@
 package loadbalancer
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/armon/go-metrics"
 	"github.com/xtracdev/xavi/config"
 )
@@ -14,7 +22,80 @@ type LoadBalancerEndpoint struct {
 	PingURI    string
 	Up         bool
 	CACertPath string
+	CACert     string
 	mu         sync.RWMutex
+	inFlight   int64
+	latency    float64 //Peak EWMA of call latency in nanoseconds
+	observed   time.Time
+	ejectedAt  time.Time
+	ejectedFor time.Duration
+	breaker    *circuitBreaker
+}
+
+//newLoadBalancerEndpoint creates an endpoint for the server, marked up, and starts its health check
+func newLoadBalancerEndpoint(s config.ServerConfig, backendConfig *config.BackendConfig) *LoadBalancerEndpoint {
+	lbEndpoint := new(LoadBalancerEndpoint)
+	lbEndpoint.Address = fmt.Sprintf("%s:%d", s.Address, s.Port)
+	metrics.SetGauge([]string{"endpoint", lbEndpoint.Address}, 1.0)
+	lbEndpoint.PingURI = s.PingURI
+	lbEndpoint.Up = true
+	lbEndpoint.CACertPath = backendConfig.CACertPath
+	lbEndpoint.CACert = backendConfig.CACert
+
+	log.Debug("Spawing health check for address ", lbEndpoint.Address)
+	healthCheckFunction := MakeHealthCheck(lbEndpoint, s, true)
+	go healthCheckFunction()
+
+	return lbEndpoint
+}
+
+//InFlight returns the number of requests to the endpoint that have started but not finished. Only load
+//balancers that implement RequestTracker count requests.
+func (lb *LoadBalancerEndpoint) InFlight() int64 {
+	return atomic.LoadInt64(&lb.inFlight)
+}
+
+//ewmaDecay is the time over which the weight of a latency observation decays to 1/e
+const ewmaDecay = 10 * time.Second
+
+//observeLatency folds a call latency into the endpoint's peak EWMA. A latency above the average
+//replaces it outright, so the average reacts at once to an endpoint slowing down and recovers
+//gradually as faster calls are observed.
+func (lb *LoadBalancerEndpoint) observeLatency(latency time.Duration) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := time.Now()
+	sample := float64(latency)
+	if sample > lb.latency || lb.observed.IsZero() {
+		lb.latency = sample
+	} else {
+		w := math.Exp(-float64(now.Sub(lb.observed)) / float64(ewmaDecay))
+		lb.latency = lb.latency*w + sample*(1-w)
+	}
+	lb.observed = now
+}
+
+//Latency returns the peak EWMA of the endpoint's call latency, decayed towards zero for the time
+//since the last observation so that an endpoint that was slow is tried again after a while. Only
+//load balancers that implement LatencyRecorder observe latencies.
+func (lb *LoadBalancerEndpoint) Latency() time.Duration {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	if lb.observed.IsZero() {
+		return 0
+	}
+
+	return time.Duration(lb.latency * math.Exp(-float64(time.Since(lb.observed))/float64(ewmaDecay)))
+}
+
+func (lb *LoadBalancerEndpoint) requestStarted() {
+	atomic.AddInt64(&lb.inFlight, 1)
+}
+
+func (lb *LoadBalancerEndpoint) requestFinished() {
+	atomic.AddInt64(&lb.inFlight, -1)
 }
 
 //IsUp reads the status of the endpoint. The function is safe for simultaneous use by multiple goroutines.
@@ -24,6 +105,20 @@ func (lb *LoadBalancerEndpoint) IsUp() bool {
 	return lb.Up
 }
 
+//Available returns true if the endpoint is up, not ejected by outlier detection, and its circuit
+//breaker lets requests through. Load balancers hand out only available endpoints.
+func (lb *LoadBalancerEndpoint) Available() bool {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	now := time.Now()
+	return lb.Up && !lb.ejected(now) && lb.breaker.allows(now)
+}
+
+//ejected returns true if the endpoint is ejected at the given time. Callers must hold the endpoint lock.
+func (lb *LoadBalancerEndpoint) ejected(now time.Time) bool {
+	return now.Before(lb.ejectedAt.Add(lb.ejectedFor))
+}
+
 //MarkLoadBalancerEndpointUp sets the status for this endpoind. The function is safe for simultaneous use by multiple goroutines.
 func (lb *LoadBalancerEndpoint) MarkLoadBalancerEndpointUp(isUp bool) {
 	lb.mu.Lock()
@@ -45,7 +140,169 @@ type LoadBalancer interface {
 	GetEndpoints() (healthy []string, unhealthy []string)
 }
 
+//RequestAttributes are the details of a request available to load balancers choosing an endpoint for it
+type RequestAttributes struct {
+	Header   http.Header
+	ClientIP string
+}
+
+//NewRequestAttributes returns the attributes of the request. The client IP is the host of the
+//request's remote address.
+func NewRequestAttributes(r *http.Request) *RequestAttributes {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	return &RequestAttributes{Header: r.Header, ClientIP: clientIP}
+}
+
+//Cookie returns the value of the named request cookie, or the empty string if the request has no such cookie
+func (ra *RequestAttributes) Cookie(name string) string {
+	cookie, err := (&http.Request{Header: ra.Header}).Cookie(name)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+//RequestAwareLoadBalancer is implemented by load balancers that choose an endpoint based on the
+//request, for example to send requests from the same user to the same server.
+type RequestAwareLoadBalancer interface {
+	GetConnectAddressForRequest(attributes *RequestAttributes) (string, error)
+}
+
+//GetConnectAddressForRequest returns the connect address for a request, passing the request attributes
+//to load balancers that use them.
+func GetConnectAddressForRequest(lb LoadBalancer, attributes *RequestAttributes) (string, error) {
+	if requestAware, ok := lb.(RequestAwareLoadBalancer); ok && attributes != nil {
+		return requestAware.GetConnectAddressForRequest(attributes)
+	}
+
+	return lb.GetConnectAddress()
+}
+
+//GetConnectAddressExcluding returns the connect address for a request like GetConnectAddressForRequest, but
+//when the load balancer picks an excluded address and lists its endpoints, another available endpoint is
+//chosen at random if there is one. It is used to retry a request on a different server. When every available
+//endpoint is excluded, or the load balancer does not list its endpoints, the excluded address picked is
+//returned, so the retry goes to a server already tried.
+func GetConnectAddressExcluding(lb LoadBalancer, attributes *RequestAttributes, excluded map[string]bool) (string, error) {
+	address, err := GetConnectAddressForRequest(lb, attributes)
+	if err != nil || !excluded[address] {
+		return address, err
+	}
+
+	lister, ok := lb.(EndpointLister)
+	if !ok {
+		return address, nil
+	}
+
+	endpoints := lister.Endpoints()
+	start := rand.Intn(len(endpoints))
+	for i := range endpoints {
+		e := endpoints[(start+i)%len(endpoints)]
+		if !excluded[e.Address] && e.Available() {
+			return e.Address, nil
+		}
+	}
+
+	return address, nil
+}
+
+//AffinityCookieIssuer is implemented by load balancers that issue cookies pinning clients to the endpoint
+//that served them. AffinityCookie returns the cookie to set on a response from the connect address, or
+//nil if no cookie is needed.
+type AffinityCookieIssuer interface {
+	AffinityCookie(attributes *RequestAttributes, connectAddress string) *http.Cookie
+}
+
+//AffinityCookie returns the affinity cookie to set on a response from the connect address, or nil if the
+//load balancer does not issue affinity cookies or the request already carries the right one.
+func AffinityCookie(lb LoadBalancer, attributes *RequestAttributes, connectAddress string) *http.Cookie {
+	issuer, ok := lb.(AffinityCookieIssuer)
+	if !ok || attributes == nil {
+		return nil
+	}
+
+	return issuer.AffinityCookie(attributes, connectAddress)
+}
+
+//RequestTracker is implemented by load balancers that need to know when requests to the
+//addresses they hand out start and finish, such as load balancers that favor the least busy endpoint.
+type RequestTracker interface {
+	RequestStarted(connectAddress string)
+	RequestFinished(connectAddress string)
+}
+
+//TrackRequest notes the start of a request to the connect address if the load balancer tracks
+//requests. The returned function must be called once the request finishes.
+func TrackRequest(lb LoadBalancer, connectAddress string) func() {
+	tracker, ok := lb.(RequestTracker)
+	if !ok {
+		return func() {}
+	}
+
+	tracker.RequestStarted(connectAddress)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { tracker.RequestFinished(connectAddress) })
+	}
+}
+
+//LatencyRecorder is implemented by load balancers that take the latency of calls to their endpoints
+//into account
+type LatencyRecorder interface {
+	RecordLatency(connectAddress string, latency time.Duration)
+}
+
+//RecordLatency passes the latency of a call to the connect address to load balancers that record latencies
+func RecordLatency(lb LoadBalancer, connectAddress string, latency time.Duration) {
+	if recorder, ok := lb.(LatencyRecorder); ok {
+		recorder.RecordLatency(connectAddress, latency)
+	}
+}
+
+//DefaultFailureLatency is the least latency recorded for a failed call to a backend without a timeout
+const DefaultFailureLatency = time.Second
+
+//FailureLatency returns the latency to record for a failed call that took the given time. Failed calls
+//count as taking at least the backend timeout, or DefaultFailureLatency if the backend has none, so an
+//endpoint that refuses connections or fails fast is not mistaken for a fast endpoint.
+func FailureLatency(latency, backendTimeout time.Duration) time.Duration {
+	penalty := backendTimeout
+	if penalty <= 0 {
+		penalty = DefaultFailureLatency
+	}
+
+	if latency > penalty {
+		return latency
+	}
+
+	return penalty
+}
+
 //LoadBalancerFactory defines an interface for instantiating load balancers.
 type LoadBalancerFactory interface {
 	NewLoadBalancer(name, caCertPath string, servers []config.ServerConfig) (LoadBalancer, error)
 }
+
+//BackendConfigLoadBalancerFactory is implemented by factories for load balancers configured with
+//settings from the backend definition, such as the hash key of a consistent hash load balancer or
+//an inline CA certificate for health checks.
+type BackendConfigLoadBalancerFactory interface {
+	NewLoadBalancerForBackend(backendConfig *config.BackendConfig, servers []config.ServerConfig) (LoadBalancer, error)
+}
+
+//NewLoadBalancerForBackend creates a load balancer for the backend using the given factory, passing the
+//backend definition to factories that use it. Other factories are given the CACertPath only, so their
+//health checks do not use an inline CACert.
+func NewLoadBalancerForBackend(factory LoadBalancerFactory, backendConfig *config.BackendConfig, servers []config.ServerConfig) (LoadBalancer, error) {
+	if backendFactory, ok := factory.(BackendConfigLoadBalancerFactory); ok {
+		return backendFactory.NewLoadBalancerForBackend(backendConfig, servers)
+	}
+
+	return factory.NewLoadBalancer(backendConfig.Name, backendConfig.CACertPath, servers)
+}
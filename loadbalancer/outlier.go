@@ -0,0 +1,249 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/xtracdev/xavi/config"
+)
+
+//Outlier detection defaults, used when the backend definition leaves a setting at zero
+const (
+	DefaultBaseEjectionTime   = 30000 //In milliseconds
+	DefaultMaxEjectionPercent = 10
+)
+
+//maxEjectionTime caps the exponential growth of the ejection time of an endpoint that keeps failing,
+//unless the base ejection time is longer
+const maxEjectionTime = 5 * time.Minute
+
+//Error rates are measured over fixed intervals, and only for endpoints that served at least
+//errorRateMinRequests requests in the interval
+const (
+	errorRateInterval    = 10 * time.Second
+	errorRateMinRequests = 5
+)
+
+//EndpointLister is implemented by load balancers that hand out addresses of LoadBalancerEndpoints. Outlier
+//detection can only eject endpoints of load balancers that implement it.
+type EndpointLister interface {
+	Endpoints() []*LoadBalancerEndpoint
+}
+
+type outlierStats struct {
+	consecutiveErrors int
+	requests          int
+	errors            int
+	intervalStart     time.Time
+	ejections         int
+}
+
+//OutlierDetector ejects endpoints of a backend based on the outcomes of live requests, so a server that
+//fails requests stops receiving them before the next active health check notices. An endpoint is
+//ejected after a run of consecutive errors, or when its error rate over an interval reaches a threshold.
+//Ejection time doubles each time an endpoint is ejected again, and at most a percentage of the
+//endpoints are ejected at once.
+type OutlierDetector struct {
+	backend            string
+	consecutiveErrors  int
+	errorRate          int
+	baseEjectionTime   time.Duration
+	maxEjectionPercent int
+
+	mu        sync.Mutex
+	endpoints []*LoadBalancerEndpoint
+	stats     map[string]*outlierStats
+}
+
+//NewOutlierDetector returns an outlier detector for the load balancer of the backend, or nil if the
+//backend definition does not enable outlier detection or the load balancer does not list its endpoints
+func NewOutlierDetector(lb LoadBalancer, backendConfig *config.BackendConfig) *OutlierDetector {
+	if backendConfig.OutlierConsecutiveErrors <= 0 && backendConfig.OutlierErrorRate <= 0 {
+		return nil
+	}
+
+	lister, ok := lb.(EndpointLister)
+	if !ok {
+		log.Warnf("Outlier detection is not supported by the load balancer of backend %s", backendConfig.Name)
+		return nil
+	}
+
+	od := &OutlierDetector{
+		backend:            backendConfig.Name,
+		consecutiveErrors:  backendConfig.OutlierConsecutiveErrors,
+		errorRate:          backendConfig.OutlierErrorRate,
+		baseEjectionTime:   time.Duration(backendConfig.OutlierBaseEjectionTime) * time.Millisecond,
+		maxEjectionPercent: backendConfig.OutlierMaxEjectionPercent,
+		endpoints:          lister.Endpoints(),
+		stats:              make(map[string]*outlierStats),
+	}
+
+	if od.baseEjectionTime <= 0 {
+		od.baseEjectionTime = DefaultBaseEjectionTime * time.Millisecond
+	}
+
+	if od.maxEjectionPercent <= 0 {
+		od.maxEjectionPercent = DefaultMaxEjectionPercent
+	}
+
+	return od
+}
+
+//ReportOutcome records the outcome of a request to the connect address, ejecting the endpoint if it has
+//become an outlier. Connection errors, timeouts and 5xx responses are errors. ReportOutcome may be called
+//on a nil detector, which ignores the outcome.
+func (od *OutlierDetector) ReportOutcome(connectAddress string, failed bool) {
+	if od == nil {
+		return
+	}
+
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	endpoint := od.endpoint(connectAddress)
+	if endpoint == nil {
+		return
+	}
+
+	now := time.Now()
+	stats := od.stats[connectAddress]
+	if stats == nil {
+		stats = &outlierStats{intervalStart: now}
+		od.stats[connectAddress] = stats
+	}
+
+	if now.Sub(stats.intervalStart) >= errorRateInterval {
+		stats.requests, stats.errors, stats.intervalStart = 0, 0, now
+	}
+
+	stats.requests++
+	if !failed {
+		stats.consecutiveErrors = 0
+		od.forgetEjections(endpoint, stats, now)
+		return
+	}
+
+	stats.errors++
+	stats.consecutiveErrors++
+
+	switch {
+	case od.consecutiveErrors > 0 && stats.consecutiveErrors >= od.consecutiveErrors:
+		od.eject(endpoint, stats, now, fmt.Sprintf("%d consecutive errors", stats.consecutiveErrors))
+	case od.errorRate > 0 && stats.requests >= errorRateMinRequests && stats.errors*100 >= od.errorRate*stats.requests:
+		od.eject(endpoint, stats, now, fmt.Sprintf("%d errors in %d requests", stats.errors, stats.requests))
+	}
+}
+
+func (od *OutlierDetector) endpoint(connectAddress string) *LoadBalancerEndpoint {
+	for _, e := range od.endpoints {
+		if e.Address == connectAddress {
+			return e
+		}
+	}
+
+	return nil
+}
+
+//forgetEjections resets the ejection count of an endpoint that has served without being ejected for as
+//long as its last ejection lasted
+func (od *OutlierDetector) forgetEjections(endpoint *LoadBalancerEndpoint, stats *outlierStats, now time.Time) {
+	if stats.ejections == 0 {
+		return
+	}
+
+	endpoint.mu.RLock()
+	ejectionEnd := endpoint.ejectedAt.Add(endpoint.ejectedFor)
+	lastEjection := endpoint.ejectedFor
+	endpoint.mu.RUnlock()
+
+	if now.Sub(ejectionEnd) >= lastEjection {
+		stats.ejections = 0
+	}
+}
+
+//ejectionTime returns the ejection time for an endpoint's nth ejection
+func (od *OutlierDetector) ejectionTime(ejections int) time.Duration {
+	limit := maxEjectionTime
+	if od.baseEjectionTime > limit {
+		limit = od.baseEjectionTime
+	}
+
+	ejectionTime := od.baseEjectionTime
+	for i := 1; i < ejections && ejectionTime < limit; i++ {
+		ejectionTime *= 2
+	}
+
+	if ejectionTime > limit {
+		ejectionTime = limit
+	}
+
+	return ejectionTime
+}
+
+//eject ejects the endpoint unless it is already ejected or ejecting it would exceed the maximum
+//percentage of ejected endpoints. At least one endpoint may always be ejected.
+func (od *OutlierDetector) eject(endpoint *LoadBalancerEndpoint, stats *outlierStats, now time.Time, reason string) {
+	ejected := 0
+	for _, e := range od.endpoints {
+		e.mu.RLock()
+		if e.ejected(now) {
+			if e == endpoint {
+				e.mu.RUnlock()
+				return
+			}
+			ejected++
+		}
+		e.mu.RUnlock()
+	}
+
+	maxEjected := len(od.endpoints) * od.maxEjectionPercent / 100
+	if maxEjected < 1 {
+		maxEjected = 1
+	}
+
+	if ejected >= maxEjected {
+		log.Warnf("Not ejecting %s from backend %s after %s: %d of %d endpoints already ejected",
+			endpoint.Address, od.backend, reason, ejected, len(od.endpoints))
+		return
+	}
+
+	stats.ejections++
+	stats.consecutiveErrors = 0
+	stats.requests, stats.errors, stats.intervalStart = 0, 0, now
+	ejectionTime := od.ejectionTime(stats.ejections)
+
+	endpoint.mu.Lock()
+	endpoint.ejectedAt = now
+	endpoint.ejectedFor = ejectionTime
+	endpoint.mu.Unlock()
+
+	log.Warnf("Ejecting %s from backend %s for %s after %s", endpoint.Address, od.backend, ejectionTime, reason)
+	metrics.IncrCounter([]string{"outlier-ejection", od.backend}, 1.0)
+}
+
+//Ejections describes the endpoints currently ejected, e.g. localhost:3000 ejections=2 remaining=45s
+func (od *OutlierDetector) Ejections() []string {
+	if od == nil {
+		return nil
+	}
+
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	now := time.Now()
+	var ejections []string
+	for _, e := range od.endpoints {
+		e.mu.RLock()
+		if e.ejected(now) {
+			remaining := e.ejectedAt.Add(e.ejectedFor).Sub(now).Round(time.Second)
+			ejections = append(ejections, fmt.Sprintf("%s ejections=%d remaining=%s",
+				e.Address, od.stats[e.Address].ejections, remaining))
+		}
+		e.mu.RUnlock()
+	}
+
+	return ejections
+}
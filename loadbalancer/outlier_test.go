@@ -0,0 +1,135 @@
+package loadbalancer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTestOutlierDetector(t *testing.T, backendConfig *config.BackendConfig) (LoadBalancer, *OutlierDetector) {
+	lb, err := new(RoundRobinLoadBalancerFactory).NewLoadBalancer("backend", "", makeTestHashServers())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	backendConfig.Name = "backend"
+	od := NewOutlierDetector(lb, backendConfig)
+	if !assert.NotNil(t, od) {
+		t.FailNow()
+	}
+
+	return lb, od
+}
+
+func TestOutlierDetectionDisabled(t *testing.T) {
+	lb, _ := new(RoundRobinLoadBalancerFactory).NewLoadBalancer("backend", "", makeTestHashServers())
+	od := NewOutlierDetector(lb, &config.BackendConfig{Name: "backend"})
+	assert.Nil(t, od)
+
+	od.ReportOutcome("a.domain.com:11000", true)
+	assert.Nil(t, od.Ejections())
+}
+
+func TestOutlierConsecutiveErrors(t *testing.T) {
+	lb, od := makeTestOutlierDetector(t, &config.BackendConfig{OutlierConsecutiveErrors: 3})
+
+	od.ReportOutcome("a.domain.com:11000", true)
+	od.ReportOutcome("a.domain.com:11000", true)
+	od.ReportOutcome("a.domain.com:11000", false)
+	od.ReportOutcome("a.domain.com:11000", true)
+	od.ReportOutcome("a.domain.com:11000", true)
+	assert.Nil(t, od.Ejections(), "A success resets the run of errors")
+
+	od.ReportOutcome("a.domain.com:11000", true)
+	ejections := od.Ejections()
+	if assert.Equal(t, 1, len(ejections)) {
+		assert.Equal(t, "a.domain.com:11000 ejections=1 remaining=30s", ejections[0])
+	}
+
+	for i := 0; i < 8; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		assert.NotEqual(t, "a.domain.com:11000", address)
+	}
+
+	h, _ := lb.GetEndpoints()
+	assert.Equal(t, 4, len(h), "Ejected endpoints are still healthy as far as health checks are concerned")
+}
+
+func TestOutlierErrorRate(t *testing.T) {
+	_, od := makeTestOutlierDetector(t, &config.BackendConfig{OutlierErrorRate: 50})
+
+	for _, failed := range []bool{true, false, true, false} {
+		od.ReportOutcome("b.domain.com:11000", failed)
+	}
+	assert.Nil(t, od.Ejections(), "Error rates need a minimum number of requests")
+
+	od.ReportOutcome("b.domain.com:11000", true)
+	ejections := od.Ejections()
+	if assert.Equal(t, 1, len(ejections)) {
+		assert.True(t, strings.HasPrefix(ejections[0], "b.domain.com:11000"))
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	_, od := makeTestOutlierDetector(t, &config.BackendConfig{OutlierConsecutiveErrors: 1})
+
+	od.ReportOutcome("a.domain.com:11000", true)
+	od.ReportOutcome("b.domain.com:11000", true)
+	assert.Equal(t, 1, len(od.Ejections()), "The default cap of 10% still allows one ejection")
+
+	_, od = makeTestOutlierDetector(t, &config.BackendConfig{OutlierConsecutiveErrors: 1, OutlierMaxEjectionPercent: 50})
+	od.ReportOutcome("a.domain.com:11000", true)
+	od.ReportOutcome("b.domain.com:11000", true)
+	od.ReportOutcome("c.domain.com:11000", true)
+	assert.Equal(t, 2, len(od.Ejections()))
+
+	od.ReportOutcome("nope.domain.com:11000", true)
+	assert.Equal(t, 2, len(od.Ejections()))
+}
+
+func TestOutlierEjectionTimeGrows(t *testing.T) {
+	lb, od := makeTestOutlierDetector(t, &config.BackendConfig{OutlierConsecutiveErrors: 1, OutlierBaseEjectionTime: 1000})
+
+	assert.Equal(t, time.Second, od.ejectionTime(1))
+	assert.Equal(t, 2*time.Second, od.ejectionTime(2))
+	assert.Equal(t, 4*time.Second, od.ejectionTime(3))
+	assert.Equal(t, maxEjectionTime, od.ejectionTime(20))
+
+	endpoint := lb.(EndpointLister).Endpoints()[0]
+	expire := func() {
+		endpoint.mu.Lock()
+		endpoint.ejectedAt = endpoint.ejectedAt.Add(-endpoint.ejectedFor)
+		endpoint.mu.Unlock()
+	}
+
+	od.ReportOutcome(endpoint.Address, true)
+	assert.False(t, endpoint.Available())
+
+	//The endpoint returns once the ejection time passes, and fails again straight away
+	expire()
+	assert.True(t, endpoint.Available())
+	od.ReportOutcome(endpoint.Address, true)
+	assert.Equal(t, []string{endpoint.Address + " ejections=2 remaining=2s"}, od.Ejections())
+
+	//Serving successfully for as long as the last ejection resets the count
+	expire()
+	endpoint.mu.Lock()
+	endpoint.ejectedAt = endpoint.ejectedAt.Add(-endpoint.ejectedFor)
+	endpoint.mu.Unlock()
+	od.ReportOutcome(endpoint.Address, false)
+	od.ReportOutcome(endpoint.Address, true)
+	assert.Equal(t, []string{endpoint.Address + " ejections=1 remaining=1s"}, od.Ejections())
+}
+
+func TestOutlierDetectionWithPreferLocal(t *testing.T) {
+	lb, err := new(PreferLocalLoadBalancerFactory).NewLoadBalancer("backend", "", makeTestHashServers())
+	if assert.Nil(t, err) {
+		od := NewOutlierDetector(lb, &config.BackendConfig{Name: "backend", OutlierConsecutiveErrors: 1})
+		if assert.NotNil(t, od) {
+			assert.Equal(t, 4, len(od.endpoints))
+		}
+	}
+}
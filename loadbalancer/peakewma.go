@@ -0,0 +1,68 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xtracdev/xavi/config"
+)
+
+//PeakEWMALoadBalancer steers requests away from endpoints that are up but slow. Each endpoint's
+//score is the peak EWMA of its call latency multiplied by one more than its requests in flight, and
+//requests go to the healthy endpoint with the lowest score. Endpoints yet to be measured score zero,
+//so they are tried first.
+type PeakEWMALoadBalancer struct {
+	*LeastRequestsLoadBalancer
+}
+
+//PeakEWMALoadBalancerFactory is the method receiver for the peak EWMA load balancer factory method
+type PeakEWMALoadBalancerFactory struct{}
+
+//NewLoadBalancer creates a new instance of a peak EWMA load balancer
+func (pf *PeakEWMALoadBalancerFactory) NewLoadBalancer(backendName, caCertPath string, servers []config.ServerConfig) (LoadBalancer, error) {
+	return pf.NewLoadBalancerForBackend(&config.BackendConfig{Name: backendName, CACertPath: caCertPath}, servers)
+}
+
+//NewLoadBalancerForBackend creates a peak EWMA load balancer using the CA certificate settings of the
+//backend definition for health checks
+func (pf *PeakEWMALoadBalancerFactory) NewLoadBalancerForBackend(backendConfig *config.BackendConfig, servers []config.ServerConfig) (LoadBalancer, error) {
+	lb, err := new(LeastRequestsLoadBalancerFactory).NewLoadBalancerForBackend(backendConfig, servers)
+	if err != nil {
+		return nil, err
+	}
+
+	lr := lb.(*LeastRequestsLoadBalancer)
+	lr.loadFn = score
+	return &PeakEWMALoadBalancer{LeastRequestsLoadBalancer: lr}, nil
+}
+
+func score(e *LoadBalancerEndpoint) float64 {
+	return float64(e.Latency()) * float64(e.InFlight()+1)
+}
+
+//RecordLatency folds the latency of a call to the endpoint with the given connect address into its
+//peak EWMA
+func (pe *PeakEWMALoadBalancer) RecordLatency(connectAddress string, latency time.Duration) {
+	if e := pe.endpoint(connectAddress); e != nil {
+		e.observeLatency(latency)
+	}
+}
+
+//GetEndpoints returns the endpoints associated with the load balancer, partitioning
+//the set of endpoints into healthy and unhealthy endpoints. Each endpoint is reported
+//with its latency, requests in flight and score, e.g.
+//localhost:3000 ewma=12.5ms inflight=1 score=25
+func (pe *PeakEWMALoadBalancer) GetEndpoints() ([]string, []string) {
+	var healthy, unhealthy []string
+	for _, e := range pe.endpoints {
+		endpoint := fmt.Sprintf("%s ewma=%s inflight=%d score=%.0f", e.Address,
+			e.Latency().Round(time.Microsecond), e.InFlight(), score(e)/float64(time.Millisecond))
+		if e.IsUp() {
+			healthy = append(healthy, endpoint)
+		} else {
+			unhealthy = append(unhealthy, endpoint)
+		}
+	}
+
+	return healthy, unhealthy
+}
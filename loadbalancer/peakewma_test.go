@@ -0,0 +1,98 @@
+package loadbalancer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTestPeakEWMA(t *testing.T) *PeakEWMALoadBalancer {
+	lb, err := new(PeakEWMALoadBalancerFactory).NewLoadBalancer("backend", "", makeTestLeastRequestsServers())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	return lb.(*PeakEWMALoadBalancer)
+}
+
+func TestPeakEWMARegistered(t *testing.T) {
+	assert.True(t, IsKnownLoadBalancerPolicy("peak-ewma"))
+
+	_, err := new(PeakEWMALoadBalancerFactory).NewLoadBalancer("", "", makeTestLeastRequestsServers())
+	assert.NotNil(t, err)
+}
+
+func TestPeakEWMAAvoidsSlowEndpoints(t *testing.T) {
+	lb := makeTestPeakEWMA(t)
+
+	RecordLatency(lb, "a.domain.com:11000", 500*time.Millisecond)
+	RecordLatency(lb, "b.domain.com:11000", 5*time.Millisecond)
+	RecordLatency(lb, "c.domain.com:11000", 10*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		assert.Equal(t, "b.domain.com:11000", address)
+	}
+
+	//Requests in flight count against an endpoint, so a busy fast endpoint gives way
+	done := TrackRequest(lb, "b.domain.com:11000")
+	done2 := TrackRequest(lb, "b.domain.com:11000")
+	address, _ := lb.GetConnectAddress()
+	assert.Equal(t, "c.domain.com:11000", address)
+	done()
+	done2()
+
+	lb.MarkEndpointDown("b.domain.com:11000")
+	lb.MarkEndpointDown("c.domain.com:11000")
+	address, _ = lb.GetConnectAddress()
+	assert.Equal(t, "a.domain.com:11000", address, "Slow endpoints are still used when nothing else is up")
+}
+
+func TestPeakEWMALatency(t *testing.T) {
+	e := new(LoadBalancerEndpoint)
+	assert.Equal(t, time.Duration(0), e.Latency())
+
+	e.observeLatency(10 * time.Millisecond)
+	assert.InDelta(t, float64(10*time.Millisecond), float64(e.Latency()), float64(time.Millisecond))
+
+	//A slower call is taken as the new latency at once
+	e.observeLatency(100 * time.Millisecond)
+	assert.InDelta(t, float64(100*time.Millisecond), float64(e.Latency()), float64(time.Millisecond))
+
+	//Faster calls bring it down gradually, weighted by the time since the last call
+	e.mu.Lock()
+	e.observed = e.observed.Add(-ewmaDecay)
+	e.mu.Unlock()
+	e.observeLatency(10 * time.Millisecond)
+	latency := e.Latency()
+	assert.True(t, latency > 10*time.Millisecond && latency < 100*time.Millisecond, latency.String())
+
+	//An idle endpoint's latency decays so it is tried again
+	e.mu.Lock()
+	e.observed = e.observed.Add(-10 * ewmaDecay)
+	e.mu.Unlock()
+	assert.True(t, e.Latency() < time.Millisecond)
+}
+
+func TestPeakEWMAFailureLatency(t *testing.T) {
+	assert.Equal(t, DefaultFailureLatency, FailureLatency(time.Millisecond, 0))
+	assert.Equal(t, 200*time.Millisecond, FailureLatency(time.Millisecond, 200*time.Millisecond))
+	assert.Equal(t, 2*time.Second, FailureLatency(2*time.Second, 200*time.Millisecond))
+}
+
+func TestPeakEWMAEndpointsShowScores(t *testing.T) {
+	lb := makeTestPeakEWMA(t)
+	RecordLatency(lb, "a.domain.com:11000", 12*time.Millisecond)
+	done := TrackRequest(lb, "a.domain.com:11000")
+	defer done()
+	lb.MarkEndpointDown("c.domain.com:11000")
+
+	h, u := lb.GetEndpoints()
+	if assert.Equal(t, 2, len(h)) && assert.Equal(t, 1, len(u)) {
+		assert.True(t, strings.HasPrefix(h[0], "a.domain.com:11000 ewma=12ms inflight=1 score=24"), h[0])
+		assert.Equal(t, "b.domain.com:11000 ewma=0s inflight=0 score=0", h[1])
+		assert.Equal(t, "c.domain.com:11000 ewma=0s inflight=0 score=0", u[0])
+	}
+}
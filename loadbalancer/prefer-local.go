@@ -88,6 +88,13 @@ func partitionServers(servers []config.ServerConfig) ([]config.ServerConfig, []c
 
 //NewLoadBalancer creates an instance of PreferLocalLoadBalancer
 func (pl *PreferLocalLoadBalancerFactory) NewLoadBalancer(backendName, caCertPath string, servers []config.ServerConfig) (LoadBalancer, error) {
+	return pl.NewLoadBalancerForBackend(&config.BackendConfig{Name: backendName, CACertPath: caCertPath}, servers)
+}
+
+//NewLoadBalancerForBackend creates an instance of PreferLocalLoadBalancer using the CA certificate
+//settings of the backend definition for health checks
+func (pl *PreferLocalLoadBalancerFactory) NewLoadBalancerForBackend(backendConfig *config.BackendConfig, servers []config.ServerConfig) (LoadBalancer, error) {
+	backendName := backendConfig.Name
 
 	if backendName == "" {
 		return nil, fmt.Errorf("Expected non-empty backend name")
@@ -100,7 +107,7 @@ func (pl *PreferLocalLoadBalancerFactory) NewLoadBalancer(backendName, caCertPat
 	log.Info("Creating prefer-local load balancer for backend ", backendName, " with ", len(servers), " servers")
 
 	var preferLocalLB PreferLocalLoadBalancer
-	var roundRobinFactory LoadBalancerFactory = new(RoundRobinLoadBalancerFactory)
+	roundRobinFactory := new(RoundRobinLoadBalancerFactory)
 
 	localServers, remoteServers, err := partitionServers(servers)
 	if err != nil {
@@ -110,7 +117,7 @@ func (pl *PreferLocalLoadBalancerFactory) NewLoadBalancer(backendName, caCertPat
 	preferLocalLB.BackendName = backendName
 
 	if len(localServers) > 0 {
-		localLB, err := roundRobinFactory.NewLoadBalancer(backendName, caCertPath, localServers)
+		localLB, err := roundRobinFactory.NewLoadBalancerForBackend(backendConfig, localServers)
 		if err != nil {
 			return nil, err
 		}
@@ -121,7 +128,7 @@ func (pl *PreferLocalLoadBalancerFactory) NewLoadBalancer(backendName, caCertPat
 	}
 
 	if len(remoteServers) > 0 {
-		remoteLB, err := roundRobinFactory.NewLoadBalancer(backendName, caCertPath, remoteServers)
+		remoteLB, err := roundRobinFactory.NewLoadBalancerForBackend(backendConfig, remoteServers)
 		if err != nil {
 			return nil, err
 		}
@@ -220,3 +227,15 @@ func (pl *PreferLocalLoadBalancer) GetEndpoints() ([]string, []string) {
 
 	return healthy, unhealthy
 }
+
+//Endpoints returns the endpoints of the local and remote pools
+func (pl *PreferLocalLoadBalancer) Endpoints() []*LoadBalancerEndpoint {
+	var endpoints []*LoadBalancerEndpoint
+	for _, pool := range []LoadBalancer{pl.LocalServers, pl.RemoteServers} {
+		if lister, ok := pool.(EndpointLister); ok {
+			endpoints = append(endpoints, lister.Endpoints()...)
+		}
+	}
+
+	return endpoints
+}
@@ -28,9 +28,15 @@ type RoundRobinLoadBalancerFactory struct{}
 
 //NewLoadBalancer creates a new instance of a Round Robin load balancer
 func (rrf *RoundRobinLoadBalancerFactory) NewLoadBalancer(backendName, caCertPath string, servers []config.ServerConfig) (LoadBalancer, error) {
+	return rrf.NewLoadBalancerForBackend(&config.BackendConfig{Name: backendName, CACertPath: caCertPath}, servers)
+}
+
+//NewLoadBalancerForBackend creates a round robin load balancer using the CA certificate settings
+//of the backend definition for health checks
+func (rrf *RoundRobinLoadBalancerFactory) NewLoadBalancerForBackend(backendConfig *config.BackendConfig, servers []config.ServerConfig) (LoadBalancer, error) {
 	var rrlb RoundRobinLoadBalancer
 
-	if backendName == "" {
+	if backendConfig.Name == "" {
 		return nil, fmt.Errorf("Expected non-empty backend name")
 	}
 
@@ -38,7 +44,7 @@ func (rrf *RoundRobinLoadBalancerFactory) NewLoadBalancer(backendName, caCertPat
 		return nil, fmt.Errorf("Expected at least one server in servers argument")
 	}
 
-	rrlb.backend = backendName
+	rrlb.backend = backendConfig.Name
 	rrlb.servers = ring.New(len(servers))
 
 	for _, s := range servers {
@@ -48,7 +54,8 @@ func (rrf *RoundRobinLoadBalancerFactory) NewLoadBalancer(backendName, caCertPat
 		metrics.SetGauge([]string{"endpoint", lbEndpoint.Address}, 1.0)
 		lbEndpoint.PingURI = s.PingURI
 		lbEndpoint.Up = true
-		lbEndpoint.CACertPath = caCertPath
+		lbEndpoint.CACertPath = backendConfig.CACertPath
+		lbEndpoint.CACert = backendConfig.CACert
 
 		log.Debug("Spawing health check for address ", lbEndpoint.Address)
 		healthCheckFunction := MakeHealthCheck(lbEndpoint, s, true)
@@ -73,7 +80,7 @@ func (rr *RoundRobinLoadBalancer) GetConnectAddress() (string, error) {
 		rr.servers = rr.servers.Next()
 		loadBalancingEndpoint, ok := s.(*LoadBalancerEndpoint)
 		if ok {
-			if loadBalancingEndpoint.IsUp() {
+			if loadBalancingEndpoint.Available() {
 				address = loadBalancingEndpoint.Address
 				break
 			}
@@ -143,6 +150,18 @@ func (rr *RoundRobinLoadBalancer) changeEndpointStatus(connectAddress string, st
 
 }
 
+//Endpoints returns the load balancer's endpoints
+func (rr *RoundRobinLoadBalancer) Endpoints() []*LoadBalancerEndpoint {
+	var endpoints []*LoadBalancerEndpoint
+	rr.servers.Do(func(s interface{}) {
+		if loadBalancingEndpoint, ok := s.(*LoadBalancerEndpoint); ok {
+			endpoints = append(endpoints, loadBalancingEndpoint)
+		}
+	})
+
+	return endpoints
+}
+
 //GetEndpoints returns the endpoints associated with the load balancer, partitioning
 //the set of endpoints into healthy and unhealthy endpoints
 func (rr *RoundRobinLoadBalancer) GetEndpoints() ([]string, []string) {
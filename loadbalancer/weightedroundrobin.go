@@ -0,0 +1,164 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/config"
+)
+
+//weightedEndpoint is a load balancer endpoint with its weight and the current weight used
+//by smooth weighted round robin selection
+type weightedEndpoint struct {
+	*LoadBalancerEndpoint
+	Weight        int
+	currentWeight int
+}
+
+//WeightedRoundRobinLoadBalancer hands out connections in proportion to the weights of its servers,
+//interleaving them so that heavily weighted servers do not receive bursts of consecutive requests
+type WeightedRoundRobinLoadBalancer struct {
+	backend   string
+	mu        sync.Mutex
+	endpoints []*weightedEndpoint
+}
+
+//WeightedRoundRobinLoadBalancerFactory is the method receiver for the weighted round robin load balancer
+//factory method
+type WeightedRoundRobinLoadBalancerFactory struct{}
+
+//NewLoadBalancer creates a new instance of a weighted round robin load balancer. Servers without a
+//weight are given a weight of 1.
+func (wf *WeightedRoundRobinLoadBalancerFactory) NewLoadBalancer(backendName, caCertPath string, servers []config.ServerConfig) (LoadBalancer, error) {
+	return wf.NewLoadBalancerForBackend(&config.BackendConfig{Name: backendName, CACertPath: caCertPath}, servers)
+}
+
+//NewLoadBalancerForBackend creates a weighted round robin load balancer using the CA certificate
+//settings of the backend definition for health checks
+func (wf *WeightedRoundRobinLoadBalancerFactory) NewLoadBalancerForBackend(backendConfig *config.BackendConfig, servers []config.ServerConfig) (LoadBalancer, error) {
+	if backendConfig.Name == "" {
+		return nil, fmt.Errorf("Expected non-empty backend name")
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("Expected at least one server in servers argument")
+	}
+
+	wrr := &WeightedRoundRobinLoadBalancer{backend: backendConfig.Name}
+
+	for _, s := range servers {
+		if s.Weight < 0 {
+			return nil, fmt.Errorf("Server %s has a negative weight", s.Name)
+		}
+
+		lbEndpoint := newLoadBalancerEndpoint(s, backendConfig)
+
+		weight := s.Weight
+		if weight == 0 {
+			weight = 1
+		}
+
+		log.Debug("Adding server with address ", lbEndpoint.Address, " and weight ", weight)
+		wrr.endpoints = append(wrr.endpoints, &weightedEndpoint{LoadBalancerEndpoint: lbEndpoint, Weight: weight})
+	}
+
+	return wrr, nil
+}
+
+//GetConnectAddress returns the address of the healthy server furthest ahead of its share of requests.
+//Each selection adds every healthy server's weight to its current weight, picks the server with the
+//highest current weight, then reduces that server's current weight by the total weight.
+func (wrr *WeightedRoundRobinLoadBalancer) GetConnectAddress() (string, error) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	var selected *weightedEndpoint
+	total := 0
+	for _, e := range wrr.endpoints {
+		if !e.Available() {
+			continue
+		}
+
+		e.currentWeight += e.Weight
+		total += e.Weight
+		if selected == nil || e.currentWeight > selected.currentWeight {
+			selected = e
+		}
+	}
+
+	if selected == nil {
+		return "", fmt.Errorf("All servers in backend %s are marked down", wrr.backend)
+	}
+
+	selected.currentWeight -= total
+	return selected.Address, nil
+}
+
+//MarkEndpointUp marks the endpoint in the load balancer pool associated with the
+//connect address as up.
+func (wrr *WeightedRoundRobinLoadBalancer) MarkEndpointUp(connectAddress string) error {
+	log.Infof("mark %s up", connectAddress)
+	return wrr.changeEndpointStatus(connectAddress, true)
+}
+
+//MarkEndpointDown marks the endpoint in the load balancer pool associated with the
+//connect address as down.
+func (wrr *WeightedRoundRobinLoadBalancer) MarkEndpointDown(connectAddress string) error {
+	log.Infof("mark %s down", connectAddress)
+	return wrr.changeEndpointStatus(connectAddress, false)
+}
+
+func (wrr *WeightedRoundRobinLoadBalancer) changeEndpointStatus(connectAddress string, status bool) error {
+	if connectAddress == "" {
+		return fmt.Errorf("Non-empty connectAddress expected")
+	}
+
+	addrParts := strings.Split(connectAddress, ":")
+	if len(addrParts) != 2 {
+		return fmt.Errorf("Expected connect address in the form of host:port (%s)", connectAddress)
+	}
+
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	for _, e := range wrr.endpoints {
+		if e.Address == connectAddress {
+			e.MarkLoadBalancerEndpointUp(status)
+
+			//Start a returning server afresh rather than with the weight it had when it went down
+			e.currentWeight = 0
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Address not found in load balancing pool: %s", connectAddress)
+}
+
+//Endpoints returns the load balancer's endpoints
+func (wrr *WeightedRoundRobinLoadBalancer) Endpoints() []*LoadBalancerEndpoint {
+	var endpoints []*LoadBalancerEndpoint
+	for _, e := range wrr.endpoints {
+		endpoints = append(endpoints, e.LoadBalancerEndpoint)
+	}
+
+	return endpoints
+}
+
+//GetEndpoints returns the endpoints associated with the load balancer, partitioning
+//the set of endpoints into healthy and unhealthy endpoints. Each endpoint is reported
+//with its weight, e.g. localhost:3000 weight=2
+func (wrr *WeightedRoundRobinLoadBalancer) GetEndpoints() ([]string, []string) {
+	var healthy, unhealthy []string
+	for _, e := range wrr.endpoints {
+		endpoint := fmt.Sprintf("%s weight=%d", e.Address, e.Weight)
+		if e.IsUp() {
+			healthy = append(healthy, endpoint)
+		} else {
+			unhealthy = append(unhealthy, endpoint)
+		}
+	}
+
+	return healthy, unhealthy
+}
@@ -0,0 +1,85 @@
+package loadbalancer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"testing"
+)
+
+func makeTestWeightedServers() []config.ServerConfig {
+	return []config.ServerConfig{
+		{Name: "a", Address: "a.domain.com", Port: 11000, Weight: 5},
+		{Name: "b", Address: "b.domain.com", Port: 11000},
+		{Name: "c", Address: "c.domain.com", Port: 11000},
+	}
+}
+
+func TestWeightedRoundRobinRegistered(t *testing.T) {
+	assert.True(t, IsKnownLoadBalancerPolicy("weighted-round-robin"))
+}
+
+func TestWeightedRoundRobinGuardrails(t *testing.T) {
+	factory := new(WeightedRoundRobinLoadBalancerFactory)
+
+	_, err := factory.NewLoadBalancer("", "", makeTestWeightedServers())
+	assert.NotNil(t, err)
+
+	_, err = factory.NewLoadBalancer("backend", "", nil)
+	assert.NotNil(t, err)
+
+	_, err = factory.NewLoadBalancer("backend", "", []config.ServerConfig{{Name: "a", Address: "a", Port: 1, Weight: -1}})
+	assert.NotNil(t, err)
+}
+
+func TestWeightedRoundRobinSmoothSelection(t *testing.T) {
+	factory := new(WeightedRoundRobinLoadBalancerFactory)
+	lb, err := factory.NewLoadBalancer("backend", "", makeTestWeightedServers())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var selected []string
+	for i := 0; i < 7; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		selected = append(selected, address)
+	}
+
+	//The heavily weighted server gets 5 of every 7 requests, interleaved with the others
+	assert.Equal(t, []string{
+		"a.domain.com:11000", "a.domain.com:11000", "b.domain.com:11000", "a.domain.com:11000",
+		"c.domain.com:11000", "a.domain.com:11000", "a.domain.com:11000",
+	}, selected)
+}
+
+func TestWeightedRoundRobinMarkDown(t *testing.T) {
+	factory := new(WeightedRoundRobinLoadBalancerFactory)
+	lb, err := factory.NewLoadBalancer("backend", "", makeTestWeightedServers())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Nil(t, lb.MarkEndpointDown("a.domain.com:11000"))
+	for i := 0; i < 4; i++ {
+		address, err := lb.GetConnectAddress()
+		assert.Nil(t, err)
+		assert.NotEqual(t, "a.domain.com:11000", address)
+	}
+
+	h, u := lb.GetEndpoints()
+	assert.Equal(t, []string{"b.domain.com:11000 weight=1", "c.domain.com:11000 weight=1"}, h)
+	assert.Equal(t, []string{"a.domain.com:11000 weight=5"}, u)
+
+	lb.MarkEndpointDown("b.domain.com:11000")
+	lb.MarkEndpointDown("c.domain.com:11000")
+	_, err = lb.GetConnectAddress()
+	assert.NotNil(t, err)
+
+	assert.Nil(t, lb.MarkEndpointUp("a.domain.com:11000"))
+	address, err := lb.GetConnectAddress()
+	assert.Nil(t, err)
+	assert.Equal(t, "a.domain.com:11000", address)
+
+	assert.NotNil(t, lb.MarkEndpointUp("nope.domain.com:11000"))
+	assert.NotNil(t, lb.MarkEndpointUp("nope"))
+}
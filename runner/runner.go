@@ -65,6 +65,15 @@ func setupXAVIEnvironment(pluginRegistrationFn func()) kvstore.KVStore {
 		log.Fatal(err.Error())
 	}
 
+	//Fail fast if the store credentials do not allow access to the configuration. Other errors,
+	//for example the store being unreachable, surface when the store is used.
+	if err := config.CheckKVStoreAccess(kvs); err != nil {
+		if _, denied := err.(*kvstore.AccessDeniedError); denied {
+			log.Fatal(err.Error())
+		}
+		log.Warn("Unable to verify KV store access: ", err.Error())
+	}
+
 	return kvs
 }
 
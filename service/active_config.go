@@ -0,0 +1,54 @@
+package service
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xtracdev/xavi/config"
+	"net"
+	"net/http"
+)
+
+//ActiveConfigPath is the path the active configuration of a listener is served on when the
+//listen command is given an active config address. Secret references are served unresolved.
+const ActiveConfigPath = "/active-config"
+
+//ActiveConfigHandler returns the handler serving the listener's active configuration as
+//recorded by config.RecordActiveConfig, so changes can be planned against it
+func ActiveConfigHandler(listenerName string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		sc := config.ActiveConfigForListener(listenerName)
+		if sc == nil {
+			http.Error(rw, "No active configuration recorded for listener "+listenerName, http.StatusNotFound)
+			return
+		}
+
+		b, err := json.Marshal(sc)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write(b)
+	}
+}
+
+//ServeActiveConfig serves the listener's active configuration at ActiveConfigPath on the given
+//address, separately from the listener's own address so it need not be reachable by the clients
+//of the listener. The returned net.Listener stops serving when closed.
+func ServeActiveConfig(listenerName, address string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ActiveConfigPath, ActiveConfigHandler(listenerName))
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Info("Stopped serving active configuration of listener ", listenerName, ": ", err.Error())
+		}
+	}()
+
+	return ln, nil
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"net/http"
+	"testing"
+)
+
+func TestServeActiveConfig(t *testing.T) {
+	var testKVS = initKVStore(t)
+
+	ln, err := ServeActiveConfig("listener", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer ln.Close()
+	url := "http://" + ln.Addr().String() + ActiveConfigPath
+
+	config.RecordActiveConfig(&config.ServiceConfig{Listener: &config.ListenerConfig{Name: "other"}})
+	res, err := http.Get(url)
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	}
+
+	sc, _ := config.ReadServiceConfig("listener", testKVS)
+	config.RecordActiveConfig(sc)
+	res, err = http.Get(url)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer res.Body.Close()
+
+	var active config.ServiceConfig
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&active))
+	assert.Equal(t, sc, &active)
+
+	_, err = ServeActiveConfig("listener", ln.Addr().String())
+	assert.NotNil(t, err, "The address is in use")
+}
@@ -9,25 +9,31 @@ import (
 	"github.com/xtracdev/xavi/config"
 	"github.com/xtracdev/xavi/kvstore"
 	"github.com/xtracdev/xavi/loadbalancer"
-	"io/ioutil"
+	"time"
 )
 
 type backend struct {
-	Name         string
-	LoadBalancer loadbalancer.LoadBalancer
-	TLSOnly      bool
-	CACert       *x509.CertPool
+	Name                  string
+	LoadBalancer          loadbalancer.LoadBalancer
+	TLSOnly               bool
+	CACert                *x509.CertPool
+	Timeout               time.Duration
+	ConnectTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+	OutlierDetector       *loadbalancer.OutlierDetector
+	CircuitBreakers       *loadbalancer.CircuitBreakers
+	RetryPolicy           *retryPolicy
 }
 
 var ErrCACertFile = errors.New("CACert file contained no certificates")
 
-func instantiateLoadBalancer(policyName string, backendName, caCertPath string, servers []config.ServerConfig) (loadbalancer.LoadBalancer, error) {
-	factory := loadbalancer.ObtainFactoryForLoadBalancer(policyName)
-	if policyName == "" || factory == nil {
+func instantiateLoadBalancer(backendConfig *config.BackendConfig, servers []config.ServerConfig) (loadbalancer.LoadBalancer, error) {
+	factory := loadbalancer.ObtainFactoryForLoadBalancer(backendConfig.LoadBalancerPolicy)
+	if backendConfig.LoadBalancerPolicy == "" || factory == nil {
 		factory = new(loadbalancer.RoundRobinLoadBalancerFactory)
 	}
 
-	return factory.NewLoadBalancer(backendName, caCertPath, servers)
+	return loadbalancer.NewLoadBalancerForBackend(factory, backendConfig, servers)
 }
 
 func buildBackends(kvs kvstore.KVStore, names []string) ([]*backend, error) {
@@ -57,6 +63,10 @@ func buildBackend(kvs kvstore.KVStore, name string) (*backend, error) {
 		return nil, errors.New("Backend defnition for '" + name + "' not found")
 	}
 
+	if err := backendConfig.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+
 	b.Name = name
 	var servers []config.ServerConfig
 
@@ -69,14 +79,24 @@ func buildBackend(kvs kvstore.KVStore, name string) (*backend, error) {
 
 	}
 
-	loadBalancer, err := instantiateLoadBalancer(backendConfig.LoadBalancerPolicy, name, backendConfig.CACertPath, servers)
+	loadBalancer, err := instantiateLoadBalancer(backendConfig, servers)
 	if err != nil {
 		return nil, err
 	}
 
 	b.LoadBalancer = loadBalancer
+	b.OutlierDetector = loadbalancer.NewOutlierDetector(loadBalancer, backendConfig)
+	b.CircuitBreakers = loadbalancer.NewCircuitBreakers(loadBalancer, backendConfig)
 
 	b.TLSOnly = backendConfig.TLSOnly
+	b.Timeout = time.Duration(backendConfig.Timeout) * time.Millisecond
+	b.ConnectTimeout = time.Duration(backendConfig.ConnectTimeout) * time.Millisecond
+	b.ResponseHeaderTimeout = time.Duration(backendConfig.ResponseHeaderTimeout) * time.Millisecond
+
+	b.RetryPolicy, err = newRetryPolicy(backendConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	b.CACert, err = createCertPool(backendConfig)
 	if err != nil {
@@ -93,12 +113,19 @@ func (b *backend) String() string {
 	return buffer.String()
 }
 
-func (b *backend) getConnectAddress() (string, error) {
-	return b.LoadBalancer.GetConnectAddress()
+func (b *backend) getConnectAddress(attributes *loadbalancer.RequestAttributes) (string, error) {
+	return loadbalancer.GetConnectAddressForRequest(b.LoadBalancer, attributes)
+}
+
+//reserveConnectAddress picks the server for an attempt at the request, avoiding the servers already tried,
+//and reserves the attempt on the server's circuit breaker. It returns the function to call with the outcome
+//of the attempt.
+func (b *backend) reserveConnectAddress(attributes *loadbalancer.RequestAttributes, tried map[string]bool) (string, func(outcome loadbalancer.Outcome), error) {
+	return b.CircuitBreakers.ConnectAddress(b.LoadBalancer, attributes, tried)
 }
 
 func createCertPool(backendConfig *config.BackendConfig) (*x509.CertPool, error) {
-	if backendConfig.CACertPath == "" {
+	if backendConfig.CACertPath == "" && backendConfig.CACert == "" {
 		return nil, nil
 	}
 
@@ -106,7 +133,7 @@ func createCertPool(backendConfig *config.BackendConfig) (*x509.CertPool, error)
 
 	pool := x509.NewCertPool()
 
-	pemData, err := ioutil.ReadFile(backendConfig.CACertPath)
+	pemData, err := config.ReadCACert(backendConfig.CACert, backendConfig.CACertPath)
 	if err != nil {
 		return nil, err
 	}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
 	"testing"
 )
 
@@ -13,7 +14,7 @@ func TestGetConnectAddress(t *testing.T) {
 
 	serverMap := make(map[string]string)
 	for i := 0; i < 2; i++ {
-		s, err := backend.getConnectAddress()
+		s, err := backend.getConnectAddress(nil)
 		assert.Nil(t, err)
 		serverMap[s] = s
 	}
@@ -43,3 +44,32 @@ func TestBuildTLSBackendBadCert(t *testing.T) {
 		t.Log(err.Error())
 	}
 }
+
+func TestBuildTLSBackendWithSecretRefs(t *testing.T) {
+	var testKVS = initKVStore(t)
+	b, err := config.ReadBackendConfig("be-tls", testKVS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Name = "be-tls-secret"
+	b.CACert = "${file:" + b.CACertPath + "}"
+	b.CACertPath = ""
+	if err := b.Store(testKVS); err != nil {
+		t.Fatal(err)
+	}
+
+	be, err := buildBackend(testKVS, "be-tls-secret")
+	if assert.Nil(t, err) {
+		assert.NotNil(t, be.CACert)
+	}
+
+	b.Name = "be-tls-missing-secret"
+	b.CACert = "${env:XAVI_TEST_NO_SUCH_CERT}"
+	if err := b.Store(testKVS); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = buildBackend(testKVS, "be-tls-missing-secret")
+	assert.NotNil(t, err)
+}
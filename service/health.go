@@ -44,6 +44,8 @@ type backendContext struct {
 	Up                    bool     `json:"up"`
 	HealthyDependencies   []string `json:healthyDependencies`
 	UnhealthyDependencies []string `json:unhealthyDependencies`
+	EjectedDependencies   []string `json:"ejectedDependencies,omitempty"`
+	CircuitBreakers       []string `json:"circuitBreakers,omitempty"`
 }
 
 //HealthCheckContext is a type  that is used to supply the context needed to build the
@@ -95,6 +97,8 @@ func (hcc *HealthCheckContext) GetHealthStatus() *HealthResponse {
 			bctx.Up = len(h) > 0
 			bctx.HealthyDependencies = h
 			bctx.UnhealthyDependencies = uh
+			bctx.EjectedDependencies = b.OutlierDetector.Ejections()
+			bctx.CircuitBreakers = b.CircuitBreakers.States()
 
 			rc.Backends = append(rc.Backends, bctx)
 
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"strings"
 
-	"crypto/tls"
 	log "github.com/Sirupsen/logrus"
 	"github.com/xtracdev/xavi/plugin"
 )
@@ -57,17 +56,11 @@ type guardAndHandler struct {
 func makeGHEntryForSingleBackendRoute(r route) guardAndHandler {
 	guardFn := makeGuardFunction(r)
 
-	tlsConfig := &tls.Config{RootCAs: r.Backends[0].CACert}
-
-	requestHandler := &requestHandler{
-		Transport:    &http.Transport{DisableKeepAlives: false, DisableCompression: false, Proxy: http.ProxyFromEnvironment},
-		TLSTransport: &http.Transport{DisableKeepAlives: false, DisableCompression: false, Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
-		Backend:      r.Backends[0],
-	}
+	requestHandler := newRequestHandler(r.Backends[0])
 
 	handlerFn := requestHandler.toHandlerFunc()
 
-	handler := plugin.WrapHandlerFunc(handlerFn, r.WrapperFactories)
+	handler := withTimeout(plugin.WrapHandlerFunc(handlerFn, r.WrapperFactories), r.Timeout)
 
 	ghEntry := guardAndHandler{Guard: guardFn, HandlerFn: handler}
 
@@ -94,12 +87,7 @@ func makeGHEntryForMultipleBackends(r route) guardAndHandler {
 	for _, backend := range r.Backends {
 		log.Debug("handler for ", backend.Name)
 
-		tlsConfig := &tls.Config{RootCAs: backend.CACert}
-		requestHandler := &requestHandler{
-			Transport:    &http.Transport{DisableKeepAlives: false, DisableCompression: false, Proxy: http.ProxyFromEnvironment},
-			TLSTransport: &http.Transport{DisableKeepAlives: false, DisableCompression: false, Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
-			Backend:      backend,
-		}
+		requestHandler := newRequestHandler(backend)
 
 		handlerFn := requestHandler.toHandlerFunc()
 
@@ -111,7 +99,7 @@ func makeGHEntryForMultipleBackends(r route) guardAndHandler {
 	multiRouteHandler := factory(handlerMap)
 
 	//Now wrap the handler function with the plugins.
-	handler := plugin.WrapHandlerFunc(multiRouteHandler.ToHandlerFunc(), r.WrapperFactories)
+	handler := withTimeout(plugin.WrapHandlerFunc(multiRouteHandler.ToHandlerFunc(), r.WrapperFactories), r.Timeout)
 
 	return guardAndHandler{Guard: guardFn, HandlerFn: handler}
 }
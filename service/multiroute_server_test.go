@@ -104,7 +104,7 @@ func makeBackend(name string, serverConfig config.ServerConfig) *backend {
 	servers := []config.ServerConfig{serverConfig}
 	var b backend
 	b.Name = name
-	loadBalancer, err := instantiateLoadBalancer("round-robin", b.Name, "", servers)
+	loadBalancer, err := instantiateLoadBalancer(&config.BackendConfig{Name: b.Name, LoadBalancerPolicy: "round-robin"}, servers)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -3,18 +3,22 @@ package service
 import (
 	"container/list"
 	"context"
+	"crypto/tls"
 	"expvar"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/armon/go-metrics"
+	"github.com/xtracdev/xavi/loadbalancer"
 	"github.com/xtracdev/xavi/plugin"
 	"github.com/xtracdev/xavi/plugin/timing"
 	"github.com/xtracdev/xavi/timer"
 	"golang.org/x/net/context/ctxhttp"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 var contextCounts = expvar.NewMap("contextCounts")
@@ -27,7 +31,7 @@ func incCounter(counterName string) {
 func incrementErrorCounts(err error) {
 	if err == context.Canceled {
 		incCounter("cancelled-count")
-	} else if err == context.DeadlineExceeded {
+	} else if isTimeout(err) {
 		incCounter("timeout-count")
 	}
 }
@@ -45,6 +49,64 @@ type requestHandler struct {
 	PluginChain  *list.List
 }
 
+//newRequestHandler creates a request handler for a backend, with transports honoring the
+//backend's connect and response header timeouts
+func newRequestHandler(be *backend) *requestHandler {
+	tlsConfig := &tls.Config{RootCAs: be.CACert}
+	return &requestHandler{
+		Transport:    newTransport(be, nil),
+		TLSTransport: newTransport(be, tlsConfig),
+		Backend:      be,
+	}
+}
+
+func newTransport(be *backend, tlsConfig *tls.Config) *http.Transport {
+	transport := &http.Transport{
+		DisableKeepAlives:     false,
+		DisableCompression:    false,
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSClientConfig:       tlsConfig,
+		ResponseHeaderTimeout: be.ResponseHeaderTimeout,
+	}
+
+	if be.ConnectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: be.ConnectTimeout}).DialContext
+	}
+
+	return transport
+}
+
+//withTimeout applies a route's overall timeout to the requests it handles. A zero timeout
+//leaves the handler as is.
+func withTimeout(handler http.HandlerFunc, timeout time.Duration) http.HandlerFunc {
+	if timeout <= 0 {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+//isTimeout returns true for context deadlines and network timeouts
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+//requestFailed returns true if a backend call failed in a way that counts against the endpoint
+//for outlier detection: a connection error, a timeout or a 5xx response. Requests cancelled by
+//the client are not failures, but are not successes either, so callers do not report them.
+func requestFailed(err error, resp *http.Response) bool {
+	if err != nil {
+		return err != context.Canceled
+	}
+
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
 func backendName(name string) string {
 	if strings.Contains(name, "backend") {
 		return name
@@ -70,7 +132,8 @@ func (rh *requestHandler) toHandlerFunc() func(w http.ResponseWriter, r *http.Re
 
 		timingContributor := rt.StartContributor(backendName(rh.Backend.Name))
 
-		connectString, err := rh.Backend.getConnectAddress()
+		attributes := loadbalancer.NewRequestAttributes(r)
+		connectString, reportOutcome, err := rh.Backend.reserveConnectAddress(attributes, nil)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 			timingContributor.End(err)
@@ -80,10 +143,6 @@ func (rh *requestHandler) toHandlerFunc() func(w http.ResponseWriter, r *http.Re
 			return
 		}
 
-		log.Debug("connect string for ", rh.Backend.Name, "is ", connectString)
-		r.URL.Host = connectString
-		r.Host = connectString
-
 		log.Debug("invoke backend service")
 		serviceName := timing.GetServiceNameFromContext(ctx)
 		if serviceName == "" {
@@ -100,26 +159,51 @@ func (rh *requestHandler) toHandlerFunc() func(w http.ResponseWriter, r *http.Re
 
 		log.Debug(r.URL.Scheme, " transport for backend ", rh.Backend.Name)
 
-		beTimer := timingContributor.StartServiceCall(serviceName, connectString)
-		log.Debug("call service ", serviceName, " for backend ", rh.Backend.Name)
+		budget.deposit()
+		attempts := rh.Backend.RetryPolicy.attemptsFor(r)
+		tried := make(map[string]bool)
 
-		//resp, err := transport.RoundTrip(r)
-		client := &http.Client{
-			Transport: transport,
-		}
+		var resp *http.Response
+		finish := func() {}
+		defer func() { finish() }()
+
+		for attempt := 1; ; attempt++ {
+			tried[connectString] = true
+			if attempt > 1 && r.GetBody != nil {
+				r.Body, _ = r.GetBody()
+			}
 
-		r.RequestURI = "" //Must clear when using http.Client
-		resp, err := ctxhttp.Do(ctx, client, r)
+			resp, finish, err = rh.callBackend(ctx, r, transport, timingContributor, serviceName, connectString, reportOutcome)
+			if attempt >= attempts || !rh.Backend.RetryPolicy.retryable(ctx, err, resp) {
+				break
+			}
+
+			if !budget.withdraw() || !rh.Backend.RetryPolicy.wait(ctx, attempt) {
+				break
+			}
+
+			next, nextReportOutcome, nextErr := rh.Backend.reserveConnectAddress(attributes, tried)
+			if nextErr != nil {
+				break
+			}
+
+			log.Infof("Retrying request to backend %s on %s after attempt %d on %s", rh.Backend.Name, next, attempt, connectString)
+			metrics.IncrCounter([]string{"retry", rh.Backend.Name}, 1.0)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			finish()
+			connectString, reportOutcome = next, nextReportOutcome
+		}
 
-		beTimer.End(err)
 		if err != nil {
 			go incrementErrorCounts(err)
 			log.Info(err.Error())
 
-			switch err {
-			case context.Canceled:
+			switch {
+			case err == context.Canceled:
 				w.WriteHeader(http.StatusInternalServerError)
-			case context.DeadlineExceeded:
+			case isTimeout(err):
 				w.WriteHeader(http.StatusGatewayTimeout)
 			default:
 				w.WriteHeader(http.StatusServiceUnavailable)
@@ -140,6 +224,10 @@ func (rh *requestHandler) toHandlerFunc() func(w http.ResponseWriter, r *http.Re
 			}
 		}
 
+		if cookie := loadbalancer.AffinityCookie(rh.Backend.LoadBalancer, attributes, connectString); cookie != nil {
+			http.SetCookie(w, cookie)
+		}
+
 		log.Debug("write status code to response")
 		w.WriteHeader(resp.StatusCode)
 
@@ -154,6 +242,62 @@ func (rh *requestHandler) toHandlerFunc() func(w http.ResponseWriter, r *http.Re
 	}
 }
 
+//callBackend makes one attempt at the request on the server at the connect address, timing it as a service
+//call and reporting its outcome to the backend's load balancer and outlier detector, and to the circuit
+//breaker the attempt was reserved on. The returned function must be called once the response is no longer
+//needed.
+func (rh *requestHandler) callBackend(ctx context.Context, r *http.Request, transport *http.Transport,
+	timingContributor *timer.Contributor, serviceName, connectString string, reportOutcome func(outcome loadbalancer.Outcome)) (*http.Response, func(), error) {
+
+	log.Debug("connect string for ", rh.Backend.Name, "is ", connectString)
+	r.URL.Host = connectString
+	r.Host = connectString
+
+	done := loadbalancer.TrackRequest(rh.Backend.LoadBalancer, connectString)
+
+	beTimer := timingContributor.StartServiceCall(serviceName, connectString)
+	log.Debug("call service ", serviceName, " for backend ", rh.Backend.Name)
+
+	client := &http.Client{
+		Transport: transport,
+	}
+
+	//Bound this attempt by the backend timeout, within any overall route deadline
+	cancel := context.CancelFunc(func() {})
+	if rh.Backend.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, rh.Backend.Timeout)
+	}
+
+	r.RequestURI = "" //Must clear when using http.Client
+	resp, err := ctxhttp.Do(ctx, client, r)
+
+	beTimer.End(err)
+	beTimer.RLock()
+	latency := beTimer.Duration
+	beTimer.RUnlock()
+
+	//Calls cancelled by the client say nothing about the endpoint, so they only give back any trial request
+	//reserved on its circuit breaker
+	if err == context.Canceled {
+		reportOutcome(loadbalancer.Cancelled)
+	} else {
+		outcome := loadbalancer.Succeeded
+		if requestFailed(err, resp) {
+			outcome = loadbalancer.Failed
+			latency = loadbalancer.FailureLatency(latency, rh.Backend.Timeout)
+		}
+
+		loadbalancer.RecordLatency(rh.Backend.LoadBalancer, connectString, latency)
+		rh.Backend.OutlierDetector.ReportOutcome(connectString, outcome == loadbalancer.Failed)
+		reportOutcome(outcome)
+	}
+
+	return resp, func() {
+		cancel()
+		done()
+	}, err
+}
+
 func (rh *requestHandler) getTransportForBackend(ctx context.Context) *http.Transport {
 	//If we always use TLS use the TLS transport
 	if rh.Backend.TLSOnly {
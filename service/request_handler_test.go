@@ -3,11 +3,20 @@ package service
 import (
 	"crypto/tls"
 	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/loadbalancer"
 	"github.com/xtracdev/xavi/plugin"
+	"github.com/xtracdev/xavi/plugin/timing"
 
 	"context"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNonTLSTransportSelection(t *testing.T) {
@@ -67,3 +76,226 @@ func TestNonTLSTransportHttpsContext(t *testing.T) {
 	}
 
 }
+
+func slowHandler(rw http.ResponseWriter, req *http.Request) {
+	time.Sleep(200 * time.Millisecond)
+	rw.WriteHeader(http.StatusOK)
+}
+
+func serveTimedRequest(handlerFn http.HandlerFunc) (*httptest.ResponseRecorder, context.Context) {
+	ctx := timing.NewContextWithTimer(context.Background())
+	req := httptest.NewRequest("GET", "/foo", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	handlerFn(rec, req)
+	return rec, ctx
+}
+
+func TestBackendTimeouts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(slowHandler))
+	defer ts.Close()
+
+	be := makeTestBackend(t, ts.URL, "")
+	be.Timeout = 20 * time.Millisecond
+
+	rec, ctx := serveTimedRequest(newRequestHandler(be).toHandlerFunc())
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	rt := timing.TimerFromContext(ctx)
+	if assert.Equal(t, 1, len(rt.Contributors)) && assert.Equal(t, 1, len(rt.Contributors[0].ServiceCalls)) {
+		assert.True(t, rt.Contributors[0].TimedOut)
+		assert.True(t, rt.Contributors[0].ServiceCalls[0].TimedOut)
+	}
+
+	be.Timeout = 0
+	be.ResponseHeaderTimeout = 20 * time.Millisecond
+	rec, _ = serveTimedRequest(newRequestHandler(be).toHandlerFunc())
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	be.ResponseHeaderTimeout = 0
+	rec, _ = serveTimedRequest(newRequestHandler(be).toHandlerFunc())
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRouteTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(slowHandler))
+	defer ts.Close()
+
+	handlerFn := newRequestHandler(makeTestBackend(t, ts.URL, "")).toHandlerFunc()
+
+	rec, ctx := serveTimedRequest(withTimeout(handlerFn, 20*time.Millisecond))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.True(t, timing.TimerFromContext(ctx).Contributors[0].TimedOut)
+
+	rec, _ = serveTimedRequest(withTimeout(handlerFn, 0))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRequestsInFlightTracked(t *testing.T) {
+	var be *backend
+	var inFlight int64
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		inFlight = be.LoadBalancer.(*loadbalancer.LeastRequestsLoadBalancer).InFlight(req.Host)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	be = makeTestBackend(t, ts.URL, "least-requests")
+
+	rec, _ := serveTimedRequest(newRequestHandler(be).toHandlerFunc())
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(1), inFlight)
+	assert.Equal(t, int64(0), be.LoadBalancer.(*loadbalancer.LeastRequestsLoadBalancer).InFlight(ts.Listener.Addr().String()))
+}
+
+func TestAffinityCookieIssued(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	testURL, _ := url.Parse(ts.URL)
+	host, port, _ := net.SplitHostPort(testURL.Host)
+	portVal, _ := strconv.Atoi(port)
+	lb, err := instantiateLoadBalancer(&config.BackendConfig{Name: "sticky-backend", LoadBalancerPolicy: "consistent-hash",
+		AffinityCookie: "sticky"}, []config.ServerConfig{{Name: "s1", Address: host, Port: portVal}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	handlerFn := newRequestHandler(&backend{Name: "sticky-backend", LoadBalancer: lb}).toHandlerFunc()
+	rec, _ := serveTimedRequest(handlerFn)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	if assert.Equal(t, 1, len(cookies)) {
+		assert.Equal(t, "sticky", cookies[0].Name)
+	}
+}
+
+func TestCallLatencyRecorded(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	be := makeTestBackend(t, ts.URL, "peak-ewma")
+	rec, _ := serveTimedRequest(newRequestHandler(be).toHandlerFunc())
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	h, _ := be.LoadBalancer.GetEndpoints()
+	if assert.Equal(t, 1, len(h)) {
+		assert.False(t, strings.Contains(h[0], "ewma=0s"), h[0])
+	}
+}
+
+func TestPeakEWMAAvoidsFailingEndpoint(t *testing.T) {
+	refusing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	refusing.Close()
+
+	var served int
+	working := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		served++
+		time.Sleep(10 * time.Millisecond)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer working.Close()
+
+	be := makeTestRetryBackend(t, &config.BackendConfig{LoadBalancerPolicy: "peak-ewma"}, refusing.URL, working.URL)
+	handlerFn := newRequestHandler(be).toHandlerFunc()
+
+	//Connection refused comes back in microseconds, but counts as slow so the endpoint loses traffic
+	var failed int
+	for i := 0; i < 10; i++ {
+		rec, _ := serveTimedRequest(handlerFn)
+		if rec.Code != http.StatusOK {
+			failed++
+		}
+	}
+
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, 9, served)
+}
+
+func TestFailingEndpointEjected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	be := makeTestBackend(t, ts.URL, "")
+	be.OutlierDetector = loadbalancer.NewOutlierDetector(be.LoadBalancer,
+		&config.BackendConfig{Name: be.Name, OutlierConsecutiveErrors: 2})
+
+	handlerFn := newRequestHandler(be).toHandlerFunc()
+	for i := 0; i < 2; i++ {
+		rec, _ := serveTimedRequest(handlerFn)
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+	}
+
+	hcc := &HealthCheckContext{ListenerName: "outliers"}
+	hcc.AddRouteContext(&route{Name: "r1", URIRoot: "/foo", Backends: []*backend{be}})
+	ejected := hcc.GetHealthStatus().Routes[0].Backends[0].EjectedDependencies
+	if assert.Equal(t, 1, len(ejected)) {
+		assert.True(t, strings.HasPrefix(ejected[0], ts.Listener.Addr().String()+" ejections=1"), ejected[0])
+	}
+
+	rec, _ := serveTimedRequest(handlerFn)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "No endpoints are available while the only one is ejected")
+}
+
+func TestCancelledRequestsDoNotResetOutlierErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	be := makeTestBackend(t, ts.URL, "")
+	be.OutlierDetector = loadbalancer.NewOutlierDetector(be.LoadBalancer,
+		&config.BackendConfig{Name: be.Name, OutlierConsecutiveErrors: 2})
+
+	handlerFn := newRequestHandler(be).toHandlerFunc()
+	rec, _ := serveTimedRequest(handlerFn)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+
+	//A request the client gives up on does not reset the run of errors
+	ctx, cancel := context.WithCancel(timing.NewContextWithTimer(context.Background()))
+	cancel()
+	rec = httptest.NewRecorder()
+	handlerFn(rec, httptest.NewRequest("GET", "/foo", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	rec, _ = serveTimedRequest(handlerFn)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+
+	hcc := &HealthCheckContext{ListenerName: "outliers"}
+	hcc.AddRouteContext(&route{Name: "r1", URIRoot: "/foo", Backends: []*backend{be}})
+	assert.Equal(t, 1, len(hcc.GetHealthStatus().Routes[0].Backends[0].EjectedDependencies))
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	be := makeTestBackend(t, ts.URL, "")
+	be.CircuitBreakers = loadbalancer.NewCircuitBreakers(be.LoadBalancer,
+		&config.BackendConfig{Name: be.Name, CircuitBreakerFailures: 2})
+
+	handlerFn := newRequestHandler(be).toHandlerFunc()
+	for i := 0; i < 2; i++ {
+		rec, _ := serveTimedRequest(handlerFn)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	}
+
+	rec, _ := serveTimedRequest(handlerFn)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, 2, calls, "Requests skip an endpoint whose circuit is open")
+
+	hcc := &HealthCheckContext{ListenerName: "breakers"}
+	hcc.AddRouteContext(&route{Name: "r1", URIRoot: "/foo", Backends: []*backend{be}})
+	assert.Equal(t, []string{ts.Listener.Addr().String() + " state=open retry-in=10s"},
+		hcc.GetHealthStatus().Routes[0].Backends[0].CircuitBreakers)
+}
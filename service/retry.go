@@ -0,0 +1,297 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/xtracdev/xavi/config"
+)
+
+//Conditions a retry policy can retry on, besides specific response status codes such as 503
+const (
+	RetryOnConnectError = "connect-error" //The connection to the server could not be made
+	RetryOnReset        = "reset"         //The connection failed after the request was sent
+	RetryOnTimeout      = "timeout"       //The backend timeout expired
+	RetryOn5xx          = "5xx"           //Any 5xx response
+)
+
+//DefaultRetryOn is used when a backend with retries does not name the conditions to retry on
+const DefaultRetryOn = "connect-error,502,503,504"
+
+//Retry defaults and limits
+const (
+	DefaultRetryBackoff = 25 //In milliseconds
+	maxRetryBackoff     = time.Second
+	maxRetryBodySize    = 64 << 10 //Larger request bodies are streamed and not retried
+)
+
+//parseRetryOn parses a comma separated list of retry conditions
+func parseRetryOn(retryOn string) (map[string]bool, error) {
+	if retryOn == "" {
+		retryOn = DefaultRetryOn
+	}
+
+	conditions := make(map[string]bool)
+	for _, condition := range strings.Split(retryOn, ",") {
+		condition = strings.TrimSpace(condition)
+		switch condition {
+		case RetryOnConnectError, RetryOnReset, RetryOnTimeout, RetryOn5xx:
+		default:
+			if status, err := strconv.Atoi(condition); err != nil || status < 100 || status > 599 {
+				return nil, fmt.Errorf("Invalid retry condition '%s' - expected %s, %s, %s, %s or a status code",
+					condition, RetryOnConnectError, RetryOnReset, RetryOnTimeout, RetryOn5xx)
+			}
+		}
+		conditions[condition] = true
+	}
+
+	return conditions, nil
+}
+
+//ValidateRetryOn returns an error if the retry conditions are not a comma separated list of connect-error,
+//reset, timeout, 5xx and status codes
+func ValidateRetryOn(retryOn string) error {
+	_, err := parseRetryOn(retryOn)
+	return err
+}
+
+//retryPolicy retries requests to a backend that fail in a retryable way on a different server, after a
+//jittered exponential backoff. Only idempotent requests are retried unless the policy opts in to
+//retrying others. Backends have their own retry policies, which routes may override.
+type retryPolicy struct {
+	attempts      int
+	conditions    map[string]bool
+	nonIdempotent bool
+	backoff       time.Duration
+}
+
+//newRetryPolicy returns the retry policy of the backend, or nil if the backend makes only one attempt
+func newRetryPolicy(backendConfig *config.BackendConfig) (*retryPolicy, error) {
+	return makeRetryPolicy(backendConfig.RetryAttempts, backendConfig.RetryOn, backendConfig.RetryNonIdempotent,
+		backendConfig.RetryBackoff)
+}
+
+//newRouteRetryPolicy returns the retry policy overriding those of the route's backends, and true if
+//the route overrides them. The policy is nil if the route turns retries off.
+func newRouteRetryPolicy(routeConfig *config.RouteConfig) (*retryPolicy, bool, error) {
+	if routeConfig.RetryAttempts <= 0 {
+		return nil, false, nil
+	}
+
+	rp, err := makeRetryPolicy(routeConfig.RetryAttempts, routeConfig.RetryOn, routeConfig.RetryNonIdempotent,
+		routeConfig.RetryBackoff)
+	return rp, true, err
+}
+
+func makeRetryPolicy(attempts int, retryOn string, nonIdempotent bool, backoff int) (*retryPolicy, error) {
+	if attempts <= 1 {
+		return nil, nil
+	}
+
+	conditions, err := parseRetryOn(retryOn)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &retryPolicy{
+		attempts:      attempts,
+		conditions:    conditions,
+		nonIdempotent: nonIdempotent,
+		backoff:       time.Duration(backoff) * time.Millisecond,
+	}
+
+	if rp.backoff <= 0 {
+		rp.backoff = DefaultRetryBackoff * time.Millisecond
+	}
+
+	return rp, nil
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+//attemptsFor returns the most attempts to make for the request. Requests that may be retried have their
+//bodies buffered so they can be replayed with GetBody. Requests that cannot be retried, including those
+//with large bodies, get one attempt. attemptsFor may be called on a nil policy.
+func (rp *retryPolicy) attemptsFor(r *http.Request) int {
+	if rp == nil {
+		return 1
+	}
+
+	if !rp.nonIdempotent && !idempotent(r.Method) {
+		return 1
+	}
+
+	if !bufferBody(r) {
+		return 1
+	}
+
+	return rp.attempts
+}
+
+//bufferBody reads a request body of up to maxRetryBodySize into memory, returning false if the body is
+//too large or cannot be read. The request is left able to send its whole body either way.
+func bufferBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+
+	if r.ContentLength > maxRetryBodySize {
+		return false
+	}
+
+	body := r.Body
+	buf, err := ioutil.ReadAll(io.LimitReader(body, maxRetryBodySize+1))
+	if err != nil || len(buf) > maxRetryBodySize {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), body), body}
+		return false
+	}
+
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+	r.Body, _ = r.GetBody()
+
+	return true
+}
+
+//isConnectError returns true if the error reports a failure to connect to the server
+func isConnectError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+//retryable returns true if the outcome of an attempt is one the policy retries. Nothing is retried once
+//the request's context is done.
+func (rp *retryPolicy) retryable(ctx context.Context, err error, resp *http.Response) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	switch {
+	case err == context.Canceled:
+		return false
+	case err != nil && isConnectError(err):
+		return rp.conditions[RetryOnConnectError]
+	case err != nil && isTimeout(err):
+		return rp.conditions[RetryOnTimeout]
+	case err != nil:
+		return rp.conditions[RetryOnReset]
+	default:
+		return rp.conditions[strconv.Itoa(resp.StatusCode)] ||
+			(rp.conditions[RetryOn5xx] && resp.StatusCode >= http.StatusInternalServerError)
+	}
+}
+
+//wait sleeps before the retry following the given attempt, for a random time of up to the backoff
+//doubled for each attempt made. It returns false if the request's context is done first.
+func (rp *retryPolicy) wait(ctx context.Context, attempt int) bool {
+	limit := rp.backoff
+	for i := 1; i < attempt && limit < maxRetryBackoff; i++ {
+		limit *= 2
+	}
+
+	if limit > maxRetryBackoff {
+		limit = maxRetryBackoff
+	}
+
+	t := time.NewTimer(time.Duration(rand.Int63n(int64(limit) + 1)))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//Retry budget defaults. Besides the percentage of requests, minRetriesPerSecond retries a second are allowed
+//so that retries still happen under light load, and unused budget accumulates up to maxRetryTokens retries.
+const (
+	DefaultRetryBudget  = 20 //Percent of requests
+	minRetriesPerSecond = 10
+	maxRetryTokens      = 100
+)
+
+//retryBudget limits retries across all backends to a percentage of requests, so that retrying a failing
+//backend cannot multiply the load on it. Each request deposits a fraction of a retry and each retry
+//withdraws a whole one.
+type retryBudget struct {
+	sync.Mutex
+	ratio    float64
+	tokens   float64
+	refilled time.Time
+}
+
+var budget = &retryBudget{
+	ratio:    DefaultRetryBudget / 100.0,
+	tokens:   minRetriesPerSecond,
+	refilled: time.Now(),
+}
+
+//SetRetryBudget sets the percentage of requests that may be retried across all backends
+func SetRetryBudget(percent int) {
+	budget.Lock()
+	budget.ratio = float64(percent) / 100.0
+	budget.Unlock()
+}
+
+//refill adds the retries allowed for the time since the last refill. Callers must hold the lock.
+func (rb *retryBudget) refill(now time.Time) {
+	rb.tokens += now.Sub(rb.refilled).Seconds() * minRetriesPerSecond
+	if rb.tokens > maxRetryTokens {
+		rb.tokens = maxRetryTokens
+	}
+	rb.refilled = now
+}
+
+func (rb *retryBudget) deposit() {
+	rb.Lock()
+	rb.refill(time.Now())
+	rb.tokens += rb.ratio
+	if rb.tokens > maxRetryTokens {
+		rb.tokens = maxRetryTokens
+	}
+	rb.Unlock()
+}
+
+//withdraw returns true if a retry is within the budget, taking it from the budget
+func (rb *retryBudget) withdraw() bool {
+	rb.Lock()
+	defer rb.Unlock()
+
+	rb.refill(time.Now())
+	if rb.tokens < 1 {
+		metrics.IncrCounter([]string{"retry-budget-exhausted"}, 1.0)
+		return false
+	}
+
+	rb.tokens--
+	return true
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/plugin/timing"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryOnValidation(t *testing.T) {
+	assert.Nil(t, ValidateRetryOn(""))
+	assert.Nil(t, ValidateRetryOn("connect-error, reset,timeout,5xx,429"))
+	assert.NotNil(t, ValidateRetryOn("connect-error,sometimes"))
+	assert.NotNil(t, ValidateRetryOn("5000"))
+
+	rp, err := newRetryPolicy(&config.BackendConfig{RetryAttempts: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, rp)
+
+	_, err = newRetryPolicy(&config.BackendConfig{RetryAttempts: 3, RetryOn: "never"})
+	assert.NotNil(t, err)
+}
+
+func TestRetryableOutcomes(t *testing.T) {
+	rp, err := newRetryPolicy(&config.BackendConfig{RetryAttempts: 2})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := context.Background()
+	connectErr := &url.Error{Op: "Get", URL: "http://localhost:1", Err: &net.OpError{Op: "dial", Err: assert.AnError}}
+	resetErr := &url.Error{Op: "Get", URL: "http://localhost:1", Err: &net.OpError{Op: "read", Err: assert.AnError}}
+
+	assert.True(t, rp.retryable(ctx, connectErr, nil))
+	assert.False(t, rp.retryable(ctx, resetErr, nil))
+	assert.False(t, rp.retryable(ctx, context.DeadlineExceeded, nil))
+	assert.False(t, rp.retryable(ctx, context.Canceled, nil))
+	assert.True(t, rp.retryable(ctx, nil, &http.Response{StatusCode: http.StatusServiceUnavailable}))
+	assert.False(t, rp.retryable(ctx, nil, &http.Response{StatusCode: http.StatusInternalServerError}))
+	assert.False(t, rp.retryable(ctx, nil, &http.Response{StatusCode: http.StatusOK}))
+
+	rp.conditions, _ = parseRetryOn("reset,timeout,5xx")
+	assert.False(t, rp.retryable(ctx, connectErr, nil))
+	assert.True(t, rp.retryable(ctx, resetErr, nil))
+	assert.True(t, rp.retryable(ctx, context.DeadlineExceeded, nil))
+	assert.True(t, rp.retryable(ctx, nil, &http.Response{StatusCode: http.StatusInternalServerError}))
+
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, rp.retryable(done, nil, &http.Response{StatusCode: http.StatusInternalServerError}),
+		"Nothing is retried once the request is done")
+	assert.False(t, rp.wait(done, 1))
+}
+
+func TestRetryAttemptsForRequest(t *testing.T) {
+	var rp *retryPolicy
+	assert.Equal(t, 1, rp.attemptsFor(httptest.NewRequest("GET", "/foo", nil)))
+
+	rp, _ = newRetryPolicy(&config.BackendConfig{RetryAttempts: 3})
+	assert.Equal(t, 3, rp.attemptsFor(httptest.NewRequest("GET", "/foo", nil)))
+	assert.Equal(t, 1, rp.attemptsFor(httptest.NewRequest("POST", "/foo", strings.NewReader("body"))))
+
+	req := httptest.NewRequest("PUT", "/foo", strings.NewReader("body"))
+	assert.Equal(t, 3, rp.attemptsFor(req))
+	for i := 0; i < 2; i++ {
+		body, _ := req.GetBody()
+		b, _ := ioutil.ReadAll(body)
+		assert.Equal(t, "body", string(b))
+	}
+
+	//Large bodies are streamed in one attempt, and still sent whole
+	large := strings.Repeat("x", maxRetryBodySize+1)
+	req = httptest.NewRequest("PUT", "/foo", strings.NewReader(large))
+	req.ContentLength = -1
+	assert.Equal(t, 1, rp.attemptsFor(req))
+	b, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, large, string(b))
+
+	rp.nonIdempotent = true
+	assert.Equal(t, 3, rp.attemptsFor(httptest.NewRequest("POST", "/foo", strings.NewReader("body"))))
+}
+
+func TestRetryBudget(t *testing.T) {
+	rb := &retryBudget{ratio: 0.5, tokens: 1, refilled: time.Now()}
+	assert.True(t, rb.withdraw())
+	assert.False(t, rb.withdraw())
+
+	rb.deposit()
+	rb.deposit()
+	assert.True(t, rb.withdraw())
+
+	//Retries accumulate over time up to a limit
+	rb.refilled = rb.refilled.Add(-time.Hour)
+	rb.deposit()
+	assert.Equal(t, float64(maxRetryTokens), rb.tokens)
+}
+
+//makeTestRetryBackend creates a backend with a retry policy for the servers at the URLs, and tops up the
+//retry budget so earlier tests cannot exhaust it
+func makeTestRetryBackend(t *testing.T, retryConfig *config.BackendConfig, urls ...string) *backend {
+	budget.Lock()
+	budget.tokens = maxRetryTokens
+	budget.Unlock()
+
+	var servers []config.ServerConfig
+	for _, u := range urls {
+		testURL, _ := url.Parse(u)
+		host, port, _ := net.SplitHostPort(testURL.Host)
+		portVal, _ := strconv.Atoi(port)
+		servers = append(servers, config.ServerConfig{Name: port, Address: host, Port: portVal})
+	}
+
+	retryConfig.Name = "retry-backend"
+	lb, err := instantiateLoadBalancer(retryConfig, servers)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	rp, err := newRetryPolicy(retryConfig)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	return &backend{Name: retryConfig.Name, LoadBalancer: lb, RetryPolicy: rp}
+}
+
+func TestRouteRetryPolicyOverridesBackend(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&config.ServerConfig{Name: "s1", Address: "localhost", Port: 3000, HealthCheck: "none"}).Store(kvs)
+	(&config.BackendConfig{Name: "b1", ServerNames: []string{"s1"}, RetryAttempts: 3}).Store(kvs)
+	(&config.RouteConfig{Name: "inherits", URIRoot: "/one", Backends: []string{"b1"}}).Store(kvs)
+	(&config.RouteConfig{Name: "no-retries", URIRoot: "/two", Backends: []string{"b1"}, RetryAttempts: 1}).Store(kvs)
+	(&config.RouteConfig{Name: "overrides", URIRoot: "/three", Backends: []string{"b1"}, RetryAttempts: 5,
+		RetryOn: "5xx"}).Store(kvs)
+	(&config.RouteConfig{Name: "invalid", URIRoot: "/four", Backends: []string{"b1"}, RetryAttempts: 2,
+		RetryOn: "sometimes"}).Store(kvs)
+
+	r, err := buildRoute("inherits", kvs)
+	if assert.Nil(t, err) && assert.NotNil(t, r.Backends[0].RetryPolicy) {
+		assert.Equal(t, 3, r.Backends[0].RetryPolicy.attempts)
+	}
+
+	r, err = buildRoute("no-retries", kvs)
+	if assert.Nil(t, err) {
+		assert.Nil(t, r.Backends[0].RetryPolicy)
+	}
+
+	r, err = buildRoute("overrides", kvs)
+	if assert.Nil(t, err) && assert.NotNil(t, r.Backends[0].RetryPolicy) {
+		assert.Equal(t, 5, r.Backends[0].RetryPolicy.attempts)
+		assert.Equal(t, map[string]bool{RetryOn5xx: true}, r.Backends[0].RetryPolicy.conditions)
+	}
+
+	_, err = buildRoute("invalid", kvs)
+	assert.NotNil(t, err)
+}
+
+func TestRequestRetriedOnAnotherServer(t *testing.T) {
+	var calls int
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer working.Close()
+
+	be := makeTestRetryBackend(t, &config.BackendConfig{RetryAttempts: 2, RetryBackoff: 1}, failing.URL, working.URL)
+	handlerFn := newRequestHandler(be).toHandlerFunc()
+
+	//Round robin alternates between the servers, so one request starts on each
+	for i := 0; i < 2; i++ {
+		calls = 0
+		rec, ctx := serveTimedRequest(handlerFn)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		serviceCalls := timing.TimerFromContext(ctx).Contributors[0].ServiceCalls
+		if calls == 2 && assert.Equal(t, 2, len(serviceCalls)) {
+			assert.Equal(t, failing.Listener.Addr().String(), serviceCalls[0].Endpoint)
+			assert.Equal(t, working.Listener.Addr().String(), serviceCalls[1].Endpoint)
+		} else {
+			assert.Equal(t, 1, calls)
+		}
+	}
+
+	//The last attempt's response is returned when every attempt fails
+	be = makeTestRetryBackend(t, &config.BackendConfig{RetryAttempts: 3, RetryBackoff: 1}, failing.URL)
+	calls = 0
+	rec, _ := serveTimedRequest(newRequestHandler(be).toHandlerFunc())
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRequestRetriedAfterConnectError(t *testing.T) {
+	closed := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	closed.Close()
+
+	var body string
+	working := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer working.Close()
+
+	be := makeTestRetryBackend(t, &config.BackendConfig{RetryAttempts: 2, RetryBackoff: 1}, closed.URL, working.URL)
+	handlerFn := newRequestHandler(be).toHandlerFunc()
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("PUT", "/foo", strings.NewReader("replayed"))
+		rec := httptest.NewRecorder()
+		handlerFn(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "replayed", body)
+	}
+
+	//Non-idempotent requests are not retried unless the backend opts in
+	var codes []int
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handlerFn(rec, httptest.NewRequest("POST", "/foo", strings.NewReader("once")))
+		codes = append(codes, rec.Code)
+	}
+	assert.Contains(t, codes, http.StatusServiceUnavailable)
+	assert.Contains(t, codes, http.StatusOK)
+}
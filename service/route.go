@@ -9,6 +9,7 @@ import (
 	"github.com/xtracdev/xavi/config"
 	"github.com/xtracdev/xavi/kvstore"
 	"github.com/xtracdev/xavi/plugin"
+	"time"
 )
 
 type route struct {
@@ -18,6 +19,7 @@ type route struct {
 	WrapperFactories       []*plugin.WrapperFactoryContext
 	MsgProps               string
 	MultiBackendPluginName string
+	Timeout                time.Duration
 }
 
 func makeRouteNotFoundError(name string) error {
@@ -38,6 +40,10 @@ func buildRoute(name string, kvs kvstore.KVStore) (*route, error) {
 		return nil, makeRouteNotFoundError(name)
 	}
 
+	if err := routeConfig.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+
 	backends, err := buildBackends(kvs, routeConfig.Backends)
 	if err != nil {
 		return nil, err
@@ -46,6 +52,19 @@ func buildRoute(name string, kvs kvstore.KVStore) (*route, error) {
 	r.URIRoot = routeConfig.URIRoot
 	r.Backends = backends
 	r.MultiBackendPluginName = routeConfig.MultiBackendAdapter
+	r.Timeout = time.Duration(routeConfig.Timeout) * time.Millisecond
+
+	retryPolicy, overridden, err := newRouteRetryPolicy(routeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	//Backends are built for each route, so the route's retry policy only applies to its own requests
+	if overridden {
+		for _, b := range r.Backends {
+			b.RetryPolicy = retryPolicy
+		}
+	}
 
 	if len(r.Backends) == 0 {
 		return nil, errors.New("No backends configured for route")
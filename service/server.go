@@ -19,5 +19,9 @@ func buildServer(name string, kvs kvstore.KVStore) (*config.ServerConfig, error)
 		return nil, errors.New("No definition for server '" + name + "' found")
 	}
 
+	if err := serverConfig.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+
 	return serverConfig, nil
 }
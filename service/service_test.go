@@ -60,7 +60,7 @@ func makeTestBackend(t *testing.T, testServerURL string, loadBalancerPolicyName
 
 	var b backend
 	b.Name = "test-backend"
-	loadBalancer, err := instantiateLoadBalancer(loadBalancerPolicyName, b.Name, "", servers)
+	loadBalancer, err := instantiateLoadBalancer(&config.BackendConfig{Name: b.Name, LoadBalancerPolicy: loadBalancerPolicyName}, servers)
 	if err != nil {
 		t.Log("Error instantiating test load balancer ", err)
 		t.FailNow()
@@ -449,3 +449,13 @@ func TestPanicGuardConfig(t *testing.T) {
 		ms.organizeRoutesByUri()
 	})
 }
+
+func TestHealthStatusShowsWeights(t *testing.T) {
+	be := makeTestBackend(t, "http://localhost:3000", "weighted-round-robin")
+
+	hcc := &HealthCheckContext{ListenerName: "weighted"}
+	hcc.AddRouteContext(&route{Name: "r1", URIRoot: "/foo", Backends: []*backend{be}})
+
+	health := hcc.GetHealthStatus()
+	assert.Equal(t, []string{"localhost:3000 weight=1"}, health.Routes[0].Backends[0].HealthyDependencies)
+}
@@ -0,0 +1,414 @@
+package service
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/loadbalancer"
+	"github.com/xtracdev/xavi/plugin"
+	"sort"
+	"strings"
+)
+
+//Problem severities. Errors prevent a listener from being built or served correctly, while
+//warnings flag definitions that are likely to be mistakes.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+//Problem describes a problem found when validating configuration
+type Problem struct {
+	Severity string
+	Kind     string
+	Name     string
+	Message  string
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s: %s %s: %s", p.Severity, p.Kind, p.Name, p.Message)
+}
+
+//ValidationReport lists the problems found when validating configuration. The
+//configuration is valid if there are no problems with error severity.
+type ValidationReport struct {
+	Listener string `json:",omitempty"`
+	Valid    bool
+	Problems []*Problem
+}
+
+//Errors returns the problems with error severity
+func (vr *ValidationReport) Errors() []*Problem {
+	var errors []*Problem
+	for _, p := range vr.Problems {
+		if p.Severity == SeverityError {
+			errors = append(errors, p)
+		}
+	}
+	return errors
+}
+
+//ValidateStore validates the definitions in the supplied KVS. If a listener name is given
+//only the listener and the definitions it uses are validated.
+func ValidateStore(listenerName string, kvs kvstore.KVStore) (*ValidationReport, error) {
+	defs, err := config.ExportDefinitions("", kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	return ValidateDefinitions(defs, listenerName), nil
+}
+
+//validator accumulates the problems found in a set of definitions
+type validator struct {
+	defs      *config.Definitions
+	servers   map[string]*config.ServerConfig
+	backends  map[string]*config.BackendConfig
+	routes    map[string]*config.RouteConfig
+	listeners map[string]*config.ListenerConfig
+	problems  []*Problem
+}
+
+func (v *validator) problem(severity, kind, name, format string, args ...interface{}) {
+	v.problems = append(v.problems, &Problem{
+		Severity: severity,
+		Kind:     kind,
+		Name:     name,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) error(kind, name, format string, args ...interface{}) {
+	v.problem(SeverityError, kind, name, format, args...)
+}
+
+//ValidateDefinitions checks a complete set of definitions for the problems that would
+//otherwise only be discovered when a listener is built or serves requests: references to
+//definitions that do not exist, unknown plugins, load balancer policies and health checks,
+//multiple routes without a MsgProps guard for the same URI root on a listener, malformed
+//MsgProps, unresolvable secret references and unreadable CA certs. Definitions that are
+//not referenced by any other definition are reported as warnings. If a listener name is
+//given only the listener and the definitions it uses are checked, and unreferenced
+//definitions are not reported.
+func ValidateDefinitions(defs *config.Definitions, listenerName string) *ValidationReport {
+	v := &validator{
+		defs:      defs,
+		servers:   make(map[string]*config.ServerConfig),
+		backends:  make(map[string]*config.BackendConfig),
+		routes:    make(map[string]*config.RouteConfig),
+		listeners: make(map[string]*config.ListenerConfig),
+	}
+
+	for _, s := range defs.Servers {
+		v.servers[s.Name] = s
+	}
+	for _, b := range defs.Backends {
+		v.backends[b.Name] = b
+	}
+	for _, r := range defs.Routes {
+		v.routes[r.Name] = r
+	}
+	for _, l := range defs.Listeners {
+		v.listeners[l.Name] = l
+	}
+
+	if listenerName == "" {
+		v.checkAll()
+		v.checkOrphans()
+	} else {
+		v.checkListenerTree(listenerName)
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Severity == SeverityError && v.problems[j].Severity != SeverityError
+	})
+
+	report := &ValidationReport{Listener: listenerName, Problems: v.problems}
+	report.Valid = len(report.Errors()) == 0
+	return report
+}
+
+func (v *validator) checkAll() {
+	for _, s := range v.defs.Servers {
+		v.checkServer(s)
+	}
+	for _, b := range v.defs.Backends {
+		v.checkBackend(b)
+	}
+	for _, r := range v.defs.Routes {
+		v.checkRoute(r)
+	}
+	for _, l := range v.defs.Listeners {
+		v.checkListener(l)
+	}
+}
+
+//checkListenerTree checks the listener along with the routes, backends and servers it uses
+func (v *validator) checkListenerTree(listenerName string) {
+	l, ok := v.listeners[listenerName]
+	if !ok {
+		v.error(config.ListenerKind, listenerName, "listener is not defined")
+		return
+	}
+
+	checked := make(map[string]bool)
+	firstCheck := func(kind, name string) bool {
+		if checked[kind+"/"+name] {
+			return false
+		}
+		checked[kind+"/"+name] = true
+		return true
+	}
+
+	v.checkListener(l)
+	for _, routeName := range l.RouteNames {
+		r, ok := v.routes[routeName]
+		if !ok || !firstCheck(config.RouteKind, routeName) {
+			continue
+		}
+
+		v.checkRoute(r)
+		for _, backendName := range r.Backends {
+			b, ok := v.backends[backendName]
+			if !ok || !firstCheck(config.BackendKind, backendName) {
+				continue
+			}
+
+			v.checkBackend(b)
+			for _, serverName := range b.ServerNames {
+				s, ok := v.servers[serverName]
+				if ok && firstCheck(config.ServerKind, serverName) {
+					v.checkServer(s)
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) checkServer(s *config.ServerConfig) {
+	if s.Address == "" {
+		v.error(config.ServerKind, s.Name, "no address specified")
+	}
+
+	if s.Port <= 0 {
+		v.error(config.ServerKind, s.Name, "no port specified")
+	}
+
+	if s.HealthCheck != "" && !loadbalancer.IsKnownHealthCheck(s.HealthCheck) {
+		v.error(config.ServerKind, s.Name, "unknown health check %s - known health checks: %s",
+			s.HealthCheck, loadbalancer.KnownHealthChecks())
+	}
+
+	if s.HealthCheck == "exec" {
+		v.error(config.ServerKind, s.Name, "exec health checks are only accepted from xavi add-server, and only "+
+			"run by listeners started with -allow-exec-health-checks")
+	}
+
+	if s.HealthCheckInterval > 0 && s.HealthCheckTimeout >= s.HealthCheckInterval {
+		v.error(config.ServerKind, s.Name, "health check timeout must be less than health check interval")
+	}
+
+	if s.Weight < 0 {
+		v.error(config.ServerKind, s.Name, "weight cannot be negative")
+	}
+
+	if err := loadbalancer.ValidateHealthCheckSpec(s); err != nil {
+		v.error(config.ServerKind, s.Name, "%s", err.Error())
+	}
+
+	resolved := *s
+	if err := resolved.ResolveSecrets(); err != nil {
+		v.error(config.ServerKind, s.Name, "%s", err.Error())
+	}
+}
+
+func (v *validator) checkBackend(b *config.BackendConfig) {
+	if len(b.ServerNames) == 0 {
+		v.error(config.BackendKind, b.Name, "no servers specified")
+	}
+
+	for _, serverName := range b.ServerNames {
+		if _, ok := v.servers[serverName]; !ok {
+			v.error(config.BackendKind, b.Name, "references server %s, which is not defined", serverName)
+		}
+	}
+
+	if b.LoadBalancerPolicy != "" && !loadbalancer.IsKnownLoadBalancerPolicy(b.LoadBalancerPolicy) {
+		v.error(config.BackendKind, b.Name, "unknown load balancer policy %s - known policies: %s",
+			b.LoadBalancerPolicy, loadbalancer.RegisteredLoadBalancers())
+	}
+
+	if b.Timeout < 0 || b.ConnectTimeout < 0 || b.ResponseHeaderTimeout < 0 {
+		v.error(config.BackendKind, b.Name, "timeouts cannot be negative")
+	}
+
+	if b.OutlierConsecutiveErrors < 0 || b.OutlierBaseEjectionTime < 0 {
+		v.error(config.BackendKind, b.Name, "outlier detection settings cannot be negative")
+	}
+
+	if b.OutlierErrorRate < 0 || b.OutlierErrorRate > 100 || b.OutlierMaxEjectionPercent < 0 || b.OutlierMaxEjectionPercent > 100 {
+		v.error(config.BackendKind, b.Name, "outlier percentages must be between 0 and 100")
+	}
+
+	if b.CircuitBreakerFailures < 0 || b.CircuitBreakerOpenTime < 0 || b.CircuitBreakerTrialRequests < 0 {
+		v.error(config.BackendKind, b.Name, "circuit breaker settings cannot be negative")
+	}
+
+	if b.RetryAttempts < 0 || b.RetryBackoff < 0 {
+		v.error(config.BackendKind, b.Name, "retry settings cannot be negative")
+	}
+
+	if err := ValidateRetryOn(b.RetryOn); err != nil {
+		v.error(config.BackendKind, b.Name, "%s", err.Error())
+	}
+
+	if err := loadbalancer.ValidateHashKey(b.HashKey); err != nil {
+		v.error(config.BackendKind, b.Name, "%s", err.Error())
+	}
+
+	resolved := *b
+	if b.CACert != "" && b.CACertPath != "" {
+		v.error(config.BackendKind, b.Name, "%s", config.ErrCACertAndPath.Error())
+	} else if err := resolved.ResolveSecrets(); err != nil {
+		v.error(config.BackendKind, b.Name, "%s", err.Error())
+	} else if resolved.CACert != "" || resolved.CACertPath != "" {
+		v.checkCACert(&resolved)
+	}
+}
+
+func (v *validator) checkCACert(b *config.BackendConfig) {
+	field := "CACertPath"
+	if b.CACert != "" {
+		field = "CACert"
+	}
+
+	pemData, err := config.ReadCACert(b.CACert, b.CACertPath)
+	if err != nil {
+		v.error(config.BackendKind, b.Name, "unable to read %s: %s", field, err.Error())
+		return
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM(pemData) {
+		v.error(config.BackendKind, b.Name, "%s contains no certificates", field)
+	}
+}
+
+func (v *validator) checkRoute(r *config.RouteConfig) {
+	if r.URIRoot == "" {
+		v.error(config.RouteKind, r.Name, "no URIRoot specified")
+	}
+
+	if len(r.Backends) == 0 {
+		v.error(config.RouteKind, r.Name, "no backends specified")
+	}
+
+	for _, backendName := range r.Backends {
+		if _, ok := v.backends[backendName]; !ok {
+			v.error(config.RouteKind, r.Name, "references backend %s, which is not defined", backendName)
+		}
+	}
+
+	if len(r.Backends) > 1 {
+		if r.MultiBackendAdapter == "" {
+			v.error(config.RouteKind, r.Name, "multiple backends specified without a MultiBackendAdapter")
+		} else if _, err := plugin.LookupMultiBackendAdapterFactory(r.MultiBackendAdapter); err != nil {
+			v.error(config.RouteKind, r.Name, "unknown MultiBackendAdapter %s", r.MultiBackendAdapter)
+		}
+	}
+
+	for _, pluginName := range r.Plugins {
+		if !plugin.RegistryContains(pluginName) {
+			v.error(config.RouteKind, r.Name, "unknown plugin %s", pluginName)
+		}
+	}
+
+	if r.MsgProps != "" && len(strings.Split(r.MsgProps, "=")) != 2 {
+		v.error(config.RouteKind, r.Name, "malformed MsgProps %s - expected header=value", r.MsgProps)
+	}
+
+	if r.Timeout < 0 {
+		v.error(config.RouteKind, r.Name, "timeout cannot be negative")
+	}
+
+	if r.RetryAttempts < 0 || r.RetryBackoff < 0 {
+		v.error(config.RouteKind, r.Name, "retry settings cannot be negative")
+	}
+
+	if err := ValidateRetryOn(r.RetryOn); err != nil {
+		v.error(config.RouteKind, r.Name, "%s", err.Error())
+	}
+
+	resolved := *r
+	if err := resolved.ResolveSecrets(); err != nil {
+		v.error(config.RouteKind, r.Name, "%s", err.Error())
+	}
+}
+
+func (v *validator) checkListener(l *config.ListenerConfig) {
+	var uriRoots []string
+	unguarded := make(map[string][]string)
+	for _, routeName := range l.RouteNames {
+		r, ok := v.routes[routeName]
+		if !ok {
+			v.error(config.ListenerKind, l.Name, "references route %s, which is not defined", routeName)
+			continue
+		}
+
+		if r.MsgProps == "" {
+			if _, seen := unguarded[r.URIRoot]; !seen {
+				uriRoots = append(uriRoots, r.URIRoot)
+			}
+			unguarded[r.URIRoot] = append(unguarded[r.URIRoot], r.Name)
+		}
+	}
+
+	for _, uriRoot := range uriRoots {
+		if routes := unguarded[uriRoot]; len(routes) > 1 {
+			v.error(config.ListenerKind, l.Name, "routes %s all serve %s without a MsgProps guard - only one unguarded route is allowed per URI root",
+				strings.Join(routes, ", "), uriRoot)
+		}
+	}
+}
+
+//checkOrphans reports definitions not referenced by any other definition
+func (v *validator) checkOrphans() {
+	referenced := make(map[string]bool)
+	for _, b := range v.backends {
+		for _, s := range b.ServerNames {
+			referenced[config.ServerKind+"/"+s] = true
+		}
+	}
+	for _, r := range v.routes {
+		for _, b := range r.Backends {
+			referenced[config.BackendKind+"/"+b] = true
+		}
+	}
+	for _, l := range v.listeners {
+		for _, r := range l.RouteNames {
+			referenced[config.RouteKind+"/"+r] = true
+		}
+	}
+
+	orphan := func(kind, name string) {
+		if !referenced[kind+"/"+name] {
+			v.problem(SeverityWarning, kind, name, "not referenced by any %s", referencingKind[kind])
+		}
+	}
+
+	for _, s := range v.defs.Servers {
+		orphan(config.ServerKind, s.Name)
+	}
+	for _, b := range v.defs.Backends {
+		orphan(config.BackendKind, b.Name)
+	}
+	for _, r := range v.defs.Routes {
+		orphan(config.RouteKind, r.Name)
+	}
+}
+
+var referencingKind = map[string]string{
+	config.ServerKind:  config.BackendKind,
+	config.BackendKind: config.RouteKind,
+	config.RouteKind:   config.ListenerKind,
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/kvstore"
+	"github.com/xtracdev/xavi/plugin"
+	"github.com/xtracdev/xavi/plugin/logging"
+	"strings"
+	"testing"
+)
+
+func testValidDefinitions() *config.Definitions {
+	plugin.RegisterWrapperFactory("Logging", logging.NewLoggingWrapper)
+	return &config.Definitions{
+		Servers: []*config.ServerConfig{
+			{Name: "s1", Address: "localhost", Port: 3000, HealthCheck: "none"},
+		},
+		Backends: []*config.BackendConfig{
+			{Name: "b1", ServerNames: []string{"s1"}, CACertPath: "./cert.pem"},
+		},
+		Routes: []*config.RouteConfig{
+			{Name: "r1", URIRoot: "/one", Backends: []string{"b1"}, Plugins: []string{"Logging"}},
+			{Name: "r1-guarded", URIRoot: "/one", Backends: []string{"b1"}, MsgProps: "SOAPAction=foo"},
+		},
+		Listeners: []*config.ListenerConfig{
+			{Name: "l1", RouteNames: []string{"r1", "r1-guarded"}},
+		},
+	}
+}
+
+func testProblemMessages(report *ValidationReport) string {
+	var messages []string
+	for _, p := range report.Problems {
+		messages = append(messages, p.String())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func TestValidateValidDefinitions(t *testing.T) {
+	report := ValidateDefinitions(testValidDefinitions(), "")
+	assert.True(t, report.Valid)
+	assert.Equal(t, 0, len(report.Problems), testProblemMessages(report))
+
+	report = ValidateDefinitions(testValidDefinitions(), "l1")
+	assert.True(t, report.Valid)
+	assert.Equal(t, "l1", report.Listener)
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	defs := testValidDefinitions()
+	defs.Servers[0].HealthCheck = "smoke-signals"
+	defs.Servers = append(defs.Servers, &config.ServerConfig{Name: "s2", Address: "localhost", Port: 3001})
+	defs.Backends[0].LoadBalancerPolicy = "coin-toss"
+	defs.Backends[0].CACertPath = "./no-such-cert.pem"
+	defs.Routes[0].Plugins = []string{"no-such-plugin"}
+	defs.Routes[1].MsgProps = "SOAPAction"
+	defs.Routes = append(defs.Routes,
+		&config.RouteConfig{Name: "r2", URIRoot: "/one", Backends: []string{"b1", "b2"}, MultiBackendAdapter: "no-such-adapter"})
+	defs.Listeners[0].RouteNames = append(defs.Listeners[0].RouteNames, "r2", "r3")
+
+	report := ValidateDefinitions(defs, "")
+	assert.False(t, report.Valid)
+
+	messages := testProblemMessages(report)
+	for _, expected := range []string{
+		"error: server s1: unknown health check smoke-signals",
+		"error: backend b1: unknown load balancer policy coin-toss",
+		"error: backend b1: unable to read CACertPath",
+		"error: route r1: unknown plugin no-such-plugin",
+		"error: route r1-guarded: malformed MsgProps SOAPAction",
+		"error: route r2: references backend b2, which is not defined",
+		"error: route r2: unknown MultiBackendAdapter no-such-adapter",
+		"error: listener l1: references route r3, which is not defined",
+		"error: listener l1: routes r1, r2 all serve /one without a MsgProps guard",
+		"warning: server s2: not referenced by any backend",
+	} {
+		assert.Contains(t, messages, expected)
+	}
+
+	assert.Equal(t, 9, len(report.Errors()), messages)
+	assert.Equal(t, SeverityWarning, report.Problems[len(report.Problems)-1].Severity, "Errors should be listed first")
+}
+
+func TestValidateExecHealthCheck(t *testing.T) {
+	defs := testValidDefinitions()
+	defs.Servers[0].HealthCheck = "exec"
+	defs.Servers[0].HealthCheckCommand = "/usr/local/bin/check"
+
+	report := ValidateDefinitions(defs, "")
+	assert.False(t, report.Valid)
+	assert.Contains(t, testProblemMessages(report), "error: server s1: exec health checks are only accepted from xavi add-server")
+}
+
+func TestValidateListenerTree(t *testing.T) {
+	defs := testValidDefinitions()
+	defs.Servers = append(defs.Servers, &config.ServerConfig{Name: "s2"})
+	defs.Backends = append(defs.Backends, &config.BackendConfig{Name: "b2", ServerNames: []string{"s2"}})
+	defs.Routes = append(defs.Routes, &config.RouteConfig{Name: "r2", URIRoot: "/two", Backends: []string{"b2"}})
+	defs.Listeners = append(defs.Listeners, &config.ListenerConfig{Name: "l2", RouteNames: []string{"r2"}})
+
+	report := ValidateDefinitions(defs, "l1")
+	assert.True(t, report.Valid, testProblemMessages(report))
+
+	report = ValidateDefinitions(defs, "l2")
+	assert.False(t, report.Valid)
+	assert.Contains(t, testProblemMessages(report), "error: server s2: no address specified")
+
+	report = ValidateDefinitions(defs, "no-such-listener")
+	assert.False(t, report.Valid)
+}
+
+func TestValidateSecretRefs(t *testing.T) {
+	defs := testValidDefinitions()
+	defs.Backends[0].CACertPath = ""
+	defs.Backends[0].CACert = "${file:./cert.pem}"
+	report := ValidateDefinitions(defs, "l1")
+	assert.True(t, report.Valid, testProblemMessages(report))
+
+	defs.Backends[0].CACert = "${env:XAVI_TEST_NO_SUCH_CERT}"
+	report = ValidateDefinitions(defs, "l1")
+	assert.False(t, report.Valid)
+	assert.Contains(t, testProblemMessages(report), "XAVI_TEST_NO_SUCH_CERT")
+
+	//PEM data in CACertPath is read as a path
+	defs.Backends[0].CACert = ""
+	defs.Backends[0].CACertPath = "${file:./cert.pem}"
+	report = ValidateDefinitions(defs, "l1")
+	assert.Contains(t, testProblemMessages(report), "unable to read CACertPath")
+
+	defs.Backends[0].CACert = "${file:./cert.pem}"
+	defs.Backends[0].CACertPath = "./cert.pem"
+	report = ValidateDefinitions(defs, "l1")
+	assert.Contains(t, testProblemMessages(report), config.ErrCACertAndPath.Error())
+
+	defs.Backends[0].CACert = ""
+	defs.Backends[0].CACertPath = "./badcert.pem"
+	report = ValidateDefinitions(defs, "l1")
+	assert.Contains(t, testProblemMessages(report), "CACertPath contains no certificates")
+}
+
+func TestValidateStore(t *testing.T) {
+	kvs, _ := kvstore.NewHashKVStore("")
+	(&config.ListenerConfig{Name: "l1", RouteNames: []string{"r1"}}).Store(kvs)
+
+	report, err := ValidateStore("", kvs)
+	assert.Nil(t, err)
+	assert.False(t, report.Valid)
+	assert.Contains(t, testProblemMessages(report), "references route r1, which is not defined")
+}
@@ -29,6 +29,20 @@ func TestMainNoArgs(t *testing.T) {
 	assert.True(t, strings.Contains(out, "list-routes"), "Missing list-routes command.")
 	assert.True(t, strings.Contains(out, "list-listeners"), "Missing list-listeners command.")
 	assert.True(t, strings.Contains(out, "list-plugins"), "Missing list-plugins command.")
+	assert.True(t, strings.Contains(out, "list-namespaces"), "Missing list-namespaces command.")
+	assert.True(t, strings.Contains(out, "history"), "Missing history command.")
+	assert.True(t, strings.Contains(out, "rollback"), "Missing rollback command.")
+	assert.True(t, strings.Contains(out, "reencrypt"), "Missing reencrypt command.")
+	assert.True(t, strings.Contains(out, "apply"), "Missing apply command.")
+	assert.True(t, strings.Contains(out, "export"), "Missing export command.")
+	assert.True(t, strings.Contains(out, "validate"), "Missing validate command.")
+	assert.True(t, strings.Contains(out, "plan"), "Missing plan command.")
+	assert.True(t, strings.Contains(out, "graph"), "Missing graph command.")
+	assert.True(t, strings.Contains(out, "migrate-config"), "Missing migrate-config command.")
+	assert.True(t, strings.Contains(out, "delete-server"), "Missing delete-server command.")
+	assert.True(t, strings.Contains(out, "delete-backend"), "Missing delete-backend command.")
+	assert.True(t, strings.Contains(out, "delete-route"), "Missing delete-route command.")
+	assert.True(t, strings.Contains(out, "delete-listener"), "Missing delete-listener command.")
 }
 
 func TestSetupError(t *testing.T) {
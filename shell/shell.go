@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/mitchellh/cli"
 	"github.com/xtracdev/xavi/commands"
+	"github.com/xtracdev/xavi/config"
+	"github.com/xtracdev/xavi/env"
 	"github.com/xtracdev/xavi/kvstore"
 	"io"
 	"os"
@@ -11,11 +13,21 @@ import (
 
 var xaviCommands map[string]cli.CommandFactory
 
+//cliAuthor returns the author recorded in the revisions of definitions changed via the
+//command line - XAVI_AUTHOR if set, otherwise the user running the command
+func cliAuthor() string {
+	if author := os.Getenv(env.Author); author != "" {
+		return author
+	}
+	return os.Getenv("USER")
+}
+
 func commandSetup(kvs kvstore.KVStore, writer io.Writer) error {
 	if kvs == nil || writer == nil {
 		return fmt.Errorf("Must supply non-nil KVS and Writer arguments")
 	}
 
+	kvs = config.WithAuthor(kvs, cliAuthor())
 	ui := &cli.BasicUi{Writer: writer, ErrorWriter: writer}
 
 	xaviCommands = map[string]cli.CommandFactory{
@@ -55,6 +67,48 @@ func commandSetup(kvs kvstore.KVStore, writer io.Writer) error {
 		"list-plugins": func() (cli.Command, error) {
 			return &commands.PluginList{ui, kvs}, nil
 		},
+		"list-namespaces": func() (cli.Command, error) {
+			return &commands.NamespaceList{ui, kvs}, nil
+		},
+		"history": func() (cli.Command, error) {
+			return &commands.History{ui, kvs}, nil
+		},
+		"rollback": func() (cli.Command, error) {
+			return &commands.Rollback{ui, kvs}, nil
+		},
+		"reencrypt": func() (cli.Command, error) {
+			return &commands.Reencrypt{ui, kvs}, nil
+		},
+		"apply": func() (cli.Command, error) {
+			return &commands.Apply{ui, kvs}, nil
+		},
+		"export": func() (cli.Command, error) {
+			return &commands.Export{ui, kvs}, nil
+		},
+		"validate": func() (cli.Command, error) {
+			return &commands.Validate{ui, kvs}, nil
+		},
+		"plan": func() (cli.Command, error) {
+			return &commands.Plan{ui, kvs}, nil
+		},
+		"graph": func() (cli.Command, error) {
+			return &commands.Graph{ui, kvs}, nil
+		},
+		"migrate-config": func() (cli.Command, error) {
+			return &commands.MigrateConfig{ui, kvs}, nil
+		},
+		"delete-server": func() (cli.Command, error) {
+			return &commands.DeleteServer{ui, kvs}, nil
+		},
+		"delete-backend": func() (cli.Command, error) {
+			return &commands.DeleteBackend{ui, kvs}, nil
+		},
+		"delete-route": func() (cli.Command, error) {
+			return &commands.DeleteRoute{ui, kvs}, nil
+		},
+		"delete-listener": func() (cli.Command, error) {
+			return &commands.DeleteListener{ui, kvs}, nil
+		},
 	}
 
 	return nil
@@ -19,6 +19,7 @@ type ServiceCall struct {
 	Endpoint      string
 	Duration      time.Duration
 	Error         string
+	TimedOut      bool `json:",omitempty"`
 	errorReported bool
 	start         time.Time
 }
@@ -29,6 +30,7 @@ type Contributor struct {
 	Name          string
 	Duration      time.Duration
 	Error         string
+	TimedOut      bool `json:",omitempty"`
 	errorReported bool
 	start         time.Time
 	ServiceCalls  []*ServiceCall
@@ -134,12 +136,14 @@ func (t *EndToEndTimer) ToJSONString() string {
 
 //End stops the clock for a contributor. Any errors of note that occur
 //during the contributor should be pass along in the err argument, otherwise
-//pass nil.
+//pass nil. Errors reporting a timeout, such as context.DeadlineExceeded, are
+//flagged as timeouts.
 func (c *Contributor) End(err error) {
 	c.Lock()
 	c.Duration = time.Now().Sub(c.start)
 	if err != nil {
 		c.Error = err.Error()
+		c.TimedOut = isTimeout(err)
 		c.errorReported = true
 	}
 	c.Unlock()
@@ -163,17 +167,28 @@ func (c *Contributor) StartServiceCall(name string, endpoint string) *ServiceCal
 	return svcCall
 }
 
-//End stops the clock for a ServiceCall
+//End stops the clock for a ServiceCall. Errors reporting a timeout are flagged
+//as timeouts.
 func (sc *ServiceCall) End(err error) {
 	sc.Lock()
 	sc.Duration = time.Now().Sub(sc.start)
 	if err != nil {
 		sc.Error = err.Error()
+		sc.TimedOut = isTimeout(err)
 		sc.errorReported = true
 	}
 	sc.Unlock()
 }
 
+//isTimeout returns true for errors that report a timeout via a Timeout method, as
+//context.DeadlineExceeded and net.Error timeouts do
+func isTimeout(err error) bool {
+	timeout, ok := err.(interface {
+		Timeout() bool
+	})
+	return ok && timeout.Timeout()
+}
+
 func makeTxnId() string {
 	b := make([]byte, 16)
 	rand.Read(b)
@@ -1,6 +1,7 @@
 package timer
 
 import (
+	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"strings"
@@ -115,3 +116,16 @@ func TestMultiBackendRecordings(t *testing.T) {
 
 	println(at.ToJSONString())
 }
+
+func TestTimeoutsFlagged(t *testing.T) {
+	at := NewEndToEndTimer("foo")
+	c := at.StartContributor("c1")
+	sc := c.StartServiceCall("s1", "localhost:80")
+	sc.End(context.DeadlineExceeded)
+	c.End(errors.New("not a timeout"))
+	at.Stop(nil)
+
+	assert.True(t, sc.TimedOut)
+	assert.False(t, c.TimedOut)
+	assert.True(t, strings.Contains(at.ToJSONString(), `"TimedOut":true`))
+}